@@ -0,0 +1,426 @@
+package filemanager
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mholt/caddy"
+)
+
+const (
+	// maxJobOutput is the maximum number of bytes of output kept for a job.
+	maxJobOutput = 64 << 10
+	// maxJobs is the maximum number of finished jobs which are kept.
+	maxJobs = 100
+	// jobRetention is how long the jobs are kept in the state store
+	// after they were last saved.
+	jobRetention = 24 * time.Hour
+)
+
+var (
+	errActionNotExist = errors.New("action does not exist")
+	errActionTarget   = errors.New("the action can't be run on this file")
+	errShuttingDown   = errors.New("the server is shutting down")
+)
+
+// Action is a named command template which the users can run on files,
+// e.g. to convert an image or transcode a video. The placeholders '{path}'
+// and '{dir}' in the arguments of the command are replaced by the path of
+// the file and of its directory.
+type Action struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+
+	// Extensions are the extensions of the files on which the action can
+	// be run, such as '.png'. If it is empty, the action can be run on
+	// any file.
+	Extensions []string `json:"extensions"`
+}
+
+// ParseAction parses an action in the format 'name=command' or
+// 'name:.ext1,.ext2=command' to restrict the extensions of the files.
+func ParseAction(s string) (Action, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+		return Action{}, errors.New("invalid action: " + s)
+	}
+
+	a := Action{
+		Name:    parts[0],
+		Command: strings.TrimSpace(parts[1]),
+	}
+
+	if i := strings.Index(a.Name, ":"); i != -1 {
+		a.Extensions = strings.Split(a.Name[i+1:], ",")
+		a.Name = a.Name[:i]
+	}
+
+	if _, _, err := caddy.SplitCommandAndArgs(a.Command); err != nil {
+		return Action{}, err
+	}
+
+	return a, nil
+}
+
+// accepts tells if the action can be run on the file at path.
+func (a *Action) accepts(path string) bool {
+	if len(a.Extensions) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range a.Extensions {
+		if strings.ToLower(e) == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+// command builds the command of the action for the file at path. The
+// placeholders are replaced after splitting the arguments so the path
+// is always passed as a single argument.
+func (a *Action) command(path string) (*exec.Cmd, error) {
+	name, args, err := caddy.SplitCommandAndArgs(a.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	replacer := strings.NewReplacer("{path}", path, "{dir}", filepath.Dir(path))
+	for i := range args {
+		args[i] = replacer.Replace(args[i])
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = filepath.Dir(path)
+	return cmd, nil
+}
+
+// job is the execution of an action, of a bulk move or copy, which has
+// the results of each item once it finishes, or of the generation of
+// the thumbnails of a directory.
+type job struct {
+	sync.Mutex
+
+	ID       string     `json:"id"`
+	Action   string     `json:"action"`
+	Path     string     `json:"path"`
+	Username string     `json:"-"`
+	Status   string     `json:"status"`
+	Output   string     `json:"output"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Results map[string]*bulkResult `json:"results,omitempty"`
+
+	// Done and Total are the number of items processed so far and the
+	// number of items to process, for the jobs which report progress.
+	Done  int `json:"done,omitempty"`
+	Total int `json:"total,omitempty"`
+
+	cmd *exec.Cmd
+}
+
+// Write appends the output of the command to the job, up
+// to maxJobOutput bytes.
+func (j *job) Write(p []byte) (int, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if room := maxJobOutput - len(j.Output); room > 0 {
+		if len(p) > room {
+			j.Output += string(p[:room])
+		} else {
+			j.Output += string(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// snapshot returns a copy of the job which is safe to render.
+func (j *job) snapshot() *job {
+	j.Lock()
+	defer j.Unlock()
+
+	return &job{
+		ID:       j.ID,
+		Action:   j.Action,
+		Path:     j.Path,
+		Status:   j.Status,
+		Output:   j.Output,
+		Error:    j.Error,
+		Started:  j.Started,
+		Finished: j.Finished,
+		Results:  j.Results,
+		Done:     j.Done,
+		Total:    j.Total,
+	}
+}
+
+// jobList holds the jobs which are running or have finished recently.
+type jobList struct {
+	sync.Mutex
+	items map[string]*job
+}
+
+// add adds a job, removing the oldest finished ones if there are too many.
+func (l *jobList) add(j *job) {
+	l.Lock()
+	defer l.Unlock()
+
+	finished := []*job{}
+	for _, item := range l.items {
+		if item.snapshot().Finished != nil {
+			finished = append(finished, item)
+		}
+	}
+
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].Started.Before(finished[b].Started)
+	})
+
+	for i := 0; i < len(finished)-maxJobs+1; i++ {
+		delete(l.items, finished[i].ID)
+	}
+
+	l.items[j.ID] = j
+}
+
+// kill kills the processes of the jobs which are still running. The bulk
+// moves have no process and are left to finish.
+func (l *jobList) kill() {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, item := range l.items {
+		if item.snapshot().Finished == nil && item.cmd != nil && item.cmd.Process != nil {
+			item.cmd.Process.Kill()
+		}
+	}
+}
+
+// get returns the jobs started by the user, sorted by start time.
+func (l *jobList) get(username string) []*job {
+	l.Lock()
+	defer l.Unlock()
+
+	jobs := []*job{}
+	for _, item := range l.items {
+		if item.Username == username {
+			jobs = append(jobs, item.snapshot())
+		}
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].Started.Before(jobs[b].Started)
+	})
+
+	return jobs
+}
+
+// actionHandler runs the action in the 'name' query parameter on the file
+// in the URL. The action runs in the background and the response is the
+// job which can be followed on /api/jobs/<id>.
+func actionHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed(w, http.MethodPost)
+	}
+
+	if !c.User.AllowCommands {
+		return http.StatusForbidden, nil
+	}
+
+	var action *Action
+	name := r.URL.Query().Get("name")
+
+	for i := range c.Actions {
+		if c.Actions[i].Name == name {
+			action = &c.Actions[i]
+			break
+		}
+	}
+
+	if action == nil {
+		return http.StatusNotFound, errActionNotExist
+	}
+
+	if c.File.IsDir || !action.accepts(c.File.Name) {
+		return http.StatusBadRequest, errActionTarget
+	}
+
+	cmd, err := action.command(c.File.Path)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	j, err := c.runJob(c.User, action.Name, c.File.VirtualPath, cmd)
+	if err == errShuttingDown {
+		return rejectShutdown(w)
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Location", c.RootURL()+"/api/jobs/"+j.ID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	return renderJSON(w, j.snapshot())
+}
+
+// runJob runs the command in the background as a job of the user, with
+// the environment of the commands of the user. The errors of the command
+// are logged and reported in the job.
+func (m *FileManager) runJob(u *User, action, path string, cmd *exec.Cmd) (*job, error) {
+	bytes, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		ID:       hex.EncodeToString(bytes),
+		Action:   action,
+		Path:     path,
+		Username: u.Username,
+		Status:   "running",
+		Started:  time.Now(),
+	}
+
+	cmd.Env = commandEnvironment(u)
+	cmd.Stdout = j
+	cmd.Stderr = j
+	j.cmd = cmd
+
+	// Shutting down waits for the jobs in progress.
+	if !m.shutdown.begin() {
+		return nil, errShuttingDown
+	}
+
+	if err = cmd.Start(); err != nil {
+		m.shutdown.end()
+		return nil, err
+	}
+
+	m.jobs.add(j)
+	m.saveJob(j)
+
+	go func() {
+		defer m.shutdown.end()
+
+		err := cmd.Wait()
+		now := time.Now()
+
+		j.Lock()
+		j.Finished = &now
+		j.Status = "done"
+
+		if err != nil {
+			j.Status = "failed"
+			j.Error = err.Error()
+			log.Printf("[ERROR] The job %s of %s on %s failed: %v", action, u.Username, path, err)
+		}
+
+		j.Unlock()
+		m.saveJob(j)
+	}()
+
+	return j, nil
+}
+
+// jobsHandler lists the jobs of the user or returns the one in the URL.
+func jobsHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	jobs, err := c.userJobs(c.User.Username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	id := strings.Trim(r.URL.Path, "/")
+	if id == "" {
+		return renderJSON(w, jobs)
+	}
+
+	for _, j := range jobs {
+		if j.ID == id {
+			return renderJSON(w, j)
+		}
+	}
+
+	return http.StatusNotFound, nil
+}
+
+// jobKey is the key of a job in the state store.
+func jobKey(username, id string) string {
+	return "jobs/" + url.PathEscape(username) + "/" + id
+}
+
+// saveJob saves the job in the state store so it can be followed on the
+// other instances too. Its output is only saved when it is started and
+// when it finishes.
+func (m *FileManager) saveJob(j *job) {
+	data, err := json.Marshal(j.snapshot())
+	if err == nil {
+		err = m.state().Set(jobKey(j.Username, j.ID), data, jobRetention)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Could not save the job %s: %v", j.ID, err)
+	}
+}
+
+// userJobs returns the jobs started by the user, sorted by start time.
+// The ones running on this instance are up to date, while the ones of
+// the others are as they were last saved.
+func (m *FileManager) userJobs(username string) ([]*job, error) {
+	jobs := m.jobs.get(username)
+	local := map[string]bool{}
+	for _, j := range jobs {
+		local[j.ID] = true
+	}
+
+	prefix := jobKey(username, "")
+	keys, err := m.state().Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if local[strings.TrimPrefix(key, prefix)] {
+			continue
+		}
+
+		data, ok, err := m.state().Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		j := &job{}
+		if !ok || json.Unmarshal(data, j) != nil {
+			continue
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].Started.Before(jobs[b].Started)
+	})
+
+	return jobs, nil
+}
@@ -0,0 +1,263 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAction(t *testing.T) {
+	a, err := ParseAction("thumb:.png,.JPG=convert {path} -resize 64x64 {dir}/thumb.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Name != "thumb" || !reflect.DeepEqual(a.Extensions, []string{".png", ".JPG"}) {
+		t.Errorf("Wrong action: %+v", a)
+	}
+
+	if !a.accepts("/photos/cat.jpg") || a.accepts("/photos/cat.gif") {
+		t.Errorf("Wrong extensions accepted")
+	}
+
+	cmd, err := a.command("/srv/my photos/cat.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"convert", "/srv/my photos/cat.png", "-resize", "64x64", "/srv/my photos/thumb.png"}
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("Wrong arguments: got %q, want %q", cmd.Args, want)
+	}
+
+	for _, s := range []string{"", "name", "=command", "name= "} {
+		if _, err := ParseAction(s); err == nil {
+			t.Errorf("Invalid action %q was accepted", s)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp isn't installed")
+	}
+
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, name := range []string{"file.txt", "image.png"} {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte("content"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range []string{"copy:.txt=cp {path} {dir}/copy.txt", "fail=cp {path}"} {
+		a, err := ParseAction(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fm.Actions = append(fm.Actions, a)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	// run runs the action and waits for its job to finish.
+	run := func(name, path string) *job {
+		w := do("POST", "/api/action"+path+"?name="+name)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s on %s: got %v", name, path, w.Code)
+		}
+
+		var j job
+		if err := json.Unmarshal(w.Body.Bytes(), &j); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasSuffix(w.Header().Get("Location"), "/api/jobs/"+j.ID) {
+			t.Errorf("%s on %s: got the location %q", name, path, w.Header().Get("Location"))
+		}
+
+		for i := 0; i < 100; i++ {
+			w = do("GET", "/api/jobs/"+j.ID)
+			if err := json.Unmarshal(w.Body.Bytes(), &j); err != nil {
+				t.Fatal(err)
+			}
+
+			if j.Finished != nil {
+				return &j
+			}
+
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		t.Fatalf("%s on %s didn't finish", name, path)
+		return nil
+	}
+
+	if j := run("copy", "/file.txt"); j.Action != "copy" || j.Path != "/file.txt" || j.Status != "done" {
+		t.Errorf("Got the job %+v", j)
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(scope, "copy.txt")); err != nil || string(content) != "content" {
+		t.Errorf("The action didn't run on the file: %q, %v", content, err)
+	}
+
+	if j := run("fail", "/file.txt"); j.Status != "failed" || j.Error == "" || j.Output == "" {
+		t.Errorf("Got the failed job %+v", j)
+	}
+
+	var jobs []job
+	if err := json.Unmarshal(do("GET", "/api/jobs/").Body.Bytes(), &jobs); err != nil || len(jobs) != 2 {
+		t.Errorf("Got the jobs %+v, %v", jobs, err)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"POST", "/api/action/file.txt?name=missing", http.StatusNotFound},
+		{"POST", "/api/action/image.png?name=copy", http.StatusBadRequest},
+		{"POST", "/api/action/?name=fail", http.StatusBadRequest},
+		{"GET", "/api/action/file.txt?name=copy", http.StatusMethodNotAllowed},
+		{"GET", "/api/jobs/missing", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		if w := do(test.method, test.url); w.Code != test.code {
+			t.Errorf("%s %s: got %v, want %v", test.method, test.url, w.Code, test.code)
+		}
+	}
+
+	// Only the users who can execute commands can run the actions.
+	fm.Users["admin"].AllowCommands = false
+	if w := do("POST", "/api/action/file.txt?name=copy"); w.Code != http.StatusForbidden {
+		t.Errorf("Without the command permission: got %v", w.Code)
+	}
+}
+
+func TestSharedJobs(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	// A job started on this instance and another one saved by a
+	// different instance sharing the state store.
+	local := &job{ID: "local", Username: "admin", Status: "running", Started: time.Now()}
+	fm.jobs.add(local)
+	fm.saveJob(local)
+
+	local.Status = "done"
+
+	remote := &job{ID: "remote", Username: "admin", Status: "done", Started: time.Now().Add(-time.Minute)}
+	fm.saveJob(remote)
+	fm.saveJob(&job{ID: "other", Username: "other", Started: time.Now()})
+
+	jobs, err := fm.userJobs("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 2 || jobs[0].ID != "remote" || jobs[1].ID != "local" {
+		t.Fatalf("Got %+v", jobs)
+	}
+
+	// The jobs of this instance are up to date.
+	if jobs[1].Status != "done" {
+		t.Errorf("Got the saved status %q of the local job", jobs[1].Status)
+	}
+}
+
+func TestUploadHook(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp isn't installed")
+	}
+
+	fm := newTest(t)
+	defer fm.Clean()
+
+	hook, err := ParseAction("upload=cp {path} {dir}/copy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.UploadHook = &hook
+
+	token := login(t, fm, defaultCredentials)
+
+	upload := func(name string) {
+		r, err := http.NewRequest("POST", "/api/resource/"+name, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Upload of %s: got %v", name, w.Code)
+		}
+	}
+
+	// wait waits for the jobs of the hook to finish.
+	wait := func(n int) []*job {
+		for i := 0; i < 100; i++ {
+			jobs := fm.jobs.get("admin")
+			finished := 0
+			for _, j := range jobs {
+				if j.Finished != nil {
+					finished++
+				}
+			}
+
+			if len(jobs) == n && finished == n {
+				return jobs
+			}
+
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		t.Fatal("The jobs of the upload hook didn't finish")
+		return nil
+	}
+
+	upload("file.txt")
+	jobs := wait(1)
+	if jobs[0].Action != "upload" || jobs[0].Path != "/file.txt" || jobs[0].Status != "done" {
+		t.Errorf("Got the job %+v", jobs[0])
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(fm.Temp, "scope", "copy.txt"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("The hook didn't run on the file: %q, %v", content, err)
+	}
+
+	// The failures don't fail the uploads but are reported in the jobs.
+	hook.Command = "cp {path}"
+	*fm.UploadHook = hook
+	upload("other.txt")
+
+	jobs = wait(2)
+	if jobs[1].Path != "/other.txt" || jobs[1].Status != "failed" || jobs[1].Error == "" {
+		t.Errorf("Got the job %+v", jobs[1])
+	}
+}
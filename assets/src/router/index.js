@@ -11,6 +11,7 @@ import Error403 from '@/views/errors/403'
 import Error404 from '@/views/errors/404'
 import Error500 from '@/views/errors/500'
 import auth from '@/utils/auth.js'
+import * as api from '@/utils/api'
 import store from '@/store'
 
 Vue.use(Router)
@@ -26,7 +27,7 @@ const router = new Router({
       beforeEnter: function (to, from, next) {
         auth.loggedIn()
           .then(() => {
-            next({ path: '/files' })
+            next({ path: '/' })
           })
           .catch(() => {
             document.title = 'Login'
@@ -103,6 +104,15 @@ const router = new Router({
             path: '/files/'
           }
         },
+        {
+          // The root of the app opens the landing directory of the user.
+          path: '/',
+          beforeEnter: function (to, from, next) {
+            api.me()
+              .then(profile => { next({ path: '/files' + profile.landingPath }) })
+              .catch(() => { next({ path: '/files/' }) })
+          }
+        },
         {
           path: '/*',
           redirect: {
@@ -14,6 +14,12 @@ const state = {
   },
   staticGen: document.querySelector('meta[name="staticgen"]').getAttribute('content'),
   baseURL: document.querySelector('meta[name="base"]').getAttribute('content'),
+  branding: {
+    name: document.querySelector('meta[name="brand-name"]').getAttribute('content'),
+    logo: document.querySelector('meta[name="brand-logo"]').getAttribute('content'),
+    ssoURL: document.querySelector('meta[name="sso-url"]').getAttribute('content'),
+    ssoLabel: document.querySelector('meta[name="sso-label"]').getAttribute('content')
+  },
   jwt: '',
   progress: 0,
   schedule: '',
@@ -12,9 +12,13 @@ export function removePrefix (url) {
   return url
 }
 
-export function fetch (url) {
+export function fetch (url, dirsOnly = false) {
   url = removePrefix(url)
 
+  if (dirsOnly) {
+    url += '?dirsOnly=true'
+  }
+
   return new Promise((resolve, reject) => {
     let request = new window.XMLHttpRequest()
     request.open('GET', `${store.state.baseURL}/api/resource${url}`, true)
@@ -25,6 +29,11 @@ export function fetch (url) {
         case 200:
           resolve(JSON.parse(request.responseText))
           break
+        case 413:
+          // The file is too large to be previewed, so it's only
+          // described and can be downloaded.
+          resolve(JSON.parse(request.responseText))
+          break
         default:
           reject(new Error(request.status))
           break
@@ -156,6 +165,55 @@ export function copy (items) {
   return moveCopy(items, true)
 }
 
+export function bulkMove (items, dest, copy = false, override = false) {
+  dest = removePrefix(dest)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('PATCH', `${store.state.baseURL}/api/resource${dest}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+    request.setRequestHeader('Action', 'bulk')
+
+    request.onload = () => {
+      // 202 means the items are being moved in a job.
+      if (request.status === 200 || request.status === 202) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+
+    request.onerror = (error) => reject(error)
+    request.send(JSON.stringify({
+      items: items.map(removePrefix),
+      copy: copy,
+      override: override
+    }))
+  })
+}
+
+export function shareFolder (url, move = false) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('PATCH', `${store.state.baseURL}/api/resource${url}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+    request.setRequestHeader('Action', move ? 'share-move' : 'share')
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
 export function checksum (url, algo) {
   url = removePrefix(url)
 
@@ -176,6 +234,128 @@ export function checksum (url, algo) {
   })
 }
 
+export function summary (url, recursive = false) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/resource${url}?action=summary&recursive=${recursive}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function xattrs (url) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/resource${url}?action=xattrs`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function setXattrs (url, attrs) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('PATCH', `${store.state.baseURL}/api/resource${url}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+    request.setRequestHeader('Action', 'xattrs')
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send(JSON.stringify(attrs))
+  })
+}
+
+export function warmThumbnails (url, recursive = false) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('POST', `${store.state.baseURL}/api/thumbnail${url}?recursive=${recursive}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 202) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function portion (url, n, tail = false, unit = 'lines') {
+  url = removePrefix(url)
+  let query = `?${tail ? 'tail' : 'head'}=${n}&unit=${unit}`
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/resource${url}${query}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function recent (url, days = 7, limit = 100) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/recent${url}?days=${days}&limit=${limit}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
 export function command (url, command, onmessage, onclose) {
   let protocol = (ssl ? 'wss:' : 'ws:')
   url = removePrefix(url)
@@ -222,6 +402,33 @@ export function download (format, ...files) {
   window.open(url)
 }
 
+export function manifest (url, algo = 'sha256') {
+  url = removePrefix(url)
+  window.open(`${store.state.baseURL}/api/manifest${url}?algo=${algo}`)
+}
+
+export function exportData (format = 'json') {
+  window.open(`${store.state.baseURL}/api/export/?format=${format}`)
+}
+
+export function me () {
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/me`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
 export function getSettings () {
   return new Promise((resolve, reject) => {
     let request = new window.XMLHttpRequest()
@@ -429,7 +636,7 @@ export function deleteShare (hash) {
   })
 }
 
-export function share (url, expires = '', unit = 'hours') {
+export function share (url, expires = '', unit = 'hours', password = '', title = '', description = '') {
   url = removePrefix(url)
   url = `${store.state.baseURL}/api/share${url}`
   if (expires !== '') {
@@ -449,6 +656,95 @@ export function share (url, expires = '', unit = 'hours') {
       }
     }
 
+    request.onerror = (error) => reject(error)
+    let body = {}
+    if (password !== '') body.password = password
+    if (title !== '') body.title = title
+    if (description !== '') body.description = description
+
+    request.send(Object.keys(body).length > 0 ? JSON.stringify(body) : '')
+  })
+}
+
+export function batch (requests) {
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('POST', `${store.state.baseURL}/api/batch/`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+
+    request.onerror = (error) => reject(error)
+    request.send(JSON.stringify(requests))
+  })
+}
+
+export function exif (url) {
+  url = removePrefix(url)
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/exif${url}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function history (page = 1, user = null) {
+  let query = `?page=${page}`
+  if (user !== null) query += `&user=${user}`
+
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/history/${query}`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve({
+          commands: JSON.parse(request.responseText),
+          total: parseInt(request.getResponseHeader('X-Total-Count'), 10)
+        })
+      } else {
+        reject(request.responseText)
+      }
+    }
+
+    request.onerror = (error) => reject(error)
+    request.send()
+  })
+}
+
+export function checkPublish () {
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/publish/`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(request.responseText)
+      }
+    }
+
     request.onerror = (error) => reject(error)
     request.send()
   })
@@ -50,15 +50,41 @@ function login (user, password) {
   })
 }
 
+function introspect () {
+  return new Promise((resolve, reject) => {
+    let request = new window.XMLHttpRequest()
+    request.open('GET', `${store.state.baseURL}/api/auth/introspect`, true)
+    request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+
+    request.onload = () => {
+      if (request.status === 200) {
+        resolve(JSON.parse(request.responseText))
+      } else {
+        reject(new Error(request.status))
+      }
+    }
+    request.onerror = () => reject(new Error('Could not finish the request'))
+    request.send()
+  })
+}
+
 function logout () {
   let path = store.state.baseURL
   if (path === '') path = '/'
   document.cookie = `auth='nothing'; max-age=0; path=${path}`
+
+  // Removes the cookie set by the server, if any, and revokes the token.
+  let request = new window.XMLHttpRequest()
+  request.open('POST', `${store.state.baseURL}/api/auth/logout`, true)
+  if (store.state.jwt) request.setRequestHeader('Authorization', `Bearer ${store.state.jwt}`)
+  request.send()
+
   router.push({path: '/login'})
 }
 
 export default {
   loggedIn: loggedIn,
   login: login,
+  introspect: introspect,
   logout: logout
 }
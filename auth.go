@@ -2,13 +2,16 @@ package filemanager
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 )
@@ -42,6 +45,10 @@ func authHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int
 		return http.StatusForbidden, nil
 	}
 
+	// The hash is upgraded if the hashing parameters changed since
+	// it was made.
+	c.upgradePassword(u, cred.Password)
+
 	c.User = u
 	return printToken(c, w)
 }
@@ -51,13 +58,208 @@ func authHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int
 func renewAuthHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	ok, u := validateAuth(c, r)
 	if !ok {
-		return http.StatusForbidden, nil
+		return unauthorized(c, w)
 	}
 
 	c.User = u
 	return printToken(c, w)
 }
 
+// tokenInfo describes the lifetime of a token.
+type tokenInfo struct {
+	IssuedAt  int64 `json:"issuedAt,omitempty"`
+	ExpiresAt int64 `json:"expiresAt"`
+	// Remaining is the number of seconds until the token expires.
+	Remaining int64 `json:"remaining"`
+	// IdleTimeout is the number of seconds the token can go unused and
+	// IdleRemaining the ones left before it is logged out. They are
+	// omitted if there is no idle timeout.
+	IdleTimeout   int64 `json:"idleTimeout,omitempty"`
+	IdleRemaining int64 `json:"idleRemaining,omitempty"`
+}
+
+// introspectHandler tells when the token of the request expires,
+// without renewing it nor counting as an activity, so the front-end
+// can warn the user before the session ends.
+func introspectHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	// There are no tokens without authentication.
+	if c.NoAuth {
+		return http.StatusNotFound, nil
+	}
+
+	claims, raw, err := parseRawToken(c, r)
+	if err != nil || c.revoked(raw) {
+		return unauthorized(c, w)
+	}
+
+	now := time.Now()
+	info := &tokenInfo{
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		Remaining: claims.ExpiresAt - now.Unix(),
+	}
+
+	if c.IdleTimeout > 0 {
+		last, err := c.lastActivity(claims, raw)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		idle := last.Add(c.IdleTimeout).Sub(now)
+		if idle <= 0 {
+			return unauthorized(c, w)
+		}
+
+		info.IdleTimeout = int64(c.IdleTimeout / time.Second)
+		info.IdleRemaining = int64(idle / time.Second)
+	}
+
+	return renderJSON(w, info)
+}
+
+// logoutHandler removes the authentication cookie set by
+// the server, which the front-end can't access, and revokes
+// the token of the request, if any, until it expires.
+func logoutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed(w, http.MethodPost)
+	}
+
+	http.SetCookie(w, authCookie(c, "", -1))
+
+	if claims, raw, err := parseRawToken(c, r); err == nil {
+		ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+		if err = c.state().Set(revokedKey(raw), []byte("1"), ttl); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// revokedKey is the key of the state store which tells if
+// the token was revoked. The token itself isn't stored.
+func revokedKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "revoked/" + hex.EncodeToString(sum[:])
+}
+
+// revoked tells if the token was revoked. The tokens can't be trusted if
+// the state store fails, so they're considered revoked then.
+func (m *FileManager) revoked(token string) bool {
+	_, revoked, err := m.state().Get(revokedKey(token))
+	if err != nil {
+		log.Print(err)
+		return true
+	}
+
+	return revoked
+}
+
+// activityKey is the key of the state store with the time the token was
+// last used at.
+func activityKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "activity/" + hex.EncodeToString(sum[:])
+}
+
+// lastActivity returns when the token was last used, or issued if it
+// wasn't used within IdleTimeout.
+func (m *FileManager) lastActivity(claims *claims, raw string) (time.Time, error) {
+	last := time.Unix(claims.IssuedAt, 0)
+
+	value, ok, err := m.state().Get(activityKey(raw))
+	if err != nil || !ok {
+		return last, err
+	}
+
+	if unix, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+		last = time.Unix(unix, 0)
+	}
+
+	return last, nil
+}
+
+// active tells if the token was used within IdleTimeout and, if so,
+// records that it is used now.
+func (m *FileManager) active(claims *claims, raw string) bool {
+	if m.IdleTimeout <= 0 {
+		return true
+	}
+
+	last, err := m.lastActivity(claims, raw)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(last) > m.IdleTimeout {
+		return false
+	}
+
+	// The activity is forgotten once the token would be idle anyway.
+	err = m.state().Set(activityKey(raw), []byte(strconv.FormatInt(now.Unix(), 10)), m.IdleTimeout)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	return true
+}
+
+// defaultAuthScheme is the authentication scheme advertised to the
+// clients if FileManager.AuthScheme isn't set.
+const defaultAuthScheme = "Bearer"
+
+// unauthorized returns 401 Unauthorized for the requests without valid
+// credentials, with the WWW-Authenticate header telling the clients how
+// to authenticate. Authenticated requests which aren't allowed get 403
+// Forbidden instead.
+func unauthorized(c *RequestContext, w http.ResponseWriter) (int, error) {
+	scheme := c.AuthScheme
+	if scheme == "" {
+		scheme = defaultAuthScheme
+	}
+
+	w.Header().Set("WWW-Authenticate", scheme+` realm="File Manager"`)
+	return http.StatusUnauthorized, nil
+}
+
+// authCookieName is the name of the cookie set by the server when
+// the AuthCookie option is enabled.
+const authCookieName = "auth_token"
+
+// authCookie returns the authentication cookie with the token.
+func authCookie(c *RequestContext, token string, maxAge int) *http.Cookie {
+	path := c.RootURL()
+	if path == "" {
+		path = "/"
+	}
+
+	return &http.Cookie{
+		Name:     authCookieName,
+		Value:    token,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// errInvalidSigningMethod is returned when a token isn't signed with
+// the expected algorithm.
+var errInvalidSigningMethod = errors.New("invalid signing method")
+
+// errInvalidKey is returned when a token was signed with a key
+// which doesn't exist or whose grace period is over.
+var errInvalidKey = errors.New("invalid signing key")
+
 // claims is the JWT claims.
 type claims struct {
 	User
@@ -67,34 +269,56 @@ type claims struct {
 
 // printToken prints the final JWT token to the user.
 func printToken(c *RequestContext, w http.ResponseWriter) (int, error) {
+	signed, err := issueToken(c)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if c.AuthCookie {
+		http.SetCookie(w, authCookie(c, signed, 24*60*60))
+	}
+
+	// Writes the token.
+	w.Header().Set("Content-Type", "cty")
+	w.Write([]byte(signed))
+	return 0, nil
+}
+
+// issueToken returns a new signed token of c.User.
+func issueToken(c *RequestContext) (string, error) {
 	// Creates a copy of the user and removes it password
-	// hash so it never arrives to the user.
+	// hash so it never arrives to the user. The environment
+	// of the commands may hold secrets too.
 	u := User{}
 	u = *c.User
 	u.Password = ""
+	u.Environment = nil
+
+	// The ID makes every token unique, so revoking one doesn't
+	// revoke the others issued at the same time.
+	id, err := generateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
 
 	// Builds the claims.
+	now := time.Now()
 	claims := claims{
 		u,
 		c.NoAuth,
 		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+			Id:        hex.EncodeToString(id),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour * 24).Unix(),
 			Issuer:    "File Manager",
 		},
 	}
 
-	// Creates the token and signs it.
+	// Creates the token and signs it with the current key.
+	key := c.signingKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString(c.key)
-
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-
-	// Writes the token.
-	w.Header().Set("Content-Type", "cty")
-	w.Write([]byte(signed))
-	return 0, nil
+	token.Header["kid"] = keyID(key)
+	return token.SignedString(key)
 }
 
 type extractor []string
@@ -117,6 +341,18 @@ func (e extractor) ExtractToken(r *http.Request) (string, error) {
 	return cookie.Value, nil
 }
 
+// cookieExtractor extracts the token from the cookie set by the server.
+type cookieExtractor struct{}
+
+func (cookieExtractor) ExtractToken(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(authCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", request.ErrNoTokenInRequest
+	}
+
+	return cookie.Value, nil
+}
+
 // validateAuth is used to validate the authentication and returns the
 // User if it is valid.
 func validateAuth(c *RequestContext, r *http.Request) (bool, *User) {
@@ -125,22 +361,23 @@ func validateAuth(c *RequestContext, r *http.Request) (bool, *User) {
 		return true, c.User
 	}
 
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		return c.key, nil
+	claims, raw, err := parseRawToken(c, r)
+	if err != nil {
+		return false, nil
 	}
-	var claims claims
-	token, err := request.ParseFromRequestWithClaims(r,
-		extractor{},
-		&claims,
-		keyFunc,
-	)
 
-	if err != nil || !token.Valid {
+	if c.revoked(raw) {
 		return false, nil
 	}
 
+	if !c.active(claims, raw) {
+		return false, nil
+	}
+
+	// The ID must match too, so the tokens of a renamed user aren't
+	// accepted if another one takes its old username.
 	u, ok := c.Users[claims.User.Username]
-	if !ok {
+	if !ok || u.ID != claims.User.ID {
 		return false, nil
 	}
 
@@ -148,16 +385,64 @@ func validateAuth(c *RequestContext, r *http.Request) (bool, *User) {
 	return true, u
 }
 
-// hashPassword generates an hash from a password using bcrypt.
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// errInvalidToken is returned when a token is expired or invalid.
+var errInvalidToken = errors.New("invalid token")
+
+// parseToken extracts the token from the request and returns its
+// claims if it is valid.
+func parseToken(c *RequestContext, r *http.Request) (*claims, error) {
+	claims, _, err := parseRawToken(c, r)
+	return claims, err
 }
 
-// checkPasswordHash compares a password with an hash to check if they match.
-func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// parseRawToken is like parseToken but also returns the token.
+func parseRawToken(c *RequestContext, r *http.Request) (*claims, string, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		// Never trust the algorithm advertised by the token. We only
+		// sign the tokens using HS256.
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errInvalidSigningMethod
+		}
+
+		keys := c.verificationKeys()
+
+		// Tokens without key ID were signed before keys could be
+		// rotated, so they can only be verified with the current key.
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return keys[0], nil
+		}
+
+		for _, key := range keys {
+			if keyID(key) == kid {
+				return key, nil
+			}
+		}
+
+		return nil, errInvalidKey
+	}
+	// The cookie set by the server is only accepted if enabled.
+	var ext request.Extractor = extractor{}
+	if c.AuthCookie {
+		ext = request.MultiExtractor{extractor{}, cookieExtractor{}}
+	}
+
+	var claims claims
+	token, err := request.ParseFromRequestWithClaims(r,
+		ext,
+		&claims,
+		keyFunc,
+	)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !token.Valid {
+		return nil, "", errInvalidToken
+	}
+
+	return &claims, token.Raw, nil
 }
 
 // generateRandomBytes returns securely generated random bytes.
@@ -1,11 +1,15 @@
 package filemanager
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
 )
 
 var defaultCredentials = "{\"username\":\"admin\",\"password\":\"admin\"}"
@@ -90,3 +94,512 @@ func TestRenewHandler(t *testing.T) {
 		t.Errorf("Can't renew auth via cookie: got %v", w.Code)
 	}
 }
+
+func TestSigningMethod(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	u := *fm.Users["admin"]
+	u.Password = ""
+
+	newClaims := func() claims {
+		return claims{u, false, jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Issuer:    "File Manager",
+		}}
+	}
+
+	// Unsigned token with 'alg: none'.
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, newClaims()).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token signed with the right key but another HMAC algorithm.
+	hs512, err := jwt.NewWithClaims(jwt.SigningMethodHS512, newClaims()).SignedString(fm.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token claiming to use RSA, signed with the key as if it was HS256.
+	confused := jwt.NewWithClaims(jwt.SigningMethodRS256, newClaims())
+	unsigned, err := confused.SigningString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := jwt.SigningMethodHS256.Sign(unsigned, fm.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{none, hs512, unsigned + "." + signature} {
+		r, err := http.NewRequest("GET", "/api/auth/renew", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Wrong status code: got %v want %v", w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	token := w.Body.String()
+
+	renew := func() int {
+		r, err := http.NewRequest("GET", "/api/auth/renew", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// The old token must still be valid during the grace period.
+	if err = fm.RotateKey(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := renew(); code != http.StatusOK {
+		t.Errorf("Token rejected during the grace period: got %v", code)
+	}
+
+	// After another rotation without grace period, the first
+	// key is still in its grace period but the second isn't.
+	if err = fm.RotateKey(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := renew(); code != http.StatusOK {
+		t.Errorf("Token rejected during the grace period: got %v", code)
+	}
+
+	fm.oldKeys[0].Expires = time.Now().Add(-time.Second)
+
+	if code := renew(); code != http.StatusUnauthorized {
+		t.Errorf("Token accepted after the grace period: got %v", code)
+	}
+}
+
+func TestAuthCookie(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	getCookie := func() *http.Cookie {
+		r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == authCookieName {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	if getCookie() != nil {
+		t.Fatalf("Cookie was set without the option")
+	}
+
+	fm.AuthCookie = true
+	cookie := getCookie()
+
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure {
+		t.Fatalf("Wrong cookie: %v", cookie)
+	}
+
+	// The cookie alone authenticates the requests.
+	r, err := http.NewRequest("GET", "/api/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.AddCookie(&http.Cookie{Name: authCookieName, Value: cookie.Value})
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Can't authenticate via server cookie: got %v", w.Code)
+	}
+
+	// But only if the option is enabled.
+	fm.AuthCookie = false
+	r, err = http.NewRequest("GET", "/api/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.AddCookie(&http.Cookie{Name: authCookieName, Value: cookie.Value})
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Server cookie accepted without the option: got %v", w.Code)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	token := w.Body.String()
+
+	introspect := func(token string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/auth/introspect", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w = introspect(token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Couldn't introspect the token: got %v", w.Code)
+	}
+
+	var info tokenInfo
+	if err = json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IssuedAt == 0 || info.ExpiresAt <= info.IssuedAt {
+		t.Errorf("Wrong token times: %+v", info)
+	}
+
+	if info.Remaining <= 0 || info.Remaining > 24*60*60 {
+		t.Errorf("Wrong remaining time: %v", info.Remaining)
+	}
+
+	u := *fm.Users["admin"]
+	u.Password = ""
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{u, false, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		Issuer:    "File Manager",
+	}}).SignedString(fm.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{expired, "invalid.to.ken"} {
+		if w = introspect(token); w.Code != http.StatusUnauthorized {
+			t.Errorf("Wrong status code: got %v want %v", w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// The tokens revoked by logging out aren't valid anymore.
+	r, err = http.NewRequest("POST", "/api/auth/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	fm.ServeHTTP(httptest.NewRecorder(), r)
+
+	if w = introspect(token); w.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked token: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	get := func(url, token string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/api/me", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="File Manager"` {
+		t.Errorf("Without credentials: got %v with %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	fm.AuthScheme = "Token"
+	if w = get("/api/me", "invalid"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Token realm="File Manager"` {
+		t.Errorf("With an invalid token: got %v with %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// Authenticated requests which aren't allowed are forbidden.
+	r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	token := w.Body.String()
+
+	fm.Users["admin"].Admin = false
+	if w = get("/api/users/", token); w.Code != http.StatusForbidden || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("Without permission: got %v with %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	login := func() string {
+		r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	request := func(method, path, token string) int {
+		r, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	token, other := login(), login()
+	if token == other {
+		t.Fatal("Two logins got the same token")
+	}
+
+	if code := request("POST", "/api/auth/logout", token); code != http.StatusOK {
+		t.Fatalf("Logout: got %v", code)
+	}
+
+	if code := request("GET", "/api/me", token); code != http.StatusUnauthorized {
+		t.Errorf("Revoked token: got %v want %v", code, http.StatusUnauthorized)
+	}
+
+	if code := request("GET", "/api/me", other); code != http.StatusOK {
+		t.Errorf("Other token: got %v want %v", code, http.StatusOK)
+	}
+}
+
+func TestPasswordHashUpgrade(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	login := func() {
+		r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Login: got %v", w.Code)
+		}
+	}
+
+	stored := func() string {
+		var u User
+		if err := fm.db.One("ID", 1, &u); err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Password != fm.Users["admin"].Password {
+			t.Errorf("The hash in memory differs from the stored one")
+		}
+
+		return u.Password
+	}
+
+	// The hash doesn't change while the parameters don't.
+	before := stored()
+	login()
+	if stored() != before {
+		t.Errorf("The hash was upgraded without changing the parameters")
+	}
+
+	tests := []struct {
+		algorithm string
+		cost      int
+		prefix    string
+	}{
+		{HashBcrypt, 5, "$2a$05$"},
+		{HashArgon2id, 1, "$argon2id$v=19$m=65536,t=1,p=4$"},
+		{HashArgon2id, 2, "$argon2id$v=19$m=65536,t=2,p=4$"},
+		{HashBcrypt, 0, "$2a$10$"},
+	}
+
+	for _, test := range tests {
+		fm.PasswordHash, fm.PasswordCost = test.algorithm, test.cost
+
+		// The old hash is still checked with its own algorithm.
+		login()
+		if hash := stored(); !strings.HasPrefix(hash, test.prefix) {
+			t.Errorf("%s with cost %d: got hash %s", test.algorithm, test.cost, hash)
+		}
+
+		login()
+	}
+
+	if checkPasswordHash("wrong", stored()) {
+		t.Errorf("A wrong password matched the hash")
+	}
+
+	hash, err := hashPassword("admin", HashArgon2id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkPasswordHash("admin", hash) || checkPasswordHash("wrong", hash) {
+		t.Errorf("Argon2id hashes aren't checked correctly")
+	}
+
+	if _, err := hashPassword("admin", "md5", 0); err == nil {
+		t.Errorf("Unknown algorithms must fail")
+	}
+}
+
+func TestCheckPasswordCost(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		cost      int
+		valid     bool
+	}{
+		{"", 0, true},
+		{HashBcrypt, 31, true},
+		{HashBcrypt, 32, false},
+		{HashArgon2id, 0, true},
+		{HashArgon2id, 10, true},
+		{HashArgon2id, 1000, false},
+	}
+
+	for _, test := range tests {
+		if err := CheckPasswordCost(test.algorithm, test.cost); (err == nil) != test.valid {
+			t.Errorf("%s with cost %d: got %v", test.algorithm, test.cost, err)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.IdleTimeout = time.Hour
+
+	r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(defaultCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	token := w.Body.String()
+
+	request := func(path, token string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("/api/me", token); w.Code != http.StatusOK {
+		t.Fatalf("Active token: got %v", w.Code)
+	}
+
+	w = request("/api/auth/introspect", token)
+	var info tokenInfo
+	if err = json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IdleTimeout != 3600 || info.IdleRemaining <= 3500 || info.IdleRemaining > 3600 {
+		t.Errorf("Wrong idle times: %+v", info)
+	}
+
+	// The token was last used two hours ago.
+	last := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	if err = fm.state().Set(activityKey(token), []byte(last), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/api/me", "/api/auth/introspect", "/api/auth/renew"} {
+		if w := request(path, token); w.Code != http.StatusUnauthorized {
+			t.Errorf("Idle token on %s: got %v want %v", path, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// The tokens which were never used count from when they were issued.
+	u := *fm.Users["admin"]
+	u.Password = ""
+
+	old, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{u, false, jwt.StandardClaims{
+		IssuedAt:  time.Now().Add(-2 * time.Hour).Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Issuer:    "File Manager",
+	}}).SignedString(fm.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := request("/api/me", old); w.Code != http.StatusUnauthorized {
+		t.Errorf("Unused old token: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	fm.IdleTimeout = 0
+	if w := request("/api/me", old); w.Code != http.StatusOK {
+		t.Errorf("Without idle timeout: got %v want %v", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,204 @@
+package filemanager
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	errRestoreReadOnlyDatabase = errors.New("the database is opened in read-only mode")
+	errRestoreNotReadOnly      = errors.New("the read-only mode must be enabled to restore the database")
+	errBackupWithoutAdmin      = errors.New("the backup has no administrators")
+)
+
+// backupHandler lets admins download a backup of the database, with the
+// users, the shares and the settings, with a GET request and restore one
+// with a PUT request.
+func backupHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return backupGetHandler(c, w, r)
+	case http.MethodPut:
+		return backupPutHandler(c, w, r)
+	}
+
+	return methodNotAllowed(w, apiMethods["backup"]...)
+}
+
+// backupGetHandler streams a copy of the database. It is taken in a read
+// transaction so it is consistent and the server keeps working.
+func backupGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	err := c.db.Bolt.View(func(tx *bolt.Tx) error {
+		name := "filemanager-" + time.Now().Format("20060102150405") + ".db"
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+
+		_, err := tx.WriteTo(w)
+		return err
+	})
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// backupPutHandler replaces the contents of the database with the backup
+// in the body of the request. As it changes everything at once, the
+// read-only mode must be enabled and it stays enabled afterwards so the
+// admins can check the result. The tokens signed with keys which aren't
+// in the backup stop being valid.
+func backupPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.DatabaseOptions.ReadOnly {
+		return http.StatusConflict, errRestoreReadOnlyDatabase
+	}
+
+	enabled, message := c.ReadOnlyMode()
+	if !enabled {
+		return http.StatusConflict, errRestoreNotReadOnly
+	}
+
+	dir, err := c.tempDir()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	_, err = io.Copy(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	backup, err := storm.Open(path, storm.BoltOptions(0600, &bolt.Options{
+		Timeout:  time.Second,
+		ReadOnly: true,
+	}))
+
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	defer backup.Close()
+
+	// Restoring a database without administrators would lock everyone out.
+	var users []User
+	if err = backup.All(&users); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	admin := false
+	for _, u := range users {
+		admin = admin || u.Admin
+	}
+
+	if !admin {
+		return http.StatusBadRequest, errBackupWithoutAdmin
+	}
+
+	// Every bucket is replaced in a single transaction so a failed
+	// restore leaves the database untouched.
+	err = backup.Bolt.View(func(stx *bolt.Tx) error {
+		return c.db.Bolt.Update(func(dtx *bolt.Tx) error {
+			var names [][]byte
+			dtx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, append([]byte{}, name...))
+				return nil
+			})
+
+			for _, name := range names {
+				if err := dtx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(b, nb)
+			})
+		})
+	})
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err = c.reloadDatabase(message); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// reloadDatabase reloads the state kept in memory, which are the keys, the
+// commands and the users, after the database was restored. The read-only
+// mode is kept enabled with the message.
+func (m *FileManager) reloadDatabase(message string) error {
+	m.keyMu.Lock()
+	m.oldKeys = nil
+	err := m.loadKeys(m.db)
+	m.keyMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err = m.SetReadOnlyMode(true, message); err != nil {
+		return err
+	}
+
+	var commands map[string][]string
+	err = m.db.Get("config", "commands", &commands)
+	if err == nil {
+		m.Commands = commands
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+
+	// The backup may have been made by an older version.
+	if err = migrateDatabase(m.db, m.DatabaseOptions); err != nil {
+		return err
+	}
+
+	var users []User
+	if err = m.db.All(&users); err != nil {
+		return err
+	}
+
+	for name := range m.Users {
+		delete(m.Users, name)
+	}
+
+	for i := range users {
+		m.Users[users[i].Username] = &users[i]
+	}
+
+	return nil
+}
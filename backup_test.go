@@ -0,0 +1,76 @@
+package filemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "/api/backup", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("GET", "")
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Fatalf("Backup: got %v with %d bytes", w.Code, w.Body.Len())
+	}
+
+	backup := w.Body.String()
+
+	// A user created after the backup is gone once it is restored.
+	u := &User{Username: "later", Password: "hash", FileSystem: fm.Users["admin"].FileSystem}
+	if err := fm.db.Save(u); err != nil {
+		t.Fatal(err)
+	}
+	fm.Users[u.Username] = u
+
+	if w = do("PUT", backup); w.Code != http.StatusConflict {
+		t.Errorf("Restore outside of read-only mode: expected 409, got %v", w.Code)
+	}
+
+	if err := fm.SetReadOnlyMode(true, "Restoring"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w = do("PUT", "not a database"); w.Code != http.StatusBadRequest {
+		t.Errorf("Restore of invalid backup: expected 400, got %v", w.Code)
+	}
+
+	if w = do("PUT", backup); w.Code != http.StatusOK {
+		t.Fatalf("Restore: got %v: %s", w.Code, w.Body.String())
+	}
+
+	if _, ok := fm.Users["later"]; ok {
+		t.Error("User created after the backup still exists in memory")
+	}
+
+	var users []User
+	if err := fm.db.All(&users); err != nil || len(users) != 1 {
+		t.Errorf("Expected only the admin in the database, got %v: %v", len(users), err)
+	}
+
+	if enabled, message := fm.ReadOnlyMode(); !enabled || message != "Restoring" {
+		t.Errorf("Read-only mode changed by the restore: %v %q", enabled, message)
+	}
+
+	// The restored database has the same key, so the token still works.
+	fm.Users["admin"].Admin = false
+	if w = do("GET", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Backup by non admin: expected 403, got %v", w.Code)
+	}
+}
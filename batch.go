@@ -0,0 +1,162 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// batchMaxRequests is the maximum number of sub-requests in a batch.
+const batchMaxRequests = 20
+
+var (
+	errBatchTooLarge = errors.New("the batch has too many requests")
+	errBatchRouter   = errors.New("this router can't be used in a batch")
+)
+
+// batchRouters are the routers which can be used in a batch. The others
+// stream their responses, upgrade the connection, send files or are
+// batches, so their responses can't be kept.
+var batchRouters = map[string]bool{
+	"checksum": true,
+	"link":     true,
+	"metadata": true,
+	"action":   true,
+	"jobs":     true,
+	"recent":   true,
+	"resource": true,
+	"users":    true,
+	"me":       true,
+	"allowed":  true,
+	"settings": true,
+	"config":   true,
+	"share":    true,
+	"shares":   true,
+	"exif":     true,
+	"history":  true,
+}
+
+// batchRequest is a sub-request of a batch. Body is sent as is, unless
+// it is a JSON string, whose contents are sent instead.
+type batchRequest struct {
+	Method string          `json:"method"`
+	Router string          `json:"router"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// batchResult is the response to a sub-request of a batch. Body is the
+// JSON returned by the handler or, if it isn't JSON, a string.
+type batchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// batchRecorder keeps the response of a sub-request of a batch.
+type batchRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *batchRecorder) Header() http.Header {
+	return b.header
+}
+
+func (b *batchRecorder) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *batchRecorder) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// batchHandler runs the sub-requests in the body one after the other
+// with the credentials of the batch request and returns their results
+// in the same order. A failed sub-request doesn't stop the others.
+func batchHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	var requests []batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if len(requests) > batchMaxRequests {
+		return http.StatusRequestEntityTooLarge, errBatchTooLarge
+	}
+
+	results := make([]batchResult, len(requests))
+	for i, req := range requests {
+		results[i] = runBatchRequest(c, r, req)
+	}
+
+	return renderJSON(w, results)
+}
+
+// runBatchRequest runs a sub-request of a batch through apiHandler, so
+// it is authenticated and its permissions checked as usual. The
+// impersonation of the batch applies to it too.
+func runBatchRequest(c *RequestContext, r *http.Request, req batchRequest) batchResult {
+	router := strings.Trim(req.Router, "/")
+	if !batchRouters[router] {
+		return batchResult{Status: http.StatusBadRequest, Error: errBatchRouter.Error()}
+	}
+
+	body := []byte(req.Body)
+	var text string
+	if err := json.Unmarshal(req.Body, &text); err == nil {
+		body = []byte(text)
+	}
+
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	sub, err := http.NewRequest(method, "/"+router+"/"+strings.TrimPrefix(req.Path, "/"), bytes.NewReader(body))
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	sub = sub.WithContext(r.Context())
+	sub.RemoteAddr = r.RemoteAddr
+	for _, name := range []string{"Authorization", "Cookie", "User-Agent", "X-Forwarded-For", "X-Real-Ip", impersonateHeader} {
+		if values, ok := r.Header[name]; ok {
+			sub.Header[name] = values
+		}
+	}
+
+	rec := &batchRecorder{header: http.Header{}}
+	code, err := apiHandler(&RequestContext{FileManager: c.FileManager}, rec, sub)
+
+	if code >= 400 {
+		res := batchResult{Status: code, Error: http.StatusText(code)}
+		if err != nil {
+			res.Error = err.Error()
+		}
+
+		return res
+	}
+
+	res := batchResult{Status: rec.code}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+
+	if rec.body.Len() == 0 {
+		return res
+	}
+
+	if json.Valid(rec.body.Bytes()) {
+		res.Body = json.RawMessage(rec.body.Bytes())
+	} else {
+		res.Body, _ = json.Marshal(rec.body.String())
+	}
+
+	return res
+}
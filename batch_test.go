@@ -0,0 +1,116 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := os.MkdirAll(scope, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(scope, "file.txt"), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(token, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/api/batch/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("", "[]"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Batch without token: expected 401, got %v", w.Code)
+	}
+
+	w := do(token, `[
+		{"method": "GET", "router": "me"},
+		{"method": "PUT", "router": "resource", "path": "/file.txt", "body": "new"},
+		{"method": "GET", "router": "resource", "path": "/missing.txt"},
+		{"method": "GET", "router": "command", "path": "/"},
+		{"method": "GET", "router": "users"}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Batch: got %v: %s", w.Code, w.Body.String())
+	}
+
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusBadRequest, http.StatusOK}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+
+	for i, code := range expected {
+		if results[i].Status != code {
+			t.Errorf("Result %d: expected %v, got %v (%s)", i, code, results[i].Status, results[i].Error)
+		}
+	}
+
+	var me profile
+	if err := json.Unmarshal(results[0].Body, &me); err != nil || me.Username != "admin" {
+		t.Errorf("Expected the profile of the admin, got %s: %v", results[0].Body, err)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(scope, "file.txt")); string(data) != "new" {
+		t.Errorf("Expected the file to be updated, got %q", data)
+	}
+
+	// The permissions of each sub-request are still checked.
+	fm.Users["admin"].Admin = false
+	w = do(token, `[{"method": "GET", "router": "users"}]`)
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Status != http.StatusForbidden {
+		t.Errorf("Expected the users listing to be forbidden, got %v", results)
+	}
+
+	// The routers which stream their responses can't be used.
+	w = do(token, `[
+		{"router": "download", "path": "/file.txt"},
+		{"router": "export"},
+		{"router": "manifest", "path": "/"},
+		{"router": "thumbnail", "path": "/file.txt"},
+		{"router": "resource/x"}
+	]`)
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, res := range results {
+		if res.Status != http.StatusBadRequest {
+			t.Errorf("Streaming router %d: expected 400, got %v", i, res.Status)
+		}
+	}
+
+	if w = do(token, "["+strings.Repeat(`{"router": "me"},`, batchMaxRequests)+`{"router": "me"}]`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Batch too large: expected 413, got %v", w.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package filemanager
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Branding customizes the name and the logo shown on the login page and
+// adds a button to it to sign in through a single sign-on provider, such
+// as an authentication proxy. The values are escaped by the templates.
+type Branding struct {
+	// Name replaces 'File Manager' on the login page and in the title of
+	// the pages.
+	Name string
+	// Logo is the URL of the logo shown on the login page.
+	Logo string
+	// SSOURL is the URL the single sign-on button links to. The button is
+	// only shown if it is set.
+	SSOURL string
+	// SSOLabel is the text of the button, which defaults to the one of
+	// the language of the user.
+	SSOLabel string
+}
+
+// Title returns the title of the pages.
+func (b Branding) Title() string {
+	if b.Name == "" {
+		return "File Manager"
+	}
+
+	return b.Name
+}
+
+// Validate checks if the URLs are absolute HTTP(S) URLs or paths, so no
+// script can be run from them.
+func (b Branding) Validate() error {
+	for _, raw := range []string{b.Logo, b.SSOURL} {
+		if raw != "" && !safeBrandingURL(raw) {
+			return fmt.Errorf("invalid branding URL: %s", raw)
+		}
+	}
+
+	return nil
+}
+
+// branding returns the branding of the pages. The single sign-on button
+// starts the OpenID Connect login if it is enabled and the button doesn't
+// link elsewhere.
+func (m *FileManager) branding() Branding {
+	b := m.Branding.sanitized()
+	if b.SSOURL == "" && m.OIDC != nil && !m.NoAuth {
+		b.SSOURL = m.RootURL() + "/api/auth/oidc"
+	}
+
+	return b
+}
+
+// sanitized returns the branding without the URLs which aren't safe, in
+// case it wasn't validated.
+func (b Branding) sanitized() Branding {
+	if !safeBrandingURL(b.Logo) {
+		b.Logo = ""
+	}
+
+	if !safeBrandingURL(b.SSOURL) {
+		b.SSOURL = ""
+	}
+
+	return b
+}
+
+func safeBrandingURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "":
+		return u.Host == "" && strings.HasPrefix(u.Path, "/")
+	}
+
+	return false
+}
@@ -9,5 +9,11 @@ fi
 rm -rf assets/dist
 npm run build
 
+# Precompress the scripts and the styles so they're served compressed
+find assets/dist -type f \( -name '*.js' -o -name '*.css' \) -exec gzip -k -9 {} \;
+if [ -x "$(command -v brotli)" ]; then
+  find assets/dist -type f \( -name '*.js' -o -name '*.css' \) -exec brotli -k {} \;
+fi
+
 # Embed the assets using rice
 rice embed-go
@@ -4,6 +4,7 @@
 package filemanager
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/hacdias/filemanager"
@@ -50,5 +51,17 @@ func setup(c *caddy.Controller) error {
 		return plugin{Configs: configs, Next: next}
 	})
 
+	// Closes the databases on shut down and restart so the
+	// new instances can open them.
+	for _, fm := range configs {
+		fm := fm
+		c.OnShutdown(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), fm.ShutdownTimeout)
+			defer cancel()
+
+			return fm.Shutdown(ctx)
+		})
+	}
+
 	return nil
 }
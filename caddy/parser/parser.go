@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hacdias/filemanager"
 	"github.com/hacdias/fileutils"
@@ -42,7 +43,76 @@ func Parse(c *caddy.Controller, plugin string) ([]*filemanager.FileManager, erro
 		baseURL := "/"
 		scope := "."
 		database := ""
+		dbOptions := filemanager.DatabaseOptions{}
 		noAuth := false
+		var maxUploadSize int64
+		var maxPendingUploadSize int64
+		var idempotencyWindow time.Duration
+		maxDepth := -1
+		maxPreviewSize := int64(-1)
+		maxURLLength := -1
+		maxHeaderBytes := -1
+		maxQueryParams := -1
+		deleteConfirm := 0
+		contentTypes := map[string]string{}
+		var shareExpiry, maxShareExpiry, maxLinkExpiry time.Duration
+		var scanner filemanager.Scanner
+		ignore := []string{}
+		uploadPolicy := filemanager.UploadPolicy{}
+		trustedProxies := []string{}
+		stateStore := ""
+		smtpURL := ""
+		oidc := &filemanager.OIDC{}
+		createdTemplate := ""
+		expiringTemplate := ""
+		actions := []filemanager.Action{}
+		downloadHeaders := []filemanager.HeaderRule{}
+		changeFeed := false
+		authCookie := false
+		debug := false
+		readOnly := false
+		readOnlyMessage := ""
+		downloadName := ""
+		tempDir := ""
+		assetsDir := ""
+		limits := filemanager.Limits{}
+		homeSkeleton := ""
+		homeArchive := ""
+		homeRoot := ""
+		removeHomes := false
+		noDotDirs := false
+		removeDanglingShares := false
+		redactGPS := false
+		authScheme := ""
+		readme := ""
+		landingPath := ""
+		commandHistory := 100
+		resolveSymlinks := false
+		shareFolder := ""
+		passwordHash := ""
+		noCaseCollisions := false
+		xattrs := false
+		showOwners := false
+		brandName := ""
+		brandLogo := ""
+		ssoURL := ""
+		ssoLabel := ""
+		var uploadHook *filemanager.Action
+		logRedact := []string{}
+		scopePolicy := ""
+		checksums := []string{}
+		archiveFormats := []string{}
+		var thumbnailMaxAge time.Duration
+		var shareCleanupInterval time.Duration
+		var maxShares int
+		var passwordCost int
+		var shareExpiryNotice time.Duration
+		var idleTimeout time.Duration
+		var maxDownloads int
+		var rateLimit int
+		var downloadRateLimit int
+		var rateBurst int
+		var shutdownTimeout time.Duration
 
 		if plugin != "" {
 			baseURL = "/admin"
@@ -77,6 +147,48 @@ func Parse(c *caddy.Controller, plugin string) ([]*filemanager.FileManager, erro
 				}
 
 				database = c.Val()
+			case "database_timeout":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				dbOptions.Timeout, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "database_readonly":
+				if !c.NextArg() {
+					dbOptions.ReadOnly = true
+					continue
+				}
+
+				dbOptions.ReadOnly, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "database_compact":
+				if !c.NextArg() {
+					dbOptions.Compact = true
+					continue
+				}
+
+				dbOptions.Compact, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "database_migrate_defaults":
+				migrateDefaults := true
+				if c.NextArg() {
+					migrateDefaults, err = strconv.ParseBool(c.Val())
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				dbOptions.UserDefaults = nil
+				if migrateDefaults {
+					dbOptions.UserDefaults = &u
+				}
 			case "locale":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
@@ -141,6 +253,622 @@ func Parse(c *caddy.Controller, plugin string) ([]*filemanager.FileManager, erro
 				}
 
 				u.CSS = string(css)
+			case "max_upload_size":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxUploadSize, err = strconv.ParseInt(c.Val(), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+			case "max_pending_upload_size":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxPendingUploadSize, err = strconv.ParseInt(c.Val(), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+			case "idempotency_window":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				idempotencyWindow, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_depth":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxDepth, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "delete_confirm":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				deleteConfirm, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "share_expiry":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				shareExpiry, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_share_expiry":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxShareExpiry, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_link_expiry":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxLinkExpiry, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "action":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+
+				action, err := filemanager.ParseAction(args[0] + "=" + strings.Join(args[1:], " "))
+				if err != nil {
+					return nil, err
+				}
+
+				actions = append(actions, action)
+			case "download_header":
+				args := c.RemainingArgs()
+				if len(args) < 3 {
+					return nil, c.ArgErr()
+				}
+
+				rule, err := filemanager.ParseHeaderRule(args[0] + "=" + args[1] + ": " + strings.Join(args[2:], " "))
+				if err != nil {
+					return nil, err
+				}
+
+				downloadHeaders = append(downloadHeaders, rule)
+			case "ignore":
+				patterns := c.RemainingArgs()
+				if len(patterns) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				for _, pattern := range patterns {
+					if _, err = filepath.Match(pattern, ""); err != nil {
+						return nil, err
+					}
+				}
+
+				ignore = append(ignore, patterns...)
+			case "state_store":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				stateStore = c.Val()
+			case "smtp":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				smtpURL = c.Val()
+			case "share_created_template", "share_expiring_template":
+				option := c.Val()
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				data, err := ioutil.ReadFile(c.Val())
+				if err != nil {
+					return nil, err
+				}
+
+				if option == "share_created_template" {
+					createdTemplate = string(data)
+				} else {
+					expiringTemplate = string(data)
+				}
+			case "trusted_proxies":
+				cidrs := c.RemainingArgs()
+				if len(cidrs) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				trustedProxies = append(trustedProxies, cidrs...)
+			case "upload_allow":
+				types := c.RemainingArgs()
+				if len(types) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				uploadPolicy.Allow = append(uploadPolicy.Allow, types...)
+			case "upload_deny":
+				types := c.RemainingArgs()
+				if len(types) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				uploadPolicy.Deny = append(uploadPolicy.Deny, types...)
+			case "limit":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+
+				n, err := strconv.Atoi(args[1])
+				if err != nil {
+					return nil, err
+				}
+
+				if err = limits.Set(args[0], n); err != nil {
+					return nil, err
+				}
+			case "limit_wait":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				limits.Wait, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "assets_dir":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				assetsDir = c.Val()
+			case "temp_dir":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				tempDir = c.Val()
+			case "home_skeleton":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				homeSkeleton = c.Val()
+			case "home_archive":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				homeArchive = c.Val()
+			case "home_root":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				homeRoot = c.Val()
+			case "remove_homes":
+				if !c.NextArg() {
+					removeHomes = true
+					continue
+				}
+
+				removeHomes, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "no_dot_dirs":
+				if !c.NextArg() {
+					noDotDirs = true
+					continue
+				}
+
+				noDotDirs, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "thumbnail_max_age":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				thumbnailMaxAge, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "share_cleanup_interval":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				shareCleanupInterval, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "remove_dangling_shares":
+				if !c.NextArg() {
+					removeDanglingShares = true
+					continue
+				}
+
+				removeDanglingShares, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "redact_gps":
+				if !c.NextArg() {
+					redactGPS = true
+					continue
+				}
+
+				redactGPS, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "auth_scheme":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				authScheme = c.Val()
+			case "readme":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				readme = c.Val()
+			case "landing_path":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				landingPath = c.Val()
+			case "command_history":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				commandHistory, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "resolve_symlinks":
+				if !c.NextArg() {
+					resolveSymlinks = true
+					continue
+				}
+
+				resolveSymlinks, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_shares":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxShares, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_preview_size":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxPreviewSize, err = strconv.ParseInt(c.Val(), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+			case "share_folder":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				shareFolder = c.Val()
+			case "password_hash":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				passwordHash = c.Val()
+				if passwordHash != filemanager.HashBcrypt && passwordHash != filemanager.HashArgon2id {
+					return nil, fmt.Errorf("invalid password hash: %s", passwordHash)
+				}
+			case "password_cost":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				passwordCost, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_url_length":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxURLLength, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_header_bytes":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxHeaderBytes, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "max_query_params":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxQueryParams, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "share_expiry_notice":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				shareExpiryNotice, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "no_case_collisions":
+				if !c.NextArg() {
+					noCaseCollisions = true
+					continue
+				}
+
+				noCaseCollisions, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "xattrs":
+				if !c.NextArg() {
+					xattrs = true
+					continue
+				}
+
+				xattrs, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "show_owners":
+				if !c.NextArg() {
+					showOwners = true
+					continue
+				}
+
+				showOwners, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "brand_name":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				brandName = c.Val()
+			case "brand_logo":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				brandLogo = c.Val()
+			case "sso_url":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				ssoURL = c.Val()
+			case "sso_label":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				ssoLabel = c.Val()
+			case "oidc":
+				args := c.RemainingArgs()
+				if len(args) != 4 {
+					return nil, c.ArgErr()
+				}
+
+				oidc.Issuer, oidc.ClientID, oidc.ClientSecret, oidc.RedirectURL = args[0], args[1], args[2], args[3]
+			case "oidc_scopes":
+				oidc.Scopes = c.RemainingArgs()
+				if len(oidc.Scopes) == 0 {
+					return nil, c.ArgErr()
+				}
+			case "oidc_claims":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+
+				oidc.UsernameClaim, oidc.GroupsClaim = args[0], args[1]
+			case "oidc_admin_groups":
+				oidc.AdminGroups = c.RemainingArgs()
+			case "oidc_allowed_groups":
+				oidc.AllowedGroups = c.RemainingArgs()
+			case "oidc_provision":
+				oidc.AutoProvision = true
+				if c.NextArg() {
+					oidc.Template = c.Val()
+				}
+			case "idle_timeout":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				idleTimeout, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "upload_hook":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				action, err := filemanager.ParseAction("upload=" + strings.Join(args, " "))
+				if err != nil {
+					return nil, err
+				}
+
+				uploadHook = &action
+			case "log_redact":
+				names := c.RemainingArgs()
+				if len(names) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				logRedact = append(logRedact, names...)
+			case "max_downloads":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				maxDownloads, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "scope_policy":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				scopePolicy = c.Val()
+			case "rate_limit":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				rateLimit, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "download_rate_limit":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				downloadRateLimit, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "rate_burst":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				rateBurst, err = strconv.Atoi(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "clamd":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				scanner = &filemanager.ClamdScanner{Address: c.Val()}
+			case "content_type":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+
+				ext := strings.ToLower(args[0])
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+
+				contentTypes[ext] = args[1]
+			case "shutdown_timeout":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				shutdownTimeout, err = time.ParseDuration(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "checksums":
+				checksums = c.RemainingArgs()
+				if len(checksums) == 0 {
+					return nil, c.ArgErr()
+				}
+			case "archive_formats":
+				archiveFormats = c.RemainingArgs()
+				if len(archiveFormats) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				if err = filemanager.CheckArchiveFormats(archiveFormats); err != nil {
+					return nil, err
+				}
+			case "download_name":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+
+				downloadName = c.Val()
+			case "read_only":
+				readOnly = true
+				readOnlyMessage = strings.Join(c.RemainingArgs(), " ")
+			case "debug":
+				if !c.NextArg() {
+					debug = true
+					continue
+				}
+
+				debug, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "auth_cookie":
+				if !c.NextArg() {
+					authCookie = true
+					continue
+				}
+
+				authCookie, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
+			case "change_feed":
+				if !c.NextArg() {
+					changeFeed = true
+					continue
+				}
+
+				changeFeed, err = strconv.ParseBool(c.Val())
+				if err != nil {
+					return nil, err
+				}
 			case "no_auth":
 				if !c.NextArg() {
 					noAuth = true
@@ -184,7 +912,7 @@ func Parse(c *caddy.Controller, plugin string) ([]*filemanager.FileManager, erro
 		}
 
 		u.FileSystem = fileutils.Dir(scope)
-		m, err := filemanager.New(database, u)
+		m, err := filemanager.NewWithOptions(database, u, dbOptions)
 
 		switch plugin {
 		case "hugo":
@@ -222,6 +950,137 @@ func Parse(c *caddy.Controller, plugin string) ([]*filemanager.FileManager, erro
 		}
 
 		m.NoAuth = noAuth
+		m.MaxUploadSize = maxUploadSize
+		m.MaxPendingUploadSize = maxPendingUploadSize
+		m.IdempotencyWindow = idempotencyWindow
+		m.DeleteConfirmThreshold = deleteConfirm
+		m.ContentTypes = contentTypes
+		m.DefaultShareExpiry = shareExpiry
+		m.MaxShareExpiry = maxShareExpiry
+		m.MaxLinkExpiry = maxLinkExpiry
+		m.Scanner = scanner
+		m.Ignore = ignore
+		m.UploadPolicy = uploadPolicy
+		m.Actions = actions
+		m.DownloadHeaders = downloadHeaders
+		m.AuthCookie = authCookie
+		m.Debug = debug
+		m.DownloadName = downloadName
+		m.HomeSkeleton = homeSkeleton
+		m.RemoveHomes = removeHomes
+		m.HomeArchive = homeArchive
+		m.HomeRoot = homeRoot
+		m.AssetsDir = assetsDir
+		m.NoDotDirs = noDotDirs
+		m.ThumbnailMaxAge = thumbnailMaxAge
+		m.ShareCleanupInterval = shareCleanupInterval
+		m.RemoveDanglingShares = removeDanglingShares
+		m.RedactGPS = redactGPS
+		m.AuthScheme = authScheme
+		m.ReadmeName = readme
+		m.LandingPath = landingPath
+		m.CommandHistory = commandHistory
+		m.ResolveSymlinks = resolveSymlinks
+		m.MaxShares = maxShares
+		m.MaxDownloads = maxDownloads
+		m.RateLimit = rateLimit
+		m.DownloadRateLimit = downloadRateLimit
+		m.RateBurst = rateBurst
+		m.ShareFolder = shareFolder
+		m.PasswordHash = passwordHash
+		m.PasswordCost = passwordCost
+		m.ShareExpiryNotice = shareExpiryNotice
+		m.NoCaseCollisions = noCaseCollisions
+		m.Xattrs = xattrs
+		m.ShowOwners = showOwners
+		m.IdleTimeout = idleTimeout
+		m.Branding.Name = brandName
+		m.Branding.Logo = brandLogo
+		m.Branding.SSOURL = ssoURL
+		m.Branding.SSOLabel = ssoLabel
+
+		if err = m.Branding.Validate(); err != nil {
+			return nil, err
+		}
+
+		if err = filemanager.CheckPasswordCost(m.PasswordHash, m.PasswordCost); err != nil {
+			return nil, err
+		}
+
+		m.UploadHook = uploadHook
+		m.LogRedact = logRedact
+		m.Limits = limits
+		m.ChecksumAlgorithms = checksums
+		m.ArchiveFormats = archiveFormats
+
+		if err = m.SetTempDir(tempDir); err != nil {
+			return nil, err
+		}
+
+		if err = m.CheckScopes(scopePolicy); err != nil {
+			return nil, err
+		}
+
+		if err = m.SetTrustedProxies(trustedProxies); err != nil {
+			return nil, err
+		}
+
+		if stateStore != "" {
+			if m.State, err = filemanager.NewRedisStore(stateStore); err != nil {
+				return nil, err
+			}
+		}
+
+		if smtpURL != "" {
+			notifier, err := filemanager.NewSMTPNotifier(smtpURL)
+			if err != nil {
+				return nil, err
+			}
+
+			notifier.CreatedTemplate = createdTemplate
+			notifier.ExpiringTemplate = expiringTemplate
+			m.Notifier = notifier
+		}
+
+		if oidc.Issuer != "" {
+			m.OIDC = oidc
+		}
+
+		if shutdownTimeout > 0 {
+			m.ShutdownTimeout = shutdownTimeout
+		}
+
+		if maxDepth >= 0 {
+			m.MaxDepth = maxDepth
+		}
+
+		if maxPreviewSize >= 0 {
+			m.MaxPreviewSize = maxPreviewSize
+		}
+
+		if maxURLLength >= 0 {
+			m.MaxURLLength = maxURLLength
+		}
+
+		if maxHeaderBytes >= 0 {
+			m.MaxHeaderBytes = maxHeaderBytes
+		}
+
+		if maxQueryParams >= 0 {
+			m.MaxQueryParams = maxQueryParams
+		}
+
+		if readOnly {
+			if err = m.SetReadOnlyMode(true, readOnlyMessage); err != nil {
+				return nil, err
+			}
+		}
+
+		if changeFeed {
+			if err = m.EnableChangeFeed(); err != nil {
+				return nil, err
+			}
+		}
 		m.SetBaseURL(baseURL)
 		m.SetPrefixURL(strings.TrimSuffix(caddyConf.Addr.Path, "/"))
 
@@ -0,0 +1,71 @@
+package filemanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// checkCaseCollision fails if NoCaseCollisions is set and p, a virtual
+// path of the user, differs only in case from an existing file or
+// directory other than except, which allows renames that only change the
+// case of a name.
+func (m *FileManager) checkCaseCollision(u *User, p, except string) error {
+	if !m.NoCaseCollisions {
+		return nil
+	}
+
+	if other, ok := caseCollision(u, p, except); ok {
+		return fmt.Errorf("%s: the name differs only in case from %s", p, other)
+	}
+
+	return nil
+}
+
+// caseCollision returns the existing entry whose path differs only in case
+// from p, if there is one. The directories are read instead of using Stat
+// so it works the same on case-insensitive file systems.
+func caseCollision(u *User, p, except string) (string, bool) {
+	current := "/"
+
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		f, err := u.FileSystem.OpenFile(current, os.O_RDONLY, 0)
+		if err != nil {
+			return "", false
+		}
+
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return "", false
+		}
+
+		exists := false
+		for _, other := range names {
+			if other == name {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			for _, other := range names {
+				if strings.EqualFold(other, name) && path.Join(current, other) != except {
+					return path.Join(current, other), true
+				}
+			}
+
+			// The rest of the path doesn't exist either.
+			return "", false
+		}
+
+		current = path.Join(current, name)
+	}
+
+	return "", false
+}
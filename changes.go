@@ -0,0 +1,233 @@
+package filemanager
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// changeFeedSize is the number of changes kept in memory.
+const changeFeedSize = 1024
+
+// change is a modification made to a file or directory.
+type change struct {
+	// Cursor is the position of this change in the feed.
+	Cursor uint64 `json:"cursor"`
+	// Op is the kind of change: create, modify or delete.
+	Op string `json:"op"`
+	// Path is the relative path to user's virtual File System.
+	Path string `json:"path"`
+	// Time is when the change was noticed.
+	Time time.Time `json:"time"`
+	// path is the absolute path of the changed file.
+	path string
+}
+
+// changeFeed records the changes made to the watched directories in a
+// ring buffer so clients can poll them incrementally.
+type changeFeed struct {
+	sync.RWMutex
+	watcher  *fsnotify.Watcher
+	maxDepth int
+	changes  [changeFeedSize]change
+	// next is the cursor of the next change.
+	next uint64
+}
+
+// changesResponse is the response of the changes API.
+type changesResponse struct {
+	// Cursor should be sent as 'since' on the next request.
+	Cursor uint64 `json:"cursor"`
+	// Truncated indicates that some changes were already discarded
+	// from the feed so the client should list everything again.
+	Truncated bool      `json:"truncated"`
+	Changes   []*change `json:"changes"`
+}
+
+// EnableChangeFeed starts watching the scopes of every user so their
+// changes can be consulted through the API.
+func (m *FileManager) EnableChangeFeed() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	m.changes = &changeFeed{
+		watcher:  watcher,
+		maxDepth: m.MaxDepth,
+	}
+	go m.changes.run()
+
+	if err := m.changes.watch(string(m.DefaultUser.FileSystem)); err != nil {
+		return err
+	}
+
+	for _, u := range m.Users {
+		if err := m.changes.watch(string(u.FileSystem)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watch adds a directory and all of its subdirectories to the watcher.
+func (f *changeFeed) watch(scope string) error {
+	scope, err := filepath.Abs(scope)
+	if err != nil {
+		return err
+	}
+
+	truncated, err := walk(scope, scope, f.maxDepth, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		return f.watcher.Add(path)
+	})
+
+	if truncated {
+		log.Printf("[WARNING] Not watching the directories of %s deeper than %d levels", scope, f.maxDepth)
+	}
+
+	return err
+}
+
+// run records the events received by the watcher until it is closed.
+func (f *changeFeed) run() {
+	for {
+		select {
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+
+			f.record(event)
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Print(err)
+		}
+	}
+}
+
+// record adds an event to the feed. New directories start being watched.
+func (f *changeFeed) record(event fsnotify.Event) {
+	var op string
+
+	switch {
+	case event.Op&fsnotify.Create != 0:
+		op = "create"
+
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := f.watch(event.Name); err != nil {
+				log.Print(err)
+			}
+		}
+	case event.Op&fsnotify.Write != 0:
+		op = "modify"
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		// Renames are followed by a create event with the new name.
+		op = "delete"
+	default:
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.changes[f.next%changeFeedSize] = change{
+		Cursor: f.next + 1,
+		Op:     op,
+		Time:   time.Now(),
+		path:   event.Name,
+	}
+	f.next++
+}
+
+// since returns the changes made to the files inside scope after
+// the cursor, as long as the user is allowed to see them.
+func (f *changeFeed) since(cursor uint64, u *User) (*changesResponse, error) {
+	scope, err := filepath.Abs(string(u.FileSystem))
+	if err != nil {
+		return nil, err
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+
+	res := &changesResponse{
+		Cursor:  f.next,
+		Changes: []*change{},
+	}
+
+	if cursor > f.next {
+		cursor = f.next
+	}
+
+	// The oldest change still in the feed.
+	first := uint64(0)
+	if f.next > changeFeedSize {
+		first = f.next - changeFeedSize
+	}
+
+	if cursor < first {
+		res.Truncated = true
+		cursor = first
+	}
+
+	for i := cursor; i < f.next; i++ {
+		ch := f.changes[i%changeFeedSize]
+
+		rel, err := filepath.Rel(scope, ch.path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		ch.Path = "/" + filepath.ToSlash(rel)
+		if !u.Allowed(ch.Path) {
+			continue
+		}
+
+		res.Changes = append(res.Changes, &ch)
+	}
+
+	return res, nil
+}
+
+// changesHandler lists the changes since the cursor in the 'since' query
+// parameter. If there is no cursor, every change in the feed is listed.
+func changesHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.changes == nil {
+		return http.StatusNotFound, nil
+	}
+
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	var cursor uint64
+	if since := r.URL.Query().Get("since"); since != "" {
+		var err error
+		cursor, err = strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	res, err := c.changes.since(cursor, c.User)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, res)
+}
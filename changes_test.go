@@ -0,0 +1,101 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hacdias/fileutils"
+)
+
+func TestChangeFeed(t *testing.T) {
+	scope := filepath.Join(string(filepath.Separator), "scope")
+	u := &User{FileSystem: fileutils.Dir(scope), Rules: []*Rule{{Path: "/private"}}}
+
+	f := &changeFeed{}
+	f.record(fsnotify.Event{Name: filepath.Join(scope, "a.txt"), Op: fsnotify.Write})
+	f.record(fsnotify.Event{Name: filepath.Join(scope, "b.txt"), Op: fsnotify.Remove})
+	f.record(fsnotify.Event{Name: filepath.Join(scope, "private", "c.txt"), Op: fsnotify.Write})
+	f.record(fsnotify.Event{Name: filepath.Join(string(filepath.Separator), "other", "d.txt"), Op: fsnotify.Write})
+	f.record(fsnotify.Event{Name: filepath.Join(scope, "e.txt"), Op: fsnotify.Chmod})
+
+	res, err := f.since(0, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes outside of the scope or hidden by the rules aren't
+	// listed and the changes of the permissions aren't recorded.
+	if res.Cursor != 4 || res.Truncated || len(res.Changes) != 2 {
+		t.Fatalf("Got %+v", res)
+	}
+
+	if ch := res.Changes[0]; ch.Path != "/a.txt" || ch.Op != "modify" || ch.Cursor != 1 {
+		t.Errorf("First change: got %+v", ch)
+	}
+
+	if ch := res.Changes[1]; ch.Path != "/b.txt" || ch.Op != "delete" || ch.Cursor != 2 {
+		t.Errorf("Second change: got %+v", ch)
+	}
+
+	if res, _ = f.since(2, u); len(res.Changes) != 0 {
+		t.Errorf("Changes since the cursor: got %+v", res.Changes)
+	}
+
+	// The oldest changes are discarded once the feed is full.
+	for i := 0; i < changeFeedSize; i++ {
+		f.record(fsnotify.Event{Name: filepath.Join(scope, "a.txt"), Op: fsnotify.Write})
+	}
+
+	if res, _ = f.since(1, u); !res.Truncated || len(res.Changes) != changeFeedSize {
+		t.Errorf("Truncated feed: got %v with %d changes", res.Truncated, len(res.Changes))
+	}
+}
+
+func TestChangesHandler(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/api/changes"); w.Code != http.StatusNotFound {
+		t.Errorf("Disabled feed: got %v", w.Code)
+	}
+
+	fm.changes = &changeFeed{}
+	fm.changes.record(fsnotify.Event{Name: filepath.Join(string(fm.Users["admin"].FileSystem), "a.txt"), Op: fsnotify.Create})
+
+	// The router is found without the trailing slash too.
+	w := get("/api/changes")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Changes: got %v", w.Code)
+	}
+
+	res := &changesResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Changes) != 1 || res.Changes[0].Path != "/a.txt" || res.Changes[0].Op != "create" {
+		t.Errorf("Changes: got %+v", res)
+	}
+
+	if w = get("/api/changes/?since=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid cursor: got %v", w.Code)
+	}
+}
@@ -0,0 +1,181 @@
+package filemanager
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// checksumCacheSize is the maximum number of checksums in cache.
+	checksumCacheSize = 4096
+	// maxListingHashFiles is the maximum number of files of a listing
+	// whose checksums are included in it.
+	maxListingHashFiles = 1000
+	// maxListingHashBytes is the maximum total size of the files of
+	// a listing whose checksums are calculated to include them in it.
+	maxListingHashBytes = 64 << 20
+	// maxInlineHashSize is the maximum size of a file whose checksum is
+	// calculated to include it in a listing. The bigger ones are only
+	// included if their checksum is in cache.
+	maxInlineHashSize = 8 << 20
+)
+
+// checksumAlgorithms are the algorithms supported by file.Checksum.
+var checksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+// enabledChecksums returns the checksum algorithms the clients can use.
+func (m *FileManager) enabledChecksums() []string {
+	if len(m.ChecksumAlgorithms) == 0 {
+		return checksumAlgorithms
+	}
+
+	return m.ChecksumAlgorithms
+}
+
+// checksumEnabled tells if the clients can use the checksum algorithm.
+func (m *FileManager) checksumEnabled(algo string) bool {
+	for _, enabled := range m.enabledChecksums() {
+		if enabled == algo {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checksum returns the checksum of the file using the cache. It fails
+// with errInvalidOption if the algorithm isn't enabled.
+func (m *FileManager) checksum(f *file, algo string) (string, error) {
+	if !m.checksumEnabled(algo) {
+		return "", errInvalidOption
+	}
+
+	return m.checksums.get(f, algo)
+}
+
+// checksumKey identifies a version of a file and the algorithm
+// of its checksum.
+type checksumKey struct {
+	algo    string
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// checksumKeyOf returns the key of the checksum of the file made with
+// the algorithm.
+func checksumKeyOf(f *file, algo string) checksumKey {
+	return checksumKey{
+		algo:    algo,
+		path:    f.Path,
+		modTime: f.ModTime,
+		size:    f.Size,
+	}
+}
+
+// checksumCache keeps the checksums of the files, indexed by the
+// algorithm and the path, modification time and size of the file.
+type checksumCache struct {
+	sync.Mutex
+	items map[checksumKey]string
+}
+
+// cached returns the checksum of the file if it is in cache.
+func (c *checksumCache) cached(f *file, algo string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	sum, ok := c.items[checksumKeyOf(f, algo)]
+	return sum, ok
+}
+
+// get returns the checksum of the file, calculating it if it
+// isn't in cache yet.
+func (c *checksumCache) get(f *file, algo string) (string, error) {
+	key := checksumKeyOf(f, algo)
+
+	if sum, ok := c.cached(f, algo); ok {
+		return sum, nil
+	}
+
+	sum, err := f.Checksum(algo)
+	if err != nil {
+		return "", err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Starts over when the cache is full.
+	if len(c.items) >= checksumCacheSize {
+		c.items = map[checksumKey]string{}
+	}
+
+	c.items[key] = sum
+	return sum, nil
+}
+
+// move makes the checksums of the files at src, and of its descendants,
+// follow them to dst.
+func (c *checksumCache) move(src, dst string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, sum := range c.items {
+		if path, ok := movedPath(key.path, src, dst); ok {
+			delete(c.items, key)
+			key.path = path
+			c.items[key] = sum
+		}
+	}
+}
+
+// embedChecksums adds the checksums of the files to the listing. Only
+// the checksums in cache and the ones of the small files are added, so
+// it returns false if some were left out. If the listing has too many
+// files, no checksum is added.
+func (l listing) embedChecksums(m *FileManager, algo string) (bool, error) {
+	if !m.checksumEnabled(algo) {
+		return false, errInvalidOption
+	}
+
+	var count int
+	for _, item := range l.Items {
+		if !item.IsDir {
+			count++
+		}
+	}
+
+	if count > maxListingHashFiles {
+		return false, nil
+	}
+
+	complete := true
+	var size int64
+
+	for _, item := range l.Items {
+		if item.IsDir {
+			continue
+		}
+
+		if sum, ok := m.checksums.cached(item, algo); ok {
+			item.Hash = sum
+			continue
+		}
+
+		if item.Size > maxInlineHashSize || size+item.Size > maxListingHashBytes {
+			complete = false
+			continue
+		}
+
+		size += item.Size
+		sum, err := m.checksums.get(item, algo)
+		if err != nil {
+			return false, err
+		}
+
+		item.Hash = sum
+	}
+
+	return complete, nil
+}
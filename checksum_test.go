@@ -0,0 +1,93 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListingChecksums(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := ioutil.WriteFile(filepath.Join(scope, "small.txt"), []byte("hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	big, err := os.Create(filepath.Join(scope, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = big.Truncate(maxInlineHashSize + 1)
+	big.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	list := func() (map[string]string, bool) {
+		w := get("/api/resource/?hash=md5")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Listing: got %v", w.Code)
+		}
+
+		var listing struct {
+			Items []struct {
+				Name string `json:"name"`
+				Hash string `json:"hash"`
+			} `json:"items"`
+			HashesOmitted bool `json:"hashesOmitted"`
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+			t.Fatal(err)
+		}
+
+		hashes := map[string]string{}
+		for _, item := range listing.Items {
+			hashes[item.Name] = item.Hash
+		}
+
+		return hashes, listing.HashesOmitted
+	}
+
+	// The big files aren't hashed to list them.
+	hashes, omitted := list()
+	if hashes["small.txt"] != "5d41402abc4b2a76b9719d911017c592" || hashes["big.bin"] != "" || !omitted {
+		t.Errorf("Listing: got %v omitted %v", hashes, omitted)
+	}
+
+	// Once their checksum is in cache, it is listed.
+	w := get("/api/checksum/big.bin?algo=md5")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Checksum: got %v", w.Code)
+	}
+
+	hashes, omitted = list()
+	if hashes["big.bin"] != w.Body.String() || omitted {
+		t.Errorf("Listing with a cached checksum: got %v omitted %v", hashes, omitted)
+	}
+
+	if w = get("/api/resource/?hash=md4"); w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown algorithm: got %v", w.Code)
+	}
+}
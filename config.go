@@ -0,0 +1,249 @@
+package filemanager
+
+import (
+	"net/http"
+	"time"
+)
+
+// effectiveConfig is the configuration the server is running with, as
+// reported to the administrators. The secrets, such as the keys, the
+// passwords and the commands, aren't included. The durations are in
+// seconds.
+type effectiveConfig struct {
+	BaseURL          string   `json:"baseURL"`
+	PrefixURL        string   `json:"prefixURL"`
+	AssetsDir        string   `json:"assetsDir"`
+	TempDir          string   `json:"tempDir"`
+	Database         string   `json:"database"`
+	DatabaseReadOnly bool     `json:"databaseReadOnly"`
+	StaticGen        string   `json:"staticGen"`
+	StateStore       string   `json:"stateStore"`
+	TrustedProxies   []string `json:"trustedProxies"`
+	Debug            bool     `json:"debug"`
+	LogRedact        []string `json:"logRedact"`
+
+	Auth   authConfig   `json:"auth"`
+	Limits limitsConfig `json:"limits"`
+	Shares sharesConfig `json:"shares"`
+	Files  filesConfig  `json:"files"`
+
+	ReadOnly readOnlyMode `json:"readOnly"`
+}
+
+type authConfig struct {
+	NoAuth       bool   `json:"noAuth"`
+	Cookie       bool   `json:"cookie"`
+	Scheme       string `json:"scheme"`
+	IdleTimeout  int64  `json:"idleTimeout"`
+	PasswordHash string `json:"passwordHash"`
+	PasswordCost int    `json:"passwordCost"`
+	// OIDC is the provider of the single sign-on, if there is one,
+	// without the secret of the client.
+	OIDC *oidcConfig `json:"oidc,omitempty"`
+}
+
+type oidcConfig struct {
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientID"`
+	RedirectURL   string   `json:"redirectURL"`
+	Scopes        []string `json:"scopes"`
+	AdminGroups   []string `json:"adminGroups"`
+	AllowedGroups []string `json:"allowedGroups"`
+	AutoProvision bool     `json:"autoProvision"`
+}
+
+type limitsConfig struct {
+	MaxDepth       int   `json:"maxDepth"`
+	MaxPreviewSize int64 `json:"maxPreviewSize"`
+	MaxUploadSize  int64 `json:"maxUploadSize"`
+	MaxPending     int64 `json:"maxPendingUploadSize"`
+	Idempotency    int64 `json:"idempotencyWindow"`
+	MaxURLLength   int   `json:"maxURLLength"`
+	MaxHeaderBytes int   `json:"maxHeaderBytes"`
+	MaxQueryParams int   `json:"maxQueryParams"`
+	MaxDownloads   int   `json:"maxDownloads"`
+	RateLimit      int   `json:"rateLimit"`
+	DownloadRate   int   `json:"downloadRateLimit"`
+	RateBurst      int   `json:"rateBurst"`
+	DeleteConfirm  int   `json:"deleteConfirm"`
+	// Operations are the maximum number of operations of each kind
+	// which can run at once.
+	Operations      map[string]int `json:"operations"`
+	OperationWait   int64          `json:"operationWait"`
+	ShutdownTimeout int64          `json:"shutdownTimeout"`
+}
+
+type sharesConfig struct {
+	DefaultExpiry   int64  `json:"defaultExpiry"`
+	MaxExpiry       int64  `json:"maxExpiry"`
+	MaxShares       int    `json:"maxShares"`
+	Folder          string `json:"folder"`
+	Notifications   bool   `json:"notifications"`
+	ExpiryNotice    int64  `json:"expiryNotice"`
+	CleanupInterval int64  `json:"cleanupInterval"`
+	RemoveDangling  bool   `json:"removeDangling"`
+}
+
+type filesConfig struct {
+	Checksums        []string          `json:"checksums"`
+	ArchiveFormats   []string          `json:"archiveFormats"`
+	DownloadName     string            `json:"downloadName"`
+	MaxLinkExpiry    int64             `json:"maxLinkExpiry"`
+	DownloadHeaders  []HeaderRule      `json:"downloadHeaders"`
+	ContentTypes     map[string]string `json:"contentTypes"`
+	Ignore           []string          `json:"ignore"`
+	UploadPolicy     UploadPolicy      `json:"uploadPolicy"`
+	Scanner          bool              `json:"scanner"`
+	Actions          []string          `json:"actions"`
+	UploadHook       bool              `json:"uploadHook"`
+	ChangeFeed       bool              `json:"changeFeed"`
+	NoDotDirs        bool              `json:"noDotDirs"`
+	NoCaseCollisions bool              `json:"noCaseCollisions"`
+	Xattrs           bool              `json:"xattrs"`
+	ShowOwners       bool              `json:"showOwners"`
+	ResolveSymlinks  bool              `json:"resolveSymlinks"`
+	RedactGPS        bool              `json:"redactGPS"`
+	ReadmeName       string            `json:"readmeName"`
+	LandingPath      string            `json:"landingPath"`
+	CommandHistory   int               `json:"commandHistory"`
+	ThumbnailMaxAge  int64             `json:"thumbnailMaxAge"`
+	HomeSkeleton     string            `json:"homeSkeleton"`
+	RemoveHomes      bool              `json:"removeHomes"`
+	HomeArchive      string            `json:"homeArchive"`
+	HomeRoot         string            `json:"homeRoot"`
+}
+
+// configHandler reports the configuration the server is running with to
+// the administrators, including the options changed after it started.
+func configHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+
+	if r.URL.Path != "" && r.URL.Path != "/" {
+		return http.StatusNotFound, nil
+	}
+
+	return renderJSON(w, c.effectiveConfig())
+}
+
+// effectiveConfig returns the current configuration without the secrets.
+func (m *FileManager) effectiveConfig() *effectiveConfig {
+	seconds := func(d time.Duration) int64 {
+		return int64(d / time.Second)
+	}
+
+	config := &effectiveConfig{
+		BaseURL:          m.BaseURL,
+		PrefixURL:        m.PrefixURL,
+		AssetsDir:        m.AssetsDir,
+		TempDir:          m.TempDir,
+		Database:         m.DatabasePath,
+		DatabaseReadOnly: m.DatabaseOptions.ReadOnly,
+		StaticGen:        m.staticgen,
+		StateStore:       "memory",
+		TrustedProxies:   []string{},
+		Debug:            m.Debug,
+		LogRedact:        m.LogRedact,
+		Auth: authConfig{
+			NoAuth:       m.NoAuth,
+			Cookie:       m.AuthCookie,
+			Scheme:       m.AuthScheme,
+			IdleTimeout:  seconds(m.IdleTimeout),
+			PasswordHash: m.PasswordHash,
+			PasswordCost: m.PasswordCost,
+		},
+		Limits: limitsConfig{
+			MaxDepth:       m.MaxDepth,
+			MaxPreviewSize: m.MaxPreviewSize,
+			MaxUploadSize:  m.MaxUploadSize,
+			MaxPending:     m.MaxPendingUploadSize,
+			Idempotency:    seconds(m.IdempotencyWindow),
+			MaxURLLength:   m.MaxURLLength,
+			MaxHeaderBytes: m.MaxHeaderBytes,
+			MaxQueryParams: m.MaxQueryParams,
+			MaxDownloads:   m.MaxDownloads,
+			RateLimit:      m.RateLimit,
+			DownloadRate:   m.DownloadRateLimit,
+			RateBurst:      m.RateBurst,
+			DeleteConfirm:  m.DeleteConfirmThreshold,
+			Operations: map[string]int{
+				limitChecksum:  m.Limits.Checksum,
+				limitSearch:    m.Limits.Search,
+				limitThumbnail: m.Limits.Thumbnail,
+				limitArchive:   m.Limits.Archive,
+				limitBuild:     m.Limits.of(limitBuild),
+			},
+			OperationWait:   seconds(m.Limits.Wait),
+			ShutdownTimeout: seconds(m.ShutdownTimeout),
+		},
+		Shares: sharesConfig{
+			DefaultExpiry:   seconds(m.DefaultShareExpiry),
+			MaxExpiry:       seconds(m.MaxShareExpiry),
+			MaxShares:       m.MaxShares,
+			Folder:          m.ShareFolder,
+			Notifications:   m.Notifier != nil,
+			ExpiryNotice:    seconds(m.ShareExpiryNotice),
+			CleanupInterval: seconds(m.ShareCleanupInterval),
+			RemoveDangling:  m.RemoveDanglingShares,
+		},
+		Files: filesConfig{
+			Checksums:        m.enabledChecksums(),
+			ArchiveFormats:   m.enabledArchiveFormats(),
+			DownloadName:     m.DownloadName,
+			MaxLinkExpiry:    seconds(m.MaxLinkExpiry),
+			DownloadHeaders:  m.DownloadHeaders,
+			ContentTypes:     m.ContentTypes,
+			Ignore:           m.Ignore,
+			UploadPolicy:     m.UploadPolicy,
+			Scanner:          m.Scanner != nil,
+			Actions:          []string{},
+			UploadHook:       m.UploadHook != nil,
+			ChangeFeed:       m.changes != nil,
+			NoDotDirs:        m.NoDotDirs,
+			NoCaseCollisions: m.NoCaseCollisions,
+			Xattrs:           m.Xattrs,
+			ShowOwners:       m.ShowOwners,
+			ResolveSymlinks:  m.ResolveSymlinks,
+			RedactGPS:        m.RedactGPS,
+			ReadmeName:       m.ReadmeName,
+			LandingPath:      m.LandingPath,
+			CommandHistory:   m.CommandHistory,
+			ThumbnailMaxAge:  seconds(m.ThumbnailMaxAge),
+			HomeSkeleton:     m.HomeSkeleton,
+			RemoveHomes:      m.RemoveHomes,
+			HomeArchive:      m.HomeArchive,
+			HomeRoot:         m.HomeRoot,
+		},
+		ReadOnly: m.readOnlyStatus(),
+	}
+
+	// The address of the state store may have a password.
+	if m.State != nil {
+		config.StateStore = "external"
+	}
+
+	for _, network := range m.trustedProxies {
+		config.TrustedProxies = append(config.TrustedProxies, network.String())
+	}
+
+	// Only the names of the actions are reported since their commands
+	// may have secrets.
+	for _, action := range m.Actions {
+		config.Files.Actions = append(config.Files.Actions, action.Name)
+	}
+
+	if m.OIDC != nil {
+		config.Auth.OIDC = &oidcConfig{
+			Issuer:        m.OIDC.Issuer,
+			ClientID:      m.OIDC.ClientID,
+			RedirectURL:   m.OIDC.RedirectURL,
+			Scopes:        m.OIDC.Scopes,
+			AdminGroups:   m.OIDC.AdminGroups,
+			AllowedGroups: m.OIDC.AllowedGroups,
+			AutoProvision: m.OIDC.AutoProvision,
+		}
+	}
+
+	return config
+}
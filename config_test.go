@@ -0,0 +1,80 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.MaxDepth = 3
+	fm.IdleTimeout = time.Hour
+	fm.ArchiveFormats = []string{"zip"}
+	fm.Actions = []Action{{Name: "upload", Command: "curl -u admin:hunter2 {path}"}}
+	fm.OIDC = &OIDC{Issuer: "https://id.example.com", ClientID: "files", ClientSecret: "hunter2"}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func() *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/config", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("The configuration has secrets: %s", w.Body.String())
+	}
+
+	var config effectiveConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Limits.MaxDepth != 3 || config.Auth.IdleTimeout != 3600 {
+		t.Errorf("Got limits %+v and auth %+v", config.Limits, config.Auth)
+	}
+
+	if len(config.Files.ArchiveFormats) != 1 || len(config.Files.Actions) != 1 || config.Files.Actions[0] != "upload" {
+		t.Errorf("Got files %+v", config.Files)
+	}
+
+	if config.Auth.OIDC == nil || config.Auth.OIDC.ClientID != "files" {
+		t.Errorf("Got OIDC %+v", config.Auth.OIDC)
+	}
+
+	// The options changed while running are reported.
+	if err := fm.SetReadOnlyMode(true, "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+
+	config = effectiveConfig{}
+	if err := json.Unmarshal(get().Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.ReadOnly.Enabled || config.ReadOnly.Message != "maintenance" {
+		t.Errorf("Got read-only mode %+v", config.ReadOnly)
+	}
+
+	fm.Users["admin"].Admin = false
+	if w := get(); w.Code != http.StatusForbidden {
+		t.Errorf("Non-administrator: got status %v", w.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package filemanager
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// defaultDatabaseTimeout is how long File Manager waits for the database
+// to be unlocked by other processes before failing.
+const defaultDatabaseTimeout = time.Second
+
+// DatabaseOptions are the options used to open the database.
+type DatabaseOptions struct {
+	// Timeout is how long to wait for the database to be unlocked by other
+	// processes. If it is zero, File Manager waits one second.
+	Timeout time.Duration
+
+	// ReadOnly opens the database in read-only mode. This allows running an
+	// instance against a snapshot of the database of another one. Every
+	// change made through that instance fails.
+	ReadOnly bool
+
+	// Compact rewrites the database before opening it to reclaim the space
+	// left by deleted records. As it needs exclusive access to the database,
+	// it is only done on start up.
+	Compact bool
+
+	// UserDefaults are the values given to the fields missing from the
+	// users saved by older versions, such as new permissions, when the
+	// database is upgraded. If it is nil, they're left to their zero
+	// value, which denies the permissions. The username, the password
+	// and the admin flag are never set.
+	UserDefaults *User
+}
+
+// openDatabase opens the database with the options, compacting it first
+// if requested.
+func openDatabase(path string, opts DatabaseOptions) (*storm.DB, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultDatabaseTimeout
+	}
+
+	if opts.Compact && !opts.ReadOnly {
+		if err := compactDatabase(path, opts.Timeout); err != nil {
+			return nil, fmt.Errorf("couldn't compact the database at %s: %v", path, err)
+		}
+	}
+
+	db, err := storm.Open(path, storm.BoltOptions(0600, &bolt.Options{
+		Timeout:  opts.Timeout,
+		ReadOnly: opts.ReadOnly,
+	}))
+
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the database at %s: %v", path, err)
+	}
+
+	return db, nil
+}
+
+// compactDatabase copies every bucket of the database to a new file, which
+// doesn't have the free pages of the original, and replaces it.
+func compactDatabase(path string, timeout time.Duration) error {
+	// There is nothing to compact if the database doesn't exist yet.
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	// A copy left by an interrupted compaction would make every bucket
+	// exist already.
+	temp := path + ".compact"
+	if err = os.Remove(temp); err != nil && !os.IsNotExist(err) {
+		src.Close()
+		return err
+	}
+
+	dst, err := bolt.Open(temp, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	err = src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(b, nb)
+			})
+		})
+	})
+
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	src.Close()
+
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// copyBucket copies the keys, the nested buckets and the sequence of
+// a bucket to another one.
+func copyBucket(src, dst *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		// Nested buckets have nil values.
+		if v == nil {
+			nb, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+
+			return copyBucket(src.Bucket(k), nb)
+		}
+
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,59 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactDatabase(t *testing.T) {
+	temp, err := ioutil.TempDir("", "filemanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	path := filepath.Join(temp, "database.db")
+	db, err := openDatabase(path, DatabaseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.Save(&shareLink{Hash: "a", Path: "/a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The copy left by an interrupted compaction already has the buckets.
+	stale, err := openDatabase(path+".compact", DatabaseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = stale.Save(&shareLink{Hash: "b", Path: "/b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	for i := 0; i < 2; i++ {
+		db, err = openDatabase(path, DatabaseOptions{Compact: true})
+		if err != nil {
+			t.Fatalf("Compaction %d: %v", i, err)
+		}
+
+		var links []shareLink
+		if err = db.All(&links); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		if len(links) != 1 || links[0].Hash != "a" {
+			t.Errorf("Compaction %d: got the shares %+v", i, links)
+		}
+	}
+
+	if _, err = os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("The copy was left: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package filemanager
+
+import "errors"
+
+var errDiskUnsupported = errors.New("the disk usage isn't supported on this platform")
+
+// diskUsage is the size of the file system of a scope and how much of it
+// can still be written.
+type diskUsage struct {
+	Total uint64 `json:"total"`
+	// Free is the space available to File Manager, which may be less than
+	// the free space of the file system if part of it is reserved.
+	Free uint64 `json:"free"`
+}
+
+// userDisk returns the disk usage of the file system of the scope of a
+// user or nil if it can't be known.
+func userDisk(u *User) *diskUsage {
+	usage, err := diskStats(string(u.FileSystem))
+	if err != nil {
+		return nil
+	}
+
+	return &usage
+}
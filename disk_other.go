@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package filemanager
+
+// diskStats isn't supported on this platform.
+func diskStats(path string) (diskUsage, error) {
+	return diskUsage{}, errDiskUnsupported
+}
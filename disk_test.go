@@ -0,0 +1,74 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hacdias/fileutils"
+)
+
+func TestUserDisk(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if _, err := diskStats(fm.Temp); err == errDiskUnsupported {
+		t.Skip(err)
+	}
+
+	disk := userDisk(fm.Users["admin"])
+	if disk == nil {
+		t.Fatal("Expected the disk usage of the scope")
+	}
+
+	if disk.Total == 0 || disk.Free > disk.Total {
+		t.Errorf("Invalid disk usage: %+v", disk)
+	}
+
+	missing := &User{FileSystem: fileutils.Dir(fm.Temp + "/missing")}
+	if disk = userDisk(missing); disk != nil {
+		t.Errorf("Expected no disk usage for a missing scope, got %+v", disk)
+	}
+}
+
+func TestProfileDisk(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if _, err := diskStats(fm.Temp); err == errDiskUnsupported {
+		t.Skip(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	me := func() map[string]json.RawMessage {
+		r, err := http.NewRequest("GET", "/api/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var p map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	var disk diskUsage
+	if err := json.Unmarshal(me()["disk"], &disk); err != nil || disk.Total == 0 || disk.Free > disk.Total {
+		t.Errorf("Got the disk usage %+v, %v", disk, err)
+	}
+
+	// The disk usage is omitted if the scope can't be read.
+	fm.Users["admin"].FileSystem = fileutils.Dir(filepath.Join(fm.Temp, "missing"))
+	if raw, ok := me()["disk"]; ok {
+		t.Errorf("Got the disk usage %s of a missing scope", raw)
+	}
+}
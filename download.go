@@ -1,32 +1,60 @@
 package filemanager
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/hmac"
+	"errors"
 	"io"
-	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hacdias/fileutils"
 	"github.com/mholt/archiver"
 )
 
-// downloadHandler creates an archive in one of the supported formats (zip, tar,
-// tar.gz or tar.bz2) and sends it to be downloaded.
+// downloadHandler creates an archive in one of the enabled formats (zip, tar,
+// tar.gz, tar.bz2 or tar.xz) and sends it to be downloaded.
 func downloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	// Shutting down waits for the downloads in progress.
+	if !c.shutdown.begin() {
+		return rejectShutdown(w)
+	}
+	defer c.shutdown.end()
+
+	// The downloads in progress of each user are limited.
+	free, ok := c.acquireDownload(r, c.File.Path)
+	if !ok {
+		return rejectTooManyDownloads(w)
+	}
+	defer free()
+
 	query := r.URL.Query().Get("format")
 
 	// If the file isn't a directory, serve it using http.ServeFile. We display it
-	// inline if it is requested.
+	// inline if it is requested, unless it could run scripts in the browser.
 	if !c.File.IsDir {
-		if r.URL.Query().Get("inline") == "true" {
-			w.Header().Set("Content-Disposition", "inline")
-		} else {
-			w.Header().Set("Content-Disposition", "attachment; filename="+c.File.Name)
+		typ, err := c.contentType(c.File)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+
+		disposition := "attachment"
+		if r.URL.Query().Get("inline") == "true" && inlineSafe(typ) {
+			disposition = "inline"
 		}
 
+		w.Header().Set("Content-Type", typ)
+		w.Header().Set("Content-Disposition", contentDisposition(disposition, downloadName(c, c.File.Name)))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("ETag", sizeModETag(c.File.Size, c.File.ModTime))
+		c.setDownloadHeaders(w, c.File.VirtualPath)
 		http.ServeFile(w, r, c.File.Path)
 		return 0, nil
 	}
@@ -52,9 +80,18 @@ func downloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		files = append(files, c.File.Path)
 	}
 
-	// If the format is true, just set it to "zip".
+	// If the format is true, just use the first enabled one.
 	if query == "true" || query == "" {
-		query = "zip"
+		query = c.enabledArchiveFormats()[0]
+	}
+
+	if !c.archiveEnabled(query) {
+		return http.StatusUnsupportedMediaType, nil
+	}
+
+	// The gzipped tarballs are streamed while they are made.
+	if query == "targz" {
+		return downloadTarGz(c, w, r, files)
 	}
 
 	var (
@@ -64,8 +101,14 @@ func downloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		tempfile  string
 	)
 
+	release, ok := c.acquire(r, limitArchive)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
 	// Create a temporary directory.
-	temp, err = ioutil.TempDir("", "")
+	temp, err = c.tempDir()
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -78,14 +121,12 @@ func downloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		extension, err = ".zip", archiver.Zip.Make(tempfile, files)
 	case "tar":
 		extension, err = ".tar", archiver.Tar.Make(tempfile, files)
-	case "targz":
-		extension, err = ".tar.gz", archiver.TarGz.Make(tempfile, files)
 	case "tarbz2":
 		extension, err = ".tar.bz2", archiver.TarBz2.Make(tempfile, files)
 	case "tarxz":
 		extension, err = ".tar.xz", archiver.TarXZ.Make(tempfile, files)
 	default:
-		return http.StatusNotImplemented, nil
+		return http.StatusUnsupportedMediaType, nil
 	}
 
 	if err != nil {
@@ -106,7 +147,418 @@ func downloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	}
 	defer file.Close()
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	w.Header().Set("Content-Disposition", attachment(downloadName(c, name)))
+	c.setDownloadHeaders(w, c.File.VirtualPath)
 	_, err = io.Copy(w, file)
 	return 0, err
 }
+
+// defaultArchiveFormats are the formats of the archives of the
+// directories the clients can use if none is set.
+var defaultArchiveFormats = []string{"zip", "tar", "targz"}
+
+// archiveExtensions are the extensions of the archives of the directories
+// by their format. They are all the formats which are supported.
+var archiveExtensions = map[string]string{
+	"zip":    ".zip",
+	"tar":    ".tar",
+	"targz":  ".tar.gz",
+	"tarbz2": ".tar.bz2",
+	"tarxz":  ".tar.xz",
+}
+
+// CheckArchiveFormats checks every format of the archives is supported.
+func CheckArchiveFormats(formats []string) error {
+	for _, format := range formats {
+		if _, ok := archiveExtensions[format]; !ok {
+			return errors.New("unknown archive format: " + format)
+		}
+	}
+
+	return nil
+}
+
+// archiveLink is a link to download a directory as an archive in one of
+// the formats, which is shown on the pages of the shares.
+type archiveLink struct {
+	Format string
+	Name   string
+}
+
+// alternativeArchives returns the links to the archives in the enabled
+// formats other than the first one, which is the default.
+func (m *FileManager) alternativeArchives() []archiveLink {
+	links := []archiveLink{}
+	for _, format := range m.enabledArchiveFormats()[1:] {
+		links = append(links, archiveLink{
+			Format: format,
+			Name:   strings.TrimPrefix(archiveExtensions[format], "."),
+		})
+	}
+
+	return links
+}
+
+// enabledArchiveFormats returns the formats of the archives of the
+// directories the clients can use.
+func (m *FileManager) enabledArchiveFormats() []string {
+	if len(m.ArchiveFormats) == 0 {
+		return defaultArchiveFormats
+	}
+
+	return m.ArchiveFormats
+}
+
+// archiveEnabled tells if the clients can use the archive format.
+func (m *FileManager) archiveEnabled(format string) bool {
+	for _, enabled := range m.enabledArchiveFormats() {
+		if enabled == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// downloadTarGz streams the files, and the contents of the directories, as
+// a gzipped tarball. The modes and the symbolic links are kept as they are
+// and the entries the user can't access aren't included.
+func downloadTarGz(c *RequestContext, w http.ResponseWriter, r *http.Request, files []string) (int, error) {
+	release, ok := c.acquire(r, limitArchive)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	// The shares are archived as if they were the scope of a user without
+	// rules, like when they're browsed.
+	u := c.User
+	if c.share != nil {
+		u = &User{FileSystem: fileutils.Dir(c.share.Path)}
+	}
+
+	name := c.File.Name
+	if name == "." || name == "" {
+		name = "download"
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", attachment(downloadName(c, name+".tar.gz")))
+	c.setDownloadHeaders(w, c.File.VirtualPath)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, file := range files {
+		if err := c.writeTar(tw, u, file); err != nil {
+			// The headers were already sent so the error can only
+			// be logged.
+			return 0, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+
+	return 0, gz.Close()
+}
+
+// writeTar writes the file or the directory at root to the tarball, named
+// relatively to the directory which contains it. The symbolic links aren't
+// followed.
+func (m *FileManager) writeTar(tw *tar.Writer, u *User, root string) error {
+	scope := filepath.Clean(string(u.FileSystem))
+	parent := filepath.Dir(filepath.Clean(root))
+
+	// The directories are walked even if they are symbolic links
+	// themselves, since they are what was requested.
+	if info, err := os.Stat(root); err == nil && info.IsDir() {
+		root = filepath.Clean(root) + string(filepath.Separator)
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(scope, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return os.ErrPermission
+		}
+
+		virtual := "/" + filepath.ToSlash(rel)
+		if rel != "." && (!u.Allowed(virtual) || m.ignored(u, virtual) || partialFile(virtual)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			// The sockets and the other special files can't be
+			// archived.
+			return nil
+		}
+
+		name, err := filepath.Rel(parent, p)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.CopyN(tw, f, info.Size())
+		return err
+	})
+}
+
+// downloadName applies the DownloadName template to the name of a
+// downloaded file. It falls back to the original name if the result
+// is empty after being sanitized.
+func downloadName(c *RequestContext, name string) string {
+	if c.DownloadName == "" {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if strings.HasSuffix(strings.ToLower(name), ".tar"+ext) {
+		ext = ".tar" + ext
+	}
+
+	var user, share string
+	if c.User != nil {
+		user = c.User.Username
+	}
+
+	if c.share != nil {
+		share = c.share.Hash
+	}
+
+	now := time.Now()
+	result := strings.NewReplacer(
+		"{name}", name,
+		"{base}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("150405"),
+		"{user}", user,
+		"{share}", share,
+	).Replace(c.DownloadName)
+
+	if result = sanitizeFilename(result); result == "" {
+		return name
+	}
+
+	return result
+}
+
+// sanitizeFilename removes the characters which aren't allowed or are
+// dangerous in file names, such as path separators and control characters.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+
+		return r
+	}, name)
+
+	return strings.Trim(name, " .")
+}
+
+// attachment returns the Content-Disposition header value to
+// download a file with the name.
+func attachment(name string) string {
+	return contentDisposition("attachment", name)
+}
+
+// contentDisposition returns the Content-Disposition header value to
+// show a file with the name 'inline' or to download it as an 'attachment'.
+func contentDisposition(disposition, name string) string {
+	value := mime.FormatMediaType(disposition, map[string]string{"filename": name})
+	if value == "" {
+		return disposition
+	}
+
+	return value
+}
+
+// contentType returns the content type a file is served with: the one
+// set for its extension, the one known for it or the one detected from
+// its content.
+func (m *FileManager) contentType(f *file) (string, error) {
+	ext := strings.ToLower(filepath.Ext(f.Name))
+	if typ, ok := m.ContentTypes[ext]; ok {
+		return typ, nil
+	}
+
+	if typ := mime.TypeByExtension(ext); typ != "" {
+		return typ, nil
+	}
+
+	return sniffMimeType(f.Path)
+}
+
+// inlineSafe tells if the files of the content type can be shown by the
+// browsers. The documents which can run scripts, such as HTML and SVG,
+// would do it in the origin of File Manager, so they're always downloaded.
+func inlineSafe(typ string) bool {
+	typ, _, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.Contains(typ, "html"),
+		strings.Contains(typ, "xml"),
+		strings.Contains(typ, "javascript"),
+		strings.Contains(typ, "ecmascript"):
+		return false
+	}
+
+	return typ != "application/x-shockwave-flash"
+}
+
+// signedLink is a temporary download link for a single file which
+// doesn't require the visitor to be logged in.
+type signedLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+var errLinkExpiry = errors.New("the link must expire within the maximum link expiry")
+
+// linkHandler generates a signed download link for the current file. The
+// link is valid for one hour, or the maximum link expiry if it's shorter,
+// unless 'expires' and 'unit' are set.
+func linkHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed(w, http.MethodPost)
+	}
+
+	if c.File.IsDir {
+		return http.StatusBadRequest, errors.New("only files can have direct links")
+	}
+
+	expire := r.URL.Query().Get("expires")
+	duration := time.Hour
+	if expire != "" {
+		var err error
+		duration, err = parseExpiry(expire, r.URL.Query().Get("unit"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if c.MaxLinkExpiry > 0 && duration > c.MaxLinkExpiry {
+		if expire != "" {
+			return http.StatusBadRequest, errLinkExpiry
+		}
+
+		duration = c.MaxLinkExpiry
+	}
+
+	expires := time.Now().Add(duration)
+	path := sanitizeURL(r.URL.Path)
+
+	query := url.Values{}
+	query.Set("user", c.User.Username)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", signDownload(c.signingKey(), c.User.Username, path, expires.Unix()))
+
+	link := url.URL{Path: c.RootURL() + "/dl" + path, RawQuery: query.Encode()}
+
+	return renderJSON(w, signedLink{
+		URL:     link.String(),
+		Expires: expires,
+	})
+}
+
+// signedDownloadHandler serves the files requested through links
+// generated by linkHandler after checking their signature and expiry.
+func signedDownloadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	r.URL.Path = sanitizeURL(r.URL.Path)
+	query := r.URL.Query()
+	username := query.Get("user")
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return http.StatusForbidden, nil
+	}
+
+	// Links signed with previous keys are valid during their grace period.
+	valid := false
+	for _, key := range c.verificationKeys() {
+		signature := signDownload(key, username, r.URL.Path, expires)
+		if hmac.Equal([]byte(signature), []byte(query.Get("signature"))) {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return http.StatusForbidden, nil
+	}
+
+	if time.Now().Unix() > expires {
+		return http.StatusGone, nil
+	}
+
+	u, ok := c.Users[username]
+	if c.NoAuth {
+		u, ok = c.DefaultUser, true
+	}
+
+	if !ok || !u.Allowed(r.URL.Path) {
+		return http.StatusForbidden, nil
+	}
+
+	c.User = u
+	c.File, err = getInfo(r.URL, c.FileManager, u)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if c.File.IsDir {
+		return http.StatusForbidden, nil
+	}
+
+	return downloadHandler(c, w, r)
+}
+
+// signDownload returns the HMAC signature of a download link for the
+// path of the user which expires at the given Unix time.
+func signDownload(key []byte, username, path string, expires int64) string {
+	return sign(key, username, path, strconv.FormatInt(expires, 10))
+}
@@ -0,0 +1,904 @@
+package filemanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedLink(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	// Generates the signed link.
+	r, err := http.NewRequest("POST", "/api/link/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Couldn't generate link: got %v", w.Code)
+	}
+
+	var link signedLink
+	if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	// Downloads the file without authentication.
+	r, err = http.NewRequest("GET", link.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != "content" {
+		t.Errorf("Couldn't download via signed link: got %v", w.Code)
+	}
+
+	// Tampering with the path must invalidate the link.
+	r, err = http.NewRequest("GET", strings.Replace(link.URL, "file.txt", "other.txt", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Wrong status code for tampered link: got %v want %v", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestLinkExpiry(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+	fm.MaxLinkExpiry = 2 * time.Hour
+
+	tests := []struct {
+		query  string
+		code   int
+		expiry time.Duration
+	}{
+		{"", http.StatusOK, time.Hour},
+		{"?expires=2", http.StatusOK, 2 * time.Hour},
+		{"?expires=90&unit=minutes", http.StatusOK, 90 * time.Minute},
+		{"?expires=3", http.StatusBadRequest, 0},
+		{"?expires=1&unit=days", http.StatusBadRequest, 0},
+		{"?expires=0", http.StatusBadRequest, 0},
+		{"?expires=-1", http.StatusBadRequest, 0},
+		{"?expires=a", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "/api/link/file.txt"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%q: got %v, want %v", test.query, w.Code, test.code)
+			continue
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var link signedLink
+		if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+			t.Fatal(err)
+		}
+
+		if d := link.Expires.Sub(start); d < test.expiry-time.Second || d > test.expiry+time.Minute {
+			t.Errorf("%q: expires in %v, want %v", test.query, d, test.expiry)
+		}
+	}
+
+	// Without a maximum, the huge expiries still can't overflow.
+	fm.MaxLinkExpiry = 0
+	r, err := http.NewRequest("POST", "/api/link/file.txt?expires=9223372036854775807&unit=days", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Overflowing expiry: got %v", w.Code)
+	}
+
+	// The links without expiry take the maximum if it's shorter.
+	fm.MaxLinkExpiry = 30 * time.Minute
+	r, err = http.NewRequest("POST", "/api/link/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var link signedLink
+	if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Until(link.Expires); w.Code != http.StatusOK || d > 30*time.Minute+time.Second {
+		t.Errorf("Link without expiry: got %v, expires in %v", w.Code, d)
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	c := &RequestContext{
+		FileManager: &FileManager{},
+		User:        &User{Username: "admin"},
+	}
+
+	if name := downloadName(c, "report.pdf"); name != "report.pdf" {
+		t.Errorf("Name changed without template: %s", name)
+	}
+
+	tests := map[string]string{
+		"{user}-{base}{ext}":   "admin-report.pdf",
+		"{base}.backup{ext}":   "report.backup.pdf",
+		"../{name}":            "report.pdf",
+		"{share}":              "report.pdf",
+		"a/b\\c:{name}\x00":    "abcreport.pdf",
+		"{date}-{name}" + "  ": time.Now().Format("2006-01-02") + "-report.pdf",
+	}
+
+	for template, want := range tests {
+		c.DownloadName = template
+		if name := downloadName(c, "report.pdf"); name != want {
+			t.Errorf("Template %q: got %q, want %q", template, name, want)
+		}
+	}
+
+	c.DownloadName = "{base}{ext}"
+	if name := downloadName(c, "archive.tar.gz"); name != "archive.tar.gz" {
+		t.Errorf("Wrong archive name: %s", name)
+	}
+}
+
+func TestResumedShareDownload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("0123456789"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	r, err := http.NewRequest("POST", "/api/share/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var link shareLink
+	if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(rng, ifRange string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/share/"+link.Hash+"?dl=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+
+		if ifRange != "" {
+			r.Header.Set("If-Range", ifRange)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	downloads := func() int {
+		var s shareLink
+		if err := fm.db.One("Hash", link.Hash, &s); err != nil {
+			t.Fatal(err)
+		}
+
+		return s.Downloads
+	}
+
+	// The download is paused after the first bytes.
+	w = download("bytes=0-3", "")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+		t.Fatalf("First range: got %v %q", w.Code, w.Body.String())
+	}
+
+	if n := downloads(); n != 0 {
+		t.Errorf("Unfinished download was counted: got %d", n)
+	}
+
+	// And resumed from where it stopped, if the file didn't change.
+	w = download("bytes=4-", w.Header().Get("Last-Modified"))
+	if w.Code != http.StatusPartialContent || w.Body.String() != "456789" {
+		t.Fatalf("Resumed range: got %v %q", w.Code, w.Body.String())
+	}
+
+	if n := downloads(); n != 1 {
+		t.Errorf("Resumed download: got %d downloads, want 1", n)
+	}
+
+	// A stale If-Range gets the whole file.
+	w = download("bytes=4-", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("Stale If-Range: got %v %q", w.Code, w.Body.String())
+	}
+
+	if n := downloads(); n != 2 {
+		t.Errorf("Full download: got %d downloads, want 2", n)
+	}
+}
+
+func TestProtectedShare(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("0123456789"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	r, err := http.NewRequest("POST", "/api/share/file.txt", strings.NewReader(`{"password":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var link shareLink
+	if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if !link.Protected {
+		t.Fatal("Share with password isn't protected")
+	}
+
+	if link.Password != "" {
+		t.Error("The password hash was sent to the client")
+	}
+
+	auth := func(password string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/share/"+link.Hash, strings.NewReader(`{"password":"`+password+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	download := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/share/"+link.Hash+"?dl=1"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		r.Header.Set("Range", "bytes=2-5")
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w = download("", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Download without token: got %v", w.Code)
+	}
+
+	if w = auth("wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Wrong password: got %v", w.Code)
+	}
+
+	w = auth("secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Right password: got %v", w.Code)
+	}
+
+	var st shareToken
+	if err = json.NewDecoder(w.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != st.Token || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly cookie with the token, got %v", cookies)
+	}
+
+	if w = download("&token="+st.Token, nil); w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("Ranged download with token: got %v %q", w.Code, w.Body.String())
+	}
+
+	if w = download("", cookies[0]); w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("Ranged download with cookie: got %v %q", w.Code, w.Body.String())
+	}
+
+	// Tokens of other shares or tampered with are rejected.
+	if w = download("&token=1"+st.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Download with tampered token: got %v", w.Code)
+	}
+
+	// The browsers are asked for the password.
+	r, err = http.NewRequest("GET", "/share/"+link.Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Browser without token: got %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// The passwords can't be guessed endlessly.
+	for i := 0; i < shareAttemptsPerClient && w.Code != http.StatusTooManyRequests; i++ {
+		w = auth("wrong")
+	}
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Too many attempts: got %v", w.Code)
+	}
+
+	if w = auth("secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Right password after too many attempts: got %v", w.Code)
+	}
+}
+
+func TestBrowsableShare(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, dir := range []string{"shared/sub", "secret"} {
+		if err := os.MkdirAll(filepath.Join(scope, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		"shared/sub/file.txt": "inside",
+		"secret/file.txt":     "outside",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(scope, "secret"), filepath.Join(scope, "shared", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func(query string) shareLink {
+		r, err := http.NewRequest("POST", "/api/share/shared"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var link shareLink
+		if err = json.NewDecoder(w.Body).Decode(&link); err != nil {
+			t.Fatal(err)
+		}
+
+		return link
+	}
+
+	download := func(link shareLink, path string) string {
+		r, err := http.NewRequest("GET", "/share/"+link.Hash+path+"?dl=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	browse := share("?browse=true&expires=1")
+	if !browse.Browse {
+		t.Fatal("Share isn't browsable")
+	}
+
+	if got := download(browse, "/sub/file.txt"); got != "inside" {
+		t.Errorf("Download from browsable share: got %q", got)
+	}
+
+	for _, path := range []string{"/../secret/file.txt", "/sub/../../secret/file.txt", "/link/file.txt"} {
+		if got := download(browse, path); got == "outside" {
+			t.Errorf("%s: file outside of the share was downloaded", path)
+		}
+	}
+
+	// The listings are rendered from the share root and its subdirectories.
+	for _, path := range []string{"", "/", "/sub/"} {
+		r, err := http.NewRequest("GET", "/share/"+browse.Hash+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("Listing of %q: got %v", path, w.Code)
+		}
+	}
+
+	plain := share("?expires=1")
+	if got := download(plain, "/sub/file.txt"); got == "inside" {
+		t.Error("Subpath of a share which isn't browsable was downloaded")
+	}
+}
+
+func TestShareCleanup(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := os.MkdirAll(scope, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(scope, "file.txt"), []byte("file"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	links := []*shareLink{
+		{Hash: "active", Path: filepath.Join(scope, "file.txt"), Expires: true, ExpireDate: time.Now().Add(time.Hour)},
+		{Hash: "expired", Path: filepath.Join(scope, "file.txt"), Expires: true, ExpireDate: time.Now().Add(-time.Hour)},
+		{Hash: "dangling", Path: filepath.Join(scope, "missing.txt")},
+	}
+
+	for _, link := range links {
+		if err := fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := fm.shareCleaner(); n != 1 {
+		t.Fatalf("expected 1 share to be removed, got %d", n)
+	}
+
+	// The dangling shares are only removed when enabled.
+	fm.RemoveDanglingShares = true
+	if n := fm.shareCleaner(); n != 1 {
+		t.Fatalf("expected 1 share to be removed, got %d", n)
+	}
+
+	var left []shareLink
+	if err := fm.db.All(&left); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(left) != 1 || left[0].Hash != "active" {
+		t.Errorf("expected only the active share to be left, got %v", left)
+	}
+
+	// The cleanup doesn't run before the interval passes.
+	fm.ShareCleanupInterval = time.Hour
+	if err := fm.db.Save(links[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.shareCleanupTick()
+	if err := fm.db.One("Hash", "expired", &shareLink{}); err != nil {
+		t.Errorf("expected the cleanup not to run yet: %v", err)
+	}
+
+	fm.ShareCleanupInterval = time.Nanosecond
+	fm.shareCleanupTick()
+	if err := fm.db.One("Hash", "expired", &shareLink{}); err == nil {
+		t.Error("expected the expired share to be removed")
+	}
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	for _, dir := range []string{"private", "public"} {
+		if err := os.MkdirAll(filepath.Join(fm.Temp, "scope", dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"private/a.txt", "private/report.pdf", "public/logo.png"} {
+		err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", filepath.FromSlash(name)), []byte("content"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range []string{
+		"/private/=Cache-Control: no-store",
+		"/private/*.pdf=Cache-Control: private, max-age=60",
+		"/public/=cache-control: public, max-age=31536000",
+		"/public/=X-Robots-Tag: noindex",
+	} {
+		rule, err := ParseHeaderRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fm.DownloadHeaders = append(fm.DownloadHeaders, rule)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		path   string
+		header string
+		want   string
+	}{
+		{"/private/a.txt", "Cache-Control", "no-store"},
+		{"/private/report.pdf", "Cache-Control", "private, max-age=60"},
+		{"/public/logo.png", "Cache-Control", "public, max-age=31536000"},
+		{"/public/logo.png", "X-Robots-Tag", "noindex"},
+		{"/private/a.txt", "X-Robots-Tag", ""},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/api/download"+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %v", test.path, w.Code)
+		}
+
+		if got := w.Header().Get(test.header); got != test.want {
+			t.Errorf("%s: got %s %q want %q", test.path, test.header, got, test.want)
+		}
+	}
+
+	for _, s := range []string{"/private/", "/private/=no-colon", "=X-A: b", "[=X-A: b"} {
+		if _, err := ParseHeaderRule(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTarGzDownload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	dir := filepath.Join(fm.Temp, "scope", "dir")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mode := range map[string]os.FileMode{"run.sh": 0755, "sub/a.txt": 0640, "secret.txt": 0644} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(filepath.Join(dir, name), mode)
+	}
+
+	if err := os.Symlink("sub/a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skip("symbolic links aren't supported")
+	}
+
+	fm.Users["admin"].Rules = []*Rule{{Path: "/dir/secret.txt"}}
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	// entries lists the entries of the tarball with their modes and the
+	// targets of the links.
+	entries := func(w *httptest.ResponseRecorder) map[string]string {
+		if w.Code != http.StatusOK {
+			t.Fatalf("Download: got %v", w.Code)
+		}
+
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]string{}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got[header.Name] = fmt.Sprintf("%o %s", header.Mode&0777, header.Linkname)
+		}
+
+		return got
+	}
+
+	w := do("GET", "/api/download/dir?format=targz")
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "dir.tar.gz") {
+		t.Errorf("Got the disposition %q", disposition)
+	}
+
+	want := map[string]string{
+		"dir/":          "750 ",
+		"dir/link":      "777 sub/a.txt",
+		"dir/run.sh":    "755 ",
+		"dir/sub/":      "750 ",
+		"dir/sub/a.txt": "640 ",
+	}
+
+	if got := entries(w); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the entries %v, want %v", got, want)
+	}
+
+	w = do("POST", "/api/share/dir")
+	var link shareLink
+	if err := json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	// The shares have all of their files.
+	want["dir/secret.txt"] = "644 "
+	if got := entries(do("GET", "/share/"+link.Hash+"?dl=1&format=targz")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the entries of the share %v, want %v", got, want)
+	}
+}
+
+func TestInlineDownload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	files := map[string]string{
+		"a.txt":     "content",
+		"doc.pdf":   "%PDF-1.4",
+		"page.html": "<html><body></body></html>",
+		"image.svg": "<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>",
+		"noext":     "<html><body></body></html>",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		url         string
+		disposition string
+		typ         string
+	}{
+		{"/api/download/a.txt", "attachment", "text/plain"},
+		{"/api/download/a.txt?inline=true", "inline", "text/plain"},
+		{"/api/download/doc.pdf?inline=true", "inline", "application/pdf"},
+		{"/api/download/page.html?inline=true", "attachment", "text/html"},
+		{"/api/download/image.svg?inline=true", "attachment", "image/svg+xml"},
+		{"/api/download/noext?inline=true", "attachment", "text/html"},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %v", test.url, w.Code)
+		}
+
+		if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, test.disposition+";") {
+			t.Errorf("%s: got Content-Disposition %q, want %s", test.url, got, test.disposition)
+		}
+
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, test.typ) {
+			t.Errorf("%s: got Content-Type %q, want %s", test.url, got, test.typ)
+		}
+
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: got X-Content-Type-Options %q", test.url, got)
+		}
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.ContentTypes = map[string]string{
+		".m3u8": "application/x-mpegURL",
+		".txt":  "text/x-notes",
+	}
+
+	files := map[string]string{
+		"list.m3u8":  "#EXTM3U",
+		"NOTES.TXT":  "content",
+		"doc.noext1": "%PDF-1.4",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		url string
+		typ string
+	}{
+		{"/api/download/list.m3u8", "application/x-mpegURL"},
+		{"/api/download/list.m3u8?inline=true", "application/x-mpegURL"},
+		{"/api/download/NOTES.TXT", "text/x-notes"},
+		// Without an override, the type is detected from the content.
+		{"/api/download/doc.noext1", "application/pdf"},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %v", test.url, w.Code)
+		}
+
+		if got := w.Header().Get("Content-Type"); got != test.typ {
+			t.Errorf("%s: got Content-Type %q, want %s", test.url, got, test.typ)
+		}
+	}
+}
+
+func TestArchiveFormats(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := os.MkdirAll(filepath.Join(fm.Temp, "scope", "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	download := func(format string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/download/dir/?format="+format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := download("targz"); w.Code != http.StatusOK {
+		t.Errorf("Default format: got %v", w.Code)
+	}
+
+	if w := download("tarbz2"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Format disabled by default: got %v", w.Code)
+	}
+
+	fm.ArchiveFormats = []string{"targz"}
+
+	if w := download("zip"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Disabled format: got %v", w.Code)
+	}
+
+	w := download("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Without format: got %v", w.Code)
+	}
+
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, ".tar.gz") {
+		t.Errorf("Without format: got Content-Disposition %q", got)
+	}
+
+	// The pages of the shares link to the formats other than the default.
+	fm.ArchiveFormats = []string{"zip", "targz", "tarxz"}
+	if got := fmt.Sprint(fm.alternativeArchives()); got != "[{targz tar.gz} {tarxz tar.xz}]" {
+		t.Errorf("Alternative archives: got %s", got)
+	}
+
+	if err := CheckArchiveFormats([]string{"zip", "tarxz"}); err != nil {
+		t.Errorf("Supported formats: got %v", err)
+	}
+
+	if err := CheckArchiveFormats([]string{"zip", "rar"}); err == nil {
+		t.Errorf("Unsupported formats must fail")
+	}
+}
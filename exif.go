@@ -0,0 +1,353 @@
+package filemanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	errNotImage    = errors.New("the file isn't an image")
+	errInvalidExif = errors.New("invalid EXIF data")
+)
+
+// The EXIF tags which are read.
+const (
+	exifMake               = 0x010F
+	exifModel              = 0x0110
+	exifOrientation        = 0x0112
+	exifDateTime           = 0x0132
+	exifExposureTime       = 0x829A
+	exifFNumber            = 0x829D
+	exifIFD                = 0x8769
+	exifISO                = 0x8827
+	exifGPSIFD             = 0x8825
+	exifDateTimeOriginal   = 0x9003
+	exifOffsetTimeOriginal = 0x9011
+	exifFocalLength        = 0x920A
+	exifLensModel          = 0xA434
+	exifGPSLatitudeRef     = 0x0001
+	exifGPSLatitude        = 0x0002
+	exifGPSLongitudeRef    = 0x0003
+	exifGPSLongitude       = 0x0004
+)
+
+// exifTypeSizes are the sizes of the values of each EXIF type.
+var exifTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8,
+}
+
+// exifData are the fields of the EXIF metadata of an image shown to the
+// users. The missing ones are omitted.
+type exifData struct {
+	// Taken is when the photo was taken, in the time zone of the camera,
+	// with its offset if it is known.
+	Taken        string   `json:"taken,omitempty"`
+	Make         string   `json:"make,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Lens         string   `json:"lens,omitempty"`
+	Orientation  int      `json:"orientation,omitempty"`
+	ExposureTime string   `json:"exposureTime,omitempty"`
+	FNumber      float64  `json:"fNumber,omitempty"`
+	ISO          int      `json:"iso,omitempty"`
+	FocalLength  float64  `json:"focalLength,omitempty"`
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+}
+
+// exifHandler returns the EXIF metadata of the image in c.File. Images
+// without EXIF metadata, or in formats other than JPEG, have none. The
+// location is left out if RedactGPS is set.
+func exifHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.File.IsDir {
+		return http.StatusBadRequest, errNotImage
+	}
+
+	if err := c.File.GetFileType(false); err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if c.File.Type != "image" {
+		return http.StatusBadRequest, errNotImage
+	}
+
+	f, err := os.Open(c.File.Path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+	defer f.Close()
+
+	data, err := readExif(f)
+	if err == errInvalidExif {
+		// The metadata is broken, so the image is treated as if it
+		// had none.
+		data = &exifData{}
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if c.RedactGPS {
+		data.Latitude, data.Longitude = nil, nil
+	}
+
+	return renderJSON(w, data)
+}
+
+// readExif reads the EXIF metadata of a JPEG image. It is empty if the
+// image has none.
+func readExif(r io.Reader) (*exifData, error) {
+	br := bufio.NewReader(r)
+	data := &exifData{}
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return data, nil
+	}
+
+	// The metadata is in an APP1 segment before the image data.
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return data, nil
+		}
+
+		if marker[0] != 0xFF || marker[1] == 0xDA || marker[1] == 0xD9 {
+			return data, nil
+		}
+
+		length := int64(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, errInvalidExif
+		}
+
+		if marker[1] != 0xE1 {
+			if _, err := io.CopyN(ioutil.Discard, br, length); err != nil {
+				return data, nil
+			}
+
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return nil, errInvalidExif
+		}
+
+		// Other APP1 segments, such as XMP, are skipped.
+		if !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			continue
+		}
+
+		return parseExif(segment[6:])
+	}
+}
+
+// exifEntry is an entry of an IFD with the bytes of its values.
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifReader reads the IFDs of TIFF data, which is how EXIF is stored.
+type exifReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifd reads the entries of the IFD at an offset.
+func (e *exifReader) ifd(offset uint32) (map[uint16]exifEntry, error) {
+	if uint64(offset)+2 > uint64(len(e.data)) {
+		return nil, errInvalidExif
+	}
+
+	n := int(e.order.Uint16(e.data[offset:]))
+	start := uint64(offset) + 2
+	if start+uint64(n)*12 > uint64(len(e.data)) {
+		return nil, errInvalidExif
+	}
+
+	entries := map[uint16]exifEntry{}
+	for i := 0; i < n; i++ {
+		raw := e.data[start+uint64(i)*12:]
+		entry := exifEntry{
+			typ:   e.order.Uint16(raw[2:]),
+			count: e.order.Uint32(raw[4:]),
+		}
+
+		size, ok := exifTypeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+
+		// The values of up to 4 bytes are stored in the entry itself.
+		length := size * uint64(entry.count)
+		if length <= 4 {
+			entry.value = raw[8 : 8+length]
+		} else {
+			at := uint64(e.order.Uint32(raw[8:]))
+			if at+length > uint64(len(e.data)) {
+				continue
+			}
+
+			entry.value = e.data[at : at+length]
+		}
+
+		entries[e.order.Uint16(raw)] = entry
+	}
+
+	return entries, nil
+}
+
+// string returns the value of an ASCII entry.
+func (e exifEntry) string() string {
+	if e.typ != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// uint returns the first value of a SHORT or LONG entry.
+func (e exifEntry) uint(order binary.ByteOrder) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return order.Uint32(e.value), true
+	}
+
+	return 0, false
+}
+
+// rational returns the ith value of a RATIONAL entry as its numerator
+// and denominator.
+func (e exifEntry) rational(order binary.ByteOrder, i int) (uint32, uint32, bool) {
+	if e.typ != 5 || len(e.value) < (i+1)*8 {
+		return 0, 0, false
+	}
+
+	return order.Uint32(e.value[i*8:]), order.Uint32(e.value[i*8+4:]), true
+}
+
+// float returns the ith value of a RATIONAL entry.
+func (e exifEntry) float(order binary.ByteOrder, i int) (float64, bool) {
+	num, den, ok := e.rational(order, i)
+	if !ok || den == 0 {
+		return 0, false
+	}
+
+	return float64(num) / float64(den), true
+}
+
+// parseExif reads the fields of exifData from TIFF data.
+func parseExif(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, errInvalidExif
+	}
+
+	e := &exifReader{data: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		e.order = binary.LittleEndian
+	case "MM":
+		e.order = binary.BigEndian
+	default:
+		return nil, errInvalidExif
+	}
+
+	ifd0, err := e.ifd(e.order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	data := &exifData{
+		Make:  ifd0[exifMake].string(),
+		Model: ifd0[exifModel].string(),
+		Taken: exifTime(ifd0[exifDateTime].string(), ""),
+	}
+
+	if orientation, ok := ifd0[exifOrientation].uint(e.order); ok {
+		data.Orientation = int(orientation)
+	}
+
+	if offset, ok := ifd0[exifIFD].uint(e.order); ok {
+		sub, err := e.ifd(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if taken := exifTime(sub[exifDateTimeOriginal].string(), sub[exifOffsetTimeOriginal].string()); taken != "" {
+			data.Taken = taken
+		}
+
+		data.Lens = sub[exifLensModel].string()
+		data.FNumber, _ = sub[exifFNumber].float(e.order, 0)
+		data.FocalLength, _ = sub[exifFocalLength].float(e.order, 0)
+
+		if num, den, ok := sub[exifExposureTime].rational(e.order, 0); ok && den != 0 {
+			data.ExposureTime = fmt.Sprintf("%d/%d", num, den)
+		}
+
+		if iso, ok := sub[exifISO].uint(e.order); ok {
+			data.ISO = int(iso)
+		}
+	}
+
+	if offset, ok := ifd0[exifGPSIFD].uint(e.order); ok {
+		gps, err := e.ifd(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Latitude = exifCoordinate(gps[exifGPSLatitude], gps[exifGPSLatitudeRef].string(), "S", e.order)
+		data.Longitude = exifCoordinate(gps[exifGPSLongitude], gps[exifGPSLongitudeRef].string(), "W", e.order)
+	}
+
+	return data, nil
+}
+
+// exifTime formats an EXIF date, with its offset if it is known. It is
+// empty if the date is invalid.
+func exifTime(date, offset string) string {
+	t, err := time.Parse("2006:01:02 15:04:05", date)
+	if err != nil {
+		return ""
+	}
+
+	if _, err = time.Parse("-07:00", offset); err == nil {
+		return t.Format("2006-01-02T15:04:05") + offset
+	}
+
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// exifCoordinate converts a GPS coordinate, given in degrees, minutes and
+// seconds, to degrees. It is negative if the reference is negative, such
+// as south or west.
+func exifCoordinate(e exifEntry, ref, negative string, order binary.ByteOrder) *float64 {
+	var parts [3]float64
+	for i := range parts {
+		v, ok := e.float(order, i)
+		if !ok {
+			return nil
+		}
+
+		parts[i] = v
+	}
+
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref == negative {
+		degrees = -degrees
+	}
+
+	return &degrees
+}
@@ -0,0 +1,198 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// testExifEntry is an entry of an IFD made by testTIFF. If ifd is set,
+// the entry points to that IFD.
+type testExifEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+	ifd      int
+}
+
+// testTIFF makes big endian TIFF data with the IFDs, the first being IFD0.
+func testTIFF(ifds ...[]testExifEntry) []byte {
+	order := binary.BigEndian
+	offsets := make([]uint32, len(ifds))
+	cursor := uint32(8)
+
+	for i, ifd := range ifds {
+		offsets[i] = cursor
+		cursor += 2 + 12*uint32(len(ifd)) + 4
+		for _, e := range ifd {
+			if len(e.value) > 4 {
+				cursor += uint32(len(e.value))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("MM\x00\x2A")
+	binary.Write(&buf, order, offsets[0])
+
+	for i, ifd := range ifds {
+		data := offsets[i] + 2 + 12*uint32(len(ifd)) + 4
+		var values bytes.Buffer
+
+		binary.Write(&buf, order, uint16(len(ifd)))
+		for _, e := range ifd {
+			if e.ifd > 0 {
+				e.typ, e.count = 4, 1
+				e.value = make([]byte, 4)
+				order.PutUint32(e.value, offsets[e.ifd])
+			}
+
+			binary.Write(&buf, order, e.tag)
+			binary.Write(&buf, order, e.typ)
+			binary.Write(&buf, order, e.count)
+
+			if len(e.value) > 4 {
+				binary.Write(&buf, order, data+uint32(values.Len()))
+				values.Write(e.value)
+			} else {
+				inline := make([]byte, 4)
+				copy(inline, e.value)
+				buf.Write(inline)
+			}
+		}
+
+		binary.Write(&buf, order, uint32(0))
+		buf.Write(values.Bytes())
+	}
+
+	return buf.Bytes()
+}
+
+// testRationals encodes rationals given as numerator and denominator pairs.
+func testRationals(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[i*4:], v)
+	}
+
+	return b
+}
+
+// testJPEG makes a JPEG with an APP0 segment and, if it isn't empty, an
+// EXIF segment with the TIFF data.
+func testJPEG(tiff []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x07})
+	buf.WriteString("JFIF\x00")
+
+	if tiff != nil {
+		buf.Write([]byte{0xFF, 0xE1})
+		binary.Write(&buf, binary.BigEndian, uint16(len(tiff)+8))
+		buf.WriteString("Exif\x00\x00")
+		buf.Write(tiff)
+	}
+
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func TestExif(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	tiff := testTIFF(
+		[]testExifEntry{
+			{tag: exifMake, typ: 2, count: 6, value: []byte("Canon\x00")},
+			{tag: exifModel, typ: 2, count: 4, value: []byte("R5\x00\x00")},
+			{tag: exifOrientation, typ: 3, count: 1, value: []byte{0, 6}},
+			{tag: exifIFD, ifd: 1},
+			{tag: exifGPSIFD, ifd: 2},
+		},
+		[]testExifEntry{
+			{tag: exifDateTimeOriginal, typ: 2, count: 20, value: []byte("2020:06:15 10:30:00\x00")},
+			{tag: exifOffsetTimeOriginal, typ: 2, count: 7, value: []byte("+02:00\x00")},
+			{tag: exifExposureTime, typ: 5, count: 1, value: testRationals(1, 250)},
+			{tag: exifFNumber, typ: 5, count: 1, value: testRationals(28, 10)},
+			{tag: exifISO, typ: 3, count: 1, value: []byte{0x01, 0x90}},
+		},
+		[]testExifEntry{
+			{tag: exifGPSLatitudeRef, typ: 2, count: 2, value: []byte("N\x00")},
+			{tag: exifGPSLatitude, typ: 5, count: 3, value: testRationals(38, 1, 30, 1, 0, 1)},
+			{tag: exifGPSLongitudeRef, typ: 2, count: 2, value: []byte("W\x00")},
+			{tag: exifGPSLongitude, typ: 5, count: 3, value: testRationals(9, 1, 15, 1, 36, 1)},
+		},
+	)
+
+	scope := filepath.Join(fm.Temp, "scope")
+	files := map[string][]byte{
+		"photo.jpg":  testJPEG(tiff),
+		"plain.jpg":  testJPEG(nil),
+		"broken.jpg": testJPEG([]byte("MM\x00\x2A\x00\x00\xFF\xFF")),
+		"text.txt":   []byte("not an image"),
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(name string) (int, *exifData) {
+		r, err := http.NewRequest("GET", "/api/exif/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		data := &exifData{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), data); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, data
+	}
+
+	code, data := get("photo.jpg")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", code)
+	}
+
+	if data.Make != "Canon" || data.Model != "R5" || data.Orientation != 6 {
+		t.Errorf("Wrong camera: %+v", data)
+	}
+
+	if data.Taken != "2020-06-15T10:30:00+02:00" || data.ExposureTime != "1/250" || data.FNumber != 2.8 || data.ISO != 400 {
+		t.Errorf("Wrong exposure: %+v", data)
+	}
+
+	if data.Latitude == nil || *data.Latitude != 38.5 || data.Longitude == nil || *data.Longitude != -9.26 {
+		t.Errorf("Wrong location: %v %v", data.Latitude, data.Longitude)
+	}
+
+	fm.RedactGPS = true
+	if _, data = get("photo.jpg"); data.Latitude != nil || data.Longitude != nil || data.Make != "Canon" {
+		t.Errorf("Expected only the location to be redacted: %+v", data)
+	}
+
+	for _, name := range []string{"plain.jpg", "broken.jpg"} {
+		if code, data = get(name); code != http.StatusOK || *data != (exifData{}) {
+			t.Errorf("%s: expected no metadata, got %v %+v", name, code, data)
+		}
+	}
+
+	if code, _ = get("text.txt"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a file which isn't an image, got %v", code)
+	}
+}
@@ -0,0 +1,166 @@
+package filemanager
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// userExport is everything the server keeps about a user, so the users
+// can get a copy of their data. The password of the user and the ones of
+// the shares aren't included.
+type userExport struct {
+	Exported time.Time    `json:"exported"`
+	Profile  *User        `json:"profile"`
+	Shares   []*shareLink `json:"shares"`
+	// Commands is the command history of the user, which is the only
+	// record the server keeps of what the users do.
+	Commands []commandRecord `json:"commands"`
+	// Uploads are the unfinished resumable uploads of the user.
+	Uploads []upload `json:"uploads"`
+}
+
+// exportHandler downloads the data of the user, as a JSON document or as
+// a zip archive with a JSON file for each kind of data if the 'format'
+// query parameter is 'zip'. Admins can export the data of other users
+// with the 'user' query parameter.
+func exportHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	if format != "json" && format != "zip" {
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	id := c.User.ID
+
+	if val := query.Get("user"); val != "" {
+		other, err := strconv.Atoi(val)
+		if err != nil {
+			return http.StatusBadRequest, errInvalidOption
+		}
+
+		if other != id && !c.User.Admin {
+			return http.StatusForbidden, nil
+		}
+
+		id = other
+	}
+
+	data, err := c.exportUser(id)
+	if err == storm.ErrNotFound {
+		return http.StatusNotFound, errUserNotExist
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	name := "filemanager-" + data.Profile.Username + "-" + data.Exported.Format("20060102150405") + "." + format
+	w.Header().Set("Content-Disposition", attachment(name))
+
+	if format == "json" {
+		return renderJSON(w, data)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	if err := writeExportZip(w, data); err != nil {
+		// The headers were already sent so the error can only be logged.
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// exportUser reads the data of the user with the ID. It is read in a
+// single transaction so it is consistent even if it changes meanwhile.
+func (m *FileManager) exportUser(id int) (*userExport, error) {
+	data := &userExport{
+		Exported: time.Now(),
+		Profile:  &User{},
+		Shares:   []*shareLink{},
+		Commands: []commandRecord{},
+		Uploads:  []upload{},
+	}
+
+	err := m.db.Bolt.View(func(tx *bolt.Tx) error {
+		node := m.db.WithTransaction(tx)
+
+		if err := node.One("ID", id, data.Profile); err != nil {
+			return err
+		}
+
+		err := node.Find("UserID", id, &data.Shares)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		err = node.Find("UserID", id, &data.Commands)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		err = node.Find("Username", data.Profile.Username, &data.Uploads)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	data.Profile.Password = ""
+	data.Profile.Environment = nil
+	hidePasswords(data.Shares)
+	return data, nil
+}
+
+// writeExportZip writes the data of a user as a zip archive with a JSON
+// file for each kind of data.
+func writeExportZip(w http.ResponseWriter, data *userExport) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", data.Profile},
+		{"shares.json", data.Shares},
+		{"commands.json", data.Commands},
+		{"uploads.json", data.Uploads},
+	}
+
+	for _, file := range files {
+		out, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: data.Exported,
+		})
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,192 @@
+package filemanager
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportUser(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.CommandHistory = 10
+
+	password, err := hashPassword("secret", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &User{
+		Username:    "alice",
+		Password:    password,
+		FileSystem:  fm.Users["admin"].FileSystem,
+		Environment: map[string]string{"API_KEY": "env-secret"},
+	}
+	if err = fm.db.Save(alice); err != nil {
+		t.Fatal(err)
+	}
+	fm.Users["alice"] = alice
+
+	links := []*shareLink{
+		{Hash: "a1", Path: "/a.txt", UserID: alice.ID, Password: "hash"},
+		{Hash: "a2", Path: "/b.txt", UserID: alice.ID, Expires: true, ExpireDate: time.Now().Add(time.Hour)},
+		{Hash: "b1", Path: "/c.txt", UserID: 1},
+	}
+
+	for _, link := range links {
+		if err = fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.recordCommand(&commandRecord{UserID: alice.ID, Command: "git status"})
+	fm.recordCommand(&commandRecord{UserID: 1, Command: "git log"})
+
+	if err = fm.db.Save(&upload{ID: "u1", Username: "alice", Path: "/big.iso", Length: 1024}); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := login(t, fm, defaultCredentials)
+	user := login(t, fm, `{"username":"alice","password":"secret"}`)
+
+	do := func(token, query string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/export/"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	check := func(name string, data *userExport, username string, shares []string, commands []string) {
+		if data.Profile.Username != username {
+			t.Errorf("%s: got the profile of %q, want %q", name, data.Profile.Username, username)
+		}
+
+		if data.Profile.Password != "" {
+			t.Errorf("%s: the password hash was exported", name)
+		}
+
+		got := []string{}
+		for _, link := range data.Shares {
+			got = append(got, link.Hash)
+			if link.Password != "" {
+				t.Errorf("%s: the password of the share %s was exported", name, link.Hash)
+			}
+		}
+		sort.Strings(got)
+
+		if fmt.Sprint(got) != fmt.Sprint(shares) {
+			t.Errorf("%s: got the shares %v, want %v", name, got, shares)
+		}
+
+		got = []string{}
+		for _, record := range data.Commands {
+			got = append(got, record.Command)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(commands) {
+			t.Errorf("%s: got the commands %v, want %v", name, got, commands)
+		}
+	}
+
+	w := do(user, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Own export: expected 200, got %v", w.Code)
+	}
+
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "filemanager-alice-") {
+		t.Errorf("Own export: got the Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+
+	if strings.Contains(w.Body.String(), password) {
+		t.Error("Own export: the password hash was exported")
+	}
+
+	if strings.Contains(w.Body.String(), "env-secret") {
+		t.Error("Own export: the environment was exported")
+	}
+
+	// The tokens are readable by anyone who holds them, so they don't
+	// carry the environment either.
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(user, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(payload), "env-secret") {
+		t.Errorf("The environment is in the token: %s", payload)
+	}
+
+	data := &userExport{}
+	if err = json.Unmarshal(w.Body.Bytes(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	check("Own export", data, "alice", []string{"a1", "a2"}, []string{"git status"})
+
+	if len(data.Uploads) != 1 || data.Uploads[0].ID != "u1" {
+		t.Errorf("Own export: got the uploads %+v", data.Uploads)
+	}
+
+	data = &userExport{}
+	if err = json.Unmarshal(do(admin, "?user=1").Body.Bytes(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	check("Admin export", data, "admin", []string{"b1"}, []string{"git log"})
+
+	data = &userExport{}
+	if err = json.Unmarshal(do(admin, fmt.Sprintf("?user=%d", alice.ID)).Body.Bytes(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	check("Export by an admin", data, "alice", []string{"a1", "a2"}, []string{"git status"})
+
+	w = do(user, "?format=zip")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Zip export: expected 200, got %v", w.Code)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+
+	if fmt.Sprint(names) != "[profile.json shares.json commands.json uploads.json]" {
+		t.Errorf("Zip export: got the files %v", names)
+	}
+
+	tests := []struct {
+		token, query string
+		code         int
+	}{
+		{user, "?user=1", http.StatusForbidden},
+		{admin, "?user=99", http.StatusNotFound},
+		{admin, "?user=admin", http.StatusBadRequest},
+		{admin, "?format=xml", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if w := do(test.token, test.query); w.Code != test.code {
+			t.Errorf("Export with %q: expected %v, got %v", test.query, test.code, w.Code)
+		}
+	}
+}
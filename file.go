@@ -45,6 +45,12 @@ type file struct {
 	Mode os.FileMode `json:"mode"`
 	// Indicates if this file is a directory.
 	IsDir bool `json:"isDir"`
+	// Indicates if this file is a symbolic link.
+	Symlink bool `json:"symlink,omitempty"`
+	// Indicates if this file is a symbolic link whose target is missing.
+	Broken bool `json:"broken,omitempty"`
+	// The user and the group which own the file, if ShowOwners is set.
+	Owner *owner `json:"owner,omitempty"`
 	// Absolute path.
 	Path string `json:"path"`
 	// Relative path to user's virtual File System.
@@ -53,6 +59,15 @@ type file struct {
 	Type string `json:"type"`
 	// Stores the content of a text file.
 	Content string `json:"content,omitempty"`
+	// Indicates if the file is larger than the maximum size of the
+	// previews, in which case its content isn't included.
+	TooLarge bool `json:"tooLarge,omitempty"`
+	// Indicates if the content is only the head or the tail of the file.
+	Partial bool `json:"partial,omitempty"`
+	// Small version of an image as a data URI.
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// Checksum of the file, only included in listings if requested.
+	Hash string `json:"hash,omitempty"`
 
 	*listing `json:",omitempty"`
 
@@ -72,8 +87,13 @@ type listing struct {
 	Sort string `json:"sort"`
 	// And which order.
 	Order string `json:"order"`
-	// Displays in mosaic or list.
+	// Displays in list, grid or mosaic.
 	Display string `json:"display"`
+	// Tells if some of the requested checksums were omitted
+	// because the files are too many or too big.
+	HashesOmitted bool `json:"hashesOmitted,omitempty"`
+	// The rendered README of the directory, if enabled.
+	Readme *readme `json:"readme,omitempty"`
 }
 
 // getInfo gets the file information and, in case of error, returns the
@@ -88,6 +108,21 @@ func getInfo(url *url.URL, c *FileManager, u *User) (*file, error) {
 	}
 
 	info, err := u.FileSystem.Stat(url.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return i, err
+	}
+
+	// Broken symbolic links are described by themselves, and so are the
+	// ones out of the scope if ResolveSymlinks is set.
+	if link, lerr := os.Lstat(i.Path); lerr == nil && link.Mode()&os.ModeSymlink != 0 {
+		target, broken := symlinkTarget(u, url.Path)
+		i.Symlink, i.Broken = true, broken
+
+		if broken || (c.ResolveSymlinks && target == nil) {
+			info, err = link, nil
+		}
+	}
+
 	if err != nil {
 		return i, err
 	}
@@ -99,6 +134,10 @@ func getInfo(url *url.URL, c *FileManager, u *User) (*file, error) {
 	i.Size = info.Size()
 	i.Extension = filepath.Ext(i.Name)
 
+	if c.ShowOwners {
+		i.Owner = c.fileOwner(info)
+	}
+
 	if i.IsDir && !strings.HasSuffix(i.URL, "/") {
 		i.URL += "/"
 	}
@@ -132,6 +171,9 @@ func (i *file) getListing(c *RequestContext, r *http.Request) error {
 		return err
 	}
 
+	showHidden := r.URL.Query().Get("hidden") == "true"
+	dirsOnly := r.URL.Query().Get("dirsOnly") == "true"
+
 	for _, f := range files {
 		name := f.Name()
 		allowed := c.User.Allowed("/" + name)
@@ -140,7 +182,34 @@ func (i *file) getListing(c *RequestContext, r *http.Request) error {
 			continue
 		}
 
-		if f.IsDir() {
+		// The regular files are skipped early since they can't be
+		// directories, unlike the symbolic links.
+		if dirsOnly && f.Mode().IsRegular() {
+			continue
+		}
+
+		if partialFile(name) || (!showHidden && c.ignored(c.User, filepath.Join(i.VirtualPath, name))) {
+			continue
+		}
+
+		// The symbolic links are described by their targets if they're
+		// in the scope and ResolveSymlinks is set.
+		info, symlink, broken := f, false, false
+		if f.Mode()&os.ModeSymlink != 0 {
+			var target os.FileInfo
+			target, broken = symlinkTarget(c.User, filepath.Join(i.VirtualPath, name))
+			symlink = true
+
+			if target != nil && c.ResolveSymlinks {
+				info = target
+			}
+		}
+
+		if dirsOnly && !info.IsDir() {
+			continue
+		}
+
+		if info.IsDir() {
 			name += "/"
 			dirCount++
 		} else {
@@ -152,16 +221,22 @@ func (i *file) getListing(c *RequestContext, r *http.Request) error {
 
 		i := &file{
 			Name:        f.Name(),
-			Size:        f.Size(),
-			ModTime:     f.ModTime(),
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
 			Mode:        f.Mode(),
-			IsDir:       f.IsDir(),
+			IsDir:       info.IsDir(),
+			Symlink:     symlink,
+			Broken:      broken,
 			URL:         url.String(),
 			Extension:   filepath.Ext(name),
 			VirtualPath: filepath.Join(i.VirtualPath, name),
 			Path:        filepath.Join(i.Path, name),
 		}
 
+		if c.ShowOwners {
+			i.Owner = c.fileOwner(info)
+		}
+
 		i.GetFileType(false)
 		fileinfos = append(fileinfos, i)
 	}
@@ -206,9 +281,6 @@ func (i *file) getEditor() error {
 // GetFileType obtains the mimetype and converts it to a simple
 // type nomenclature.
 func (i *file) GetFileType(checkContent bool) error {
-	var content []byte
-	var err error
-
 	// Tries to get the file mimetype using its extension.
 	mimetype := mime.TypeByExtension(i.Extension)
 
@@ -248,12 +320,12 @@ func (i *file) GetFileType(checkContent bool) error {
 
 	if strings.HasPrefix(mimetype, "text") {
 		i.Type = "text"
-		goto End
+		return nil
 	}
 
 	if strings.HasPrefix(mimetype, "application/javascript") {
 		i.Type = "text"
-		goto End
+		return nil
 	}
 
 	// If the type isn't text (and is blob for example), it will check some
@@ -261,25 +333,22 @@ func (i *file) GetFileType(checkContent bool) error {
 	for _, extension := range textExtensions {
 		if strings.HasSuffix(i.Name, extension) {
 			i.Type = "text"
-			goto End
+			return nil
 		}
 	}
 
 	i.Type = "blob"
+	return nil
+}
 
-End:
-	// If the file type is text, save its content.
-	if i.Type == "text" {
-		if len(content) == 0 {
-			content, err = ioutil.ReadFile(i.Path)
-			if err != nil {
-				return err
-			}
-		}
-
-		i.Content = string(content)
+// readContent reads the content of a text file.
+func (i *file) readContent() error {
+	content, err := ioutil.ReadFile(i.Path)
+	if err != nil {
+		return err
 	}
 
+	i.Content = string(content)
 	return nil
 }
 
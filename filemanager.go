@@ -56,12 +56,14 @@ package filemanager
 import (
 	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	rice "github.com/GeertJohan/go.rice"
@@ -78,8 +80,13 @@ var (
 	errEmptyPassword      = errors.New("password is empty")
 	errEmptyUsername      = errors.New("username is empty")
 	errEmptyScope         = errors.New("scope is empty")
+	errScopeNotDir        = errors.New("scope is not a directory")
 	errWrongDataType      = errors.New("wrong data type")
 	errInvalidUpdateField = errors.New("invalid field to update")
+	errInvalidEnvironment = errors.New("invalid environment variable name")
+	errInvalidAvatar      = errors.New("avatar must be an http(s) URL or an email")
+	errInvalidViewMode    = errors.New("view mode must be list, grid or mosaic")
+	errInvalidLandingPath = errors.New("landing path must be absolute and allowed to the user")
 )
 
 // FileManager is a file manager instance. It should be creating using the
@@ -88,15 +95,62 @@ type FileManager struct {
 	// The BoltDB database for this instance.
 	db *storm.DB
 
-	// The key used to sign the JWT tokens.
+	// DatabasePath is the path of the database file and DatabaseOptions
+	// the options used to open it. They are set by NewWithOptions and
+	// changing them has no effect.
+	DatabasePath    string
+	DatabaseOptions DatabaseOptions
+
+	// The key used to sign the JWT tokens and the download links.
 	key []byte
 
+	// The previous keys which are still accepted during their grace
+	// period and the mutex which protects both.
+	oldKeys []oldKey
+	keyMu   *sync.RWMutex
+
 	// The static assets.
 	assets *rice.Box
 
 	// Job cron.
 	cron *cron.Cron
 
+	// The cache of the thumbnails embedded in listings.
+	thumbnails *thumbnailCache
+
+	// The operations which must finish before shutting down.
+	shutdown *shutdownState
+
+	// The read-only mode, which can be toggled at runtime.
+	readOnly *readOnlyState
+
+	// The cache of the checksums of the files.
+	checksums *checksumCache
+
+	// The actions which are running or have finished recently.
+	jobs *jobList
+
+	// The state store used if State isn't set.
+	memoryState StateStore
+
+	// The feed of changes made to the files. It is nil unless
+	// EnableChangeFeed was called.
+	changes *changeFeed
+
+	// When the shares were last cleaned up.
+	shareCleanup *shareCleanupState
+
+	// Held while a share is created so the share limit is checked and the
+	// share is saved at once.
+	shareMu *sync.Mutex
+
+	// Held while an upload is created so the uploads of a user can't
+	// exceed the maximum pending size together.
+	uploadsMu *sync.Mutex
+
+	// The reverse proxies whose X-Forwarded-For header is trusted.
+	trustedProxies []*net.IPNet
+
 	// PrefixURL is a part of the URL that is already trimmed from the request URL before it
 	// arrives to our handlers. It may be useful when using File Manager as a middleware
 	// such as in caddy-filemanager plugin. It is only useful in certain situations.
@@ -107,10 +161,302 @@ type FileManager struct {
 	// edited directly. Use SetBaseURL.
 	BaseURL string
 
+	// AssetsDir is a directory whose files override the embedded assets
+	// with the same path, such as 'static/img/logo.svg', so they can be
+	// replaced without rebuilding.
+	AssetsDir string
+
+	// TempDir is the directory where the temporary files are created. If
+	// it is empty, the system's one is used. It shouldn't be edited
+	// directly. Use SetTempDir.
+	TempDir string
+
 	// NoAuth disables the authentication. When the authentication is disabled,
 	// there will only exist one user, called "admin".
 	NoAuth bool
 
+	// MaxDepth is the maximum depth of the directories walked by recursive
+	// operations, such as search. Zero means there is no limit.
+	MaxDepth int
+
+	// MaxPreviewSize is the maximum size, in bytes, of the text files and
+	// READMEs whose content is previewed. The larger ones are offered for
+	// download instead. Zero means there is no limit.
+	MaxPreviewSize int64
+
+	// MaxURLLength, MaxHeaderBytes and MaxQueryParams limit the length of
+	// the URLs, the size of the headers and the number of query parameters
+	// of the requests. The larger requests are rejected with 414 URI Too
+	// Long or 431 Request Header Fields Too Large. Zero means there is no
+	// limit.
+	MaxURLLength   int
+	MaxHeaderBytes int
+	MaxQueryParams int
+
+	// DeleteConfirmThreshold is the maximum number of entries a delete can
+	// remove without being confirmed with the token from a dry-run. Zero
+	// means deletes never need to be confirmed.
+	DeleteConfirmThreshold int
+
+	// Limits are the maximum number of expensive operations, such as
+	// checksums and archives, which can run at once.
+	Limits Limits
+
+	// semaphores enforce the limits.
+	semaphores *semaphores
+
+	// downloads counts the downloads in progress of each user.
+	downloads *downloadSlots
+
+	// ShutdownTimeout is how long the server waits for the downloads and
+	// jobs in progress when it's shut down.
+	ShutdownTimeout time.Duration
+
+	// ChecksumAlgorithms are the checksum algorithms the clients can use,
+	// such as 'sha256'. If it is empty, every supported algorithm is.
+	ChecksumAlgorithms []string
+
+	// ArchiveFormats are the formats the clients can download the
+	// directories as, such as 'zip' or 'tarxz'. If it is empty, they
+	// can use zip, tar and tar.gz.
+	ArchiveFormats []string
+
+	// Debug adds a Server-Timing header to the responses of the API with
+	// the time spent on the authentication, on the permission checks and
+	// on the handler of the request. The headers of the failed requests
+	// are logged too.
+	Debug bool
+
+	// LogRedact are the names of the query parameters and the headers
+	// whose values are masked in the logs. Only the values of the known
+	// harmless ones, such as the sort order or the user agent, are ever
+	// logged and those in the list are masked too.
+	LogRedact []string
+
+	// DownloadName is the template of the names of the downloaded files.
+	// '{name}' is replaced by the original name, '{base}' by the name
+	// without its extension and '{ext}' by the extension, '{date}' and
+	// '{time}' by the current date and time, '{user}' by the username and
+	// '{share}' by the hash of the share. If it is empty, the original
+	// name is used.
+	DownloadName string
+
+	// AuthCookie makes the server also send the authentication token in
+	// an HttpOnly, Secure and SameSite cookie, which is accepted instead
+	// of the Authorization header. It allows browsers to navigate to
+	// protected URLs, such as downloads, but the cookie is only sent
+	// over HTTPS.
+	AuthCookie bool
+
+	// IdleTimeout is how long the tokens can go unused before they are
+	// rejected, even if they haven't expired. Zero disables it.
+	IdleTimeout time.Duration
+
+	// PasswordHash is the algorithm of the password hashes, HashBcrypt or
+	// HashArgon2id. Empty means bcrypt.
+	PasswordHash string
+
+	// PasswordCost is the cost of the password hashes, which is the number
+	// of iterations for argon2id. Zero means the default of the algorithm.
+	// The hashes made with other parameters are upgraded when their users
+	// log in.
+	PasswordCost int
+
+	// Actions are the command templates the users who can execute
+	// commands can run on files.
+	Actions []Action
+
+	// UploadHook is the command template, like those of the actions, run
+	// on the files uploaded through the API once they are in place. It
+	// runs in the background as a job of the user named "upload", so it
+	// doesn't delay the uploads.
+	UploadHook *Action
+
+	// Ignore are the glob patterns of the entries which are hidden from the
+	// listings and the search of every user, such as '.git' or
+	// 'node_modules'. They can be shown using the 'hidden' query parameter.
+	Ignore []string
+
+	// UploadPolicy restricts the types of the files every user can
+	// upload. Each user may have their own policy too.
+	UploadPolicy UploadPolicy
+
+	// NoDotDirs forbids creating directories whose names start with a dot,
+	// such as '.git', through uploads. Existing ones can still be used.
+	NoDotDirs bool
+
+	// NoCaseCollisions rejects creating or renaming files and directories
+	// whose paths differ only in case from existing ones, even on case
+	// sensitive file systems, so the scopes can be synced to the case
+	// insensitive ones without overwriting files.
+	NoCaseCollisions bool
+
+	// Xattrs enables reading and setting the extended attributes of the
+	// files, on the platforms which support them.
+	Xattrs bool
+
+	// ShowOwners adds the users and the groups which own the files to the
+	// listings, on the platforms which have them. It is disabled by
+	// default since looking up their names has a cost.
+	ShowOwners bool
+
+	// The cache of the names of the owners of the files.
+	owners *ownerCache
+
+	// Branding customizes the login page.
+	Branding Branding
+
+	// OIDC enables the login through an OpenID Connect provider.
+	OIDC *OIDC
+
+	// Scanner checks the uploaded files before they are saved. Uploads
+	// rejected by it fail with 422 Unprocessable Entity.
+	Scanner Scanner
+
+	// HomeSkeleton is a directory whose contents are copied into the
+	// scopes created for new users.
+	HomeSkeleton string
+
+	// RemoveHomes makes deleting a user also remove their scope, unless
+	// it is used by other users or it isn't inside HomeRoot.
+	RemoveHomes bool
+
+	// HomeArchive is a directory where the scopes of the deleted users
+	// are moved to instead of being removed.
+	HomeArchive string
+
+	// HomeRoot is the directory with the homes of the users. Only the
+	// scopes inside it are removed or archived when their users are
+	// deleted, so the other directories, such as '/' or '/etc', are never
+	// touched. Empty means no scope is removed nor archived.
+	HomeRoot string
+
+	// DefaultShareExpiry is the expiry of the shares created without one.
+	// Zero means they are permanent.
+	DefaultShareExpiry time.Duration
+
+	// MaxShareExpiry is the maximum expiry of the shares. If it is set,
+	// permanent shares can't be created.
+	MaxShareExpiry time.Duration
+
+	// MaxLinkExpiry is the maximum expiry of the signed download links.
+	// The links requested without one expire after an hour or after
+	// it, if it's shorter. Zero means there is no limit.
+	MaxLinkExpiry time.Duration
+
+	// MaxShares is the maximum number of shares, not counting the expired
+	// ones, each user can have. The users can have their own limit. Zero
+	// means there is no limit.
+	MaxShares int
+
+	// MaxDownloads is the maximum number of downloads each user can have
+	// in progress at once. The users can have their own limit. Zero means
+	// there is no limit.
+	MaxDownloads int
+
+	// RateLimit is the maximum number of requests to the API each user, or
+	// each IP address if there is no authentication, can make per minute.
+	// The users can have their own limit. Zero means there is no limit.
+	RateLimit int
+
+	// DownloadRateLimit is the maximum number of downloads each user can
+	// start per minute. Zero means RateLimit applies to them too and a
+	// negative number means there is no limit.
+	DownloadRateLimit int
+
+	// RateBurst is the number of requests, or downloads, each user can
+	// make at once. The limits above are the rates at which they can make
+	// more. Zero means the limits themselves.
+	RateBurst int
+
+	// ShareFolder is a directory in the scope of each user, such as
+	// '/public', where files can be copied or moved to and shared in a
+	// single step. Empty disables it.
+	ShareFolder string
+
+	// Notifier is notified when the shares are created and before they
+	// expire, such as an SMTPNotifier.
+	Notifier Notifier
+
+	// ShareExpiryNotice is how long before the shares expire their owners
+	// are notified. Zero means a day. The shares are checked when they are
+	// cleaned up.
+	ShareExpiryNotice time.Duration
+
+	// ShareCleanupInterval is how often the expired shares are deleted.
+	// Zero means every hour.
+	ShareCleanupInterval time.Duration
+
+	// RemoveDanglingShares makes the cleanup also delete the shares whose
+	// file or directory no longer exists.
+	RemoveDanglingShares bool
+
+	// ContentTypes maps file extensions, such as ".wasm", to the content
+	// type used when serving them. The extensions must be lowercase. If
+	// an extension isn't in the map, the content type is guessed.
+	ContentTypes map[string]string
+
+	// DownloadHeaders are extra headers sent with the downloads of the
+	// files and directories whose paths match the rules, such as
+	// 'Cache-Control: no-store' for '/private/'. The most specific rule
+	// wins when several set the same header.
+	DownloadHeaders []HeaderRule
+
+	// ReadmeName is the name of the files, such as README.md, which are
+	// rendered as the description of their directory in the listings.
+	// The case is ignored. Empty disables them.
+	ReadmeName string
+
+	// LandingPath is the directory, relative to the scope of the users,
+	// the front-end opens first instead of the root of the scope. The
+	// users can have their own. It's ignored for the users who can't
+	// access it.
+	LandingPath string
+
+	// AuthScheme is the authentication scheme in the WWW-Authenticate
+	// header of the responses to the requests without valid credentials.
+	// Empty means Bearer.
+	AuthScheme string
+
+	// ResolveSymlinks describes the symbolic links by their targets, such
+	// as by their size, if they are inside the scope of the user and
+	// allowed by the rules. The others are described by themselves.
+	ResolveSymlinks bool
+
+	// State keeps the state which must be shared by the instances of File
+	// Manager running behind a load balancer, such as a RedisStore. If it
+	// is nil, the state is kept in memory.
+	State StateStore
+
+	// CommandHistory is the number of commands run through the command
+	// router which are kept in the history of each user. The oldest are
+	// removed first. Zero disables the history.
+	CommandHistory int
+
+	// RedactGPS leaves the location out of the EXIF metadata of the
+	// images so it isn't disclosed.
+	RedactGPS bool
+
+	// ThumbnailMaxAge is how long the browsers may cache the thumbnails
+	// served by the thumbnail endpoint without revalidating them, when
+	// they are requested with their version in the 'v' parameter. Zero
+	// means they are always revalidated.
+	ThumbnailMaxAge time.Duration
+
+	// MaxUploadSize is the maximum size, in bytes, of the files uploaded
+	// through the resumable upload endpoint. Zero means there is no limit.
+	MaxUploadSize int64
+
+	// MaxPendingUploadSize is the maximum size, in bytes, of the unfinished
+	// resumable uploads of each user. Zero means there is no limit.
+	MaxPendingUploadSize int64
+
+	// IdempotencyWindow is how long the results of the uploads with an
+	// Idempotency-Key header are kept, so their retries return them
+	// instead of writing again. Zero means ten minutes and a negative
+	// duration disables the keys.
+	IdempotencyWindow time.Duration
+
 	// staticgen is the name of the current static website generator.
 	staticgen string
 	// StaticGen is the static websit generator handler.
@@ -156,6 +502,14 @@ type User struct {
 	// Locale is the language of the user.
 	Locale string `json:"locale"`
 
+	// ViewMode is the layout of the listings of the user: list, grid or
+	// mosaic. It's mosaic if it isn't set.
+	ViewMode string `json:"viewMode"`
+
+	// LandingPath is the directory, relative to the scope, the front-end
+	// opens first. Empty means the global one applies.
+	LandingPath string `json:"landingPath"`
+
 	// These indicate if the user can perform certain actions.
 	AllowNew      bool `json:"allowNew"`      // Create files and folders
 	AllowEdit     bool `json:"allowEdit"`     // Edit/rename files
@@ -164,6 +518,46 @@ type User struct {
 
 	// Commands is the list of commands the user can execute.
 	Commands []string `json:"commands"`
+
+	// Environment contains the variables set when the user executes
+	// commands, in addition to the safe ones from the server.
+	Environment map[string]string `json:"environment"`
+
+	// Ignore are the glob patterns of the entries hidden from this user,
+	// in addition to the global ones.
+	Ignore []string `json:"ignore"`
+
+	// Avatar is the URL of the picture of the user. It's optional.
+	Avatar string `json:"avatar"`
+
+	// UploadPolicy restricts the types of the files the user can upload,
+	// in addition to the global policy.
+	UploadPolicy UploadPolicy `json:"uploadPolicy"`
+
+	// MaxShares is the maximum number of shares of the user. Zero means
+	// the global limit applies and a negative number means there is no
+	// limit.
+	MaxShares int `json:"maxShares"`
+
+	// MaxDownloads is the maximum number of downloads of the user in
+	// progress at once. Zero means the global limit applies and a
+	// negative number means there is no limit.
+	MaxDownloads int `json:"maxDownloads"`
+
+	// RateLimit is the maximum number of requests to the API of the user
+	// per minute. Zero means the global limit applies and a negative
+	// number means there is no limit, not even for the downloads.
+	RateLimit int `json:"rateLimit"`
+
+	// Email is where the notifications about the shares of the user are
+	// sent. It's optional.
+	Email string `json:"email"`
+
+	// OIDCIssuer and OIDCSubject are the issuer and the subject of the
+	// account at the OpenID provider linked to the user, which is the
+	// only one that can log in as the user through the provider.
+	OIDCIssuer  string `json:"oidcIssuer"`
+	OIDCSubject string `json:"oidcSubject"`
 }
 
 // Rule is a dissalow/allow rule.
@@ -206,37 +600,65 @@ var DefaultUser = User{
 // will be created using the 'base' variable. The 'base' User should
 // not have the Password field hashed.
 func New(database string, base User) (*FileManager, error) {
+	return NewWithOptions(database, base, DatabaseOptions{})
+}
+
+// NewWithOptions is like New but opens the database with the given
+// options. A read-only database must have already been initialized.
+func NewWithOptions(database string, base User, opts DatabaseOptions) (*FileManager, error) {
 	// Creates a new File Manager instance with the Users
 	// map and Assets box.
 	m := &FileManager{
-		Users:  map[string]*User{},
-		cron:   cron.New(),
-		assets: rice.MustFindBox("./assets/dist"),
+		Users:           map[string]*User{},
+		cron:            cron.New(),
+		assets:          rice.MustFindBox("./assets/dist"),
+		MaxDepth:        defaultMaxDepth,
+		MaxPreviewSize:  defaultMaxPreviewSize,
+		MaxURLLength:    defaultMaxURLLength,
+		MaxHeaderBytes:  defaultMaxHeaderBytes,
+		MaxQueryParams:  defaultMaxQueryParams,
+		ShutdownTimeout: defaultShutdownTimeout,
+		keyMu:           &sync.RWMutex{},
+		DatabasePath:    database,
+		DatabaseOptions: opts,
+		thumbnails: &thumbnailCache{
+			items: map[thumbnailKey]string{},
+		},
+		readOnly:   &readOnlyState{},
+		shutdown:   &shutdownState{},
+		semaphores: &semaphores{},
+		downloads:  &downloadSlots{},
+		owners: &ownerCache{
+			users:  map[int]ownerName{},
+			groups: map[int]ownerName{},
+		},
+		checksums: &checksumCache{
+			items: map[checksumKey]string{},
+		},
+		jobs: &jobList{
+			items: map[string]*job{},
+		},
+		memoryState:  NewMemoryStore(),
+		shareCleanup: &shareCleanupState{last: time.Now()},
+		shareMu:      &sync.Mutex{},
+		uploadsMu:    &sync.Mutex{},
 	}
 
 	// Tries to open a database on the location provided. This
 	// function will automatically create a new one if it doesn't
-	// exist.
-	db, err := storm.Open(database)
+	// exist. It fails fast if the database is locked.
+	db, err := openDatabase(database, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Tries to get the encryption key from the database.
-	// If it doesn't exist, create a new one of 256 bits.
-	err = db.Get("config", "key", &m.key)
-	if err != nil && err == storm.ErrNotFound {
-		var bytes []byte
-		bytes, err = generateRandomBytes(64)
-		if err != nil {
-			return nil, err
-		}
-
-		m.key = bytes
-		err = db.Set("config", "key", m.key)
+	// Tries to get the encryption keys from the database.
+	if err = m.loadKeys(db); err != nil {
+		return nil, err
 	}
 
-	if err != nil {
+	// Tries to get the read-only mode from the database.
+	if err = m.loadReadOnlyMode(db); err != nil {
 		return nil, err
 	}
 
@@ -257,6 +679,11 @@ func New(database string, base User) (*FileManager, error) {
 		return nil, err
 	}
 
+	// Upgrades the database if it was created by an older version.
+	if err = migrateDatabase(db, opts); err != nil {
+		return nil, err
+	}
+
 	// Tries to fetch the users from the database and if there are
 	// any, add them to the current File Manager instance.
 	var users []User
@@ -276,7 +703,7 @@ func New(database string, base User) (*FileManager, error) {
 		u.Username = "admin"
 
 		// Hashes the password.
-		u.Password, err = hashPassword("admin")
+		u.Password, err = m.hashPassword("admin")
 		if err != nil {
 			return nil, err
 		}
@@ -304,7 +731,11 @@ func New(database string, base User) (*FileManager, error) {
 	base.Password = ""
 	m.DefaultUser = &base
 
-	m.cron.AddFunc("@hourly", m.shareCleaner)
+	// The expired shares can't be deleted from read-only databases.
+	if !opts.ReadOnly {
+		m.cron.AddFunc("@every 1m", m.shareCleanupTick)
+	}
+
 	m.cron.Start()
 
 	return m, nil
@@ -336,6 +767,10 @@ func (m *FileManager) SetBaseURL(url string) {
 
 // ServeHTTP handles the request.
 func (m *FileManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The handlers trim the path of the request, so the original one is
+	// kept for the logs.
+	u := *r.URL
+
 	code, err := serveHTTP(&RequestContext{
 		FileManager: m,
 		User:        nil,
@@ -347,7 +782,11 @@ func (m *FileManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		if err == nil {
 			txt := http.StatusText(code)
-			log.Printf("%v: %v %v\n", r.URL.Path, code, txt)
+			log.Printf("%v: %v %v\n", m.redactURL(&u), code, txt)
+
+			if m.Debug {
+				log.Printf("%v: %v\n", m.redactURL(&u), m.redactHeader(r.Header))
+			}
 			w.Write([]byte(txt))
 		}
 	}
@@ -416,49 +855,80 @@ func (m *FileManager) enableJekyll(j *Jekyll) error {
 	return nil
 }
 
-// shareCleaner removes sharing links that are no longer active.
-// This function is set to run periodically.
-func (m FileManager) shareCleaner() {
+// shareCleaner removes sharing links that are no longer active and,
+// if RemoveDanglingShares is set, the ones of paths which no longer
+// exist. It returns how many were removed.
+func (m FileManager) shareCleaner() int {
+	// The database must not change during the read-only mode.
+	if enabled, _ := m.ReadOnlyMode(); enabled {
+		return 0
+	}
+
 	var links []shareLink
 
 	// Get all links.
 	err := m.db.All(&links)
 	if err != nil {
 		log.Print(err)
-		return
+		return 0
 	}
 
-	// Find the expired ones.
+	// The owners are notified before their shares expire.
+	m.notifyExpiringShares(links)
+
+	removed := 0
 	for i := range links {
-		if links[i].Expires && links[i].ExpireDate.Before(time.Now()) {
-			err = m.db.DeleteStruct(&links[i])
-			if err != nil {
-				log.Print(err)
+		if !links[i].Expires || !links[i].ExpireDate.Before(time.Now()) {
+			if !m.RemoveDanglingShares {
+				continue
+			}
+
+			if _, err = os.Stat(links[i].Path); !os.IsNotExist(err) {
+				continue
 			}
 		}
+
+		if err = m.db.DeleteStruct(&links[i]); err != nil {
+			log.Print(err)
+			continue
+		}
+
+		removed++
 	}
+
+	if removed > 0 {
+		log.Printf("Removed %d expired or dangling shares", removed)
+	}
+
+	return removed
 }
 
 // Allowed checks if the user has permission to access a directory/file.
 func (u User) Allowed(url string) bool {
-	var rule *Rule
-	i := len(u.Rules) - 1
+	if i := u.matchRule(url); i >= 0 {
+		return u.Rules[i].Allow
+	}
+
+	return true
+}
 
-	for i >= 0 {
-		rule = u.Rules[i]
+// matchRule returns the index of the rule which decides if the user can
+// access the URL, which is the last one that matches it, or -1 if there
+// isn't any.
+func (u User) matchRule(url string) int {
+	for i := len(u.Rules) - 1; i >= 0; i-- {
+		rule := u.Rules[i]
 
 		if rule.Regex {
 			if rule.Regexp.MatchString(url) {
-				return rule.Allow
+				return i
 			}
 		} else if strings.HasPrefix(url, rule.Path) {
-			return rule.Allow
+			return i
 		}
-
-		i--
 	}
 
-	return true
+	return -1
 }
 
 // MatchString checks if this string matches the regular expression.
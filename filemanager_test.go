@@ -2,8 +2,11 @@ package filemanager
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hacdias/fileutils"
@@ -47,3 +50,20 @@ func newTest(t *testing.T) *test {
 		Temp:        temp,
 	}
 }
+
+// login signs in with the credentials and returns the token, failing the
+// test if they aren't accepted.
+func login(t *testing.T, h http.Handler, credentials string) string {
+	r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(credentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Couldn't log in: got %v %s", w.Code, w.Body.String())
+	}
+
+	return w.Body.String()
+}
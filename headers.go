@@ -0,0 +1,86 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HeaderRule adds a header to the downloads of the files whose path
+// matches Path. Paths ending with a slash match every file inside the
+// directory and the others are glob patterns, like in path.Match.
+type HeaderRule struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ParseHeaderRule parses a header rule written as 'path=Name: value',
+// such as '/private/=Cache-Control: no-store'.
+func ParseHeaderRule(s string) (HeaderRule, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return HeaderRule{}, errors.New("invalid header rule: " + s)
+	}
+
+	header := strings.SplitN(parts[1], ":", 2)
+	if len(header) != 2 || strings.TrimSpace(header[0]) == "" {
+		return HeaderRule{}, errors.New("invalid header rule: " + s)
+	}
+
+	rule := HeaderRule{
+		Path:  parts[0],
+		Name:  http.CanonicalHeaderKey(strings.TrimSpace(header[0])),
+		Value: strings.TrimSpace(header[1]),
+	}
+
+	if _, err := path.Match(rule.Path, "/"); err != nil {
+		return HeaderRule{}, errors.New("invalid header rule: " + s)
+	}
+
+	return rule, nil
+}
+
+// matches tells if the rule applies to the file at the virtual path.
+func (h HeaderRule) matches(p string) bool {
+	if strings.HasSuffix(h.Path, "/") {
+		return strings.HasPrefix(p, h.Path) || p+"/" == h.Path
+	}
+
+	ok, _ := path.Match(h.Path, p)
+	return ok
+}
+
+// specificity is the number of characters of the path of the rule which
+// aren't wildcards, so '/private/report.pdf' is more specific than
+// '/private/' and '/private/*.pdf'.
+func (h HeaderRule) specificity() int {
+	return len(h.Path) - strings.Count(h.Path, "*") - strings.Count(h.Path, "?")
+}
+
+// setDownloadHeaders sets the headers of the rules which match the file
+// at the virtual path. If several rules set the same header, the most
+// specific one wins.
+func (m *FileManager) setDownloadHeaders(w http.ResponseWriter, p string) {
+	rules := []HeaderRule{}
+	for _, rule := range m.DownloadHeaders {
+		if rule.matches(p) {
+			rules = append(rules, rule)
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].specificity() > rules[j].specificity()
+	})
+
+	set := map[string]bool{}
+	for _, rule := range rules {
+		name := http.CanonicalHeaderKey(rule.Name)
+		if !set[name] {
+			w.Header().Set(name, rule.Value)
+			set[name] = true
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package filemanager
+
+import (
+	"log"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// commandRecord is a command run by a user through the command router.
+type commandRecord struct {
+	ID      int       `json:"id" storm:"id,increment"`
+	UserID  int       `json:"userId" storm:"index"`
+	Command string    `json:"command"`
+	Path    string    `json:"path"`
+	Started time.Time `json:"started"`
+	// Duration is how long the command ran, in milliseconds.
+	Duration int64 `json:"duration"`
+	// ExitCode is -1 if the command couldn't finish, such as when it was
+	// killed by a signal.
+	ExitCode int `json:"exitCode"`
+}
+
+// exitCode returns the exit code of a command which has finished.
+func exitCode(cmd *exec.Cmd, err error) int {
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return -1
+		}
+	}
+
+	if cmd.ProcessState == nil {
+		return -1
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+
+	if cmd.ProcessState.Success() {
+		return 0
+	}
+
+	return -1
+}
+
+// recordCommand saves a command to the history of the user and removes
+// the oldest ones over the CommandHistory limit. Nothing is saved if it
+// is zero.
+func (m *FileManager) recordCommand(record *commandRecord) {
+	if m.CommandHistory <= 0 {
+		return
+	}
+
+	if err := m.db.Save(record); err != nil {
+		log.Printf("[ERROR] Could not save the command history: %v", err)
+		return
+	}
+
+	var old []commandRecord
+	err := m.db.Select(q.Eq("UserID", record.UserID)).OrderBy("ID").Reverse().Skip(m.CommandHistory).Find(&old)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("[ERROR] Could not prune the command history: %v", err)
+		return
+	}
+
+	for i := range old {
+		if err = m.db.DeleteStruct(&old[i]); err != nil {
+			log.Printf("[ERROR] Could not prune the command history: %v", err)
+			return
+		}
+	}
+}
+
+// deleteCommandHistory removes the command history of a user.
+func (m *FileManager) deleteCommandHistory(id int) error {
+	err := m.db.Select(q.Eq("UserID", id)).Delete(&commandRecord{})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// historyHandler returns the commands run by the user, the most recent
+// first, in pages like the listing of users. Admins can see the history
+// of other users with the 'user' query parameter.
+func historyHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	id := c.User.ID
+
+	if val := r.URL.Query().Get("user"); val != "" {
+		other, err := strconv.Atoi(val)
+		if err != nil {
+			return http.StatusBadRequest, errInvalidOption
+		}
+
+		if other != id && !c.User.Admin {
+			return http.StatusForbidden, nil
+		}
+
+		id = other
+	}
+
+	records := []commandRecord{}
+	err := c.db.Find("UserID", id, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return http.StatusInternalServerError, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID > records[j].ID
+	})
+
+	start, end, err := pageBounds(r, len(records))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(records)))
+	return renderJSON(w, records[start:end])
+}
+
+// newCommandRecord starts the record of a command run by the user of the
+// request.
+func newCommandRecord(c *RequestContext, command []string, path string) *commandRecord {
+	return &commandRecord{
+		UserID:  c.User.ID,
+		Command: strings.Join(command, " "),
+		Path:    path,
+		Started: time.Now(),
+	}
+}
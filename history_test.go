@@ -0,0 +1,108 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't installed")
+	}
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	if code := exitCode(cmd, cmd.Run()); code != 3 {
+		t.Errorf("got exit code %d, want 3", code)
+	}
+
+	cmd = exec.Command("sh", "-c", "true")
+	if code := exitCode(cmd, cmd.Run()); code != 0 {
+		t.Errorf("got exit code %d, want 0", code)
+	}
+}
+
+func TestCommandHistory(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.CommandHistory = 3
+	fm.Users["user"] = &User{ID: 2, Username: "user", Password: "hash"}
+
+	for _, command := range []string{"git status", "git log", "git diff", "git pull"} {
+		fm.recordCommand(&commandRecord{UserID: 1, Command: command})
+	}
+
+	fm.recordCommand(&commandRecord{UserID: 2, Command: "svn up"})
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+		total string
+	}{
+		{"", http.StatusOK, []string{"git pull", "git diff", "git log"}, "3"},
+		{"?page=2&perPage=2", http.StatusOK, []string{"git log"}, "3"},
+		{"?user=2", http.StatusOK, []string{"svn up"}, "1"},
+		{"?user=3", http.StatusOK, []string{}, "0"},
+		{"?user=admin", http.StatusBadRequest, nil, ""},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/api/history/"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%q: got status %d, want %d", test.query, w.Code, test.code)
+			continue
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var records []commandRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		for _, record := range records {
+			got = append(got, record.Command)
+		}
+
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%q: got %v, want %v", test.query, got, test.want)
+		}
+
+		if total := w.Header().Get("X-Total-Count"); total != test.total {
+			t.Errorf("%q: got total %q, want %q", test.query, total, test.total)
+		}
+	}
+
+	// The users who aren't admins only see their own history.
+	fm.Users["admin"].Admin = false
+	r, err := http.NewRequest("GET", "/api/history/?user=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
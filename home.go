@@ -0,0 +1,259 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hacdias/fileutils"
+)
+
+// The policies when the scope of a user is missing at startup.
+const (
+	ScopeWarn   = "warn"
+	ScopeFail   = "fail"
+	ScopeCreate = "create"
+)
+
+// CheckScopes checks the scopes of the default user and of every user
+// exist and are directories. Depending on the policy, a missing scope is
+// only logged, is an error or is created like the home of a new user.
+// Empty means ScopeWarn. The scopes which aren't directories are always
+// an error, except with ScopeWarn.
+func (m *FileManager) CheckScopes(policy string) error {
+	if policy == "" {
+		policy = ScopeWarn
+	}
+
+	if policy != ScopeWarn && policy != ScopeFail && policy != ScopeCreate {
+		return fmt.Errorf("invalid scope policy: %s", policy)
+	}
+
+	names := make([]string, 0, len(m.Users))
+	for name := range m.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	users := []*User{m.DefaultUser}
+	for _, name := range names {
+		users = append(users, m.Users[name])
+	}
+
+	for _, u := range users {
+		if err := m.checkScope(u, policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkScope checks the scope of a user following the policy.
+func (m *FileManager) checkScope(u *User, policy string) error {
+	owner := "the default user"
+	if u.Username != "" {
+		owner = "the user " + u.Username
+	}
+
+	scope := string(u.FileSystem)
+
+	err := scopeError(u)
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err) && policy == ScopeCreate:
+		if _, err = m.provisionHome(u); err != nil {
+			return fmt.Errorf("couldn't create the scope of %s at %s: %v", owner, scope, err)
+		}
+
+		log.Printf("created the missing scope of %s at %s", owner, scope)
+		return nil
+	}
+
+	if policy == ScopeWarn {
+		log.Printf("the scope of %s at %s is unusable: %v", owner, scope, err)
+		return nil
+	}
+
+	return fmt.Errorf("the scope of %s at %s is unusable: %v", owner, scope, err)
+}
+
+// scopeError tells why the scope of the user is unusable, which is
+// because it doesn't exist, can't be read or isn't a directory. It is nil
+// if it is usable.
+func scopeError(u *User) error {
+	info, err := os.Stat(string(u.FileSystem))
+	if err == nil && !info.IsDir() {
+		err = errScopeNotDir
+	}
+
+	return err
+}
+
+// readiness is the response of the readiness endpoint.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// UnusableScopes is the number of users whose scope is unusable,
+	// including the default user. Their names aren't told since the
+	// endpoint doesn't need authentication.
+	UnusableScopes int `json:"unusableScopes"`
+}
+
+// readyHandler tells if the instance is ready to serve the users, which it
+// is when every scope is usable, like CheckScopes requires it at startup.
+// It replies 503 Service Unavailable otherwise so it can be used as the
+// readiness probe of an orchestrator.
+func readyHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	res := &readiness{}
+	if scopeError(c.DefaultUser) != nil {
+		res.UnusableScopes++
+	}
+
+	for _, u := range c.Users {
+		if scopeError(u) != nil {
+			res.UnusableScopes++
+		}
+	}
+
+	res.Ready = res.UnusableScopes == 0
+	if !res.Ready {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return 0, json.NewEncoder(w).Encode(res)
+	}
+
+	return renderJSON(w, res)
+}
+
+// provisionHome creates the scope of a new user if it doesn't exist
+// yet and, if there is a home skeleton, copies its contents into it.
+func (m *FileManager) provisionHome(u *User) (int, error) {
+	scope := string(u.FileSystem)
+
+	info, err := os.Stat(scope)
+	if err == nil {
+		if !info.IsDir() {
+			return http.StatusBadRequest, errScopeNotDir
+		}
+
+		// Existing scopes are never seeded so their files
+		// aren't overwritten.
+		return 0, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return http.StatusInternalServerError, err
+	}
+
+	if err = os.MkdirAll(scope, 0755); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if m.HomeSkeleton == "" {
+		return 0, nil
+	}
+
+	if err = fileutils.CopyDir(m.HomeSkeleton, scope); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// homeShared checks if the scope of the user is, contains or is inside
+// the scope of the default user or of any other user.
+func (m *FileManager) homeShared(u *User, scope string) bool {
+	users := []*User{m.DefaultUser}
+	for _, user := range m.Users {
+		if user.ID != u.ID {
+			users = append(users, user)
+		}
+	}
+
+	for _, user := range users {
+		other, err := filepath.Abs(string(user.FileSystem))
+		if err != nil {
+			return true
+		}
+
+		if _, ok := movedPath(scope, other, other); ok {
+			return true
+		}
+
+		if _, ok := movedPath(other, scope, scope); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inHomeRoot checks if the absolute path is inside HomeRoot, and isn't
+// HomeRoot itself.
+func (m *FileManager) inHomeRoot(scope string) bool {
+	if m.HomeRoot == "" {
+		return false
+	}
+
+	root, err := filepath.Abs(m.HomeRoot)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, scope)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	return true
+}
+
+// releaseHome removes or archives the scope of a deleted user, depending
+// on the options. Only the scopes inside HomeRoot are, and the ones used
+// by other users are always kept.
+func (m *FileManager) releaseHome(u *User) error {
+	if !m.RemoveHomes && m.HomeArchive == "" {
+		return nil
+	}
+
+	scope, err := filepath.Abs(string(u.FileSystem))
+	if err != nil {
+		return err
+	}
+
+	if !m.inHomeRoot(scope) {
+		log.Printf("[WARNING] Keeping %s since it isn't inside the home root", scope)
+		return nil
+	}
+
+	if m.homeShared(u, scope) {
+		log.Printf("[WARNING] Keeping %s since it is used by other users", scope)
+		return nil
+	}
+
+	if _, err = os.Stat(scope); os.IsNotExist(err) {
+		return nil
+	}
+
+	if m.HomeArchive == "" {
+		return os.RemoveAll(scope)
+	}
+
+	if err = os.MkdirAll(m.HomeArchive, 0700); err != nil {
+		return err
+	}
+
+	name := u.Username + "-" + time.Now().Format("20060102150405")
+	return os.Rename(scope, filepath.Join(m.HomeArchive, name))
+}
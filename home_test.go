@@ -0,0 +1,201 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hacdias/fileutils"
+)
+
+func TestProvisionHome(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.HomeSkeleton = filepath.Join(fm.Temp, "skeleton")
+	if err := os.MkdirAll(fm.HomeSkeleton, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(fm.HomeSkeleton, "welcome.txt"), []byte("hi"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := filepath.Join(fm.Temp, "homes", "alice")
+	u := &User{Username: "alice", FileSystem: fileutils.Dir(home)}
+
+	if _, err = fm.provisionHome(u); err != nil {
+		t.Fatalf("Couldn't provision the home: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(home, "welcome.txt")); err != nil {
+		t.Errorf("The home wasn't seeded: %v", err)
+	}
+
+	// Existing scopes aren't seeded.
+	if err = os.Remove(filepath.Join(home, "welcome.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fm.provisionHome(u); err != nil {
+		t.Fatalf("Couldn't provision the home: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(home, "welcome.txt")); !os.IsNotExist(err) {
+		t.Errorf("An existing home was seeded")
+	}
+}
+
+func TestReleaseHome(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	home := filepath.Join(fm.Temp, "homes", "alice")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &User{ID: 10, Username: "alice", FileSystem: fileutils.Dir(home)}
+	shared := &User{ID: 11, Username: "bob", FileSystem: fm.DefaultUser.FileSystem}
+
+	// Homes are kept by default.
+	if err := fm.releaseHome(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(home); err != nil {
+		t.Errorf("The home was removed: %v", err)
+	}
+
+	fm.RemoveHomes = true
+
+	// The scopes outside of the home root are kept, since they may be any
+	// directory, like '/'.
+	if err := fm.releaseHome(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(home); err != nil {
+		t.Errorf("A home outside of the home root was removed: %v", err)
+	}
+
+	fm.HomeRoot = filepath.Join(fm.Temp, "homes")
+	root := &User{ID: 12, Username: "carol", FileSystem: fileutils.Dir(fm.HomeRoot)}
+
+	if err := fm.releaseHome(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(fm.HomeRoot); err != nil {
+		t.Errorf("The home root was removed: %v", err)
+	}
+
+	// The scope of the default user is inside the home root but shared.
+	fm.HomeRoot = fm.Temp
+
+	if err := fm.releaseHome(shared); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(string(shared.FileSystem)); err != nil {
+		t.Errorf("A shared home was removed: %v", err)
+	}
+
+	fm.HomeArchive = filepath.Join(fm.Temp, "archive")
+
+	if err := fm.releaseHome(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(home); !os.IsNotExist(err) {
+		t.Errorf("The home wasn't archived")
+	}
+
+	archived, err := ioutil.ReadDir(fm.HomeArchive)
+	if err != nil || len(archived) != 1 {
+		t.Errorf("The home isn't in the archive: %v", err)
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	missing := filepath.Join(fm.Temp, "missing")
+	fm.Users["bob"] = &User{Username: "bob", FileSystem: fileutils.Dir(missing)}
+
+	if err := fm.CheckScopes(""); err != nil {
+		t.Errorf("Warn policy: %v", err)
+	}
+
+	if err := fm.CheckScopes(ScopeFail); err == nil {
+		t.Error("Fail policy accepted a missing scope")
+	}
+
+	if err := fm.CheckScopes("ignore"); err == nil {
+		t.Error("An invalid policy was accepted")
+	}
+
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatalf("The scope was created before the create policy: %v", err)
+	}
+
+	if err := fm.CheckScopes(ScopeCreate); err != nil {
+		t.Fatalf("Create policy: %v", err)
+	}
+
+	if info, err := os.Stat(missing); err != nil || !info.IsDir() {
+		t.Fatalf("The scope wasn't created: %v", err)
+	}
+
+	if err := fm.CheckScopes(ScopeFail); err != nil {
+		t.Errorf("Fail policy with existing scopes: %v", err)
+	}
+
+	// The scopes which aren't directories can't be fixed.
+	file := filepath.Join(fm.Temp, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.DefaultUser.FileSystem = fileutils.Dir(file)
+	if err := fm.CheckScopes(ScopeCreate); err == nil {
+		t.Error("Create policy accepted a scope which isn't a directory")
+	}
+}
+
+func TestReady(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	ready := func() (int, readiness) {
+		r, err := http.NewRequest("GET", "/api/ready", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var res readiness
+		if err = json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Couldn't decode %q: %v", w.Body.String(), err)
+		}
+
+		return w.Code, res
+	}
+
+	if code, res := ready(); code != http.StatusOK || !res.Ready || res.UnusableScopes != 0 {
+		t.Errorf("Usable scopes: got %v %+v", code, res)
+	}
+
+	fm.Users["bob"] = &User{Username: "bob", FileSystem: fileutils.Dir(filepath.Join(fm.Temp, "missing"))}
+	if code, res := ready(); code != http.StatusServiceUnavailable || res.Ready || res.UnusableScopes != 1 {
+		t.Errorf("Missing scope: got %v %+v", code, res)
+	}
+}
@@ -3,6 +3,8 @@ package filemanager
 import (
 	"encoding/json"
 	"html/template"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -18,10 +20,20 @@ type RequestContext struct {
 	File *file
 	// On API handlers, Router is the APi handler we want.
 	Router string
+	// The share which is being accessed, if any.
+	share *shareLink
 }
 
 // serveHTTP is the main entry point of this HTML application.
 func serveHTTP(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.shutdown.isClosing() {
+		return rejectShutdown(w)
+	}
+
+	if code, err := c.checkRequestLimits(r); err != nil {
+		return code, err
+	}
+
 	// Checks if the URL contains the baseURL and strips it. Otherwise, it just
 	// returns a 404 error because we're not supposed to be here!
 	p := strings.TrimPrefix(r.URL.Path, c.BaseURL)
@@ -44,10 +56,10 @@ func serveHTTP(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 
 	// Checks if this request is made to the static assets folder. If so, and
 	// if it is a GET request, returns with the asset. Otherwise, returns
-	// a status not implemented.
+	// a status method not allowed.
 	if matchURL(r.URL.Path, "/static") {
 		if r.Method != http.MethodGet {
-			return http.StatusNotImplemented, nil
+			return methodNotAllowed(w, http.MethodGet)
 		}
 
 		return staticHandler(c, w, r)
@@ -72,6 +84,12 @@ func serveHTTP(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		return sharePage(c, w, r)
 	}
 
+	// Signed download links don't require authentication.
+	if strings.HasPrefix(r.URL.Path, "/dl/") {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/dl")
+		return signedDownloadHandler(c, w, r)
+	}
+
 	// Any other request should show the index.html file.
 	w.Header().Set("x-frame-options", "SAMEORIGIN")
 	w.Header().Set("x-content-type", "nosniff")
@@ -86,7 +104,32 @@ func serveHTTP(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 
 // staticHandler handles the static assets path.
 func staticHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	// The files in the assets directory override the embedded ones.
+	if f, info := assetOverride(c, r.URL.Path); f != nil {
+		defer f.Close()
+
+		if r.URL.Path == "/static/manifest.json" {
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+
+			return renderFile(c, w, string(data), "application/json")
+		}
+
+		if servePrecompressed(w, r, http.Dir(c.AssetsDir), r.URL.Path) {
+			return 0, nil
+		}
+
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		return 0, nil
+	}
+
 	if r.URL.Path != "/static/manifest.json" {
+		if servePrecompressed(w, r, c.assets.HTTPBox(), r.URL.Path) {
+			return 0, nil
+		}
+
 		http.FileServer(c.assets.HTTPBox()).ServeHTTP(w, r)
 		return 0, nil
 	}
@@ -98,6 +141,66 @@ func staticHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (i
 	)
 }
 
+// assetOverride opens the file which overrides the asset in the path, if
+// there is one in the assets directory. http.Dir keeps the path inside
+// the directory.
+func assetOverride(c *RequestContext, path string) (http.File, os.FileInfo) {
+	if c.AssetsDir == "" {
+		return nil, nil
+	}
+
+	f, err := http.Dir(c.AssetsDir).Open(path)
+	if err != nil {
+		return nil, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil
+	}
+
+	return f, info
+}
+
+// apiMethods are the methods supported by each route of the API.
+var apiMethods = map[string][]string{
+	"download":  {http.MethodGet},
+	"checksum":  {http.MethodGet},
+	"manifest":  {http.MethodGet},
+	"changes":   {http.MethodGet},
+	"link":      {http.MethodPost},
+	"metadata":  {http.MethodGet},
+	"action":    {http.MethodPost},
+	"jobs":      {http.MethodGet},
+	"command":   {http.MethodGet},
+	"search":    {http.MethodGet},
+	"resource":  {http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+	"users":     {http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+	"me":        {http.MethodGet},
+	"allowed":   {http.MethodGet},
+	"settings":  {http.MethodGet, http.MethodPut},
+	"config":    {http.MethodGet},
+	"share":     {http.MethodGet, http.MethodPost, http.MethodDelete},
+	"shares":    {http.MethodGet, http.MethodPost, http.MethodDelete},
+	"thumbnail": {http.MethodGet, http.MethodPost},
+	"tus":       {http.MethodOptions, http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete},
+	"backup":    {http.MethodGet, http.MethodPut},
+	"batch":     {http.MethodPost},
+	"exif":      {http.MethodGet},
+	"history":   {http.MethodGet},
+	"export":    {http.MethodGet},
+	"publish":   {http.MethodGet},
+	"recent":    {http.MethodGet},
+}
+
+// methodNotAllowed sets the Allow header to the methods supported by the
+// requested resource and returns 405 Method Not Allowed.
+func methodNotAllowed(w http.ResponseWriter, methods ...string) (int, error) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	return http.StatusMethodNotAllowed, nil
+}
+
 // apiHandler is the main entry point for the /api endpoint.
 func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	if r.URL.Path == "/auth/get" {
@@ -108,12 +211,89 @@ func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		return renewAuthHandler(c, w, r)
 	}
 
+	if r.URL.Path == "/auth/logout" {
+		return logoutHandler(c, w, r)
+	}
+
+	if r.URL.Path == "/auth/introspect" {
+		return introspectHandler(c, w, r)
+	}
+
+	if r.URL.Path == "/auth/oidc" {
+		return oidcLoginHandler(c, w, r)
+	}
+
+	if r.URL.Path == "/auth/oidc/callback" {
+		return oidcCallbackHandler(c, w, r)
+	}
+
+	if r.URL.Path == "/ready" {
+		return readyHandler(c, w, r)
+	}
+
+	if r.URL.Path == "/openapi.json" {
+		return openAPIHandler(c, w, r)
+	}
+
+	c.Router, r.URL.Path = splitURL(r.URL.Path)
+
+	methods, ok := apiMethods[c.Router]
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+
+	// Answers the OPTIONS requests, such as CORS preflights, without
+	// authentication. The TUS protocol handles them by itself.
+	if r.Method == http.MethodOptions && c.Router != "tus" {
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return 0, nil
+	}
+
+	supported := false
+	for _, method := range methods {
+		if method == r.Method {
+			supported = true
+			break
+		}
+	}
+
+	if !supported {
+		return methodNotAllowed(w, methods...)
+	}
+
+	timing := newServerTiming(c, w)
+
 	valid, _ := validateAuth(c, r)
+	timing.mark("auth")
 	if !valid {
-		return http.StatusForbidden, nil
+		return unauthorized(c, w)
 	}
 
-	c.Router, r.URL.Path = splitURL(r.URL.Path)
+	// The requests are limited for the authenticated user, even if they
+	// impersonate another one, or for the IP address without
+	// authentication.
+	bucket := rateAPI
+	if c.Router == "download" {
+		bucket = rateDownload
+	}
+
+	if ok, retry := c.allowRequest(r, c.User, bucket); !ok {
+		return rejectRateLimited(w, retry)
+	}
+
+	if code, err := impersonate(c, r); code != 0 {
+		return code, err
+	}
+
+	// The settings handler checks the read-only mode by itself because
+	// the mode must be possible to disable. Backups can only be restored
+	// while it is enabled.
+	if c.Router != "settings" && c.Router != "backup" && mutating(c.Router, r.Method) {
+		if enabled, _ := c.ReadOnlyMode(); enabled {
+			return rejectReadOnly(w)
+		}
+	}
 
 	if !c.User.Allowed(r.URL.Path) {
 		return http.StatusForbidden, nil
@@ -133,7 +313,7 @@ func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		}
 	}
 
-	if c.Router == "checksum" || c.Router == "download" {
+	if c.Router == "checksum" || c.Router == "manifest" || c.Router == "download" || c.Router == "link" || c.Router == "metadata" || c.Router == "action" || c.Router == "thumbnail" || c.Router == "exif" {
 		var err error
 		c.File, err = getInfo(r.URL, c.FileManager, c.User)
 		if err != nil {
@@ -141,6 +321,12 @@ func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		}
 	}
 
+	timing.mark("permission")
+
+	// The time of the handler is measured until it sends the headers.
+	w = timing.wrap(w, "handler")
+	defer timing.finish("handler")
+
 	var code int
 	var err error
 
@@ -149,18 +335,56 @@ func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		code, err = downloadHandler(c, w, r)
 	case "checksum":
 		code, err = checksumHandler(c, w, r)
+	case "manifest":
+		code, err = manifestHandler(c, w, r)
+	case "changes":
+		code, err = changesHandler(c, w, r)
+	case "link":
+		code, err = linkHandler(c, w, r)
+	case "metadata":
+		code, err = metadataHandler(c, w, r)
+	case "action":
+		code, err = actionHandler(c, w, r)
+	case "jobs":
+		code, err = jobsHandler(c, w, r)
 	case "command":
 		code, err = command(c, w, r)
 	case "search":
 		code, err = search(c, w, r)
+	case "recent":
+		code, err = recentHandler(c, w, r)
 	case "resource":
 		code, err = resourceHandler(c, w, r)
 	case "users":
 		code, err = usersHandler(c, w, r)
+	case "me":
+		code, err = meHandler(c, w, r)
+	case "allowed":
+		code, err = allowedHandler(c, w, r)
 	case "settings":
 		code, err = settingsHandler(c, w, r)
+	case "config":
+		code, err = configHandler(c, w, r)
 	case "share":
 		code, err = shareHandler(c, w, r)
+	case "shares":
+		code, err = sharesHandler(c, w, r)
+	case "thumbnail":
+		code, err = thumbnailHandler(c, w, r)
+	case "tus":
+		code, err = tusHandler(c, w, r)
+	case "backup":
+		code, err = backupHandler(c, w, r)
+	case "batch":
+		code, err = batchHandler(c, w, r)
+	case "exif":
+		code, err = exifHandler(c, w, r)
+	case "export":
+		code, err = exportHandler(c, w, r)
+	case "history":
+		code, err = historyHandler(c, w, r)
+	case "publish":
+		code, err = publishHandler(c, w, r)
 	default:
 		code = http.StatusNotFound
 	}
@@ -172,7 +396,13 @@ func apiHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 func checksumHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	query := r.URL.Query().Get("algo")
 
-	val, err := c.File.Checksum(query)
+	release, ok := c.acquire(r, limitChecksum)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	val, err := c.checksum(c.File, query)
 	if err == errInvalidOption {
 		return http.StatusBadRequest, err
 	} else if err != nil {
@@ -184,7 +414,9 @@ func checksumHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 }
 
 // splitURL splits the path and returns everything that stands
-// before the first slash and everything that goes after.
+// before the first slash and everything that goes after. A path
+// without any other slash, such as "/changes", is all router and
+// its rest is "/".
 func splitURL(path string) (string, string) {
 	if path == "" {
 		return "", ""
@@ -194,7 +426,7 @@ func splitURL(path string) (string, string) {
 
 	i := strings.Index(path, "/")
 	if i == -1 {
-		return "", path
+		return path, "/"
 	}
 
 	return path[0:i], path[i:]
@@ -208,6 +440,7 @@ func renderFile(c *RequestContext, w http.ResponseWriter, file string, contentTy
 	err := tpl.Execute(w, map[string]interface{}{
 		"BaseURL":   c.RootURL(),
 		"StaticGen": c.staticgen,
+		"Branding":  c.branding(),
 	})
 	if err != nil {
 		return http.StatusInternalServerError, err
@@ -217,8 +450,14 @@ func renderFile(c *RequestContext, w http.ResponseWriter, file string, contentTy
 }
 
 func sharePage(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	// The path after the hash is the one browsed inside the share.
+	hash, sub := r.URL.Path, "/"
+	if i := strings.Index(hash, "/"); i != -1 {
+		hash, sub = hash[:i], hash[i:]
+	}
+
 	var s shareLink
-	err := c.db.One("Hash", r.URL.Path, &s)
+	err := c.db.One("Hash", hash, &s)
 	if err == storm.ErrNotFound {
 		return renderFile(
 			c, w,
@@ -240,15 +479,65 @@ func sharePage(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		)
 	}
 
-	r.URL.Path = s.Path
+	// Clients from other networks can't know the share exists.
+	if !s.allowed(c.clientIP(r)) {
+		return renderFile(
+			c, w,
+			c.assets.MustString("static/share/404.html"),
+			"text/html",
+		)
+	}
+
+	// Protected shares need the token given in exchange for the password.
+	if s.Protected {
+		if r.Method == http.MethodPost {
+			return shareAuthHandler(c, w, r, &s)
+		}
 
-	info, err := os.Stat(s.Path)
+		if !shareAuthorized(c, r, &s) {
+			// The browsers get a page to type the password in.
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				page, err := c.assets.String("static/share/password.html")
+				if err != nil {
+					return http.StatusUnauthorized, nil
+				}
+
+				return renderFile(c, w, page, "text/html")
+			}
+
+			return http.StatusUnauthorized, nil
+		}
+	}
+
+	path := s.Path
+	if sub != "/" {
+		if !s.Browse {
+			return renderFile(
+				c, w,
+				c.assets.MustString("static/share/404.html"),
+				"text/html",
+			)
+		}
+
+		path, err = shareSubpath(&s, sub)
+		if err != nil {
+			return renderFile(
+				c, w,
+				c.assets.MustString("static/share/404.html"),
+				"text/html",
+			)
+		}
+	}
+
+	r.URL.Path = path
+
+	info, err := os.Stat(path)
 	if err != nil {
 		return errorToHTTP(err, false), err
 	}
 
 	c.File = &file{
-		Path:    s.Path,
+		Path:    path,
 		Name:    info.Name(),
 		ModTime: info.ModTime(),
 		Mode:    info.Mode(),
@@ -258,13 +547,21 @@ func sharePage(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 
 	dl := r.URL.Query().Get("dl")
 
+	if (dl == "" || dl == "0") && s.Browse && c.File.IsDir {
+		return shareBrowse(c, w, r, &s, sub)
+	}
+
 	if dl == "" || dl == "0" {
 		tpl := template.Must(template.New("file").Parse(c.assets.MustString("static/share/index.html")))
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+		// The template escapes the title and the description.
 		err := tpl.Execute(w, map[string]interface{}{
-			"BaseURL": c.RootURL(),
-			"File":    c.File,
+			"BaseURL":     c.RootURL(),
+			"File":        c.File,
+			"Title":       s.Title,
+			"Description": s.Description,
+			"Archives":    c.alternativeArchives(),
 		})
 
 		if err != nil {
@@ -273,7 +570,32 @@ func sharePage(c *RequestContext, w http.ResponseWriter, r *http.Request) (int,
 		return 0, nil
 	}
 
-	return downloadHandler(c, w, r)
+	rec := &downloadRecorder{ResponseWriter: w}
+	w = rec
+
+	if s.RateLimit > 0 {
+		w = &throttledWriter{ResponseWriter: w, rate: s.RateLimit}
+	}
+
+	c.share = &s
+
+	code, err := downloadHandler(c, w, r)
+	if err != nil || code != 0 || r.Method != http.MethodGet {
+		return code, err
+	}
+
+	size := c.File.Size
+	if c.File.IsDir {
+		size = -1
+	}
+
+	if rec.completed(size) {
+		if err := c.countDownload(s.Hash); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return 0, nil
 }
 
 // renderJSON prints the JSON version of data to the browser.
@@ -0,0 +1,459 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	tests := []struct {
+		method, url, allow string
+	}{
+		{"POST", "/static/manifest.json", "GET"},
+		{"POST", "/api/checksum/file.txt", "GET"},
+		{"GET", "/api/link/file.txt", "POST"},
+		{"PATCH", "/api/users/1", "GET, POST, PUT, DELETE"},
+		{"DELETE", "/api/settings/", "GET, PUT"},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: got %v, want 405", test.method, test.url, w.Code)
+		}
+
+		if allow := w.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: got Allow %q, want %q", test.method, test.url, allow, test.allow)
+		}
+	}
+
+	// The preflight requests don't need authentication.
+	r, err := http.NewRequest("OPTIONS", "/api/resource/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") == "" {
+		t.Errorf("OPTIONS request: got %v with Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func() []string {
+		r, err := http.NewRequest("GET", "/api/resource/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Header()["Server-Timing"]
+	}
+
+	if timing := get(); len(timing) != 0 {
+		t.Errorf("Server-Timing sent without debug: %v", timing)
+	}
+
+	fm.Debug = true
+	timing := get()
+
+	if len(timing) != 3 {
+		t.Fatalf("Wrong Server-Timing: %v", timing)
+	}
+
+	for i, name := range []string{"auth;dur=", "permission;dur=", "handler;dur="} {
+		if !strings.HasPrefix(timing[i], name) {
+			t.Errorf("Wrong metric %d: got %s, want %s", i, timing[i], name)
+		}
+	}
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	checksum := func(algo string) int {
+		r, err := http.NewRequest("GET", "/api/checksum/file.txt?algo="+algo, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := checksum("md5"); code != http.StatusOK {
+		t.Errorf("MD5 rejected by default: got %v", code)
+	}
+
+	fm.ChecksumAlgorithms = []string{"sha256", "sha512"}
+
+	if code := checksum("md5"); code != http.StatusBadRequest {
+		t.Errorf("Disabled algorithm accepted: got %v", code)
+	}
+
+	if code := checksum("sha256"); code != http.StatusOK {
+		t.Errorf("Enabled algorithm rejected: got %v", code)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	admin := fm.Users["admin"]
+	admin.Rules = []*Rule{
+		{Path: "/private", Allow: false},
+		{Path: "/private/ok", Allow: true},
+	}
+	fm.Users["bob"] = &User{ID: 99, Username: "bob", Rules: []*Rule{}}
+
+	allowed := func(query string) (int, *permission) {
+		r, err := http.NewRequest("GET", "/api/allowed?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		p := &permission{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, p
+	}
+
+	tests := []struct {
+		path    string
+		allowed bool
+		rule    int
+	}{
+		{"/private/secret", false, 0},
+		{"private/ok/file", true, 1},
+		{"/public", true, -1},
+	}
+
+	for _, test := range tests {
+		code, p := allowed("path=" + test.path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: got %v", test.path, code)
+		}
+
+		if p.Allowed != test.allowed || p.Rule != test.rule || len(p.Rules) != 2 {
+			t.Errorf("%s: got %+v", test.path, p)
+		}
+	}
+
+	if _, p := allowed("path=/private&user=99"); p.Username != "bob" || !p.Allowed {
+		t.Errorf("Admin couldn't check another user: got %+v", p)
+	}
+
+	if code, _ := allowed("user=1000"); code != http.StatusNotFound {
+		t.Errorf("Unknown user: got %v", code)
+	}
+
+	admin.Admin = false
+	if code, _ := allowed("user=99"); code != http.StatusForbidden {
+		t.Errorf("Non-admin checked another user: got %v", code)
+	}
+}
+
+func TestAssetsDir(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.AssetsDir = filepath.Join(fm.Temp, "assets")
+	files := map[string]string{
+		filepath.Join(fm.AssetsDir, "static", "img", "logo.svg"): "<svg/>",
+		filepath.Join(fm.AssetsDir, "static", "manifest.json"):   `{"start_url": "{{ .BaseURL }}/"}`,
+		filepath.Join(fm.Temp, "secret.txt"):                     "secret",
+	}
+
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/static/img/logo.svg"); w.Code != http.StatusOK || w.Body.String() != "<svg/>" {
+		t.Errorf("The asset wasn't overridden: got %v %q", w.Code, w.Body.String())
+	}
+
+	if w := get("/static/manifest.json"); w.Body.String() != `{"start_url": "/"}` {
+		t.Errorf("The manifest wasn't rendered: got %q", w.Body.String())
+	}
+
+	r, err := http.NewRequest("GET", "/static/img/logo.svg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.URL.Path = "/static/../../secret.txt"
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("A file outside of the assets directory was served")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+		want             bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate", "br", false},
+		{"GZIP", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"gzip;q=0.5, br;q=0", "br", false},
+		{"*", "br", true},
+		{"*, br;q=0", "br", false},
+		{"*;q=0, gzip", "gzip", true},
+		{"", "gzip", false},
+	}
+
+	for _, test := range tests {
+		if got := acceptsEncoding(test.header, test.encoding); got != test.want {
+			t.Errorf("%q accepts %s: got %v, want %v", test.header, test.encoding, got, test.want)
+		}
+	}
+}
+
+func TestPrecompressedAssets(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.AssetsDir = filepath.Join(fm.Temp, "assets")
+	files := map[string]string{
+		"app.js":    "plain",
+		"app.js.gz": "gzipped",
+		"app.js.br": "brotli",
+		"style.css": "plain",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(fm.AssetsDir, "static", "js", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path, accept, body, encoding string
+	}{
+		{"/static/js/app.js", "gzip, deflate, br", "brotli", "br"},
+		{"/static/js/app.js", "gzip", "gzipped", "gzip"},
+		{"/static/js/app.js", "br;q=0, gzip", "gzipped", "gzip"},
+		{"/static/js/app.js", "", "plain", ""},
+		{"/static/js/style.css", "gzip, br", "plain", ""},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Accept-Encoding", test.accept)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Body.String() != test.body || w.Header().Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s with %q: got %q encoded with %q", test.path, test.accept, w.Body.String(), w.Header().Get("Content-Encoding"))
+		}
+
+		if test.path == "/static/js/app.js" {
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/javascript") && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+				t.Errorf("%s with %q: got type %q", test.path, test.accept, w.Header().Get("Content-Type"))
+			}
+
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("%s with %q: got Vary %q", test.path, test.accept, w.Header().Get("Vary"))
+			}
+		}
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.MaxURLLength = 100
+	fm.MaxHeaderBytes = 200
+	fm.MaxQueryParams = 3
+
+	tests := []struct {
+		url    string
+		header string
+		code   int
+	}{
+		{"/api/auth/introspect", "", http.StatusUnauthorized},
+		{"/api/auth/introspect?" + strings.Repeat("a", 100), "", http.StatusRequestURITooLong},
+		{"/api/auth/introspect?a=1&b=2&c=3", "", http.StatusUnauthorized},
+		{"/api/auth/introspect?a=1&b=2&c=3&d=4", "", http.StatusRequestURITooLong},
+		{"/api/auth/introspect", strings.Repeat("a", 200), http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.header != "" {
+			r.Header.Set("X-Padding", test.header)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s with a header of %d bytes: got %v want %v", test.url, len(test.header), w.Code, test.code)
+		}
+	}
+
+	// Zero disables the limits.
+	fm.MaxURLLength, fm.MaxHeaderBytes, fm.MaxQueryParams = 0, 0, 0
+
+	r, err := http.NewRequest("GET", "/api/auth/introspect?"+strings.Repeat("a=1&", 100), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("X-Padding", strings.Repeat("a", 1000))
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Without limits: got %v", w.Code)
+	}
+}
+
+func TestBranding(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	page := `<title>{{ .Branding.Title }}</title><meta name="sso-url" content="{{ .Branding.SSOURL }}">`
+	c := &RequestContext{FileManager: fm.FileManager}
+
+	w := httptest.NewRecorder()
+	if _, err := renderFile(c, w, page, "text/html"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `<title>File Manager</title><meta name="sso-url" content="">`; w.Body.String() != want {
+		t.Errorf("Without branding: got %q, want %q", w.Body.String(), want)
+	}
+
+	fm.Branding = Branding{Name: `<ACME & "Co">`, SSOURL: "/sso/login?next=/files/&x=\"y\""}
+	if err := fm.Branding.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := renderFile(c, w, page, "text/html"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<title>&lt;ACME &amp; &#34;Co&#34;&gt;</title><meta name="sso-url" content="/sso/login?next=/files/&amp;x=&#34;y&#34;">`
+	if w.Body.String() != want {
+		t.Errorf("Got %q, want %q", w.Body.String(), want)
+	}
+
+	for _, raw := range []string{"javascript:alert(1)", "//evil.example", "sso/login", "data:text/html,x"} {
+		fm.Branding = Branding{SSOURL: raw}
+		if fm.Branding.Validate() == nil {
+			t.Errorf("%q: expected an invalid URL", raw)
+		}
+
+		if b := fm.Branding.sanitized(); b.SSOURL != "" {
+			t.Errorf("%q: the URL wasn't removed", raw)
+		}
+	}
+
+	fm.Branding = Branding{Logo: "https://cdn.example.com/logo.svg", SSOURL: "https://sso.example.com/"}
+	if err := fm.Branding.Validate(); err != nil {
+		t.Errorf("Got %v for HTTPS URLs", err)
+	}
+}
+
+func TestSplitURL(t *testing.T) {
+	tests := []struct {
+		path, router, rest string
+	}{
+		{"", "", ""},
+		{"/", "", "/"},
+		{"/changes", "changes", "/"},
+		{"/resource/", "resource", "/"},
+		{"/resource/a/b.txt", "resource", "/a/b.txt"},
+		{"share/abc", "share", "/abc"},
+	}
+
+	for _, test := range tests {
+		router, rest := splitURL(test.path)
+		if router != test.router || rest != test.rest {
+			t.Errorf("%q: got %q %q, want %q %q", test.path, router, rest, test.router, test.rest)
+		}
+	}
+}
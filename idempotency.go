@@ -0,0 +1,180 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// idempotencyHeader is the header with the key which makes the retries of
+// an upload return the result of the first one instead of writing again.
+const idempotencyHeader = "Idempotency-Key"
+
+// defaultIdempotencyWindow is how long the keys are kept if
+// FileManager.IdempotencyWindow isn't set.
+const defaultIdempotencyWindow = 10 * time.Minute
+
+// maxIdempotencyKey is the maximum length of the keys.
+const maxIdempotencyKey = 255
+
+// idempotencyPendingTTL is how long the records of the requests in
+// progress are kept without being refreshed, so the keys of the requests
+// which crashed can be used again soon.
+const idempotencyPendingTTL = 30 * time.Second
+
+var (
+	errIdempotencyKey      = errors.New("invalid idempotency key")
+	errIdempotencyPending  = errors.New("a request with the same idempotency key is in progress")
+	errIdempotencyMismatch = errors.New("the idempotency key was used for another request")
+)
+
+// idempotencyRecord is the result of a request with an idempotency key,
+// kept in the state store. It's pending while the request is in progress.
+type idempotencyRecord struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Pending bool   `json:"pending"`
+	Code    int    `json:"code"`
+	ETag    string `json:"etag"`
+}
+
+// idempotencyKey is the key of the record in the state store. The keys are
+// kept for each user so they can't see the results of the others.
+func idempotencyKey(username, key string) string {
+	return "idempotency/" + username + "/" + key
+}
+
+// idempotencyWindow returns how long the keys are kept, or zero if they
+// are ignored.
+func (m *FileManager) idempotencyWindow() time.Duration {
+	switch {
+	case m.IdempotencyWindow < 0:
+		return 0
+	case m.IdempotencyWindow == 0:
+		return defaultIdempotencyWindow
+	}
+
+	return m.IdempotencyWindow
+}
+
+// validIdempotencyKey checks if the key only has visible ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// idempotent runs the handler of an upload unless a request with the same
+// Idempotency-Key header was already made, in which case its result is
+// returned instead. While the first request is in progress, the others
+// are rejected with 409 Conflict. Only the successful results are kept,
+// so the failed uploads can be retried.
+func idempotent(c *RequestContext, w http.ResponseWriter, r *http.Request, handler func(*RequestContext, http.ResponseWriter, *http.Request) (int, error)) (int, error) {
+	key := r.Header.Get(idempotencyHeader)
+	window := c.idempotencyWindow()
+	if key == "" || window == 0 {
+		return handler(c, w, r)
+	}
+
+	if !validIdempotencyKey(key) {
+		return http.StatusBadRequest, errIdempotencyKey
+	}
+
+	key = idempotencyKey(c.User.Username, key)
+	record := idempotencyRecord{Method: r.Method, Path: r.URL.Path, Pending: true}
+
+	pending, err := json.Marshal(record)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	created, err := c.state().SetNX(key, pending, idempotencyPendingTTL)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if !created {
+		value, ok, err := c.state().Get(key)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		// The record of a request which just failed or crashed.
+		if !ok {
+			return http.StatusConflict, errIdempotencyPending
+		}
+
+		var saved idempotencyRecord
+		if err := json.Unmarshal(value, &saved); err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		switch {
+		case saved.Method != record.Method || saved.Path != record.Path:
+			return http.StatusUnprocessableEntity, errIdempotencyMismatch
+		case saved.Pending:
+			return http.StatusConflict, errIdempotencyPending
+		}
+
+		if saved.ETag != "" {
+			w.Header().Set("ETag", saved.ETag)
+		}
+
+		w.Header().Set("Idempotent-Replayed", "true")
+		return saved.Code, nil
+	}
+
+	// The pending record is refreshed until the request finishes.
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(idempotencyPendingTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := c.state().Set(key, pending, idempotencyPendingTTL); err != nil {
+					log.Print(err)
+				}
+			}
+		}
+	}()
+
+	code, err := handler(c, w, r)
+	close(stop)
+	<-stopped
+
+	if code < 200 || code > 299 {
+		if err := c.state().Delete(key); err != nil {
+			log.Print(err)
+		}
+
+		return code, err
+	}
+
+	record.Pending = false
+	record.Code = code
+	record.ETag = w.Header().Get("ETag")
+
+	// The upload was made so a failure to keep its result is only logged.
+	value, _ := json.Marshal(record)
+	if err := c.state().Set(key, value, window); err != nil {
+		log.Print(err)
+	}
+
+	return code, err
+}
@@ -0,0 +1,53 @@
+package filemanager
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checkIgnore checks if the ignore patterns are well formed.
+func checkIgnore(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ignored tells if the entry at the path, which is relative to the scope of
+// the user, matches one of the global or the user's ignore patterns. The
+// patterns without slashes are matched against the name of the entry and
+// the other ones against its path.
+func (m *FileManager) ignored(u *User, p string) bool {
+	if len(m.Ignore) == 0 && len(u.Ignore) == 0 {
+		return false
+	}
+
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	if p == "" {
+		return false
+	}
+
+	name := path.Base(p)
+
+	for _, patterns := range [][]string{m.Ignore, u.Ignore} {
+		for _, pattern := range patterns {
+			var ok bool
+
+			if strings.Contains(pattern, "/") {
+				ok, _ = path.Match(strings.Trim(pattern, "/"), p)
+			} else {
+				ok, _ = path.Match(pattern, name)
+			}
+
+			if ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
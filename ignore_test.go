@@ -0,0 +1,26 @@
+package filemanager
+
+import "testing"
+
+func TestIgnored(t *testing.T) {
+	m := &FileManager{Ignore: []string{".*", "node_modules"}}
+	u := &User{Ignore: []string{"docs/*.tmp"}}
+
+	tests := map[string]bool{
+		"/":                      false,
+		"/.git":                  true,
+		"/src/.env":              true,
+		"/src/node_modules":      true,
+		"/src/main.go":           false,
+		"/docs/draft.tmp":        true,
+		"/src/docs/draft.tmp":    false,
+		"/node_modules_backup":   false,
+		"/docs/nested/draft.tmp": false,
+	}
+
+	for path, want := range tests {
+		if got := m.ignored(u, path); got != want {
+			t.Errorf("ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package filemanager
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// impersonateHeader is the header with the username an administrator
+// makes the request as.
+const impersonateHeader = "Impersonate"
+
+var errImpersonation = errors.New("only administrators can impersonate other users")
+
+// impersonate makes the request run as the user named in the Impersonate
+// header, if it is set. Only the administrators can impersonate the other
+// users and the request then has the permissions of that user, never the
+// ones of the administrator. Every impersonated request is logged.
+func impersonate(c *RequestContext, r *http.Request) (int, error) {
+	name := r.Header.Get(impersonateHeader)
+	if name == "" {
+		return 0, nil
+	}
+
+	// Without authentication, every visitor would be the administrator.
+	if c.NoAuth || !c.User.Admin {
+		return http.StatusForbidden, errImpersonation
+	}
+
+	u, ok := c.Users[name]
+	if !ok {
+		return http.StatusNotFound, errUserNotExist
+	}
+
+	log.Printf("[INFO] %s is impersonating %s: %s /api/%s%s", c.User.Username, u.Username, r.Method, c.Router, r.URL.Path)
+
+	c.User = u
+	return 0, nil
+}
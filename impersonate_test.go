@@ -0,0 +1,102 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImpersonate(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	password, err := hashPassword("secret", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &User{
+		Username:   "alice",
+		Password:   password,
+		FileSystem: fm.Users["admin"].FileSystem,
+		Rules:      []*Rule{{Path: "/private"}},
+	}
+
+	if err = fm.db.Save(alice); err != nil {
+		t.Fatal(err)
+	}
+	fm.Users["alice"] = alice
+
+	do := func(token, as, url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		if as != "" {
+			r.Header.Set("Impersonate", as)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	admin := login(t, fm, defaultCredentials)
+	user := login(t, fm, `{"username":"alice","password":"secret"}`)
+
+	w := do(admin, "alice", "/api/me")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"alice"`) {
+		t.Errorf("The request didn't run as the impersonated user: %v %s", w.Code, w.Body.String())
+	}
+
+	// The impersonated requests have the permissions of the user.
+	tests := []struct {
+		token string
+		as    string
+		url   string
+		want  int
+	}{
+		{admin, "", "/api/users/", http.StatusOK},
+		{admin, "alice", "/api/users/", http.StatusForbidden},
+		{admin, "", "/api/resource/private", http.StatusNotFound},
+		{admin, "alice", "/api/resource/private", http.StatusForbidden},
+		{admin, "carol", "/api/me", http.StatusNotFound},
+		{user, "admin", "/api/users/", http.StatusForbidden},
+		{user, "alice", "/api/me", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		if w := do(test.token, test.as, test.url); w.Code != test.want {
+			t.Errorf("%s as %q: got %v, want %v", test.url, test.as, w.Code, test.want)
+		}
+	}
+
+	// The sub-requests of the impersonated batches too.
+	r, err := http.NewRequest("POST", "/api/batch/", strings.NewReader(`[{"router": "me"}, {"router": "users"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+admin)
+	r.Header.Set("Impersonate", "alice")
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var results []batchResult
+	if err = json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || !strings.Contains(string(results[0].Body), `"username":"alice"`) || results[1].Status != http.StatusForbidden {
+		t.Errorf("The batch didn't run as the impersonated user: %s", w.Body.String())
+	}
+
+	fm.NoAuth = true
+	if w := do("", "alice", "/api/me"); w.Code != http.StatusForbidden {
+		t.Errorf("Impersonation without authentication: got %v", w.Code)
+	}
+}
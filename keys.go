@@ -0,0 +1,127 @@
+package filemanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+// oldKey is a signing key which was rotated. It can still be used to
+// verify tokens and links until it expires.
+type oldKey struct {
+	Key     []byte    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// sign returns the HMAC-SHA256 signature of the values using the key.
+func sign(key []byte, values ...string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(values, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyID returns the identifier of a key which is sent in the
+// header of the JWT tokens.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadKeys tries to get the signing keys from the database.
+// If they don't exist, a new key of 512 bits is created.
+func (m *FileManager) loadKeys(db *storm.DB) error {
+	err := db.Get("config", "key", &m.key)
+	if err != nil && err == storm.ErrNotFound {
+		var bytes []byte
+		bytes, err = generateRandomBytes(64)
+		if err != nil {
+			return err
+		}
+
+		m.key = bytes
+		err = db.Set("config", "key", m.key)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = db.Get("config", "oldKeys", &m.oldKeys)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// RotateKey replaces the key used to sign the authentication tokens and
+// the download links with a new one. The previous key is still accepted
+// during the grace period so the users aren't logged out immediately.
+func (m *FileManager) RotateKey(grace time.Duration) error {
+	key, err := generateRandomBytes(64)
+	if err != nil {
+		return err
+	}
+
+	m.keyMu.Lock()
+	defer m.keyMu.Unlock()
+
+	now := time.Now()
+	keys := []oldKey{}
+
+	// Removes the keys whose grace period is over.
+	for _, k := range m.oldKeys {
+		if k.Expires.After(now) {
+			keys = append(keys, k)
+		}
+	}
+
+	if grace > 0 {
+		keys = append(keys, oldKey{
+			Key:     m.key,
+			Expires: now.Add(grace),
+		})
+	}
+
+	if err = m.db.Set("config", "oldKeys", keys); err != nil {
+		return err
+	}
+
+	if err = m.db.Set("config", "key", key); err != nil {
+		return err
+	}
+
+	m.key = key
+	m.oldKeys = keys
+	return nil
+}
+
+// signingKey returns the key used to sign new tokens and links.
+func (m *FileManager) signingKey() []byte {
+	m.keyMu.RLock()
+	defer m.keyMu.RUnlock()
+
+	return m.key
+}
+
+// verificationKeys returns the keys which are currently accepted,
+// starting with the signing key.
+func (m *FileManager) verificationKeys() [][]byte {
+	m.keyMu.RLock()
+	defer m.keyMu.RUnlock()
+
+	keys := [][]byte{m.key}
+	now := time.Now()
+
+	for _, k := range m.oldKeys {
+		if k.Expires.After(now) {
+			keys = append(keys, k.Key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,53 @@
+package filemanager
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checkLandingPath checks if the user can access the landing path. It can
+// be empty. The path doesn't need to exist yet since the directory may be
+// created later.
+func checkLandingPath(u *User, p string) error {
+	if p == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(p, "/") || !u.Allowed(path.Clean(p)) {
+		return errInvalidLandingPath
+	}
+
+	return nil
+}
+
+// landingPath returns the directory, relative to the scope of the user,
+// the front-end opens first: the one of the user or, if it has none, the
+// global one. If the user can't access the directory or it doesn't exist,
+// the next one is used, down to the root of the scope.
+func (m *FileManager) landingPath(u *User) string {
+	for _, p := range []string{u.LandingPath, m.LandingPath} {
+		if p == "" {
+			continue
+		}
+
+		p = path.Clean("/" + p)
+		if !u.Allowed(p) || m.ignored(u, p) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(string(u.FileSystem), filepath.FromSlash(p)))
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		if p != "/" {
+			p += "/"
+		}
+
+		return p
+	}
+
+	return "/"
+}
@@ -0,0 +1,267 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limitRetryAfter is the number of seconds the clients are told to
+// wait before retrying the operations rejected because of the limits.
+const limitRetryAfter = 5
+
+// The kinds of operations which can be limited.
+const (
+	limitChecksum  = "checksum"
+	limitSearch    = "search"
+	limitThumbnail = "thumbnail"
+	limitArchive   = "archive"
+	limitBuild     = "build"
+)
+
+// defaultBuildLimit is the number of builds of the website which can run
+// at once if Limits.Build isn't set, since each of them uses the whole
+// website.
+const defaultBuildLimit = 1
+
+var errInvalidLimit = errors.New("unknown operation or invalid limit")
+
+// Limits are the maximum number of operations of each kind which can run
+// at once. Zero means there is no limit.
+type Limits struct {
+	Checksum  int
+	Search    int
+	Thumbnail int
+	Archive   int
+	// Build is the number of builds of the website checked before they
+	// are published. Zero means one.
+	Build int
+
+	// Wait is how long the requests wait for a running operation to
+	// finish when the limit is reached. After that, they're rejected
+	// with 503 Service Unavailable. Zero means they're rejected at once.
+	Wait time.Duration
+}
+
+// Set sets the limit of the operations of a kind: 'checksum', 'search',
+// 'thumbnail', 'archive' or 'build'.
+func (l *Limits) Set(kind string, n int) error {
+	if n < 0 {
+		return errInvalidLimit
+	}
+
+	switch kind {
+	case limitChecksum:
+		l.Checksum = n
+	case limitSearch:
+		l.Search = n
+	case limitThumbnail:
+		l.Thumbnail = n
+	case limitArchive:
+		l.Archive = n
+	case limitBuild:
+		l.Build = n
+	default:
+		return errInvalidLimit
+	}
+
+	return nil
+}
+
+// of returns the limit of the operations of a kind.
+func (l Limits) of(kind string) int {
+	switch kind {
+	case limitChecksum:
+		return l.Checksum
+	case limitSearch:
+		return l.Search
+	case limitThumbnail:
+		return l.Thumbnail
+	case limitArchive:
+		return l.Archive
+	case limitBuild:
+		if l.Build == 0 {
+			return defaultBuildLimit
+		}
+
+		return l.Build
+	default:
+		return 0
+	}
+}
+
+// semaphores holds a semaphore for each kind of operation.
+type semaphores struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// get returns the semaphore of a kind with n slots. It is replaced if
+// the limit changed.
+func (s *semaphores) get(kind string, n int) chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.slots == nil {
+		s.slots = map[string]chan struct{}{}
+	}
+
+	sem, ok := s.slots[kind]
+	if !ok || cap(sem) != n {
+		sem = make(chan struct{}, n)
+		s.slots[kind] = sem
+	}
+
+	return sem
+}
+
+// acquire reserves a slot for an operation of a kind. If all of them are
+// taken, it waits for up to Limits.Wait or until the request is canceled.
+// It returns the function which frees the slot or false if no slot
+// was reserved.
+func (m *FileManager) acquire(r *http.Request, kind string) (func(), bool) {
+	n := m.Limits.of(kind)
+	if n <= 0 || m.semaphores == nil {
+		return func() {}, true
+	}
+
+	sem := m.semaphores.get(kind, n)
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if m.Limits.Wait <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(m.Limits.Wait)
+	defer timer.Stop()
+
+	select {
+	case sem <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// acquireJob reserves a slot for an operation of a kind run by a job in
+// the background. Since there is no request to reject, it waits for as
+// long as needed. It returns the function which frees the slot.
+func (m *FileManager) acquireJob(kind string) func() {
+	n := m.Limits.of(kind)
+	if n <= 0 || m.semaphores == nil {
+		return func() {}
+	}
+
+	sem := m.semaphores.get(kind, n)
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// downloadSlots counts the downloads in progress of each user. The ranged
+// requests of a file, which the download managers make in parallel, are
+// counted as a single download.
+type downloadSlots struct {
+	sync.Mutex
+	// active has the number of requests of each download of each user.
+	active map[string]map[string]int
+	// next is used to tell apart the downloads which aren't ranged.
+	next int
+}
+
+// acquire reserves a slot for a download of the user, unless the user
+// already has max downloads in progress. The ranged requests of the same
+// file share the slot. It returns the function which frees the slot or
+// false if no slot was reserved.
+func (d *downloadSlots) acquire(username, path string, ranged bool, max int) (func(), bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.active == nil {
+		d.active = map[string]map[string]int{}
+	}
+
+	downloads := d.active[username]
+	if downloads == nil {
+		downloads = map[string]int{}
+		d.active[username] = downloads
+	}
+
+	key := "range:" + path
+	if !ranged {
+		d.next++
+		key = strconv.Itoa(d.next)
+	}
+
+	if downloads[key] == 0 && len(downloads) >= max {
+		return nil, false
+	}
+
+	downloads[key]++
+
+	return func() {
+		d.Lock()
+		defer d.Unlock()
+
+		if downloads[key]--; downloads[key] == 0 {
+			delete(downloads, key)
+		}
+
+		if len(downloads) == 0 {
+			delete(d.active, username)
+		}
+	}, true
+}
+
+// downloadLimit returns the maximum number of downloads the user can have
+// in progress: their own MaxDownloads or, if it is zero, the global one.
+// Zero means there is no limit.
+func (m *FileManager) downloadLimit(u *User) int {
+	switch {
+	case u.MaxDownloads < 0:
+		return 0
+	case u.MaxDownloads > 0:
+		return u.MaxDownloads
+	}
+
+	return m.MaxDownloads
+}
+
+// acquireDownload reserves a slot for a download of the file at the path
+// by the user of the request. The downloads of the shares aren't limited
+// since they aren't made by the users.
+func (c *RequestContext) acquireDownload(r *http.Request, path string) (func(), bool) {
+	if c.User == nil || c.share != nil || c.downloads == nil {
+		return func() {}, true
+	}
+
+	max := c.downloadLimit(c.User)
+	if max <= 0 {
+		return func() {}, true
+	}
+
+	return c.downloads.acquire(c.User.Username, path, r.Header.Get("Range") != "", max)
+}
+
+// rejectTooManyDownloads replies with 429 Too Many Requests when the user
+// has too many downloads in progress.
+func rejectTooManyDownloads(w http.ResponseWriter) (int, error) {
+	w.Header().Set("Retry-After", strconv.Itoa(limitRetryAfter))
+	return http.StatusTooManyRequests, nil
+}
+
+// rejectBusy replies with 503 Service Unavailable when an operation
+// can't run because too many of them are running.
+func rejectBusy(w http.ResponseWriter) (int, error) {
+	w.Header().Set("Retry-After", strconv.Itoa(limitRetryAfter))
+	return http.StatusServiceUnavailable, nil
+}
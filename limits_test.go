@@ -0,0 +1,151 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a limit, there is always a slot.
+	for i := 0; i < 3; i++ {
+		if _, ok := fm.acquire(r, limitChecksum); !ok {
+			t.Fatal("Operation without limit was rejected")
+		}
+	}
+
+	if err = fm.Limits.Set(limitChecksum, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	release, ok := fm.acquire(r, limitChecksum)
+	if !ok {
+		t.Fatal("The first operation was rejected")
+	}
+
+	if _, ok = fm.acquire(r, limitChecksum); ok {
+		t.Error("The limit wasn't enforced")
+	}
+
+	// Other kinds have their own limits.
+	if _, ok = fm.acquire(r, limitArchive); !ok {
+		t.Error("Another kind of operation was rejected")
+	}
+
+	// Waiting requests get the slot once it is released.
+	fm.Limits.Wait = time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+
+	if _, ok = fm.acquire(r, limitChecksum); !ok {
+		t.Error("The waiting operation didn't get the released slot")
+	}
+
+	if err = fm.Limits.Set("unknown", 1); err == nil {
+		t.Error("Unknown operation accepted")
+	}
+}
+
+func TestDownloadSlots(t *testing.T) {
+	d := &downloadSlots{}
+
+	first, ok := d.acquire("alice", "/a", false, 2)
+	if !ok {
+		t.Fatal("The first download was rejected")
+	}
+
+	// The ranged requests of the same file are a single download.
+	var ranges []func()
+	for i := 0; i < 3; i++ {
+		release, ok := d.acquire("alice", "/b", true, 2)
+		if !ok {
+			t.Fatalf("The range %d was rejected", i)
+		}
+		ranges = append(ranges, release)
+	}
+
+	if _, ok := d.acquire("alice", "/a", false, 2); ok {
+		t.Error("A third download was accepted")
+	}
+
+	if _, ok := d.acquire("alice", "/c", true, 2); ok {
+		t.Error("The ranges of a third file were accepted")
+	}
+
+	// The limits are for each user.
+	if _, ok := d.acquire("bob", "/a", false, 2); !ok {
+		t.Error("The download of another user was rejected")
+	}
+
+	first()
+	ranges[0]()
+	ranges[1]()
+
+	if _, ok := d.acquire("alice", "/c", false, 2); !ok {
+		t.Error("A download was rejected after another one finished")
+	}
+
+	if _, ok := d.acquire("alice", "/d", false, 2); ok {
+		t.Error("The ranged download was released before its last range")
+	}
+
+	ranges[2]()
+	if _, ok := d.acquire("alice", "/d", false, 2); !ok {
+		t.Error("The ranged download wasn't released")
+	}
+}
+
+func TestMaxDownloads(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	download := func() int {
+		r, err := http.NewRequest("GET", "/api/download/file.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	fm.MaxDownloads = 1
+	release, _ := fm.downloads.acquire("admin", "/other", false, 1)
+
+	if code := download(); code != http.StatusTooManyRequests {
+		t.Errorf("Download over the limit: got %v, want %v", code, http.StatusTooManyRequests)
+	}
+
+	fm.Users["admin"].MaxDownloads = -1
+	if code := download(); code != http.StatusOK {
+		t.Errorf("Download without limit for the user: got %v, want %v", code, http.StatusOK)
+	}
+
+	fm.Users["admin"].MaxDownloads = 0
+	release()
+
+	if code := download(); code != http.StatusOK {
+		t.Errorf("Download after the other one finished: got %v, want %v", code, http.StatusOK)
+	}
+}
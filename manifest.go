@@ -0,0 +1,104 @@
+package filemanager
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestHandler streams the checksums of the files of the directory in
+// the URL, and of its subdirectories, in the format of the 'sha256sum'
+// tool and the like, so a download can be verified with them. The files
+// the user can't access and the ignored ones aren't included.
+func manifestHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+
+	if !c.checksumEnabled(algo) {
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	if !c.File.IsDir {
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	release, ok := c.acquire(r, limitChecksum)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	root := filepath.Clean(string(c.User.FileSystem))
+	dir := filepath.Clean(c.File.Path)
+
+	name := c.File.Name
+	if name == "." || name == "" {
+		name = "download"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", attachment(downloadName(c, name+"."+algo)))
+	c.setDownloadHeaders(w, c.File.VirtualPath)
+
+	out := bufio.NewWriter(w)
+
+	_, err := walk(root, dir, c.MaxDepth, func(p string, info os.FileInfo, err error) error {
+		// The directories which can't be read are skipped.
+		if err != nil {
+			return nil
+		}
+
+		virtual := virtualPath(root, p)
+		if p != dir && (!c.User.Allowed(virtual) || c.ignored(c.User, virtual)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		sum, err := c.checksums.get(&file{
+			Path:    p,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		}, algo)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		_, err = out.WriteString(manifestLine(sum, filepath.ToSlash(rel)))
+		return err
+	})
+
+	if err != nil {
+		// The headers were already sent so the error can only be logged.
+		return 0, err
+	}
+
+	return 0, out.Flush()
+}
+
+// manifestLine returns the line of a file in a checksum manifest. Like the
+// 'sha256sum' tool, the backslashes and the new lines in the name are
+// escaped and the line starts with a backslash if any was.
+func manifestLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n\r") {
+		return sum + "  " + name + "\n"
+	}
+
+	name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+	return "\\" + sum + "  " + name + "\n"
+}
@@ -0,0 +1,98 @@
+package filemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+
+	for _, name := range []string{"dir/a.txt", "dir/sub/b.txt", "dir/private/c.txt", "dir/node_modules/d.js", "e.txt"} {
+		p := filepath.Join(scope, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.Ignore = []string{"node_modules"}
+	fm.Users["admin"].Rules = []*Rule{{Path: "/dir/private"}}
+
+	token := login(t, fm, defaultCredentials)
+
+	manifest := func(url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	sum := func(content string) string {
+		h := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(h[:])
+	}
+
+	w := manifest("/api/manifest/dir/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	want := sum("dir/a.txt") + "  a.txt\n" + sum("dir/sub/b.txt") + "  sub/b.txt\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("Got manifest %q, want %q", got, want)
+	}
+
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "dir.sha256") {
+		t.Errorf("Got Content-Disposition %q", got)
+	}
+
+	if len(fm.checksums.items) != 2 {
+		t.Errorf("Got %d checksums in cache, want 2", len(fm.checksums.items))
+	}
+
+	fm.ChecksumAlgorithms = []string{"md5"}
+	if w = manifest("/api/manifest/dir/?algo=sha256"); w.Code != http.StatusBadRequest {
+		t.Errorf("Disabled algorithm: got status %v", w.Code)
+	}
+
+	if w = manifest("/api/manifest/e.txt?algo=md5"); w.Code != http.StatusBadRequest {
+		t.Errorf("Manifest of a file: got status %v", w.Code)
+	}
+}
+
+func TestManifestLine(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.txt", "abc  a.txt\n"},
+		{"a b/c.txt", "abc  a b/c.txt\n"},
+		{"a\\b.txt", "\\abc  a\\\\b.txt\n"},
+		{"a\nb.txt", "\\abc  a\\nb.txt\n"},
+	}
+
+	for _, test := range tests {
+		if got := manifestLine("abc", test.name); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
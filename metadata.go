@@ -0,0 +1,196 @@
+package filemanager
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// entryMetadata is the metadata of a directory entry.
+type entryMetadata struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modified"`
+	IsDir    bool      `json:"isDir"`
+	MimeType string    `json:"mimeType,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// metadataResponse is the response of the metadata API.
+type metadataResponse struct {
+	// Total is the number of entries in the directory, without pagination.
+	Total int              `json:"total"`
+	Items []*entryMetadata `json:"items"`
+}
+
+// metadataHandler returns the metadata of the entries of a directory in a
+// single response. The expensive fields are only included if requested:
+// 'checksum' takes the algorithm to use and 'sniff=true' detects the MIME type
+// from the content of files whose extension is unknown. 'tags=true' reads
+// the tags of the entries, which needs the extended attributes. The entries
+// can be paginated using 'offset' and 'limit'.
+func metadataHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	if !c.File.IsDir {
+		return http.StatusBadRequest, errors.New("metadata can only be listed for directories")
+	}
+
+	query := r.URL.Query()
+	algo := query.Get("checksum")
+	sniff := query.Get("sniff") == "true"
+	tags := query.Get("tags") == "true"
+
+	if tags && !c.Xattrs {
+		return http.StatusNotImplemented, errXattrsDisabled
+	}
+
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	limit, err := queryInt(query.Get("limit"), 0)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if algo != "" {
+		release, ok := c.acquire(r, limitChecksum)
+		if !ok {
+			return rejectBusy(w)
+		}
+		defer release()
+	}
+
+	f, err := c.User.FileSystem.OpenFile(c.File.VirtualPath, os.O_RDONLY, 0)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+	defer f.Close()
+
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	infos := []os.FileInfo{}
+	for _, info := range files {
+		if c.User.Allowed(path.Join(c.File.VirtualPath, info.Name())) {
+			infos = append(infos, info)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	res := &metadataResponse{
+		Total: len(infos),
+		Items: []*entryMetadata{},
+	}
+
+	if offset > len(infos) {
+		offset = len(infos)
+	}
+
+	infos = infos[offset:]
+	if limit > 0 && limit < len(infos) {
+		infos = infos[:limit]
+	}
+
+	for _, info := range infos {
+		item := &entryMetadata{
+			Name:    info.Name(),
+			Path:    path.Join(c.File.VirtualPath, info.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		}
+
+		res.Items = append(res.Items, item)
+		abs := filepath.Join(c.File.Path, info.Name())
+
+		if tags {
+			item.Tags, err = fileTags(abs)
+			if err != nil {
+				return errorToHTTP(err, true), err
+			}
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		item.MimeType = mime.TypeByExtension(filepath.Ext(info.Name()))
+
+		if item.MimeType == "" && sniff {
+			item.MimeType, err = sniffMimeType(abs)
+			if err != nil {
+				return errorToHTTP(err, true), err
+			}
+		}
+
+		if algo != "" {
+			item.Checksum, err = c.checksum(&file{
+				Path:    abs,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			}, algo)
+			if err == errInvalidOption {
+				return http.StatusBadRequest, err
+			} else if err != nil {
+				return errorToHTTP(err, true), err
+			}
+		}
+	}
+
+	return renderJSON(w, res)
+}
+
+// sniffMimeType detects the MIME type of a file using its first 512 bytes.
+func sniffMimeType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buffer[:n]), nil
+}
+
+// queryInt parses a non-negative integer from a query value, returning
+// def if the value is empty.
+func queryInt(val string, def int) (int, error) {
+	if val == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, err
+	}
+
+	if i < 0 {
+		return 0, errors.New("negative value: " + val)
+	}
+
+	return i, nil
+}
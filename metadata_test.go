@@ -0,0 +1,103 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope", "meta")
+	if err := os.MkdirAll(filepath.Join(scope, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"a.txt": "hello", "b.unknown": "<html></html>"} {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(query string) (int, *metadataResponse) {
+		r, err := http.NewRequest("GET", "/api/metadata/meta/"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		res := &metadataResponse{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, res
+	}
+
+	code, res := get("?checksum=md5&sniff=true")
+	if code != http.StatusOK || res.Total != 3 || len(res.Items) != 3 {
+		t.Fatalf("Metadata: got %v %+v", code, res)
+	}
+
+	if item := res.Items[0]; item.Name != "a.txt" || item.Size != 5 || item.Checksum != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("First entry: got %+v", item)
+	}
+
+	if item := res.Items[1]; !strings.HasPrefix(item.MimeType, "text/html") {
+		t.Errorf("Sniffed entry: got %+v", item)
+	}
+
+	if item := res.Items[2]; !item.IsDir || item.Checksum != "" {
+		t.Errorf("Directory entry: got %+v", item)
+	}
+
+	// The heavy fields are only included if requested.
+	if _, res = get(""); res.Items[0].Checksum != "" || res.Items[1].MimeType != "" {
+		t.Errorf("Metadata without options: got %+v %+v", res.Items[0], res.Items[1])
+	}
+
+	if _, res = get("?offset=1&limit=1"); res.Total != 3 || len(res.Items) != 1 || res.Items[0].Name != "b.unknown" {
+		t.Errorf("Paginated metadata: got %+v", res)
+	}
+
+	if code, _ = get("?checksum=md4"); code != http.StatusBadRequest {
+		t.Errorf("Unknown algorithm: got %v", code)
+	}
+
+	if code, _ = get("?tags=true"); code != http.StatusNotImplemented {
+		t.Errorf("Tags without the extended attributes: got %v", code)
+	}
+
+	fm.Xattrs = true
+
+	if err := setXattr(filepath.Join(scope, "a.txt"), tagsXattr, "red, blue,"); err != nil {
+		t.Skipf("the extended attributes aren't supported: %v", err)
+	}
+
+	code, res = get("?tags=true")
+	if code != http.StatusOK {
+		t.Fatalf("Tags: got %v", code)
+	}
+
+	if tags := res.Items[0].Tags; len(tags) != 2 || tags[0] != "red" || tags[1] != "blue" {
+		t.Errorf("Tags: got %v", tags)
+	}
+
+	if tags := res.Items[1].Tags; len(tags) != 0 {
+		t.Errorf("Untagged entry: got %v", tags)
+	}
+}
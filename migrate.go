@@ -0,0 +1,147 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// migrations upgrade the database to each schema version: the first one
+// upgrades it to version 1, the second to version 2 and so on. They run
+// in a single transaction, which also saves the new schema version.
+var migrations = []func(tx *bolt.Tx, opts DatabaseOptions) error{
+	backfillUsers,
+}
+
+// schemaVersion is the version of the database used by this version of
+// File Manager.
+var schemaVersion = len(migrations)
+
+// userIdentityFields are the fields of the users which are never
+// backfilled since they can't have defaults.
+var userIdentityFields = map[string]bool{
+	"ID":       true,
+	"username": true,
+	"password": true,
+	"admin":    true,
+}
+
+// migrateDatabase runs the migrations the database hasn't had yet. New
+// databases are at the latest version. Read-only databases can't be
+// migrated, so they are used as they are.
+func migrateDatabase(db *storm.DB, opts DatabaseOptions) error {
+	version := 0
+	err := db.Get("config", "schemaVersion", &version)
+	if err == storm.ErrNotFound {
+		var users []User
+		if err = db.All(&users); err != nil {
+			return err
+		}
+
+		// Databases without users are new, so they're at the latest
+		// version.
+		if len(users) == 0 {
+			if opts.ReadOnly {
+				return nil
+			}
+
+			return db.Set("config", "schemaVersion", schemaVersion)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if version >= schemaVersion {
+		return nil
+	}
+
+	if opts.ReadOnly {
+		log.Printf("[WARNING] The database is at version %d and can't be upgraded to %d in read-only mode", version, schemaVersion)
+		return nil
+	}
+
+	return db.Bolt.Update(func(tx *bolt.Tx) error {
+		for _, migrate := range migrations[version:] {
+			if err := migrate(tx, opts); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Upgraded the database from version %d to %d", version, schemaVersion)
+		return db.WithTransaction(tx).Set("config", "schemaVersion", schemaVersion)
+	})
+}
+
+// backfillUsers sets the fields missing from the users saved by older
+// versions, such as the permissions added since then, to the ones of
+// opts.UserDefaults. If it isn't set, they're left to their zero value,
+// which denies the permissions.
+func backfillUsers(tx *bolt.Tx, opts DatabaseOptions) error {
+	b := tx.Bucket([]byte("User"))
+	if b == nil {
+		return nil
+	}
+
+	var defaults User
+	if opts.UserDefaults != nil {
+		defaults = *opts.UserDefaults
+	}
+
+	data, err := json.Marshal(defaults)
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	records := map[string][]byte{}
+	err = b.ForEach(func(k, v []byte) error {
+		// Nested buckets, which hold the indexes, have nil values.
+		if v == nil {
+			return nil
+		}
+
+		var user map[string]json.RawMessage
+		if err := json.Unmarshal(v, &user); err != nil {
+			return err
+		}
+
+		changed := false
+		for name, value := range fields {
+			if _, ok := user[name]; !ok && !userIdentityFields[name] {
+				user[name] = value
+				changed = true
+			}
+		}
+
+		if !changed {
+			return nil
+		}
+
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		records[string(k)] = data
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// The bucket can't be changed while it is iterated.
+	for k, v := range records {
+		if err = b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
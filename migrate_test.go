@@ -0,0 +1,135 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// removeUserFields deletes fields from the record of a user, as if it was
+// saved by a version which didn't have them.
+func removeUserFields(t *testing.T, db *storm.DB, username string, fields ...string) {
+	err := db.Bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("User"))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var user map[string]json.RawMessage
+			if v == nil || json.Unmarshal(v, &user) != nil || string(user["username"]) != `"`+username+`"` {
+				continue
+			}
+
+			for _, field := range fields {
+				delete(user, field)
+			}
+
+			data, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+
+			return b.Put(k, data)
+		}
+
+		return storm.ErrNotFound
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	// Creates a database like the ones of the versions before the schema
+	// version was saved, whose users don't have the newer fields.
+	database := filepath.Join(temp, "database.db")
+	db, err := storm.Open(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := &User{
+		Username:      "legacy",
+		Password:      "hash",
+		Admin:         true,
+		AllowNew:      true,
+		AllowCommands: true,
+		FileSystem:    "scope",
+		UploadPolicy:  UploadPolicy{Deny: []string{".exe"}},
+	}
+
+	if err = db.Save(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	removeUserFields(t, db, "legacy", "allowEdit", "allowPublish", "uploadPolicy")
+	db.Close()
+
+	defaults := &User{
+		AllowEdit:    true,
+		AllowNew:     false,
+		UploadPolicy: UploadPolicy{Allow: []string{".txt"}},
+	}
+
+	fm, err := NewWithOptions(database, DefaultUser, DatabaseOptions{UserDefaults: defaults})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := fm.Users["legacy"]
+	if u == nil {
+		t.Fatal("The user of the old database wasn't loaded")
+	}
+
+	if !u.AllowEdit || u.AllowPublish {
+		t.Errorf("Missing permissions weren't set to the defaults: edit %v, publish %v", u.AllowEdit, u.AllowPublish)
+	}
+
+	if !u.AllowNew || !u.AllowCommands || !u.Admin || u.Password != "hash" {
+		t.Errorf("Existing fields were changed: %+v", u)
+	}
+
+	if len(u.UploadPolicy.Allow) != 1 || len(u.UploadPolicy.Deny) != 0 {
+		t.Errorf("Expected the default upload policy, got %+v", u.UploadPolicy)
+	}
+
+	var version int
+	if err = fm.db.Get("config", "schemaVersion", &version); err != nil || version != schemaVersion {
+		t.Errorf("Expected schema version %d, got %d: %v", schemaVersion, version, err)
+	}
+
+	// The migration only runs once.
+	removeUserFields(t, fm.db, "legacy", "allowEdit")
+	fm.db.Close()
+
+	fm, err = NewWithOptions(database, DefaultUser, DatabaseOptions{UserDefaults: defaults})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm.db.Close()
+
+	if fm.Users["legacy"].AllowEdit {
+		t.Error("The migration ran again on an upgraded database")
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	var version int
+	if err := fm.db.Get("config", "schemaVersion", &version); err != nil || version != schemaVersion {
+		t.Errorf("Expected new databases at schema version %d, got %d: %v", schemaVersion, version, err)
+	}
+}
@@ -0,0 +1,346 @@
+package filemanager
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// movedPath returns the new path of p after src was moved to dst. It
+// returns false if p is neither src nor one of its descendants.
+func movedPath(p, src, dst string) (string, bool) {
+	if p == src {
+		return dst, true
+	}
+
+	prefix := strings.TrimSuffix(src, string(filepath.Separator)) + string(filepath.Separator)
+	if strings.HasPrefix(p, prefix) {
+		return filepath.Join(dst, strings.TrimPrefix(p, prefix)), true
+	}
+
+	return "", false
+}
+
+// moveMetadata makes the metadata of the file at src, and of all its
+// descendants if it is a directory, follow them to dst: the shares and
+// the cached checksums and thumbnails. Both paths are absolute.
+func (m *FileManager) moveMetadata(src, dst string) error {
+	m.checksums.move(src, dst)
+	m.thumbnails.move(src, dst)
+
+	var links []*shareLink
+	if err := m.db.All(&links); err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		path, ok := movedPath(link.Path, src, dst)
+		if !ok {
+			continue
+		}
+
+		link.Path = path
+		if err := m.db.Save(link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bulkJobThreshold is the number of items above which a bulk move or copy
+// runs in the background as a job.
+const bulkJobThreshold = 50
+
+var (
+	errBulkEmpty  = errors.New("there are no items to move or copy")
+	errBulkTarget = errors.New("the destination must be a directory")
+	errBulkInside = errors.New("a directory can't be moved or copied into itself")
+	errBulkSame   = errors.New("there are many items with the same name")
+	errBulkKind   = errors.New("a file and a directory can't replace each other")
+)
+
+// bulkRequest is the body of a bulk move or copy. Items are the paths
+// of the files and directories to move or copy into the destination.
+// Override replaces the existing files with the same names, which are
+// otherwise left as they are. Replacing a directory bigger than the
+// delete threshold needs the token of a delete dry-run of it in Confirm,
+// which is indexed by the path of the directory.
+type bulkRequest struct {
+	Items    []string          `json:"items"`
+	Copy     bool              `json:"copy"`
+	Override bool              `json:"override"`
+	Confirm  map[string]string `json:"confirm"`
+}
+
+// bulkResult is the result of moving or copying one of the items.
+type bulkResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// resourceBulkMove moves, or copies, the items in the body into the
+// directory in the URL and replies with the result of each of them. If
+// there are many, they are moved in the background and the response is
+// the job, which has the results once it finishes.
+func resourceBulkMove(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	info, err := c.User.FileSystem.Stat(r.URL.Path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if !info.IsDir() {
+		return http.StatusBadRequest, errBulkTarget
+	}
+
+	var req bulkRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if len(req.Items) == 0 {
+		return http.StatusBadRequest, errBulkEmpty
+	}
+
+	// The items end up in the same directory, so one would replace the
+	// other.
+	names := map[string]bool{}
+	for _, item := range req.Items {
+		name := path.Base(path.Clean("/" + item))
+		if names[name] {
+			return http.StatusBadRequest, errBulkSame
+		}
+
+		names[name] = true
+	}
+
+	if len(req.Items) <= bulkJobThreshold {
+		return renderJSON(w, c.bulkMove(c.User, r.URL.Path, req))
+	}
+
+	bytes, err := generateRandomBytes(16)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	j := &job{
+		ID:       hex.EncodeToString(bytes),
+		Action:   "move",
+		Path:     r.URL.Path,
+		Username: c.User.Username,
+		Status:   "running",
+		Started:  time.Now(),
+	}
+
+	if req.Copy {
+		j.Action = "copy"
+	}
+
+	// Shutting down waits for the jobs in progress.
+	if !c.shutdown.begin() {
+		return rejectShutdown(w)
+	}
+
+	c.jobs.add(j)
+	c.saveJob(j)
+
+	go func(u *User, dir string) {
+		defer c.shutdown.end()
+
+		results := c.bulkMove(u, dir, req)
+		now := time.Now()
+
+		j.Lock()
+		j.Finished = &now
+		j.Status = "done"
+		j.Results = results
+		j.Unlock()
+
+		c.saveJob(j)
+	}(c.User, r.URL.Path)
+
+	w.Header().Set("Location", c.RootURL()+"/api/jobs/"+j.ID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	return renderJSON(w, j.snapshot())
+}
+
+// bulkMove moves, or copies, the items into the directory of the user and
+// returns the result of each of them. A failed item doesn't stop the
+// others.
+func (m *FileManager) bulkMove(u *User, dir string, req bulkRequest) map[string]*bulkResult {
+	results := map[string]*bulkResult{}
+
+	for _, item := range req.Items {
+		src := path.Clean("/" + item)
+		dst := path.Join(dir, path.Base(src))
+
+		code, err := m.moveItem(u, src, dst, req.Copy, req.Override, req.Confirm[dst])
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		res := &bulkResult{Status: code}
+		if err != nil {
+			res.Error = err.Error()
+		} else if code >= 400 {
+			res.Error = http.StatusText(code)
+		}
+
+		results[item] = res
+	}
+
+	return results
+}
+
+// moveItem moves, or copies, a file or directory of the user from src to
+// dst, which are virtual paths. The metadata of the moved files follows
+// them. If override is set, an existing dst of the same kind is replaced,
+// but only once the item is in place, so a failure leaves it untouched.
+// Confirm is the delete token needed to replace a big directory.
+func (m *FileManager) moveItem(u *User, src, dst string, copy, override bool, confirm string) (int, error) {
+	if src == "/" || !u.Allowed(src) || !u.Allowed(dst) {
+		return http.StatusForbidden, nil
+	}
+
+	if dst == src {
+		return http.StatusConflict, os.ErrExist
+	}
+
+	if strings.HasPrefix(dst, src+"/") {
+		return http.StatusConflict, errBulkInside
+	}
+
+	info, err := u.FileSystem.Stat(src)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if err = m.checkUploadPath(u, newPath(dst, info)); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err = m.checkCaseCollision(u, dst, ""); err != nil {
+		return http.StatusConflict, err
+	}
+
+	replaced, err := u.FileSystem.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return errorToHTTP(err, false), err
+	}
+
+	if replaced != nil {
+		if code, err := m.checkReplace(u, dst, info, replaced, override, confirm); err != nil {
+			return code, err
+		}
+	}
+
+	from := src
+	if copy {
+		// The copy is made under a temporary name so an incomplete one
+		// never takes the place of the destination.
+		if from, err = tempSibling(dst); err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		if err = u.FileSystem.Copy(src, from); err != nil {
+			u.FileSystem.RemoveAll(from)
+			return errorToHTTP(err, false), err
+		}
+	}
+
+	if err = replaceItem(u, from, dst, replaced != nil); err != nil {
+		if copy {
+			u.FileSystem.RemoveAll(from)
+		}
+
+		return errorToHTTP(err, false), err
+	}
+
+	if copy {
+		return 0, nil
+	}
+
+	scope := string(u.FileSystem)
+	if err := m.moveMetadata(filepath.Join(scope, src), filepath.Join(scope, dst)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// checkReplace checks if the item src, whose info is given, can replace
+// the existing dst. A file and a directory never replace each other and
+// the directories bigger than the delete threshold need the token of a
+// delete dry-run.
+func (m *FileManager) checkReplace(u *User, dst string, src, replaced os.FileInfo, override bool, confirm string) (int, error) {
+	if !override {
+		return http.StatusConflict, os.ErrExist
+	}
+
+	if src.IsDir() != replaced.IsDir() {
+		return http.StatusConflict, errBulkKind
+	}
+
+	if !replaced.IsDir() || m.DeleteConfirmThreshold <= 0 {
+		return 0, nil
+	}
+
+	entries, truncated, err := m.countEntries(u, dst)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if (truncated || entries > m.DeleteConfirmThreshold) && !m.checkDeleteToken(u, dst, confirm) {
+		return http.StatusConflict, errDeleteConfirm
+	}
+
+	return 0, nil
+}
+
+// replaceItem renames src to dst. If replace is set, the existing dst is
+// first put aside and only removed once src is in its place, otherwise
+// it's restored.
+func replaceItem(u *User, src, dst string, replace bool) error {
+	if !replace {
+		return u.FileSystem.Rename(src, dst)
+	}
+
+	old, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+
+	if err = u.FileSystem.Rename(dst, old); err != nil {
+		return err
+	}
+
+	if err = u.FileSystem.Rename(src, dst); err != nil {
+		u.FileSystem.Rename(old, dst)
+		return err
+	}
+
+	if err = u.FileSystem.RemoveAll(old); err != nil {
+		log.Printf("[WARNING] Couldn't remove the replaced %s: %v", old, err)
+	}
+
+	return nil
+}
+
+// tempSibling returns a hidden and unused name in the directory of the
+// virtual path p.
+func tempSibling(p string) (string, error) {
+	bytes, err := generateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(path.Dir(p), partialPrefix+hex.EncodeToString(bytes)), nil
+}
@@ -0,0 +1,417 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveMetadata(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := os.MkdirAll(filepath.Join(scope, "dir", "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"file.txt", "dir/sub/nested.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := []*shareLink{
+		{Hash: "file", Path: filepath.Join(scope, "file.txt")},
+		{Hash: "dir", Path: filepath.Join(scope, "dir")},
+		{Hash: "nested", Path: filepath.Join(scope, "dir", "sub", "nested.txt")},
+		{Hash: "sibling", Path: filepath.Join(scope, "dir2")},
+	}
+
+	for _, link := range links {
+		if err := fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Fills the checksum cache for the nested file.
+	info, err := os.Stat(filepath.Join(scope, "dir", "sub", "nested.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested := &file{
+		Path:    filepath.Join(scope, "dir", "sub", "nested.txt"),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if _, err = fm.checksums.get(nested, "md5"); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	move := func(src, dst string) {
+		r, err := http.NewRequest("PATCH", "/api/resource"+src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Destination", dst)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Couldn't move %s to %s: got %v", src, dst, w.Code)
+		}
+	}
+
+	move("/file.txt", "/renamed.txt")
+	move("/dir", "/moved")
+
+	want := map[string]string{
+		"file":    filepath.Join(scope, "renamed.txt"),
+		"dir":     filepath.Join(scope, "moved"),
+		"nested":  filepath.Join(scope, "moved", "sub", "nested.txt"),
+		"sibling": filepath.Join(scope, "dir2"),
+	}
+
+	for hash, path := range want {
+		var link shareLink
+		if err := fm.db.One("Hash", hash, &link); err != nil {
+			t.Fatal(err)
+		}
+
+		if link.Path != path {
+			t.Errorf("Share %s: got path %s, want %s", hash, link.Path, path)
+		}
+	}
+
+	nested.Path = filepath.Join(scope, "moved", "sub", "nested.txt")
+	key := checksumKey{algo: "md5", path: nested.Path, modTime: nested.ModTime, size: nested.Size}
+
+	if _, ok := fm.checksums.items[key]; !ok {
+		t.Errorf("The cached checksum didn't follow the file")
+	}
+}
+
+func TestShareFolder(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, name := range []string{"copied.txt", "moved.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func(name, action string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("PATCH", "/api/resource/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Action", action)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := share("copied.txt", "share"); w.Code != http.StatusNotImplemented {
+		t.Errorf("Without a share folder: got %v", w.Code)
+	}
+
+	fm.ShareFolder = "/public"
+
+	tests := []struct {
+		name   string
+		action string
+		kept   bool
+	}{
+		{"copied.txt", "share", true},
+		{"moved.txt", "share-move", false},
+	}
+
+	for _, test := range tests {
+		w := share(test.name, test.action)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %v", test.name, w.Code)
+		}
+
+		var link shareLink
+		if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+			t.Fatal(err)
+		}
+
+		want := filepath.Join(scope, "public", test.name)
+		if link.Path != want || link.Hash == "" {
+			t.Errorf("%s: got share of %s", test.name, link.Path)
+		}
+
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(scope, test.name)); (err == nil) != test.kept {
+			t.Errorf("%s: got error %v for the original", test.name, err)
+		}
+
+		var saved shareLink
+		if err := fm.db.One("Hash", link.Hash, &saved); err != nil {
+			t.Errorf("%s: the share wasn't saved: %v", test.name, err)
+		}
+	}
+
+	// The files in the folder aren't overwritten.
+	if w := share("copied.txt", "share"); w.Code != http.StatusConflict {
+		t.Errorf("Existing file: got %v want %v", w.Code, http.StatusConflict)
+	}
+
+	// The copies in the folder must be allowed by the policies.
+	fm.Ignore = []string{"public"}
+	if w := share("copied.txt", "share"); w.Code != http.StatusBadRequest {
+		t.Errorf("Ignored share folder: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+
+	fm.Ignore = nil
+	fm.MaxShares = 2
+	if err := ioutil.WriteFile(filepath.Join(scope, "other.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := share("other.txt", "share"); w.Code != http.StatusForbidden {
+		t.Errorf("Over the limit: got %v want %v", w.Code, http.StatusForbidden)
+	}
+
+	if _, err := os.Stat(filepath.Join(scope, "public", "other.txt")); !os.IsNotExist(err) {
+		t.Errorf("The file was copied over the limit: %v", err)
+	}
+}
+
+func TestBulkMove(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := os.MkdirAll(filepath.Join(scope, "dest"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "dest/b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	bulk := func(req bulkRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := http.NewRequest("PATCH", "/api/resource/dest/", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Action", "bulk")
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := bulk(bulkRequest{Items: []string{"/a.txt", "/b.txt", "/missing.txt", "/dest"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Bulk move: got %v", w.Code)
+	}
+
+	results := map[string]*bulkResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"/a.txt":       http.StatusOK,
+		"/b.txt":       http.StatusConflict,
+		"/missing.txt": http.StatusNotFound,
+		"/dest":        http.StatusConflict,
+	}
+
+	for item, code := range want {
+		if res := results[item]; res == nil || res.Status != code {
+			t.Errorf("%s: got %+v want %v", item, res, code)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(scope, "dest", "a.txt")); err != nil {
+		t.Errorf("The file wasn't moved: %v", err)
+	}
+
+	// The existing files are only replaced if asked to.
+	w = bulk(bulkRequest{Items: []string{"/b.txt"}, Copy: true, Override: true})
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := results["/b.txt"]; res == nil || res.Status != http.StatusOK {
+		t.Errorf("Override: got %+v", res)
+	}
+
+	if content, _ := ioutil.ReadFile(filepath.Join(scope, "dest", "b.txt")); string(content) != "b.txt" {
+		t.Errorf("Override: got content %q", content)
+	}
+
+	if _, err := os.Stat(filepath.Join(scope, "b.txt")); err != nil {
+		t.Errorf("The copied file was removed: %v", err)
+	}
+
+	// The items with the same name would replace each other.
+	if w = bulk(bulkRequest{Items: []string{"/a.txt", "/dest/a.txt"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("Same names: got %v", w.Code)
+	}
+
+	// A file never replaces a directory.
+	for _, name := range []string{"dir/x", "dir/y", "dest/dir/old", "dest/dir/sub/old"} {
+		if err := os.MkdirAll(filepath.Join(scope, filepath.Dir(name)), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(scope, "c.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(scope, "dest", "c.txt"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	w = bulk(bulkRequest{Items: []string{"/c.txt"}, Override: true})
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := results["/c.txt"]; res == nil || res.Status != http.StatusConflict {
+		t.Errorf("File over a directory: got %+v", res)
+	}
+
+	// Replacing a big directory must be confirmed.
+	fm.DeleteConfirmThreshold = 2
+	w = bulk(bulkRequest{Items: []string{"/dir"}, Override: true})
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := results["/dir"]; res == nil || res.Status != http.StatusConflict {
+		t.Errorf("Unconfirmed replace: got %+v", res)
+	}
+
+	if _, err := os.Stat(filepath.Join(scope, "dest", "dir", "sub", "old")); err != nil {
+		t.Errorf("The unconfirmed replace removed the directory: %v", err)
+	}
+
+	r, err := http.NewRequest("DELETE", "/api/resource/dest/dir?dryRun=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	dry := &deleteDryRun{}
+	if err = json.Unmarshal(w.Body.Bytes(), dry); err != nil {
+		t.Fatal(err)
+	}
+
+	w = bulk(bulkRequest{Items: []string{"/dir"}, Override: true, Confirm: map[string]string{"/dest/dir": dry.Token}})
+	if err = json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := results["/dir"]; res == nil || res.Status != http.StatusOK {
+		t.Errorf("Confirmed replace: got %+v", res)
+	}
+
+	if _, err = os.Stat(filepath.Join(scope, "dest", "dir", "old")); !os.IsNotExist(err) {
+		t.Errorf("The replaced directory was kept: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(scope, "dest", "dir", "x")); err != nil {
+		t.Errorf("The directory wasn't moved: %v", err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(scope, "dest", partialPrefix+"*"))
+	if err != nil || len(names) != 0 {
+		t.Errorf("The temporary files were left: %v %v", names, err)
+	}
+
+	fm.DeleteConfirmThreshold = 0
+
+	// Many items are copied in the background.
+	items := []string{}
+	for i := 0; i <= bulkJobThreshold; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		if err = ioutil.WriteFile(filepath.Join(scope, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		items = append(items, "/"+name)
+	}
+
+	w = bulk(bulkRequest{Items: items, Copy: true})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Bulk copy as a job: got %v", w.Code)
+	}
+
+	j := &job{}
+	if err = json.Unmarshal(w.Body.Bytes(), j); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && j.Finished == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+
+		r, err := http.NewRequest("GET", "/api/jobs/"+j.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if err = json.Unmarshal(w.Body.Bytes(), j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if j.Finished == nil || len(j.Results) != len(items) {
+		t.Fatalf("The job didn't finish with the results: %+v", j)
+	}
+
+	for _, item := range items {
+		if res := j.Results[item]; res.Status != http.StatusOK {
+			t.Errorf("%s: got %+v", item, res)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package filemanager
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications it is sent.
+type fakeNotifier chan *ShareNotification
+
+func (f fakeNotifier) Notify(n *ShareNotification) error {
+	f <- n
+	return nil
+}
+
+func TestShareNotifications(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	notifications := make(fakeNotifier, 10)
+	fm.Notifier = notifications
+
+	token := login(t, fm, defaultCredentials)
+
+	r, err := http.NewRequest("POST", "/api/share/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.URL.RawQuery = "expires=2&unit=hours"
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Couldn't create the share: got %v", w.Code)
+	}
+
+	select {
+	case n := <-notifications:
+		if n.Event != ShareCreated || n.Owner == nil || n.Owner.Username != "admin" || n.Path != "/" {
+			t.Errorf("Wrong notification of the new share: %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The new share wasn't notified")
+	}
+
+	// The shares which expire within the notice are notified once.
+	links := []*shareLink{
+		{Hash: "soon", Path: filepath.Join(fm.Temp, "scope"), UserID: 1, Expires: true, ExpireDate: time.Now().Add(time.Hour)},
+		{Hash: "later", Path: filepath.Join(fm.Temp, "scope"), UserID: 1, Expires: true, ExpireDate: time.Now().Add(48 * time.Hour)},
+		{Hash: "permanent", Path: filepath.Join(fm.Temp, "scope"), UserID: 1},
+	}
+
+	for _, link := range links {
+		if err = fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.ShareExpiryNotice = 3 * time.Hour
+	for i := 0; i < 2; i++ {
+		fm.shareCleaner()
+	}
+
+	close(notifications)
+	expiring := []string{}
+	for n := range notifications {
+		if n.Event == ShareExpiring {
+			expiring = append(expiring, strings.TrimPrefix(n.URL, "/share/"))
+		}
+	}
+
+	// The share created by the request expires within the notice too.
+	if len(expiring) != 2 || expiring[0] == "later" || expiring[1] == "later" {
+		t.Errorf("Wrong shares notified before expiring: %v", expiring)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	messages := make(chan string, 1)
+	go fakeSMTP(ln, messages)
+
+	s, err := NewSMTPNotifier("smtp://" + ln.Addr().String() + "?from=files@example.com&to=admin@example.com&site=https://files.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Notify(&ShareNotification{
+		Event: ShareCreated,
+		Owner: &User{Email: "owner@example.com"},
+		Path:  "/report\r\nBcc: evil@example.com.pdf",
+		URL:   "/share/abc",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-messages
+	for _, want := range []string{
+		"RCPT TO:<admin@example.com>",
+		"RCPT TO:<owner@example.com>",
+		"Subject: /reportBcc: evil@example.com.pdf was shared",
+		"https://files.example.com/share/abc",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("The message doesn't contain %q:\n%s", want, msg)
+		}
+	}
+
+	// The subjects with other characters are encoded.
+	go fakeSMTP(ln, messages)
+	if err = s.Notify(&ShareNotification{Event: ShareCreated, Path: "/résumé.pdf", URL: "/share/def"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg = <-messages; !strings.Contains(msg, "Subject: =?utf-8?q?/r=C3=A9sum=C3=A9.pdf_was_shared?=\r\n") {
+		t.Errorf("The subject isn't encoded:\n%s", msg)
+	}
+
+	for _, invalid := range []string{"http://localhost", "smtp://localhost"} {
+		if _, err := NewSMTPNotifier(invalid); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server accepts the connection but never replies.
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	s, err := NewSMTPNotifier("smtp://" + ln.Addr().String() + "?from=files@example.com&to=admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Timeout = 100 * time.Millisecond
+	start := time.Now()
+
+	if err = s.Notify(&ShareNotification{Event: ShareCreated, Path: "/a.txt", URL: "/share/abc"}); err == nil {
+		t.Errorf("Expected a timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("The notification took %v", elapsed)
+	}
+}
+
+// fakeSMTP accepts a connection and replies to the commands of a client
+// sending a message, which it sends to messages with the commands.
+func fakeSMTP(ln net.Listener, messages chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var log bytes.Buffer
+	rd := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ready\r\n"))
+
+	data := false
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		log.WriteString(line)
+		switch {
+		case data:
+			if line == ".\r\n" {
+				data = false
+				conn.Write([]byte("250 queued\r\n"))
+			}
+		case strings.HasPrefix(line, "DATA"):
+			data = true
+			conn.Write([]byte("354 go ahead\r\n"))
+		case strings.HasPrefix(line, "QUIT"):
+			conn.Write([]byte("221 bye\r\n"))
+			messages <- log.String()
+			return
+		default:
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+}
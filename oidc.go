@@ -0,0 +1,610 @@
+package filemanager
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/hacdias/fileutils"
+)
+
+const (
+	// oidcTimeout is the timeout of the requests to the OpenID provider.
+	oidcTimeout = 10 * time.Second
+	// oidcCookieName is the name of the cookie which keeps the state and
+	// the nonce of a login until the provider redirects back.
+	oidcCookieName = "oidc_state"
+	// oidcLoginAge is how long the users have to log in at the provider.
+	oidcLoginAge = 10 * 60
+)
+
+var (
+	errOIDCState    = errors.New("the single sign-on login expired or was started elsewhere")
+	errOIDCToken    = errors.New("invalid ID token")
+	errOIDCUsername = errors.New("the ID token has no valid username")
+	errOIDCLinked   = errors.New("the user exists but isn't linked to this account of the provider")
+	errOIDCScope    = errors.New("the scope of the user is outside of the one of the template")
+	errOIDCDenied   = errors.New("the user isn't allowed to log in")
+	errOIDCProvider = errors.New("invalid response from the OpenID provider")
+)
+
+// OIDC logs the users in through an OpenID Connect provider, using the
+// authorization code flow. The users are matched by the issuer and the
+// subject of their account at the provider, which are stable, so the
+// existing users can only log in with the provider once an admin links
+// their account.
+type OIDC struct {
+	// Issuer is the URL of the provider, such as
+	// https://accounts.example.com, where its configuration is discovered.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL the provider redirects the users to after
+	// they log in, which must be registered at the provider. It is like
+	// https://files.example.com/api/auth/oidc/callback.
+	RedirectURL string
+	// Scopes default to openid, profile and email.
+	Scopes []string
+	// UsernameClaim and GroupsClaim are the claims of the ID token with
+	// the username and the groups of the user. They default to
+	// preferred_username and groups.
+	UsernameClaim string
+	GroupsClaim   string
+	// AdminGroups are the groups whose members are admins. If it is set,
+	// the users who aren't in any of them aren't admins either.
+	AdminGroups []string
+	// AllowedGroups are the groups whose members can log in. Empty means
+	// everyone can.
+	AllowedGroups []string
+	// AutoProvision creates the users of the accounts which aren't linked
+	// yet as a copy of the user in Template, or of the default user if it
+	// is empty, linked to the account. The placeholder {username} in its
+	// scope is replaced by the username. It fails if there is already a
+	// user with the username.
+	AutoProvision bool
+	Template      string
+
+	mu       sync.Mutex
+	provider *oidcProvider
+	keys     map[string]*rsa.PublicKey
+}
+
+// oidcProvider is the configuration of an OpenID provider.
+type oidcProvider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+var oidcClient = &http.Client{Timeout: oidcTimeout}
+
+// getJSON decodes the JSON response of a request to the provider.
+func getJSON(req *http.Request, v interface{}) error {
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.URL, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover returns the configuration of the provider, which is fetched
+// the first time it is needed.
+func (o *OIDC) discover() (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider != nil {
+		return o.provider, nil
+	}
+
+	issuer := strings.TrimSuffix(o.Issuer, "/")
+	req, err := http.NewRequest(http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &oidcProvider{}
+	if err = getJSON(req, p); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(p.Issuer, "/") != issuer || p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		return nil, errOIDCProvider
+	}
+
+	o.provider = p
+	return p, nil
+}
+
+// key returns the public key of the provider with the ID. The keys are
+// fetched again when an unknown one is used, since they're rotated.
+func (o *OIDC) key(p *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	o.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err = getJSON(req, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || k.Kty != "RSA" {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, errOIDCToken
+	}
+
+	return key, nil
+}
+
+// authURL returns the URL of the login at the provider.
+func (o *OIDC) authURL(state, nonce string) (string, error) {
+	p, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.AuthURL + sep + query.Encode(), nil
+}
+
+// exchange exchanges the authorization code for the ID token and returns
+// its claims once it is verified.
+func (o *OIDC) exchange(code, nonce string) (jwt.MapClaims, error) {
+	p, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err = getJSON(req, &res); err != nil {
+		return nil, err
+	}
+
+	if res.IDToken == "" {
+		return nil, errOIDCProvider
+	}
+
+	return o.verify(p, res.IDToken, nonce)
+}
+
+// verify checks the signature and the claims of the ID token.
+func (o *OIDC) verify(p *oidcProvider, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errInvalidSigningMethod
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return o.key(p, kid)
+	})
+
+	if err != nil {
+		return nil, errOIDCToken
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, errOIDCToken
+	}
+
+	if !containsAny(claimStrings(claims, "aud"), []string{o.ClientID}) {
+		return nil, errOIDCToken
+	}
+
+	if n, _ := claims["nonce"].(string); !hmac.Equal([]byte(n), []byte(nonce)) {
+		return nil, errOIDCToken
+	}
+
+	// The tokens must expire and identify the account.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errOIDCToken
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errOIDCToken
+	}
+
+	return claims, nil
+}
+
+// claimStrings returns the claim as a list of strings, which may also be
+// a single string.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// containsAny tells if any of the values is one of the wanted ones.
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// oidcLoginHandler starts the login at the OpenID provider. The state and
+// the nonce are kept in a cookie to check the response of the provider.
+func oidcLoginHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	if c.OIDC == nil || c.NoAuth {
+		return http.StatusNotFound, nil
+	}
+
+	random, err := generateRandomBytes(32)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	state, nonce := hex.EncodeToString(random[:16]), hex.EncodeToString(random[16:])
+	target, err := c.OIDC.authURL(state, nonce)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+
+	http.SetCookie(w, oidcCookie(c, state+"."+nonce, oidcLoginAge))
+	http.Redirect(w, r, target, http.StatusFound)
+	return 0, nil
+}
+
+// oidcCallbackHandler finishes the login once the provider redirects the
+// user back. The token is given to the front-end in the cookie it uses
+// to keep the sessions, as if the user logged in with a password.
+func oidcCallbackHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	if c.OIDC == nil || c.NoAuth {
+		return http.StatusNotFound, nil
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	http.SetCookie(w, oidcCookie(c, "", -1))
+	if err != nil {
+		return http.StatusForbidden, errOIDCState
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	query := r.URL.Query()
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[0]), []byte(query.Get("state"))) {
+		return http.StatusForbidden, errOIDCState
+	}
+
+	if e := query.Get("error"); e != "" {
+		return http.StatusForbidden, fmt.Errorf("%s: %s", e, query.Get("error_description"))
+	}
+
+	claims, err := c.OIDC.exchange(query.Get("code"), parts[1])
+	if err == errOIDCToken {
+		return http.StatusForbidden, err
+	}
+
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+
+	u, code, err := c.oidcUser(claims)
+	if err != nil {
+		return code, err
+	}
+
+	c.User = u
+	token, err := issueToken(c)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if c.AuthCookie {
+		http.SetCookie(w, authCookie(c, token, 24*60*60))
+	}
+
+	path := c.RootURL()
+	if path == "" {
+		path = "/"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth",
+		Value:    token,
+		Path:     path,
+		MaxAge:   24 * 60 * 60,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, c.RootURL()+"/files"+c.landingPath(u), http.StatusFound)
+	return 0, nil
+}
+
+// oidcCookie returns the cookie with the state of the login. It must be
+// sent when the provider redirects back, so it can't be strict. It's only
+// secure if the provider redirects back over HTTPS, so the deployments
+// on plain HTTP work too.
+func oidcCookie(c *RequestContext, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     c.RootURL() + "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(c.OIDC.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// oidcUser returns the user linked to the account of the ID token,
+// creating it if needed. The username of the token is only used for the
+// new users, since it isn't unique nor stable. The admin permission
+// follows the groups of the user if AdminGroups is set.
+func (m *FileManager) oidcUser(claims jwt.MapClaims) (*User, int, error) {
+	o := m.OIDC
+
+	usernameClaim := o.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+
+	groupsClaim := o.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	groups := claimStrings(claims, groupsClaim)
+	if len(o.AllowedGroups) > 0 && !containsAny(groups, o.AllowedGroups) {
+		return nil, http.StatusForbidden, errOIDCDenied
+	}
+
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+
+	u := m.oidcLinkedUser(issuer, subject)
+	if u == nil {
+		if !o.AutoProvision {
+			return nil, http.StatusForbidden, errOIDCDenied
+		}
+
+		username, _ := claims[usernameClaim].(string)
+		if !validOIDCUsername(username) {
+			return nil, http.StatusForbidden, errOIDCUsername
+		}
+
+		// The existing users must be linked explicitly, or anyone who
+		// can choose their username at the provider could log in as them.
+		if _, ok := m.Users[username]; ok {
+			return nil, http.StatusForbidden, errOIDCLinked
+		}
+
+		email, _ := claims["email"].(string)
+		code, err := m.provisionOIDCUser(username, email, issuer, subject)
+		if err != nil {
+			return nil, code, err
+		}
+
+		u = m.Users[username]
+	}
+
+	if len(o.AdminGroups) == 0 {
+		return u, 0, nil
+	}
+
+	if admin := containsAny(groups, o.AdminGroups); admin != u.Admin {
+		if err := m.db.UpdateField(&User{ID: u.ID}, "Admin", admin); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		u.Admin = admin
+	}
+
+	return u, 0, nil
+}
+
+// oidcLinkedUser returns the user linked to the account of the provider
+// with the subject, if there is one.
+func (m *FileManager) oidcLinkedUser(issuer, subject string) *User {
+	for _, u := range m.Users {
+		if u.OIDCSubject != "" && u.OIDCIssuer == issuer && u.OIDCSubject == subject {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// validOIDCUsername checks if the username of an account of the provider
+// can be used for a new user and in the path of its scope.
+func validOIDCUsername(username string) bool {
+	return username != "" && username != "." && !strings.Contains(username, "..") &&
+		!strings.ContainsAny(username, "/\\\x00")
+}
+
+// oidcScope returns the scope of a new user from the one of the template.
+// It must be under the directory of the template where the placeholder
+// is, so the username can't make it point elsewhere.
+func oidcScope(template, username string) (string, error) {
+	if !strings.Contains(template, "{username}") {
+		return template, nil
+	}
+
+	parent := filepath.Dir(strings.SplitN(template, "{username}", 2)[0] + "_")
+	scope := filepath.Clean(strings.Replace(template, "{username}", username, -1))
+
+	if !strings.HasPrefix(scope, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", errOIDCScope
+	}
+
+	return scope, nil
+}
+
+// provisionOIDCUser creates the user as a copy of the template, linked to
+// the account of the provider. Its password is random, so it can only
+// log in through the provider until an admin changes it.
+func (m *FileManager) provisionOIDCUser(username, email, issuer, subject string) (int, error) {
+	if enabled, _ := m.ReadOnlyMode(); enabled || m.DatabaseOptions.ReadOnly {
+		return http.StatusServiceUnavailable, errReadOnlyMode
+	}
+
+	template := m.DefaultUser
+	if m.OIDC.Template != "" {
+		t, ok := m.Users[m.OIDC.Template]
+		if !ok {
+			return http.StatusInternalServerError, fmt.Errorf("the template user %s doesn't exist", m.OIDC.Template)
+		}
+
+		template = t
+	}
+
+	scope, err := oidcScope(string(template.FileSystem), username)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+
+	u := *template
+	u.ID = 0
+	u.Username = username
+	u.Email = email
+	u.OIDCIssuer = issuer
+	u.OIDCSubject = subject
+	u.FileSystem = fileutils.Dir(scope)
+	u.Rules = append([]*Rule{}, template.Rules...)
+	u.Commands = append([]string{}, template.Commands...)
+
+	if code, err := m.provisionHome(&u); err != nil {
+		return code, err
+	}
+
+	random, err := generateRandomBytes(32)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if u.Password, err = m.hashPassword(hex.EncodeToString(random)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err = m.db.Save(&u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	m.Users[u.Username] = &u
+
+	if m.changes != nil {
+		if err := m.changes.watch(string(u.FileSystem)); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return 0, nil
+}
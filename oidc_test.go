@@ -0,0 +1,290 @@
+package filemanager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/hacdias/fileutils"
+)
+
+// fakeProvider is an OpenID provider which issues the ID tokens with the
+// claims, plus the nonce of the last login.
+type fakeProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	nonce  string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "files" || secret != "secret" || r.FormValue("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   p.URL,
+			"sub":   "alice-id",
+			"aud":   "files",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": p.nonce,
+		}
+
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	p := newFakeProvider(t)
+	defer p.Close()
+
+	fm.OIDC = &OIDC{
+		Issuer:       p.URL,
+		ClientID:     "files",
+		ClientSecret: "secret",
+		RedirectURL:  "https://files.example.com/api/auth/oidc/callback",
+		AdminGroups:  []string{"admins"},
+	}
+
+	fm.DefaultUser.FileSystem = fileutils.Dir(filepath.Join(fm.Temp, "homes", "{username}"))
+
+	// login starts a login and follows it back to the callback with the
+	// code, as the browser would.
+	login := func(state string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/auth/oidc", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("Starting the login: got %v", w.Code)
+		}
+
+		target, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || !strings.HasPrefix(target.String(), p.URL+"/authorize?") {
+			t.Fatalf("Got the location %s", target)
+		}
+
+		query := target.Query()
+		if query.Get("client_id") != "files" || query.Get("redirect_uri") != fm.OIDC.RedirectURL {
+			t.Errorf("Got the query %v", query)
+		}
+
+		p.nonce = query.Get("nonce")
+		if state == "" {
+			state = query.Get("state")
+		}
+
+		r, err = http.NewRequest("GET", "/api/auth/oidc/callback?code=code&state="+state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+
+		w = httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	// The state must match the one of the cookie.
+	p.claims = jwt.MapClaims{"preferred_username": "admin"}
+	if w := login("forged"); w.Code != http.StatusForbidden {
+		t.Errorf("Forged state: got %v", w.Code)
+	}
+
+	// The unknown users can't log in unless they're provisioned.
+	p.claims = jwt.MapClaims{"preferred_username": "alice", "groups": []string{"staff"}}
+	if w := login(""); w.Code != http.StatusForbidden {
+		t.Errorf("Unknown user: got %v", w.Code)
+	}
+
+	fm.OIDC.AutoProvision = true
+
+	// The existing users, even with the same username, can't log in with
+	// the accounts which aren't linked to them.
+	p.claims = jwt.MapClaims{"preferred_username": "admin", "sub": "mallory-id"}
+	if w := login(""); w.Code != http.StatusForbidden || fm.Users["admin"].OIDCSubject != "" {
+		t.Errorf("Unlinked existing user: got %v", w.Code)
+	}
+
+	// The usernames can't change the directory of the scope.
+	for _, name := range []string{"../..", "..", "a/b", ""} {
+		p.claims = jwt.MapClaims{"preferred_username": name, "sub": "mallory-id"}
+		if w := login(""); w.Code != http.StatusForbidden {
+			t.Errorf("Username %q: got %v", name, w.Code)
+		}
+	}
+
+	// The tokens must expire.
+	p.claims = jwt.MapClaims{"preferred_username": "alice", "exp": nil}
+	if w := login(""); w.Code != http.StatusForbidden {
+		t.Errorf("Token without expiry: got %v", w.Code)
+	}
+
+	p.claims = jwt.MapClaims{"preferred_username": "alice", "groups": []string{"staff"}}
+	w := login("")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/files/" {
+		t.Fatalf("Provisioning: got %v to %s", w.Code, w.Header().Get("Location"))
+	}
+
+	var token string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "auth" {
+			token = cookie.Value
+		}
+	}
+
+	u, ok := fm.Users["alice"]
+	if !ok || u.Admin || string(u.FileSystem) != filepath.Join(fm.Temp, "homes", "alice") {
+		t.Fatalf("Got the user %+v", u)
+	}
+
+	if u.OIDCIssuer != p.URL || u.OIDCSubject != "alice-id" {
+		t.Errorf("The user isn't linked to the account: got %q and %q", u.OIDCIssuer, u.OIDCSubject)
+	}
+
+	r, err := http.NewRequest("GET", "/api/me/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Using the token: got %v", w.Code)
+	}
+
+	// The admin permission follows the groups. The username of the
+	// account can change since the user is linked to its subject.
+	p.claims = jwt.MapClaims{"preferred_username": "alice2", "groups": []string{"staff", "admins"}}
+	if w := login(""); w.Code != http.StatusFound || !fm.Users["alice"].Admin {
+		t.Errorf("Admin group: got %v and admin %v", w.Code, fm.Users["alice"].Admin)
+	}
+
+	if _, ok := fm.Users["alice2"]; ok {
+		t.Error("A new user was created for a linked account")
+	}
+
+	// The existing users log in once they're linked.
+	fm.Users["admin"].OIDCIssuer = p.URL
+	fm.Users["admin"].OIDCSubject = "admin-id"
+	p.claims = jwt.MapClaims{"preferred_username": "root", "sub": "admin-id", "groups": []string{"admins"}}
+	if w := login(""); w.Code != http.StatusFound {
+		t.Errorf("Linked existing user: got %v", w.Code)
+	}
+
+	fm.OIDC.AllowedGroups = []string{"files"}
+	if w := login(""); w.Code != http.StatusForbidden {
+		t.Errorf("Not in the allowed groups: got %v", w.Code)
+	}
+
+	// The tokens of other clients aren't accepted.
+	fm.OIDC.AllowedGroups = nil
+	p.claims = jwt.MapClaims{"preferred_username": "alice", "aud": "other"}
+	if w := login(""); w.Code != http.StatusForbidden {
+		t.Errorf("Other audience: got %v", w.Code)
+	}
+}
+
+func TestOIDCScope(t *testing.T) {
+	sep := string(filepath.Separator)
+	homes := sep + filepath.Join("srv", "homes")
+
+	tests := []struct {
+		template, username, want string
+		err                      error
+	}{
+		{homes + sep + "{username}", "alice", homes + sep + "alice", nil},
+		{homes + sep + "u-{username}" + sep + "files", "alice", filepath.Join(homes, "u-alice", "files"), nil},
+		{homes + sep + "{username}", "..", "", errOIDCScope},
+		{homes + sep + "{username}" + sep + ".." + sep + "..", "alice", "", errOIDCScope},
+		{homes, "alice", homes, nil},
+	}
+
+	for _, test := range tests {
+		got, err := oidcScope(test.template, test.username)
+		if got != test.want || err != test.err {
+			t.Errorf("Scope of %q from %q: got %q and %v, want %q and %v", test.username, test.template, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestOIDCCookie(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	c := &RequestContext{FileManager: fm.FileManager}
+	for url, secure := range map[string]bool{
+		"https://files.example.com/api/auth/oidc/callback": true,
+		"http://localhost:8080/api/auth/oidc/callback":     false,
+	} {
+		fm.OIDC = &OIDC{RedirectURL: url}
+		if cookie := oidcCookie(c, "state", 60); cookie.Secure != secure {
+			t.Errorf("Cookie with the redirect URL %s: got secure %v", url, cookie.Secure)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package filemanager
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the OpenAPI specification followed by
+// the description of the API.
+const openAPIVersion = "3.0.3"
+
+// apiParam is a query parameter of a route.
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiDoc documents a route of the API. The routes and their methods are
+// taken from apiMethods and the schemas from the types the handlers
+// return, so only the descriptions are written by hand.
+type apiDoc struct {
+	Summary string
+	// Path tells if the route takes a path after its name, such as
+	// /api/resource/{path}.
+	Path bool
+	// Params are the query parameters of the route.
+	Params []apiParam
+	// Responses are values of the types returned as JSON by each method.
+	Responses map[string]interface{}
+}
+
+// apiDocs documents every route in apiMethods.
+var apiDocs = map[string]apiDoc{
+	"download": {
+		Summary: "Downloads a file, or a directory as an archive",
+		Path:    true,
+		Params: []apiParam{
+			{"format", "Format of the archive of a directory, if it is enabled: 'zip', 'tar', 'targz', 'tarbz2' or 'tarxz'"},
+			{"files", "Comma separated names of the entries of the directory to archive"},
+			{"inline", "Shows the file in the browser if 'true', unless it could run scripts such as HTML"},
+		},
+	},
+	"checksum": {
+		Summary: "Computes the checksum of a file",
+		Path:    true,
+		Params:  []apiParam{{"algo", "Checksum algorithm, such as 'md5' or 'sha256'"}},
+	},
+	"manifest": {
+		Summary: "Downloads the checksums of the files of a directory and its subdirectories, in the format of sha256sum",
+		Path:    true,
+		Params:  []apiParam{{"algo", "Checksum algorithm, 'sha256' by default"}},
+	},
+	"changes": {
+		Summary:   "Lists the changes made to the files after a cursor",
+		Params:    []apiParam{{"since", "Cursor returned by the previous request"}},
+		Responses: map[string]interface{}{http.MethodGet: changesResponse{}},
+	},
+	"link": {
+		Summary: "Creates a signed download link to a file",
+		Path:    true,
+		Params: []apiParam{
+			{"expires", "Positive number of units the link is valid for, up to the maximum link expiry"},
+			{"unit", "Unit of the expiry: 'seconds', 'minutes', 'hours' or 'days'"},
+		},
+		Responses: map[string]interface{}{http.MethodPost: signedLink{}},
+	},
+	"metadata": {
+		Summary: "Lists the metadata of the entries of a directory",
+		Path:    true,
+		Params: []apiParam{
+			{"checksum", "Algorithm of the checksums to include"},
+			{"sniff", "Detects the content types from the files if 'true'"},
+			{"tags", "Includes the tags kept in the extended attributes if 'true'"},
+			{"offset", "Number of entries to skip"},
+			{"limit", "Maximum number of entries"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: metadataResponse{}},
+	},
+	"action": {
+		Summary:   "Runs an action on a file as a background job",
+		Path:      true,
+		Params:    []apiParam{{"name", "Name of the action"}},
+		Responses: map[string]interface{}{http.MethodPost: job{}},
+	},
+	"jobs": {
+		Summary:   "Lists the jobs of the user or gets one by its ID",
+		Path:      true,
+		Responses: map[string]interface{}{http.MethodGet: []job{}},
+	},
+	"command": {
+		Summary: "Runs a command in a directory through a WebSocket",
+		Path:    true,
+	},
+	"search": {
+		Summary: "Searches the files in a directory through a WebSocket",
+		Path:    true,
+		Params:  []apiParam{{"hidden", "Includes the ignored entries if 'true'"}},
+	},
+	"resource": {
+		Summary: "Reads, lists, creates, updates, moves and deletes files",
+		Path:    true,
+		Params: []apiParam{
+			{"action", "Counts the entries of a directory if 'summary' or gets the extended attributes if 'xattrs'"},
+			{"recursive", "Counts the entries of the subdirectories too if 'true'"},
+			{"hidden", "Includes the ignored entries if 'true'"},
+			{"dirsOnly", "Only lists the subdirectories if 'true'"},
+			{"thumbs", "Embeds the thumbnails of the images if 'inline'"},
+			{"hash", "Algorithm of the checksums to embed in the listing"},
+			{"sort", "Sorts the listing by 'name', 'size' or 'modified'"},
+			{"order", "Order of the listing: 'asc' or 'desc'"},
+			{"dryRun", "Only reports what a delete would remove if 'true'"},
+			{"head", "Only previews the first lines of a text file"},
+			{"tail", "Only previews the last lines of a text file"},
+			{"unit", "Unit of the head or the tail: 'lines' or 'bytes'"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: file{}},
+	},
+	"users": {
+		Summary: "Lists, creates, updates and deletes the users",
+		Path:    true,
+		Params: []apiParam{
+			{"q", "Only lists the users whose username contains it"},
+			{"page", "Page of the listing, starting at 1"},
+			{"perPage", "Number of users in each page"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: []User{}},
+	},
+	"me": {
+		Summary:   "Gets the profile and the permissions of the current user",
+		Responses: map[string]interface{}{http.MethodGet: profile{}},
+	},
+	"allowed": {
+		Summary: "Tells if a user can access a path",
+		Params: []apiParam{
+			{"path", "Path to check"},
+			{"user", "ID of the user to check, only for admins"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: permission{}},
+	},
+	"settings": {
+		Summary:   "Gets and updates the settings",
+		Responses: map[string]interface{}{http.MethodGet: settingsGetRequest{}},
+	},
+	"config": {
+		Summary:   "Reports the configuration the server is running with, without the secrets",
+		Responses: map[string]interface{}{http.MethodGet: effectiveConfig{}},
+	},
+	"share": {
+		Summary: "Lists, creates and deletes the shares of a file",
+		Path:    true,
+		Params: []apiParam{
+			{"expires", "Positive number of units the share is valid for"},
+			{"unit", "Unit of the expiry: 'seconds', 'minutes', 'hours' or 'days'"},
+			{"permanent", "Creates a share without expiry if 'true'"},
+			{"cidrs", "Comma separated IP ranges allowed to access the share"},
+			{"rate", "Maximum download speed in bytes per second"},
+			{"browse", "Lets the recipients browse a directory if 'true'"},
+		},
+		Responses: map[string]interface{}{
+			http.MethodGet:  []shareLink{},
+			http.MethodPost: shareLink{},
+		},
+	},
+	"shares": {
+		Summary: "Lists, expires and deletes the shares of every user",
+		Path:    true,
+		Params: []apiParam{
+			{"prefix", "Only includes the shares of the paths with this prefix"},
+			{"status", "Only includes the 'active', 'expired' or 'permanent' shares"},
+			{"hashes", "Comma separated hashes of the shares to include"},
+		},
+		Responses: map[string]interface{}{
+			http.MethodGet:    []shareLink{},
+			http.MethodPost:   []shareLink{},
+			http.MethodDelete: []shareLink{},
+		},
+	},
+	"thumbnail": {
+		Summary: "Gets the thumbnail of an image or generates the ones of a directory as a background job",
+		Path:    true,
+		Params: []apiParam{
+			{"recursive", "Generates the thumbnails of the subdirectories too if 'true'"},
+			{"v", "The ETag of the thumbnail, without the quotes, which lets the browsers cache it"},
+		},
+		Responses: map[string]interface{}{http.MethodPost: job{}},
+	},
+	"tus": {
+		Summary: "Uploads files using the TUS resumable upload protocol",
+		Path:    true,
+	},
+	"backup": {
+		Summary: "Downloads or restores a backup of the database",
+	},
+	"batch": {
+		Summary:   "Runs several requests, given in the body, one after the other",
+		Responses: map[string]interface{}{http.MethodPost: []batchResult{}},
+	},
+	"exif": {
+		Summary:   "Gets the EXIF metadata of an image, such as when and where it was taken",
+		Path:      true,
+		Responses: map[string]interface{}{http.MethodGet: exifData{}},
+	},
+	"history": {
+		Summary: "Lists the commands run by the user, the most recent first",
+		Params: []apiParam{
+			{"user", "ID of the user whose history is listed, only for admins"},
+			{"page", "Page of the history, starting at 1"},
+			{"perPage", "Number of commands in each page"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: []commandRecord{}},
+	},
+	"export": {
+		Summary: "Downloads the data kept about the user: the profile, the shares, the command history and the unfinished uploads",
+		Params: []apiParam{
+			{"user", "ID of the user whose data is exported, only for admins"},
+			{"format", "Format of the export: json, the default, or zip"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: userExport{}},
+	},
+	"recent": {
+		Summary: "Lists the files of a directory and its subdirectories modified recently, the most recent first",
+		Path:    true,
+		Params: []apiParam{
+			{"days", "Number of days in which the files were modified, 7 by default"},
+			{"limit", "Maximum number of files, 100 by default and up to 1000"},
+		},
+		Responses: map[string]interface{}{http.MethodGet: recentResponse{}},
+	},
+	"publish": {
+		Summary:   "Builds the website with the static website generator, without publishing it, to check the build",
+		Responses: map[string]interface{}{http.MethodGet: BuildReport{}},
+	},
+}
+
+// openAPIHandler describes the API using the OpenAPI specification.
+func openAPIHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	return renderJSON(w, c.openAPISpec())
+}
+
+// openAPISpec builds the OpenAPI description of the API.
+func (m *FileManager) openAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{
+		"/auth/get": map[string]interface{}{
+			"post": openAPIOperation("Gets a token with the username and the password in the body", nil, nil, schemas, false),
+		},
+		"/auth/renew": map[string]interface{}{
+			"post": openAPIOperation("Gets a new token for the current user", nil, nil, schemas, true),
+		},
+		"/auth/logout": map[string]interface{}{
+			"post": openAPIOperation("Revokes the current token", nil, nil, schemas, true),
+		},
+		"/auth/introspect": map[string]interface{}{
+			"get": openAPIOperation("Tells when the current token expires", nil, tokenInfo{}, schemas, true),
+		},
+		"/ready": map[string]interface{}{
+			"get": openAPIOperation("Tells if the scopes of every user are usable", nil, readiness{}, schemas, false),
+		},
+		"/openapi.json": map[string]interface{}{
+			"get": openAPIOperation("Describes the API", nil, nil, schemas, false),
+		},
+	}
+
+	if m.OIDC != nil {
+		paths["/auth/oidc"] = map[string]interface{}{
+			"get": openAPIOperation("Starts the login through the OpenID Connect provider", nil, nil, schemas, false),
+		}
+		paths["/auth/oidc/callback"] = map[string]interface{}{
+			"get": openAPIOperation("Logs in with the code of the OpenID Connect provider", nil, nil, schemas, false),
+		}
+	}
+
+	routes := make([]string, 0, len(apiMethods))
+	for route := range apiMethods {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		doc := apiDocs[route]
+		params := []interface{}{}
+
+		path := "/" + route
+		if doc.Path {
+			path += "/{path}"
+			params = append(params, map[string]interface{}{
+				"name":        "path",
+				"in":          "path",
+				"required":    true,
+				"description": "Path relative to the scope of the user, which may contain slashes",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+
+		for _, p := range doc.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+
+		params = append(params, map[string]interface{}{
+			"name":        impersonateHeader,
+			"in":          "header",
+			"description": "Username of the user an administrator makes the request as",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+
+		operations := map[string]interface{}{}
+		for _, method := range apiMethods[route] {
+			operations[strings.ToLower(method)] = openAPIOperation(doc.Summary, params, doc.Responses[method], schemas, true)
+		}
+
+		paths[path] = operations
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "File Manager",
+			"version": "1.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": m.RootURL() + "/api"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+// openAPIOperation describes an operation of the API. If response isn't
+// nil, its type is the schema of the JSON response.
+func openAPIOperation(summary string, params []interface{}, response interface{}, schemas map[string]interface{}, auth bool) map[string]interface{} {
+	ok := map[string]interface{}{"description": "OK"}
+	if response != nil {
+		ok["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": openAPISchema(reflect.TypeOf(response), schemas),
+			},
+		}
+	}
+
+	op := map[string]interface{}{
+		"summary": summary,
+		"responses": map[string]interface{}{
+			"200": ok,
+		},
+	}
+
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if auth {
+		op["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+		op["responses"].(map[string]interface{})["401"] = map[string]interface{}{"description": "Unauthorized"}
+		op["responses"].(map[string]interface{})["403"] = map[string]interface{}{"description": "Forbidden"}
+	}
+
+	return op
+}
+
+// openAPISchema returns the schema of the JSON encoding of the values of
+// type t. The named structs are added to the schemas and referenced.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// Byte slices are encoded in base64.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+
+		return map[string]interface{}{
+			"type":  "array",
+			"items": openAPISchema(t.Elem(), schemas),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": openAPISchema(t.Elem(), schemas),
+		}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+
+		if t.Name() == "" {
+			return openAPIObject(t, schemas)
+		}
+
+		// The schema is reserved before it is built so recursive
+		// types, such as the listings of files, end.
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = openAPIObject(t, schemas)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+// openAPIObject returns the schema of a struct, following the rules of
+// encoding/json for the names of the fields and the embedded structs.
+func openAPIObject(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	openAPIFields(t, properties, schemas)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// openAPIFields adds the schemas of the fields of a struct to properties.
+func openAPIFields(t reflect.Type, properties, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		typ := field.Type
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+
+		// The fields of the embedded structs are promoted.
+		if field.Anonymous && name == "" && typ.Kind() == reflect.Struct {
+			openAPIFields(typ, properties, schemas)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = openAPISchema(field.Type, schemas)
+	}
+}
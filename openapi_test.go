@@ -0,0 +1,69 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAPIDocs(t *testing.T) {
+	for route := range apiMethods {
+		if doc, ok := apiDocs[route]; !ok || doc.Summary == "" {
+			t.Errorf("Route %q isn't documented", route)
+		}
+	}
+
+	for route := range apiDocs {
+		if _, ok := apiMethods[route]; !ok {
+			t.Errorf("Documented route %q doesn't exist", route)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	r, err := http.NewRequest("GET", "/api/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	if err = json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := spec.Paths["/resource/{path}"]["patch"]; !ok {
+		t.Error("PATCH /resource/{path} isn't described")
+	}
+
+	if _, ok := spec.Paths["/me"]["get"]; !ok {
+		t.Error("GET /me isn't described")
+	}
+
+	// The fields of the embedded listing are promoted, like in the JSON.
+	if _, ok := spec.Components.Schemas["file"].Properties["items"]; !ok {
+		t.Error("The schema of the files doesn't have the items of the listing")
+	}
+
+	if _, ok := spec.Components.Schemas["User"].Properties["username"]; !ok {
+		t.Error("The schema of the users doesn't have their username")
+	}
+}
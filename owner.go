@@ -0,0 +1,103 @@
+package filemanager
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// owner is the user and the group which own a file.
+type owner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	// User and Group are the names of the user and of the group, if
+	// they could be looked up.
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// ownerMissTTL is how long the IDs without a name are remembered before
+// they're looked up again, since their user or group may be created later.
+const ownerMissTTL = 5 * time.Minute
+
+// ownerName is the name of a user or of a group in the cache. The IDs
+// without a name expire, the others don't.
+type ownerName struct {
+	name    string
+	expires time.Time
+}
+
+// ownerCache keeps the names of the users and of the groups, so they're
+// only looked up once. The IDs which have no name are kept for a while.
+type ownerCache struct {
+	sync.Mutex
+	users  map[int]ownerName
+	groups map[int]ownerName
+}
+
+// fileOwner returns the owner of the file, or nil if the platform
+// doesn't have owners.
+func (m *FileManager) fileOwner(info os.FileInfo) *owner {
+	uid, gid, ok := fileIDs(info)
+	if !ok {
+		return nil
+	}
+
+	return &owner{
+		UID:   uid,
+		GID:   gid,
+		User:  m.owners.user(uid),
+		Group: m.owners.group(gid),
+	}
+}
+
+// user returns the name of the user with the ID.
+func (c *ownerCache) user(uid int) string {
+	return c.lookup(c.users, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+
+		return u.Username, nil
+	})
+}
+
+// group returns the name of the group with the ID.
+func (c *ownerCache) group(gid int) string {
+	return c.lookup(c.groups, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+
+		return g.Name, nil
+	})
+}
+
+// lookup returns the name of the ID from the cache or, if it isn't there
+// or it had no name a while ago, from find. The lookups, which may ask a
+// directory server, are made without holding the lock.
+func (c *ownerCache) lookup(names map[int]ownerName, id int, find func(string) (string, error)) string {
+	c.Lock()
+	entry, ok := names[id]
+	c.Unlock()
+
+	if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry.name
+	}
+
+	name, err := find(strconv.Itoa(id))
+	entry = ownerName{name: name}
+	if err != nil {
+		entry.expires = time.Now().Add(ownerMissTTL)
+	}
+
+	c.Lock()
+	names[id] = entry
+	c.Unlock()
+
+	return name
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package filemanager
+
+import "os"
+
+// fileIDs tells the files have no owners on this platform, such as on
+// Windows where they have security descriptors instead.
+func fileIDs(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
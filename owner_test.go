@@ -0,0 +1,136 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListingOwners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The files have no owners on Windows")
+	}
+
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "a.txt"), []byte("a"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	type response struct {
+		Owner *owner  `json:"owner"`
+		Items []*file `json:"items"`
+	}
+
+	list := func() *response {
+		r, err := http.NewRequest("GET", "/api/resource/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Listing: got %v", w.Code)
+		}
+
+		res := &response{}
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	res := list()
+	if res.Owner != nil {
+		t.Errorf("Owner of the directory without ShowOwners: got %+v", res.Owner)
+	}
+
+	for _, item := range res.Items {
+		if item.Owner != nil {
+			t.Errorf("Owner of %s without ShowOwners: got %+v", item.Name, item.Owner)
+		}
+	}
+
+	fm.ShowOwners = true
+
+	res = list()
+	if res.Owner == nil || res.Owner.UID != os.Getuid() {
+		t.Errorf("Owner of the directory: got %+v, want the UID %d", res.Owner, os.Getuid())
+	}
+
+	found := false
+	for _, item := range res.Items {
+		if item.Name != "a.txt" {
+			continue
+		}
+
+		found = true
+		if item.Owner == nil {
+			t.Fatal("Owner of a.txt: got none")
+		}
+
+		if item.Owner.UID != os.Getuid() || item.Owner.GID != os.Getgid() {
+			t.Errorf("Owner of a.txt: got %d:%d, want %d:%d", item.Owner.UID, item.Owner.GID, os.Getuid(), os.Getgid())
+		}
+
+		if entry, ok := fm.owners.users[os.Getuid()]; !ok || entry.name != item.Owner.User {
+			t.Errorf("Cached name of the user: got %q, want %q", entry.name, item.Owner.User)
+		}
+	}
+
+	if !found {
+		t.Fatal("a.txt isn't in the listing")
+	}
+}
+
+func TestOwnerCache(t *testing.T) {
+	c := &ownerCache{users: map[int]ownerName{}, groups: map[int]ownerName{}}
+
+	lookups := 0
+	find := func(id string) (string, error) {
+		lookups++
+		if id == "1" {
+			return "alice", nil
+		}
+
+		return "", errors.New("unknown ID")
+	}
+
+	for i := 0; i < 2; i++ {
+		if name := c.lookup(c.users, 1, find); name != "alice" {
+			t.Errorf("Known ID: got %q", name)
+		}
+
+		if name := c.lookup(c.users, 2, find); name != "" {
+			t.Errorf("Unknown ID: got %q", name)
+		}
+	}
+
+	if lookups != 2 {
+		t.Errorf("Got %d lookups, want each ID looked up once", lookups)
+	}
+
+	// The IDs without a name are looked up again after a while.
+	entry := c.users[2]
+	entry.expires = time.Now().Add(-time.Second)
+	c.users[2] = entry
+
+	c.lookup(c.users, 2, find)
+	if lookups != 3 {
+		t.Errorf("Got %d lookups, want the unknown ID looked up again", lookups)
+	}
+}
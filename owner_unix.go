@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package filemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDs returns the IDs of the user and of the group which own the file.
+func fileIDs(info os.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,196 @@
+package filemanager
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The algorithms which can hash the passwords.
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// The parameters of argon2id other than the number of iterations, which is
+// its cost.
+const (
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	defaultArgon2Cost = 3
+	maxArgon2Cost     = 10
+)
+
+var errUnknownHash = errors.New("unknown password hashing algorithm")
+
+// argon2Hash is a password hashed with argon2id, which is encoded like
+// $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, errUnknownHash
+	}
+
+	h := &argon2Hash{}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil || h.time == 0 || h.threads == 0 {
+		return nil, errUnknownHash
+	}
+
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errUnknownHash
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, errUnknownHash
+	}
+
+	return h, nil
+}
+
+func (h *argon2Hash) String() string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		HashArgon2id, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key))
+}
+
+// hashPassword hashes a password with the algorithm and the cost, which is
+// the cost of bcrypt or the number of iterations of argon2id. Empty and
+// zero mean bcrypt with its default cost.
+func hashPassword(password, algorithm string, cost int) (string, error) {
+	cost = passwordCost(algorithm, cost)
+
+	switch algorithm {
+	case "", HashBcrypt:
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		return string(bytes), err
+	case HashArgon2id:
+		salt, err := generateRandomBytes(argon2SaltLen)
+		if err != nil {
+			return "", err
+		}
+
+		h := &argon2Hash{
+			memory:  argon2Memory,
+			time:    uint32(cost),
+			threads: argon2Threads,
+			salt:    salt,
+		}
+
+		h.key = argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2KeyLen)
+		return h.String(), nil
+	}
+
+	return "", errUnknownHash
+}
+
+// passwordCost returns the cost used by the algorithm, which is its default
+// one if the cost is too low, like bcrypt does.
+func passwordCost(algorithm string, cost int) int {
+	switch {
+	case algorithm == HashArgon2id && cost < 1:
+		return defaultArgon2Cost
+	case algorithm != HashArgon2id && cost < bcrypt.MinCost:
+		return bcrypt.DefaultCost
+	}
+
+	return cost
+}
+
+// CheckPasswordCost checks the cost can be used with the algorithm. The
+// costs above the maximum of bcrypt make every hash fail and too many
+// iterations of argon2id make each login hang.
+func CheckPasswordCost(algorithm string, cost int) error {
+	switch {
+	case algorithm == HashArgon2id && cost > maxArgon2Cost:
+		return fmt.Errorf("the argon2id cost must be at most %d", maxArgon2Cost)
+	case algorithm != HashArgon2id && cost > bcrypt.MaxCost:
+		return fmt.Errorf("the bcrypt cost must be at most %d", bcrypt.MaxCost)
+	}
+
+	return nil
+}
+
+// checkPasswordHash compares a password with an hash to check if they
+// match. The algorithm is the one the hash was made with.
+func checkPasswordHash(password, hash string) bool {
+	if !strings.HasPrefix(hash, "$"+HashArgon2id+"$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		return err == nil
+	}
+
+	h, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// hashPassword hashes a password with the algorithm and the cost of the
+// instance.
+func (m *FileManager) hashPassword(password string) (string, error) {
+	return hashPassword(password, m.PasswordHash, m.PasswordCost)
+}
+
+// outdatedHash tells if the hash wasn't made with the algorithm and the
+// cost of the instance, so it must be upgraded.
+func (m *FileManager) outdatedHash(hash string) bool {
+	cost := passwordCost(m.PasswordHash, m.PasswordCost)
+
+	switch m.PasswordHash {
+	case "", HashBcrypt:
+		current, err := bcrypt.Cost([]byte(hash))
+		return err != nil || current != cost
+	case HashArgon2id:
+		h, err := parseArgon2Hash(hash)
+		return err != nil || h.time != uint32(cost) || h.memory != argon2Memory ||
+			h.threads != argon2Threads || len(h.key) != argon2KeyLen
+	}
+
+	return false
+}
+
+// upgradePassword hashes the password of the user again if their hash is
+// outdated. It is called after the password was checked. The login
+// doesn't fail if the new hash can't be saved.
+func (m *FileManager) upgradePassword(u *User, password string) {
+	if m.DatabaseOptions.ReadOnly || !m.outdatedHash(u.Password) {
+		return
+	}
+
+	if enabled, _ := m.ReadOnlyMode(); enabled {
+		return
+	}
+
+	hash, err := m.hashPassword(password)
+	if err == nil {
+		err = m.db.UpdateField(&User{ID: u.ID}, "Password", hash)
+	}
+
+	if err != nil {
+		log.Printf("couldn't upgrade the password hash of %s: %v", u.Username, err)
+		return
+	}
+
+	u.Password = hash
+}
@@ -0,0 +1,167 @@
+package filemanager
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// portionChunk is the size of the chunks read backwards from the end of the
+// files to find the start of their last lines.
+const portionChunk = 32 << 10
+
+var errInvalidPortion = errors.New("the head or the tail must be a positive number and they can't be used together")
+
+// resourcePreviewPortion previews the first or the last lines of the text
+// file, given in the 'head' or 'tail' query parameter, or bytes if 'unit'
+// is 'bytes'. The portion, and not the whole file, is limited to
+// MaxPreviewSize. The file can't be edited from a portion.
+func resourcePreviewPortion(c *RequestContext, w http.ResponseWriter, r *http.Request, f *file) (int, error) {
+	query := r.URL.Query()
+	head, tail := query.Get("head"), query.Get("tail")
+	if head != "" && tail != "" {
+		return http.StatusBadRequest, errInvalidPortion
+	}
+
+	n, err := strconv.ParseInt(head+tail, 10, 64)
+	if err != nil || n <= 0 {
+		return http.StatusBadRequest, errInvalidPortion
+	}
+
+	lines := true
+	switch query.Get("unit") {
+	case "", "lines":
+	case "bytes":
+		lines = false
+	default:
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return errorToHTTP(err, true), err
+	}
+	defer file.Close()
+
+	var content []byte
+	if head != "" {
+		content, err = readHead(file, n, lines, c.MaxPreviewSize)
+	} else {
+		content, err = readTail(file, f.Size, n, lines, c.MaxPreviewSize)
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	f.Kind = "preview"
+	f.Content = string(content)
+	f.Partial = int64(len(content)) < f.Size
+	return renderJSON(w, f)
+}
+
+// readHead reads the first n lines of the file, or bytes if lines is false,
+// up to max bytes. Zero max means there is no limit.
+func readHead(file io.Reader, n int64, lines bool, max int64) ([]byte, error) {
+	if !lines {
+		if max > 0 && n > max {
+			n = max
+		}
+
+		return ioutil.ReadAll(io.LimitReader(file, n))
+	}
+
+	if max > 0 {
+		file = io.LimitReader(file, max)
+	}
+
+	reader := bufio.NewReader(file)
+	content := []byte{}
+
+	for i := int64(0); i < n; i++ {
+		line, err := reader.ReadBytes('\n')
+		content = append(content, line...)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content, nil
+}
+
+// readTail reads the last n lines of the file of the size, or bytes if
+// lines is false, up to max bytes. Zero max means there is no limit. The
+// file is read backwards from its end, so only the tail is read.
+func readTail(file io.ReaderAt, size, n int64, lines bool, max int64) ([]byte, error) {
+	start := size - n
+	if lines {
+		start = tailStart(file, size, n, max)
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if max > 0 && size-start > max {
+		start = size - max
+	}
+
+	content := make([]byte, size-start)
+	read, err := file.ReadAt(content, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return content[:read], nil
+}
+
+// tailStart returns the offset of the first of the last n lines of the
+// file. The line break at the end of the file doesn't start a new line.
+// It stops looking once the lines are longer than max bytes.
+func tailStart(file io.ReaderAt, size, n, max int64) int64 {
+	end := size
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err == nil && last[0] == '\n' {
+		end--
+	}
+
+	buf := make([]byte, portionChunk)
+	count := int64(0)
+
+	for pos := end; pos > 0; {
+		chunk := int64(len(buf))
+		if pos < chunk {
+			chunk = pos
+		}
+
+		pos -= chunk
+		if _, err := file.ReadAt(buf[:chunk], pos); err != nil && err != io.EOF {
+			return pos + chunk
+		}
+
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+
+			if count++; count == n {
+				return pos + i + 1
+			}
+		}
+
+		if max > 0 && size-pos >= max {
+			return pos
+		}
+	}
+
+	return 0
+}
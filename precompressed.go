@@ -0,0 +1,96 @@
+package filemanager
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// assetEncodings are the content encodings of the precompressed variants
+// of the assets and the extensions of their files, in order of preference.
+var assetEncodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// servePrecompressed serves the precompressed variant of the file in the
+// path, if there is one in fs which the client accepts. It returns false
+// if the file must be served as is.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fs http.FileSystem, name string) bool {
+	accept := r.Header.Get("Accept-Encoding")
+	varies := false
+
+	for _, enc := range assetEncodings {
+		f, err := fs.Open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		// The response depends on the header since there is a variant.
+		if !varies {
+			w.Header().Add("Vary", "Accept-Encoding")
+			varies = true
+		}
+
+		if !acceptsEncoding(accept, enc.name) {
+			continue
+		}
+
+		// The type is set beforehand so it isn't sniffed from the
+		// compressed data.
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", enc.name)
+		http.ServeContent(w, r, path.Base(name), info.ModTime(), f)
+		return true
+	}
+
+	return false
+}
+
+// acceptsEncoding checks if the Accept-Encoding header allows the content
+// encoding, either by its name or by '*', with a quality other than zero.
+func acceptsEncoding(header, encoding string) bool {
+	wildcard := false
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(param[2:], 64)
+			accepted = err == nil && q > 0
+		}
+
+		if name == encoding {
+			return accepted
+		}
+
+		wildcard = accepted
+	}
+
+	return wildcard
+}
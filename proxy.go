@@ -0,0 +1,93 @@
+package filemanager
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetTrustedProxies sets the IP ranges, in CIDR notation, of the reverse
+// proxies whose X-Forwarded-For header is trusted to find the IP address
+// of the clients. Plain IP addresses are also accepted. If there are
+// none, the address of the connection is always used.
+func (m *FileManager) SetTrustedProxies(cidrs []string) error {
+	var networks []*net.IPNet
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: cidr}
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+
+		networks = append(networks, network)
+	}
+
+	m.trustedProxies = networks
+	return nil
+}
+
+// trustedProxy checks if an address is of a trusted reverse proxy.
+func (m *FileManager) trustedProxy(ip net.IP) bool {
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the IP address of the client. The X-Forwarded-For
+// header is walked from the last address, which was added by the proxy
+// that made the connection, for as long as the addresses are of trusted
+// proxies. The first address which isn't is the one of the client.
+func (m *FileManager) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !m.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !m.trustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
@@ -0,0 +1,46 @@
+package filemanager
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	m := &FileManager{}
+
+	tests := []struct {
+		proxies   []string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		// Without trusted proxies, the header is ignored.
+		{nil, "127.0.0.1:1234", []string{"1.2.3.4"}, "127.0.0.1"},
+		{[]string{"127.0.0.1"}, "127.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		// Requests from other addresses can't spoof the client.
+		{[]string{"127.0.0.1"}, "5.6.7.8:1234", []string{"1.2.3.4"}, "5.6.7.8"},
+		// The addresses added by the client are ignored.
+		{[]string{"127.0.0.1"}, "127.0.0.1:1234", []string{"9.9.9.9, 1.2.3.4"}, "1.2.3.4"},
+		// The header is walked through every trusted proxy.
+		{[]string{"127.0.0.0/8", "10.0.0.0/8"}, "127.0.0.1:1234", []string{"9.9.9.9, 1.2.3.4", "10.0.0.2"}, "1.2.3.4"},
+		{[]string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		// Invalid addresses stop the walk.
+		{[]string{"127.0.0.1"}, "127.0.0.1:1234", []string{"1.2.3.4, invalid"}, "127.0.0.1"},
+		{[]string{"::1"}, "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		if err := m.SetTrustedProxies(test.proxies); err != nil {
+			t.Fatal(err)
+		}
+
+		r := &http.Request{RemoteAddr: test.remote, Header: http.Header{"X-Forwarded-For": test.forwarded}}
+		if got := m.clientIP(r); got.String() != test.want {
+			t.Errorf("%v from %s through %v: got %v, want %s", test.forwarded, test.remote, test.proxies, got, test.want)
+		}
+	}
+
+	if err := m.SetTrustedProxies([]string{"not a proxy"}); err == nil {
+		t.Error("Expected an error for an invalid proxy")
+	}
+}
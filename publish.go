@@ -0,0 +1,164 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxBuildFiles is the maximum number of built files listed in a build
+// report. The others are only counted.
+const maxBuildFiles = 1000
+
+// maxBuildTime is how long a build made to check the website may take
+// before it is stopped.
+const maxBuildTime = 10 * time.Minute
+
+var errNoDryRun = errors.New("the static website generator can't check the builds")
+
+// BuildReport describes a build of the website made to check it before it
+// is published.
+type BuildReport struct {
+	OK       bool     `json:"ok"`
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors"`
+	Output   string   `json:"output"`
+	// Files and Size are the number and the size of the built files, and
+	// Paths their paths in the website, up to maxBuildFiles.
+	Files int      `json:"files"`
+	Size  int64    `json:"size"`
+	Paths []string `json:"paths"`
+}
+
+// StaticGenDryRun is implemented by the static website generators which
+// can build the website without publishing it.
+type StaticGenDryRun interface {
+	DryRun(c *RequestContext) (*BuildReport, error)
+}
+
+// publishHandler builds the website as it would be published, without
+// deploying it, and reports the result of the build.
+func publishHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.StaticGen == nil {
+		return http.StatusNotFound, nil
+	}
+
+	if !c.User.AllowPublish {
+		return http.StatusForbidden, nil
+	}
+
+	gen, ok := c.StaticGen.(StaticGenDryRun)
+	if !ok {
+		return http.StatusNotImplemented, errNoDryRun
+	}
+
+	release, ok := c.acquire(r, limitBuild)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	report, err := gen.DryRun(c)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, report)
+}
+
+// buildReport runs the build command of a generator, which must write the
+// website to dest, and reports its output and the files it built. A build
+// which fails or takes longer than maxBuildTime isn't an error, only its
+// report isn't OK.
+func buildReport(command string, args []string, root, dest string) (*BuildReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxBuildTime)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = root
+	out, err := cmd.CombinedOutput()
+
+	report := &BuildReport{
+		OK:       err == nil,
+		Warnings: []string{},
+		Errors:   []string{},
+		Paths:    []string{},
+	}
+
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, err
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		report.Errors = append(report.Errors, fmt.Sprintf("the build took longer than %v", maxBuildTime))
+	}
+
+	report.Output = string(out)
+	if len(report.Output) > maxJobOutput {
+		report.Output = report.Output[:maxJobOutput]
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		lower := strings.ToLower(line)
+
+		switch {
+		case strings.Contains(lower, "error"):
+			report.Errors = append(report.Errors, line)
+		case strings.Contains(lower, "warn"):
+			report.Warnings = append(report.Warnings, line)
+		}
+	}
+
+	err = filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		report.Files++
+		report.Size += info.Size()
+
+		if len(report.Paths) < maxBuildFiles {
+			rel, _ := filepath.Rel(dest, p)
+			report.Paths = append(report.Paths, "/"+filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.Paths)
+	return report, nil
+}
+
+// withoutWatch removes the --watch flag, and its value if it has one, from
+// the arguments of a generator, since the builds of the dry runs must end.
+func withoutWatch(args []string) []string {
+	clean := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--watch" && !strings.HasPrefix(args[i], "--watch=") {
+			clean = append(clean, args[i])
+			continue
+		}
+
+		if args[i] == "--watch" && i+1 < len(args) && (args[i+1] == "true" || args[i+1] == "false") {
+			i++
+		}
+	}
+
+	return clean
+}
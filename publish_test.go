@@ -0,0 +1,153 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeGenerator is a shell script which builds a website like the static
+// website generators do.
+const fakeGenerator = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--watch) echo "ERROR the build never ends"; exit 1 ;;
+	--fail) fail=1 ;;
+	--destination) shift; dest="$1" ;;
+	esac
+	shift
+done
+
+mkdir -p "$dest/posts"
+printf 'home' > "$dest/index.html"
+printf 'post' > "$dest/posts/index.html"
+echo "WARN found no layout for taxonomy"
+
+if [ -n "$fail" ]; then
+	echo "ERROR failed to render posts"
+	exit 1
+fi
+`
+
+func TestPublishDryRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't installed")
+	}
+
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.TempDir = filepath.Join(fm.Temp, "tmp")
+	if err := os.Mkdir(fm.TempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(fm.Temp, "hugo")
+	if err := ioutil.WriteFile(exe, []byte(fakeGenerator), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func() *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/publish/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request(); w.Code != http.StatusNotFound {
+		t.Errorf("without a generator: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	public := filepath.Join(fm.Temp, "public")
+	hugo := &Hugo{Root: fm.Temp, Public: public, Exe: exe, Args: []string{"--watch", "true"}}
+	fm.StaticGen = hugo
+
+	w := request()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var report BuildReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.OK || len(report.Errors) != 0 || len(report.Warnings) != 1 {
+		t.Errorf("got ok %v, errors %q and warnings %q, want a successful build with a warning", report.OK, report.Errors, report.Warnings)
+	}
+
+	if want := []string{"/index.html", "/posts/index.html"}; !reflect.DeepEqual(report.Paths, want) {
+		t.Errorf("got paths %q, want %q", report.Paths, want)
+	}
+
+	if report.Files != 2 || report.Size != 8 {
+		t.Errorf("got %d files of %d bytes, want 2 files of 8 bytes", report.Files, report.Size)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(public, "*")); len(matches) != 0 {
+		t.Errorf("the dry run published %q", matches)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(fm.TempDir, "*")); len(matches) != 0 {
+		t.Errorf("the build wasn't removed: %q", matches)
+	}
+
+	hugo.Args = []string{"--fail"}
+	w = request()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	report = BuildReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.OK || len(report.Errors) != 1 || !strings.Contains(report.Output, "failed to render") {
+		t.Errorf("got ok %v and errors %q, want a failed build", report.OK, report.Errors)
+	}
+
+	// Only one build runs at once by default.
+	release := fm.acquireJob(limitBuild)
+	if w := request(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("while another build runs: got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	release()
+
+	fm.Users["admin"].AllowPublish = false
+	if w := request(); w.Code != http.StatusForbidden {
+		t.Errorf("without permission: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestWithoutWatch(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--minify"}, []string{"--minify"}},
+		{[]string{"--watch", "--minify"}, []string{"--minify"}},
+		{[]string{"--watch", "true", "--minify"}, []string{"--minify"}},
+		{[]string{"--minify", "--watch=false"}, []string{"--minify"}},
+	}
+
+	for _, test := range tests {
+		if got := withoutWatch(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The buckets of the requests of each user.
+const (
+	rateAPI      = "api"
+	rateDownload = "download"
+)
+
+// rateKey is the key of the token bucket of the requests of the client
+// in the bucket.
+func rateKey(bucket, client string) string {
+	return "ratelimit/" + bucket + "/" + client
+}
+
+// rateSwapAttempts is how many times the token bucket of a client is
+// read and written back before giving up when other requests of the
+// client change it at once.
+const rateSwapAttempts = 10
+
+// rateBucket is the token bucket of the requests of a client. It fills at
+// Rate tokens per minute up to Burst tokens and each request takes one.
+type rateBucket struct {
+	Tokens float64 `json:"tokens"`
+	// Time is when the bucket was last filled, in nanoseconds since the
+	// epoch.
+	Time  int64 `json:"time"`
+	Rate  int   `json:"rate"`
+	Burst int   `json:"burst"`
+}
+
+// take fills the bucket for the time passed since it was last filled and
+// takes a token, if there is one. Otherwise, it returns how long until
+// there would be.
+func (b *rateBucket) take(now time.Time) (bool, time.Duration) {
+	elapsed := now.Sub(time.Unix(0, b.Time))
+	if elapsed > 0 {
+		b.Tokens += float64(b.Rate) * elapsed.Minutes()
+		b.Time = now.UnixNano()
+	}
+
+	if b.Tokens > float64(b.Burst) {
+		b.Tokens = float64(b.Burst)
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.Tokens) / float64(b.Rate) * float64(time.Minute))
+}
+
+// full returns how long until the bucket is full again, after which it
+// can be forgotten.
+func (b *rateBucket) full() time.Duration {
+	return time.Duration((float64(b.Burst)-b.Tokens)/float64(b.Rate)*float64(time.Minute)) + time.Second
+}
+
+// rateLimit returns the maximum number of requests per minute of the user
+// in the bucket. The downloads have their own limit which, if it is zero,
+// is the one of the other requests. The users can have their own limit,
+// except for the downloads. Zero means there is no limit.
+func (m *FileManager) rateLimit(u *User, bucket string) int {
+	if u.RateLimit < 0 {
+		return 0
+	}
+
+	if bucket == rateDownload {
+		switch {
+		case m.DownloadRateLimit < 0:
+			return 0
+		case m.DownloadRateLimit > 0:
+			return m.DownloadRateLimit
+		}
+	}
+
+	if u.RateLimit > 0 {
+		return u.RateLimit
+	}
+
+	return m.RateLimit
+}
+
+// rateBurst returns the number of requests the users can make at once
+// in a bucket with the given limit.
+func (m *FileManager) rateBurst(limit int) int {
+	if m.RateBurst > 0 {
+		return m.RateBurst
+	}
+
+	return limit
+}
+
+// allowRequest takes a token of the client in the bucket and tells if
+// there was one within the limits of the user. Otherwise, it returns how
+// long until there would be. The clients are the users, unless there is
+// no authentication, in which case they are the IP addresses.
+func (m *FileManager) allowRequest(r *http.Request, u *User, bucket string) (bool, time.Duration) {
+	limit := m.rateLimit(u, bucket)
+	if limit <= 0 {
+		return true, 0
+	}
+
+	client := u.Username
+	if m.NoAuth {
+		client = m.clientIP(r).String()
+	}
+
+	return m.takeRequest(bucket, client, limit, m.rateBurst(limit))
+}
+
+// takeRequest takes a token of the token bucket of the client in the
+// bucket, which fills at rate tokens per minute up to burst, and tells if
+// there was one. Otherwise, it returns how long until there would be. The
+// bucket is swapped atomically so it is shared by the instances using the
+// same state store.
+func (m *FileManager) takeRequest(bucket, client string, rate, burst int) (bool, time.Duration) {
+	key := rateKey(bucket, client)
+
+	for i := 0; i < rateSwapAttempts; i++ {
+		now := time.Now()
+		old, ok, err := m.state().Get(key)
+		if err != nil {
+			// The API stays available if the state store fails.
+			log.Print(err)
+			return true, 0
+		}
+
+		// The new buckets and those which can't be read are full.
+		b := rateBucket{Tokens: float64(burst), Time: now.UnixNano()}
+		if !ok {
+			old = nil
+		} else if err = json.Unmarshal(old, &b); err != nil {
+			b = rateBucket{Tokens: float64(burst), Time: now.UnixNano()}
+		}
+
+		// The limits may have changed since the bucket was stored.
+		b.Rate, b.Burst = rate, burst
+
+		allowed, retry := b.take(now)
+		if !allowed {
+			return false, retry
+		}
+
+		value, err := json.Marshal(b)
+		if err != nil {
+			log.Print(err)
+			return true, 0
+		}
+
+		swapped, err := m.state().CompareAndSwap(key, old, value, b.full())
+		if err != nil {
+			log.Print(err)
+			return true, 0
+		}
+
+		if swapped {
+			return true, 0
+		}
+	}
+
+	// Too many requests of the client changed the bucket at once.
+	return false, time.Second
+}
+
+// rejectRateLimited replies with 429 Too Many Requests when the user made
+// too many requests, telling when to retry.
+func rejectRateLimited(w http.ResponseWriter, retry time.Duration) (int, error) {
+	seconds := int(math.Ceil(retry.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return http.StatusTooManyRequests, nil
+}
@@ -0,0 +1,203 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	fm.RateLimit = 3
+	fm.DownloadRateLimit = 1
+
+	for i := 0; i < 3; i++ {
+		if w := get("/api/me"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: got %v", i, w.Code)
+		}
+	}
+
+	w := get("/api/me")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request over the limit: got %v", w.Code)
+	}
+
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 1 || retry > 120 {
+		t.Errorf("Got Retry-After %q", w.Header().Get("Retry-After"))
+	}
+
+	// The downloads have their own bucket.
+	if w := get("/api/download/file.txt"); w.Code != http.StatusOK {
+		t.Errorf("Download: got %v", w.Code)
+	}
+
+	if w := get("/api/download/file.txt"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Download over the limit: got %v", w.Code)
+	}
+
+	reset := func() {
+		keys, err := fm.state().Keys("ratelimit/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range keys {
+			if err := fm.state().Delete(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The users can have their own limits.
+	reset()
+
+	fm.Users["admin"].RateLimit = 5
+	for i := 0; i < 5; i++ {
+		if w := get("/api/me"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d under the limit of the user: got %v", i, w.Code)
+		}
+	}
+
+	if w := get("/api/me"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Request over the limit of the user: got %v", w.Code)
+	}
+
+	fm.Users["admin"].RateLimit = -1
+	for i := 0; i < 20; i++ {
+		if w := get("/api/me"); w.Code != http.StatusOK {
+			t.Fatalf("Request without limit: got %v", w.Code)
+		}
+	}
+
+	// The burst can be smaller than the limit.
+	reset()
+	fm.RateBurst = 2
+	fm.Users["admin"].RateLimit = 0
+	for i := 0; i < 2; i++ {
+		if w := get("/api/me"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d of the burst: got %v", i, w.Code)
+		}
+	}
+
+	if w := get("/api/me"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Request over the burst: got %v", w.Code)
+	}
+
+	fm.RateBurst = 0
+
+	// Without authentication, each address has its own limit.
+	reset()
+	fm.NoAuth = true
+	fm.Users["admin"].RateLimit = 0
+	from := func(addr string) int {
+		r, err := http.NewRequest("GET", "/api/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.RemoteAddr = addr + ":1234"
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := from("192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("Request %d of the first address: got %v", i, code)
+		}
+	}
+
+	if code := from("192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Request over the limit of the first address: got %v", code)
+	}
+
+	if code := from("192.0.2.2"); code != http.StatusOK {
+		t.Errorf("Request of another address: got %v", code)
+	}
+
+	// The requests made at once can't take more than the burst.
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := fm.takeRequest("test", "client", 1, 5); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if allowed == 0 || allowed > 5 {
+		t.Errorf("%d requests made at once were allowed", allowed)
+	}
+}
+
+func TestRateBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		tokens  float64
+		elapsed time.Duration
+		ok      bool
+		retry   time.Duration
+	}{
+		{3, 0, true, 0},
+		{1, 0, true, 0},
+		{0, 0, false, 20 * time.Second},
+		{0, 10 * time.Second, false, 10 * time.Second},
+		{0, 20 * time.Second, true, 0},
+		{0.5, 0, false, 10 * time.Second},
+		// The bucket doesn't fill over the burst.
+		{0, time.Hour, true, 0},
+	}
+
+	for _, test := range tests {
+		b := rateBucket{Tokens: test.tokens, Time: start.UnixNano(), Rate: 3, Burst: 3}
+		ok, retry := b.take(start.Add(test.elapsed))
+		if ok != test.ok || (retry-test.retry).Round(time.Millisecond) != 0 {
+			t.Errorf("%+v: got %v %v", test, ok, retry)
+		}
+
+		if b.Tokens > 3 {
+			t.Errorf("%+v: the bucket has %v tokens", test, b.Tokens)
+		}
+	}
+
+	b := rateBucket{Tokens: 0, Time: start.UnixNano(), Rate: 3, Burst: 3}
+	if full := b.full(); full < time.Minute || full > time.Minute+2*time.Second {
+		t.Errorf("Empty bucket full in %v", full)
+	}
+}
@@ -0,0 +1,227 @@
+package filemanager
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxReadmeSize is the maximum number of bytes of a README which are
+// rendered in a listing. The rest is left out.
+const maxReadmeSize = 64 << 10
+
+// readme is the description of a directory, taken from its README file.
+type readme struct {
+	Name string `json:"name"`
+	// HTML is the rendered Markdown. Everything in the file is escaped,
+	// so it can't contain any markup but the one made by the renderer.
+	HTML string `json:"html"`
+	// Truncated tells if the file was larger than maxReadmeSize.
+	Truncated bool `json:"truncated,omitempty"`
+	// TooLarge tells if the file is larger than the maximum size of the
+	// previews, in which case it isn't rendered.
+	TooLarge bool `json:"tooLarge,omitempty"`
+}
+
+// embedReadme renders the README of the listing of dir, which is the item
+// named like name, ignoring the case, if the user can access it. Files
+// larger than maxSize aren't rendered, unless it is zero.
+func (l *listing) embedReadme(u *User, dir, name string, maxSize int64) error {
+	for _, item := range l.Items {
+		if item.IsDir || !strings.EqualFold(item.Name, name) {
+			continue
+		}
+
+		if !u.Allowed(path.Join(dir, item.Name)) {
+			return nil
+		}
+
+		if maxSize > 0 && item.Size > maxSize {
+			l.Readme = &readme{Name: item.Name, TooLarge: true}
+			return nil
+		}
+
+		f, err := os.Open(item.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		data := make([]byte, maxReadmeSize+1)
+		n, err := io.ReadFull(f, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		r := &readme{Name: item.Name}
+		if n > maxReadmeSize {
+			n, r.Truncated = maxReadmeSize, true
+		}
+
+		r.HTML = renderMarkdown(string(data[:n]))
+		l.Readme = r
+		return nil
+	}
+
+	return nil
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	markdownNumber  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	markdownRule    = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrong  = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	markdownEm      = regexp.MustCompile(`(^|[^\w*])[*_]([^*_]+)[*_]`)
+)
+
+// renderMarkdown renders the common subset of Markdown used in READMEs:
+// headings, paragraphs, lists, quotes, rules, code blocks, code spans,
+// emphasis and links. The text is escaped before it is rendered.
+func renderMarkdown(text string) string {
+	var out bytes.Buffer
+	var paragraph []string
+	list := ""
+	code := false
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	item := func(tag, content string) {
+		if len(paragraph) > 0 || list != tag {
+			flush()
+			out.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+
+		out.WriteString("<li>" + renderInline(content) + "</li>\n")
+	}
+
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if code {
+				out.WriteString("</code></pre>\n")
+			} else {
+				flush()
+				out.WriteString("<pre><code>")
+			}
+
+			code = !code
+			continue
+		}
+
+		if code {
+			out.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			flush()
+			level := strconv.Itoa(len(m[1]))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+		} else if markdownRule.MatchString(line) {
+			flush()
+			out.WriteString("<hr>\n")
+		} else if m := markdownBullet.FindStringSubmatch(line); m != nil {
+			item("ul", m[1])
+		} else if m := markdownNumber.FindStringSubmatch(line); m != nil {
+			item("ol", m[1])
+		} else if strings.HasPrefix(line, ">") {
+			flush()
+			out.WriteString("<blockquote>" + renderInline(strings.TrimSpace(line[1:])) + "</blockquote>\n")
+		} else if strings.TrimSpace(line) == "" {
+			flush()
+		} else {
+			if list != "" {
+				flush()
+			}
+
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+
+	if code {
+		out.WriteString("</code></pre>\n")
+	}
+
+	flush()
+	return out.String()
+}
+
+// renderInline escapes a line and renders its code spans, emphasis and
+// links.
+func renderInline(text string) string {
+	// The odd parts are inside code spans, which aren't rendered.
+	parts := strings.Split(text, "`")
+	for i := range parts {
+		escaped := html.EscapeString(parts[i])
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = "<code>" + escaped + "</code>"
+			continue
+		}
+
+		// The emphasis is rendered around the links so their addresses
+		// aren't changed.
+		var rendered bytes.Buffer
+		last := 0
+		for _, m := range markdownLink.FindAllStringSubmatchIndex(escaped, -1) {
+			rendered.WriteString(renderEmphasis(escaped[last:m[0]]))
+			text, link := renderEmphasis(escaped[m[2]:m[3]]), escaped[m[4]:m[5]]
+
+			if safeLink(html.UnescapeString(link)) {
+				rendered.WriteString(`<a href="` + link + `" rel="noopener noreferrer">` + text + `</a>`)
+			} else {
+				rendered.WriteString(text)
+			}
+
+			last = m[1]
+		}
+
+		rendered.WriteString(renderEmphasis(escaped[last:]))
+		parts[i] = rendered.String()
+	}
+
+	// An unmatched backtick is shown as is.
+	if len(parts)%2 == 0 {
+		last := len(parts) - 1
+		parts[last-1] += "`" + parts[last]
+		parts = parts[:last]
+	}
+
+	return strings.Join(parts, "")
+}
+
+// renderEmphasis renders the strong and emphasized text.
+func renderEmphasis(text string) string {
+	text = markdownStrong.ReplaceAllString(text, "<strong>$2</strong>")
+	return markdownEm.ReplaceAllString(text, "$1<em>$2</em>")
+}
+
+// safeLink checks if a link is relative or uses a scheme which can't run
+// scripts.
+func safeLink(link string) bool {
+	lower := strings.ToLower(strings.TrimSpace(link))
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+
+	colon := strings.Index(lower, ":")
+	return colon == -1 || (strings.ContainsAny(lower[:colon], "/?#"))
+}
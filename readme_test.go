@@ -0,0 +1,102 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		markdown, html string
+	}{
+		{"# Title #", "<h1>Title</h1>\n"},
+		{"Some **bold** and *em*\ntext.", "<p>Some <strong>bold</strong> and <em>em</em> text.</p>\n"},
+		{"- one\n- two\n\n1. first", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol>\n<li>first</li>\n</ol>\n"},
+		{"```\n<b>code</b>\n```", "<pre><code>&lt;b&gt;code&lt;/b&gt;\n</code></pre>\n"},
+		{"Use `a *b*` here", "<p>Use <code>a *b*</code> here</p>\n"},
+		{"snake_case_name", "<p>snake_case_name</p>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"[site](https://example.com/a_b_c)", `<p><a href="https://example.com/a_b_c" rel="noopener noreferrer">site</a></p>` + "\n"},
+		{"[docs](docs/index.md)", `<p><a href="docs/index.md" rel="noopener noreferrer">docs</a></p>` + "\n"},
+		{"[x](javascript:alert(1))", "<p>x)</p>\n"},
+		{`[x]("onclick="alert)`, `<p><a href="&#34;onclick=&#34;alert" rel="noopener noreferrer">x</a></p>` + "\n"},
+		{"> quote\n---", "<blockquote>quote</blockquote>\n<hr>\n"},
+	}
+
+	for _, test := range tests {
+		if got := renderMarkdown(test.markdown); got != test.html {
+			t.Errorf("%q: got %q, want %q", test.markdown, got, test.html)
+		}
+	}
+}
+
+func TestListingReadme(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	dir := filepath.Join(fm.Temp, "scope", "project")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "# Project\n" + strings.Repeat("a", maxReadmeSize)
+	if err := ioutil.WriteFile(filepath.Join(dir, "readme.md"), []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	list := func() *listing {
+		r, err := http.NewRequest("GET", "/api/resource/project/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Listing: got %v", w.Code)
+		}
+
+		res := &listing{}
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	if l := list(); l.Readme != nil {
+		t.Errorf("README embedded without the option: %+v", l.Readme)
+	}
+
+	fm.ReadmeName = "README.md"
+	l := list()
+	if l.Readme == nil {
+		t.Fatal("Expected the README to be embedded")
+	}
+
+	if l.Readme.Name != "readme.md" || !l.Readme.Truncated || !strings.HasPrefix(l.Readme.HTML, "<h1>Project</h1>") {
+		t.Errorf("Wrong README: %s %v %.30q", l.Readme.Name, l.Readme.Truncated, l.Readme.HTML)
+	}
+
+	// The READMEs the user can't access aren't shown.
+	// The READMEs larger than the previews aren't rendered.
+	fm.MaxPreviewSize = maxReadmeSize
+	if l = list(); l.Readme == nil || !l.Readme.TooLarge || l.Readme.HTML != "" {
+		t.Errorf("Expected the README to be too large: %+v", l.Readme)
+	}
+
+	fm.Users["admin"].Rules = []*Rule{{Path: "/project/readme.md", Allow: false}}
+	if l = list(); l.Readme != nil {
+		t.Error("Expected the forbidden README to be left out")
+	}
+}
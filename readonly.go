@@ -0,0 +1,101 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/asdine/storm"
+)
+
+// readOnlyRetryAfter is the number of seconds after which the clients are
+// told to retry the requests rejected because of the read-only mode.
+const readOnlyRetryAfter = 300
+
+var errReadOnlyMode = errors.New("the server is in read-only mode")
+
+// readOnlyMode is the state of the read-only mode of the server, during
+// which every request that changes something is rejected.
+type readOnlyMode struct {
+	Enabled bool `json:"enabled"`
+	// Message is shown to the users while the mode is enabled.
+	Message string `json:"message"`
+}
+
+// readOnlyState holds the read-only mode, which can be toggled while
+// the server is running.
+type readOnlyState struct {
+	sync.RWMutex
+	mode readOnlyMode
+}
+
+// loadReadOnlyMode gets the read-only mode from the database.
+func (m *FileManager) loadReadOnlyMode(db *storm.DB) error {
+	err := db.Get("config", "readOnly", &m.readOnly.mode)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// SetReadOnlyMode enables or disables the read-only mode of the server.
+// While it is enabled, the requests that change files, users, settings
+// or shares fail with 503 Service Unavailable, but reads and downloads
+// keep working. The message is shown to the users.
+func (m *FileManager) SetReadOnlyMode(enabled bool, message string) error {
+	mode := readOnlyMode{
+		Enabled: enabled,
+		Message: message,
+	}
+
+	// Read-only databases can't save the mode, so it only lasts
+	// until the server is restarted.
+	if !m.DatabaseOptions.ReadOnly {
+		if err := m.db.Set("config", "readOnly", mode); err != nil {
+			return err
+		}
+	}
+
+	m.readOnly.Lock()
+	m.readOnly.mode = mode
+	m.readOnly.Unlock()
+	return nil
+}
+
+// ReadOnlyMode tells if the read-only mode is enabled and returns
+// its message.
+func (m *FileManager) ReadOnlyMode() (bool, string) {
+	m.readOnly.RLock()
+	defer m.readOnly.RUnlock()
+
+	return m.readOnly.mode.Enabled, m.readOnly.mode.Message
+}
+
+// readOnlyStatus returns a copy of the read-only mode.
+func (m *FileManager) readOnlyStatus() readOnlyMode {
+	m.readOnly.RLock()
+	defer m.readOnly.RUnlock()
+
+	return m.readOnly.mode
+}
+
+// mutating tells if a request to an API route may change something.
+func mutating(router, method string) bool {
+	switch router {
+	case "command":
+		return true
+	case "link", "search", "batch", "thumbnail":
+		return false
+	}
+
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// rejectReadOnly returns 503 Service Unavailable with a Retry-After
+// header for the requests rejected because of the read-only mode.
+func rejectReadOnly(w http.ResponseWriter) (int, error) {
+	w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+	return http.StatusServiceUnavailable, errReadOnlyMode
+}
@@ -0,0 +1,55 @@
+package filemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if err := fm.SetReadOnlyMode(true, "Backup in progress"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := do("POST", "/api/resource/file.txt", "content")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Write allowed in read-only mode: got %v", w.Code)
+	}
+
+	if w = do("GET", "/api/resource/", ""); w.Code != http.StatusOK {
+		t.Errorf("Read rejected in read-only mode: got %v", w.Code)
+	}
+
+	// Only the read-only mode can be changed in the settings.
+	w = do("PUT", "/api/settings/", `{"what":"settings","which":"commands","data":{"commands":{}}}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Settings changed in read-only mode: got %v", w.Code)
+	}
+
+	w = do("PUT", "/api/settings/", `{"what":"settings","which":"readOnly","data":{"readOnly":{"enabled":false}}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Couldn't disable read-only mode: got %v", w.Code)
+	}
+
+	if w = do("POST", "/api/resource/file.txt", "content"); w.Code != http.StatusOK {
+		t.Errorf("Write rejected after read-only mode: got %v", w.Code)
+	}
+}
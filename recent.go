@@ -0,0 +1,134 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hacdias/fileutils"
+)
+
+const (
+	// defaultRecentDays and defaultRecentLimit are the time window and
+	// the number of files of the recent files if they aren't requested.
+	defaultRecentDays  = 7
+	defaultRecentLimit = 100
+	// maxRecentLimit is the maximum number of recent files returned.
+	maxRecentLimit = 1000
+)
+
+var errRecentLimit = errors.New("the limit of the recent files must be between 1 and 1000")
+
+// recentResponse is the response of the recent files API.
+type recentResponse struct {
+	Since time.Time        `json:"since"`
+	Items []*entryMetadata `json:"items"`
+	// Truncated indicates that the directories deeper than the maximum
+	// depth weren't walked.
+	Truncated bool `json:"truncated"`
+}
+
+// recentHandler returns the files of the directory in the URL, and of its
+// subdirectories, modified in the last 'days' days, the most recent first,
+// up to 'limit' files. The files the user can't access and the ignored
+// ones aren't included.
+func recentHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	query := r.URL.Query()
+
+	days, err := queryInt(query.Get("days"), defaultRecentDays)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	limit, err := queryInt(query.Get("limit"), defaultRecentLimit)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if limit < 1 || limit > maxRecentLimit {
+		return http.StatusBadRequest, errRecentLimit
+	}
+
+	dir := fileutils.SlashClean(r.URL.Path)
+	info, err := c.User.FileSystem.Stat(dir)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if !info.IsDir() {
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	release, ok := c.acquire(r, limitSearch)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	root := filepath.Clean(string(c.User.FileSystem))
+	since := time.Now().AddDate(0, 0, -days)
+	items := []*entryMetadata{}
+
+	// keep sorts the files, the most recent first, and only keeps the
+	// ones which are returned.
+	keep := func() {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].ModTime.After(items[j].ModTime)
+		})
+
+		if len(items) > limit {
+			items = items[:limit]
+		}
+	}
+
+	truncated, err := walk(root, filepath.Join(root, filepath.FromSlash(dir)), c.MaxDepth, func(p string, info os.FileInfo, err error) error {
+		// The directories which can't be read are skipped.
+		if err != nil {
+			return nil
+		}
+
+		virtual := virtualPath(root, p)
+
+		if virtual != dir && (!c.User.Allowed(virtual) || c.ignored(c.User, virtual) || partialFile(virtual)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() || info.ModTime().Before(since) {
+			return nil
+		}
+
+		items = append(items, &entryMetadata{
+			Name:    info.Name(),
+			Path:    virtual,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+
+		// The files are only trimmed from time to time so the walk
+		// doesn't sort them after each one.
+		if len(items) >= 2*limit {
+			keep()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	keep()
+
+	return renderJSON(w, &recentResponse{
+		Since:     since,
+		Items:     items,
+		Truncated: truncated,
+	})
+}
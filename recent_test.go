@@ -0,0 +1,103 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecentFiles(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	now := time.Now()
+
+	files := map[string]time.Duration{
+		"a.txt":             time.Hour,
+		"docs/b.txt":        2 * time.Hour,
+		"docs/deep/c.txt":   3 * time.Hour,
+		"old.txt":           30 * 24 * time.Hour,
+		"node_modules/d.js": time.Minute,
+		"private/e.txt":     time.Minute,
+	}
+
+	for name, age := range files {
+		p := filepath.Join(scope, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.Ignore = []string{"node_modules", ".gitkeep"}
+	fm.Users["admin"].Rules = []*Rule{{Path: "/private"}}
+
+	token := login(t, fm, defaultCredentials)
+
+	recent := func(url string) (int, []string) {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+
+		var res recentResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+
+		paths := []string{}
+		for _, item := range res.Items {
+			paths = append(paths, item.Path)
+		}
+
+		return w.Code, paths
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/recent/", "/a.txt /docs/b.txt /docs/deep/c.txt"},
+		{"/api/recent/?limit=2", "/a.txt /docs/b.txt"},
+		{"/api/recent/docs", "/docs/b.txt /docs/deep/c.txt"},
+		{"/api/recent/?days=60", "/a.txt /docs/b.txt /docs/deep/c.txt /old.txt"},
+	}
+
+	for _, test := range tests {
+		code, paths := recent(test.url)
+		if got := strings.Join(paths, " "); code != http.StatusOK || got != test.want {
+			t.Errorf("%s: got %v %q, want %q", test.url, code, got, test.want)
+		}
+	}
+
+	for _, url := range []string{"/api/recent/?limit=0", "/api/recent/?days=-1", "/api/recent/a.txt"} {
+		if code, _ := recent(url); code != http.StatusBadRequest {
+			t.Errorf("%s: got %v, want %v", url, code, http.StatusBadRequest)
+		}
+	}
+
+	if code, _ := recent("/api/recent/private"); code != http.StatusForbidden {
+		t.Errorf("Disallowed directory: got %v, want %v", code, http.StatusForbidden)
+	}
+}
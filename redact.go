@@ -0,0 +1,150 @@
+package filemanager
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces the values of the query parameters and the
+// headers which aren't logged.
+const redactedValue = "REDACTED"
+
+// loggedParams are the names of the query parameters whose values are
+// logged. The others are masked since they may carry the tokens, the
+// signatures of the download links, the codes of the single sign-on or
+// the names of the files searched.
+var loggedParams = []string{
+	"action",
+	"algo",
+	"checksum",
+	"days",
+	"dirsOnly",
+	"dryRun",
+	"expires",
+	"format",
+	"head",
+	"hidden",
+	"inline",
+	"limit",
+	"offset",
+	"order",
+	"page",
+	"perPage",
+	"permanent",
+	"recursive",
+	"since",
+	"sniff",
+	"sort",
+	"status",
+	"tail",
+	"thumbs",
+	"unit",
+}
+
+// loggedHeaders are the names of the headers whose values are logged by
+// the debug mode. The others, such as the cookies and the tokens, are
+// masked.
+var loggedHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Connection",
+	"Content-Length",
+	"Content-Type",
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"Origin",
+	"Range",
+	"Tus-Resumable",
+	"Upload-Length",
+	"Upload-Offset",
+	"User-Agent",
+	"X-Forwarded-For",
+	"X-Forwarded-Proto",
+}
+
+// logged tells if the value of the query parameter or the header with the
+// name can be written to the logs, which it can if it is in the list and
+// not in LogRedact. The names aren't case sensitive.
+func (m *FileManager) logged(name string, list []string) bool {
+	for _, n := range m.LogRedact {
+		if strings.EqualFold(n, name) {
+			return false
+		}
+	}
+
+	for _, n := range list {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactPath masks the hash of the share in the paths of the shared
+// pages, which is enough to browse the share.
+func redactPath(p string) string {
+	if !strings.HasPrefix(p, "/share/") {
+		return p
+	}
+
+	hash := strings.TrimPrefix(p, "/share/")
+	if i := strings.Index(hash, "/"); i != -1 {
+		return "/share/" + redactedValue + hash[i:]
+	}
+
+	return "/share/" + redactedValue
+}
+
+// redactURL returns the path and the query of the URL for the logs, with
+// the hashes of the shares and the values of the parameters which aren't
+// logged masked.
+func (m *FileManager) redactURL(u *url.URL) string {
+	p := redactPath(u.Path)
+	if u.RawQuery == "" {
+		return p
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		// The query can't be told apart so none of it is logged.
+		return p + "?" + redactedValue
+	}
+
+	for name, values := range query {
+		if !m.logged(name, loggedParams) {
+			for i := range values {
+				values[i] = redactedValue
+			}
+		}
+	}
+
+	return p + "?" + query.Encode()
+}
+
+// redactHeader returns the headers for the logs, sorted by name, with the
+// values of the ones which aren't logged masked.
+func (m *FileManager) redactHeader(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if !m.logged(name, loggedHeaders) {
+			value = redactedValue
+		}
+
+		lines = append(lines, name+": "+value)
+	}
+
+	return strings.Join(lines, "; ")
+}
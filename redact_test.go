@@ -0,0 +1,111 @@
+package filemanager
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	m := &FileManager{LogRedact: []string{"Sort"}}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/me", "/api/me"},
+		{"/api/resource/a?order=asc", "/api/resource/a?order=asc"},
+		{"/api/resource/a?SORT=name", "/api/resource/a?SORT=REDACTED"},
+		{"/dl/a.txt?user=admin&signature=abc&expires=1", "/dl/a.txt?expires=1&signature=REDACTED&user=REDACTED"},
+		{"/api/auth/oidc/callback?code=abc&STATE=def", "/api/auth/oidc/callback?STATE=REDACTED&code=REDACTED"},
+		{"/api/me?session=abc&session=def", "/api/me?session=REDACTED&session=REDACTED"},
+		{"/api/me?token=%zz", "/api/me?REDACTED"},
+		{"/share/abc", "/share/REDACTED"},
+		{"/share/abc/dir/a.txt?dl=1", "/share/REDACTED/dir/a.txt?dl=REDACTED"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := m.redactURL(u); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+func TestRedactedLogs(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	fm.Debug = true
+
+	r, err := http.NewRequest("GET", "/api/resource/?auth=secret-token&sort=name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Cookie", "auth=secret-token")
+	r.Header.Set("User-Agent", "test")
+	r.Header.Set("X-Api-Key", "secret-token")
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	line := logs.String()
+	if strings.Contains(line, "secret-token") {
+		t.Errorf("The token leaked in the logs: %s", line)
+	}
+
+	for _, want := range []string{"auth=REDACTED", "sort=name", "Authorization: REDACTED", "User-Agent: test", "X-Api-Key: REDACTED"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("The logs don't contain %q: %s", want, line)
+		}
+	}
+}
+
+func TestRedactedShareLogs(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// The protected shares refuse the requests without the password.
+	err := fm.db.Save(&shareLink{Hash: "secret-hash", Path: filepath.Join(fm.Temp, "scope"), Protected: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("GET", "/share/secret-hash/a.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	if line := logs.String(); strings.Contains(line, "secret-hash") || !strings.Contains(line, "/share/REDACTED/a.txt") {
+		t.Errorf("The hash of the share isn't masked in the logs: %s", line)
+	}
+}
@@ -0,0 +1,460 @@
+package filemanager
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout is the timeout of the connection to the Redis server and
+// of each command.
+const redisTimeout = 5 * time.Second
+
+// redisPoolSize is the number of idle connections kept open to the Redis
+// server. More are opened when they are all busy.
+const redisPoolSize = 8
+
+var errRedisReply = errors.New("invalid reply from the Redis server")
+
+// redisError is an error replied by the Redis server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisStore is a state store which keeps the state in a Redis server,
+// so it is shared by every instance connected to it. The commands run
+// at once on a pool of connections, which are reopened if they break.
+type RedisStore struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix is prepended to the keys so the database can be shared with
+	// other applications.
+	Prefix string
+
+	once sync.Once
+	pool chan *redisConn
+}
+
+// redisConn is a connection to the Redis server.
+type redisConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+// NewRedisStore returns a state store for the Redis server in the URL,
+// which is like redis://:password@host:port/db. Only the host is
+// required.
+func NewRedisStore(rawurl string) (*RedisStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL: %s", rawurl)
+	}
+
+	s := &RedisStore{Addr: u.Host, Prefix: "filemanager:"}
+	if u.Port() == "" {
+		s.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		s.Password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database: %s", db)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *RedisStore) Get(key string) ([]byte, bool, error) {
+	reply, err := s.do("GET", s.Prefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, errRedisReply
+	}
+
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", s.Prefix + key, string(value)}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+
+	_, err := s.do(args...)
+	return err
+}
+
+func (s *RedisStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", s.Prefix + key, string(value), "NX"}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+
+	// The command isn't retried once sent since the first one may have
+	// set the key.
+	reply, err := s.run(false, args)
+	return reply != nil, err
+}
+
+// redisIncr increments a key and sets its expiration, if any, when it is
+// created.
+const redisIncr = `local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n`
+
+func (s *RedisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = int64(ttl / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+	}
+
+	// The command isn't retried once sent since the first one may have
+	// incremented the key.
+	reply, err := s.run(false, []string{"EVAL", redisIncr, "1", s.Prefix + key, strconv.FormatInt(ms, 10)})
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errRedisReply
+	}
+
+	return n, nil
+}
+
+// redisSwap sets a key to ARGV[3] if its value is ARGV[2], or if it
+// doesn't exist when ARGV[1] is 0, and sets its expiration, if any.
+const redisSwap = `local v = redis.call("GET", KEYS[1])
+if ARGV[1] == "0" then
+	if v then
+		return 0
+	end
+elseif v ~= ARGV[2] then
+	return 0
+end
+if tonumber(ARGV[4]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+else
+	redis.call("SET", KEYS[1], ARGV[3])
+end
+return 1`
+
+func (s *RedisStore) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = int64(ttl / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+	}
+
+	exists := "1"
+	if old == nil {
+		exists = "0"
+	}
+
+	// The command isn't retried once sent since the first one may have
+	// set the key.
+	args := []string{"EVAL", redisSwap, "1", s.Prefix + key, exists, string(old), string(value), strconv.FormatInt(ms, 10)}
+	reply, err := s.run(false, args)
+	if err != nil {
+		return false, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return false, errRedisReply
+	}
+
+	return n == 1, nil
+}
+
+func (s *RedisStore) Delete(key string) error {
+	_, err := s.do("DEL", s.Prefix+key)
+	return err
+}
+
+func (s *RedisStore) Keys(prefix string) ([]string, error) {
+	pattern := redisGlobEscape(s.Prefix+prefix) + "*"
+	keys := []string{}
+	cursor := "0"
+
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, errRedisReply
+		}
+
+		next, ok := parts[0].([]byte)
+		found, ok2 := parts[1].([]interface{})
+		if !ok || !ok2 {
+			return nil, errRedisReply
+		}
+
+		for _, key := range found {
+			if k, ok := key.([]byte); ok {
+				keys = append(keys, strings.TrimPrefix(string(k), s.Prefix))
+			}
+		}
+
+		if cursor = string(next); cursor == "0" {
+			break
+		}
+	}
+
+	// SCAN may return the same key more than once.
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			unique = append(unique, key)
+		}
+	}
+
+	return unique, nil
+}
+
+// do runs an idempotent command and returns its reply.
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	return s.run(true, args)
+}
+
+// run runs a command and returns its reply. It is retried once on a new
+// connection if the one of the pool breaks, since it may have been
+// closed while idle, unless the command isn't idempotent and was already
+// sent.
+func (s *RedisStore) run(idempotent bool, args []string) (interface{}, error) {
+	reply, sent, err := s.roundTrip(args, false)
+	if _, ok := err.(redisError); err == nil || ok || (sent && !idempotent) {
+		return reply, err
+	}
+
+	reply, _, err = s.roundTrip(args, true)
+	return reply, err
+}
+
+// roundTrip sends a command and reads its reply, on a new connection if
+// fresh is true, and tells if the command was sent. The connection goes
+// back to the pool unless there was a network error.
+func (s *RedisStore) roundTrip(args []string, fresh bool) (interface{}, bool, error) {
+	conn, err := s.get(fresh)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	if _, err = conn.Write(redisCommand(args)); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	reply, err := readRedisReply(conn.rd)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.Close()
+		return nil, true, err
+	}
+
+	s.put(conn)
+	return reply, true, err
+}
+
+// get takes an idle connection from the pool, or opens a new one if
+// there is none or fresh is true.
+func (s *RedisStore) get(fresh bool) (*redisConn, error) {
+	s.once.Do(func() {
+		s.pool = make(chan *redisConn, redisPoolSize)
+	})
+
+	if !fresh {
+		select {
+		case conn := <-s.pool:
+			return conn, nil
+		default:
+		}
+	}
+
+	return s.connect()
+}
+
+// put returns the connection to the pool, or closes it if the pool is
+// full.
+func (s *RedisStore) put(conn *redisConn) {
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// connect opens a connection, authenticates and selects the database.
+func (s *RedisStore) connect() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", s.Addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: nc, rd: bufio.NewReader(nc)}
+
+	setup := [][]string{}
+	if s.Password != "" {
+		setup = append(setup, []string{"AUTH", s.Password})
+	}
+
+	if s.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.DB)})
+	}
+
+	for _, args := range setup {
+		conn.SetDeadline(time.Now().Add(redisTimeout))
+		if _, err = conn.Write(redisCommand(args)); err == nil {
+			_, err = readRedisReply(conn.rd)
+		}
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// redisCommand encodes a command as an array of bulk strings.
+func redisCommand(args []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+
+	return b.Bytes()
+}
+
+// readRedisReply reads a reply. Simple and bulk strings are returned as
+// []byte, integers as int64 and arrays as []interface{}. Null replies
+// are nil.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errRedisReply
+	}
+
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return []byte(line), nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, errRedisReply
+		}
+
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisReply
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisReply
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		// The errors in the array are kept so the rest of it is read.
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readRedisReply(r)
+			if e, ok := err.(redisError); ok {
+				items[i] = e
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	}
+
+	return nil, errRedisReply
+}
+
+// redisGlobEscape escapes the special characters of the patterns of
+// SCAN.
+func redisGlobEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
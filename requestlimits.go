@@ -0,0 +1,57 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// The default limits of the requests. They are generous since the URLs of
+// the downloads of many files and the headers of the requests which went
+// through proxies can be long.
+const (
+	defaultMaxURLLength   = 64 << 10
+	defaultMaxHeaderBytes = 64 << 10
+	defaultMaxQueryParams = 1000
+)
+
+var (
+	errURLTooLong      = errors.New("the URL of the request is too long")
+	errHeadersTooLarge = errors.New("the headers of the request are too large")
+	errTooManyParams   = errors.New("the request has too many query parameters")
+)
+
+// checkRequestLimits rejects the requests whose URL, headers or query are
+// larger than the limits, before anything is done with them. The query
+// parameters are counted without parsing them.
+func (m *FileManager) checkRequestLimits(r *http.Request) (int, error) {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	if m.MaxURLLength > 0 && len(uri) > m.MaxURLLength {
+		return http.StatusRequestURITooLong, errURLTooLong
+	}
+
+	if m.MaxQueryParams > 0 && r.URL.RawQuery != "" &&
+		strings.Count(r.URL.RawQuery, "&")+1 > m.MaxQueryParams {
+		return http.StatusRequestURITooLong, errTooManyParams
+	}
+
+	if m.MaxHeaderBytes > 0 {
+		size := 0
+		for name, values := range r.Header {
+			for _, value := range values {
+				// Counts the colon, the space and the line break too.
+				size += len(name) + len(value) + 4
+			}
+		}
+
+		if size > m.MaxHeaderBytes {
+			return http.StatusRequestHeaderFieldsTooLarge, errHeadersTooLarge
+		}
+	}
+
+	return 0, nil
+}
@@ -1,6 +1,10 @@
 package filemanager
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -9,13 +13,23 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hacdias/fileutils"
 )
 
+// deleteConfirmWindow is how long the delete confirmation tokens are valid.
+const deleteConfirmWindow = time.Minute
+
+var (
+	errDeleteConfirm  = errors.New("the delete must be confirmed with the token from a dry-run")
+	errInvalidModTime = errors.New("modification time must be an RFC 3339 timestamp after 1970")
+)
+
 // sanitizeURL sanitizes the URL to prevent path transversal
 // using fileutils.SlashClean and adds the trailing slash bar.
 func sanitizeURL(url string) string {
@@ -35,30 +49,36 @@ func resourceHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	case http.MethodDelete:
 		return resourceDeleteHandler(c, w, r)
 	case http.MethodPut:
-		// Before save command handler.
-		path := filepath.Join(string(c.User.FileSystem), r.URL.Path)
-		if err := c.Runner("before_save", path); err != nil {
-			return http.StatusInternalServerError, err
-		}
+		return idempotent(c, w, r, resourcePutHandler)
+	case http.MethodPatch:
+		return resourcePatchHandler(c, w, r)
+	case http.MethodPost:
+		return idempotent(c, w, r, resourcePostPutHandler)
+	}
 
-		code, err := resourcePostPutHandler(c, w, r)
-		if code != http.StatusOK {
-			return code, err
-		}
+	return methodNotAllowed(w, apiMethods["resource"]...)
+}
 
-		// After save command handler.
-		if err := c.Runner("after_save", path); err != nil {
-			return http.StatusInternalServerError, err
-		}
+// resourcePutHandler saves a file, running the commands before and after
+// it is saved.
+func resourcePutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	// Before save command handler.
+	path := filepath.Join(string(c.User.FileSystem), r.URL.Path)
+	if err := c.Runner("before_save", path); err != nil {
+		return http.StatusInternalServerError, err
+	}
 
+	code, err := resourcePostPutHandler(c, w, r)
+	if code != http.StatusOK {
 		return code, err
-	case http.MethodPatch:
-		return resourcePatchHandler(c, w, r)
-	case http.MethodPost:
-		return resourcePostPutHandler(c, w, r)
 	}
 
-	return http.StatusNotImplemented, nil
+	// After save command handler.
+	if err := c.Runner("after_save", path); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return code, err
 }
 
 func resourceGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -74,6 +94,16 @@ func resourceGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		r.URL.Path = r.URL.Path + "/"
 	}
 
+	// Counts the entries of the directory instead of listing them.
+	if r.URL.Query().Get("action") == "summary" {
+		c.File = f
+		return summaryHandler(c, w, r)
+	}
+
+	if r.URL.Query().Get("action") == "xattrs" {
+		return resourceGetXattrs(c, w, f)
+	}
+
 	// If it is a dir, go and serve the listing.
 	if f.IsDir {
 		c.File = f
@@ -85,6 +115,26 @@ func resourceGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		return errorToHTTP(err, true), err
 	}
 
+	// The ETag lets the clients make conditional deletes of the file.
+	w.Header().Set("ETag", sizeModETag(f.Size, f.ModTime))
+
+	// The text files are only read if they can be previewed, so the
+	// huge ones aren't loaded in memory.
+	if f.Type == "text" {
+		query := r.URL.Query()
+		if query.Get("head") != "" || query.Get("tail") != "" {
+			return resourcePreviewPortion(c, w, r, f)
+		}
+
+		if c.MaxPreviewSize > 0 && f.Size > c.MaxPreviewSize {
+			return previewTooLarge(w, f)
+		}
+
+		if err = f.readContent(); err != nil {
+			return errorToHTTP(err, true), err
+		}
+	}
+
 	// Serve a preview if the file can't be edited or the
 	// user has no permission to edit this file. Otherwise,
 	// just serve the editor.
@@ -103,6 +153,27 @@ func resourceGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 	return renderJSON(w, f)
 }
 
+// defaultMaxPreviewSize is the default maximum size of the previews.
+const defaultMaxPreviewSize = 10 << 20
+
+// previewTooLarge returns 413 Request Entity Too Large with the
+// information of a file larger than MaxPreviewSize, without its
+// content, so the clients can offer to download it instead.
+func previewTooLarge(w http.ResponseWriter, f *file) (int, error) {
+	f.Kind = "preview"
+	f.TooLarge = true
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write(data)
+	return 0, nil
+}
+
 func listingHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	f := c.File
 	f.Kind = "listing"
@@ -129,17 +200,153 @@ func listingHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (
 	}
 
 	listing.ApplySort()
-	listing.Display = displayMode(w, r, cookieScope)
+	listing.Display = displayMode(w, r, cookieScope, c.User)
+
+	thumbs := r.URL.Query().Get("thumbs")
+	algo := r.URL.Query().Get("hash")
+	dirsOnly := r.URL.Query().Get("dirsOnly") == "true"
+
+	// The parameters which change the representation of the
+	// listing are part of the ETag.
+	etag := listing.etag(listing.Sort, listing.Order, listing.Display, thumbs, algo, strconv.FormatBool(dirsOnly))
+	w.Header().Set("ETag", etag)
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return 0, nil
+	}
+
+	// Embeds the thumbnails of the images if requested.
+	if thumbs == "inline" {
+		release, ok := c.acquire(r, limitThumbnail)
+		if !ok {
+			return rejectBusy(w)
+		}
+
+		listing.embedThumbnails(c.thumbnails)
+		release()
+	}
+
+	// Embeds the README of the directory if there is one. The listings of
+	// the directories only are meant for pickers, which don't show it.
+	if c.ReadmeName != "" && !dirsOnly {
+		if err := listing.embedReadme(c.User, c.File.VirtualPath, c.ReadmeName, c.MaxPreviewSize); err != nil {
+			return errorToHTTP(err, true), err
+		}
+	}
+
+	// Embeds the checksums of the files if requested.
+	if algo != "" {
+		if !c.checksumEnabled(algo) {
+			return http.StatusBadRequest, errInvalidOption
+		}
+
+		release, ok := c.acquire(r, limitChecksum)
+		if !ok {
+			return rejectBusy(w)
+		}
+		defer release()
+
+		ok, err := listing.embedChecksums(c.FileManager, algo)
+		if err == errInvalidOption {
+			return http.StatusBadRequest, err
+		} else if err != nil {
+			return errorToHTTP(err, true), err
+		}
+
+		listing.HashesOmitted = !ok
+	}
 
 	return renderJSON(w, f)
 }
 
+// etag returns a weak ETag of the listing computed from the names, sizes
+// and modification times of its items and from the params.
+func (l listing) etag(params ...string) string {
+	hash := sha1.New()
+
+	for _, p := range params {
+		fmt.Fprintf(hash, "%s\n", p)
+	}
+
+	for _, item := range l.Items {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%t\n", item.Name, item.Size, item.ModTime.UnixNano(), item.IsDir)
+	}
+
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// fileETag returns the ETag of a file, which is sent when it is read or
+// written and checked by the conditional deletes.
+func fileETag(info os.FileInfo) string {
+	return sizeModETag(info.Size(), info.ModTime())
+}
+
+// sizeModETag returns the strong ETag of a file with the size and the
+// modification time.
+func sizeModETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`"%x%x"`, modTime.UnixNano(), size)
+}
+
+// etagMatch tells if the ETag is in the value of an If-None-Match or an
+// If-Match header. The If-None-Match headers use the weak comparison and
+// the If-Match ones the strong comparison, where the weak ETags never
+// match (RFC 7232, section 2.3.2).
+func etagMatch(header, etag string, strong bool) bool {
+	if header == "" {
+		return false
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strong && tag == etag {
+			return true
+		}
+
+		if !strong && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func resourceDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Prevent the removal of the root directory.
 	if r.URL.Path == "/" || !c.User.AllowEdit {
 		return http.StatusForbidden, nil
 	}
 
+	// The file isn't deleted if it changed since the client got its ETag.
+	// The directories are only matched by '*' since their ETags depend
+	// on how they are listed.
+	if match := r.Header.Get("If-Match"); match != "" {
+		info, err := c.User.FileSystem.Stat(r.URL.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return errorToHTTP(err, false), err
+		}
+
+		if err != nil || !etagMatch(match, fileETag(info), true) {
+			return http.StatusPreconditionFailed, nil
+		}
+	}
+
+	// Big deletes must be confirmed with the token from a dry-run.
+	if c.DeleteConfirmThreshold > 0 || r.URL.Query().Get("dryRun") == "true" {
+		code, err := resourceDeleteConfirm(c, w, r)
+		if code != 0 || err != nil {
+			return code, err
+		}
+	}
+
 	// Remove the file or folder.
 	err := c.User.FileSystem.RemoveAll(r.URL.Path)
 	if err != nil {
@@ -149,6 +356,96 @@ func resourceDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Req
 	return http.StatusOK, nil
 }
 
+// deleteDryRun is the response of a delete dry-run.
+type deleteDryRun struct {
+	// Entries is the number of files and directories that would be deleted.
+	Entries int `json:"entries"`
+	// Truncated is true if there are more entries than the ones counted.
+	Truncated bool `json:"truncated"`
+	// Token must be sent on the Confirm header to perform the delete.
+	Token string `json:"token,omitempty"`
+}
+
+// resourceDeleteConfirm counts the entries the delete would remove. If the
+// request is a dry-run, it replies with the count and the confirmation token.
+// Otherwise, if the count is above the threshold, it checks the token.
+func resourceDeleteConfirm(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	res := &deleteDryRun{}
+	entries, truncated, err := c.countEntries(c.User, r.URL.Path)
+	if err != nil {
+		return errorToHTTP(err, true), err
+	}
+
+	res.Entries = entries
+	res.Truncated = truncated
+	confirm := c.DeleteConfirmThreshold > 0 &&
+		(res.Truncated || res.Entries > c.DeleteConfirmThreshold)
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		if confirm {
+			expires := strconv.FormatInt(time.Now().Add(deleteConfirmWindow).Unix(), 10)
+			res.Token = expires + "." + sign(c.signingKey(), "delete", c.User.Username, r.URL.Path, expires)
+		}
+
+		if code, err := renderJSON(w, res); err != nil {
+			return code, err
+		}
+
+		return http.StatusOK, nil
+	}
+
+	if confirm && !c.checkDeleteToken(c.User, r.URL.Path, r.Header.Get("Confirm")) {
+		return http.StatusConflict, errDeleteConfirm
+	}
+
+	return 0, nil
+}
+
+// countEntries counts the files and directories a delete of the virtual
+// path of the user would remove. It also returns true if the walk
+// stopped before counting all of them.
+func (m *FileManager) countEntries(u *User, path string) (int, bool, error) {
+	entries := 0
+	scope := string(u.FileSystem)
+	truncated, err := walk(scope, filepath.Join(scope, path), m.MaxDepth, func(path string, info os.FileInfo, err error) error {
+		entries++
+
+		// The delete removes the links, not what they point to.
+		if err == nil && info.IsDir() {
+			if link, err := os.Lstat(path); err == nil && link.Mode()&os.ModeSymlink != 0 {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+
+	return entries, truncated, err
+}
+
+// checkDeleteToken checks if the confirmation token is valid for the
+// user deleting the path and if it hasn't expired yet.
+func (m *FileManager) checkDeleteToken(u *User, path, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	for _, key := range m.verificationKeys() {
+		signature := sign(key, "delete", u.Username, path, parts[0])
+		if hmac.Equal([]byte(signature), []byte(parts[1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func resourcePostPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	if !c.User.AllowNew && r.Method == http.MethodPost {
 		return http.StatusForbidden, nil
@@ -164,12 +461,24 @@ func resourcePostPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Re
 		io.Copy(ioutil.Discard, r.Body)
 	}()
 
+	// Only the new files are checked so the existing ones can still be
+	// saved, even if the policies changed since they were created.
+	if _, err := c.User.FileSystem.Stat(r.URL.Path); err != nil {
+		if err := c.checkUploadPath(c.User, r.URL.Path); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if err := c.checkCaseCollision(c.User, r.URL.Path, ""); err != nil {
+		return http.StatusConflict, err
+	}
+
 	// Checks if the current request is for a directory and not a file.
 	if strings.HasSuffix(r.URL.Path, "/") {
 		// If the method is PUT, we return 405 Method not Allowed, because
 		// POST should be used instead.
 		if r.Method == http.MethodPut {
-			return http.StatusMethodNotAllowed, nil
+			return methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete)
 		}
 
 		// Otherwise we try to create the directory.
@@ -177,6 +486,16 @@ func resourcePostPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Re
 		return errorToHTTP(err, false), err
 	}
 
+	// With 'If-None-Match: *' the file is only created if it doesn't
+	// exist. It is opened exclusively so, of several concurrent creates,
+	// only one succeeds.
+	exclusive := r.Header.Get("If-None-Match") == "*"
+	if exclusive {
+		if _, err := c.User.FileSystem.Stat(r.URL.Path); err == nil {
+			return http.StatusPreconditionFailed, nil
+		}
+	}
+
 	// If using POST method, we are trying to create a new file so it is not
 	// desirable to override an already existent file. Thus, we check
 	// if the file already exists. If so, we just return a 409 Conflict.
@@ -186,25 +505,126 @@ func resourcePostPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	// Create/Open the file.
-	f, err := c.User.FileSystem.OpenFile(r.URL.Path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0776)
+	// The 'Modified' header sets the modification time of the
+	// uploaded file, so it is preserved when restoring or syncing.
+	var modTime time.Time
+	if header := r.Header.Get("Modified"); header != "" {
+		var err error
+		if modTime, err = parseModTime(header); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	// PUT requests with a Content-Range header only write that
+	// region of an existing file.
+	if header := r.Header.Get("Content-Range"); header != "" && r.Method == http.MethodPut {
+		rng, err := parseContentRange(header)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		return resourcePutRange(c, w, r, rng, modTime)
+	}
+
+	// The type of the new content is checked before anything is written.
+	mime, body, err := sniffType(r.Body)
 	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if !c.uploadAllowed(c.User, r.URL.Path, mime) {
+		return http.StatusUnsupportedMediaType, errUploadType
+	}
+
+	// saved tells if the file was written so the empty file reserved by
+	// an exclusive create can be removed if the upload fails.
+	saved := false
+
+	// When there is a scanner, the content is written to a temporary
+	// file which is only moved into place if it passes the scan.
+	name := r.URL.Path
+	if c.Scanner != nil {
+		bytes, err := generateRandomBytes(8)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		name = path.Join(path.Dir(r.URL.Path), partialPrefix+hex.EncodeToString(bytes))
+
+		// The path is reserved before the scan so it's still exclusive
+		// when the temporary file is moved into place.
+		if exclusive {
+			f, err := c.User.FileSystem.OpenFile(r.URL.Path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0776)
+			if os.IsExist(err) {
+				return http.StatusPreconditionFailed, nil
+			} else if err != nil {
+				return errorToHTTP(err, false), err
+			}
+			f.Close()
+
+			defer func() {
+				if !saved {
+					c.User.FileSystem.RemoveAll(r.URL.Path)
+				}
+			}()
+		}
+	}
+
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if exclusive && name == r.URL.Path {
+		flags |= os.O_EXCL
+	}
+
+	// Create/Open the file.
+	f, err := c.User.FileSystem.OpenFile(name, flags, 0776)
+	if exclusive && os.IsExist(err) {
+		return http.StatusPreconditionFailed, nil
+	} else if err != nil {
 		return errorToHTTP(err, false), err
 	}
 	defer f.Close()
 
 	// Copies the new content for the file.
-	_, err = io.Copy(f, r.Body)
+	_, err = io.Copy(f, body)
 	if err != nil {
+		if name != r.URL.Path {
+			c.User.FileSystem.RemoveAll(name)
+		}
+
 		return errorToHTTP(err, false), err
 	}
 
+	if c.Scanner != nil {
+		if code, err := scanFile(c.Scanner, f); err != nil {
+			c.User.FileSystem.RemoveAll(name)
+			return code, err
+		}
+	}
+
+	if name != r.URL.Path {
+		if err = c.User.FileSystem.Rename(name, r.URL.Path); err != nil {
+			c.User.FileSystem.RemoveAll(name)
+			return errorToHTTP(err, false), err
+		}
+	}
+
+	saved = true
+
+	if !modTime.IsZero() {
+		err = os.Chtimes(filepath.Join(string(c.User.FileSystem), r.URL.Path), modTime, modTime)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+	}
+
 	// Gets the info about the file.
 	fi, err := f.Stat()
 	if err != nil {
 		return errorToHTTP(err, false), err
 	}
 
+	c.runUploadHook(c.User, r.URL.Path)
+
 	// Check if this instance has a Static Generator and handles publishing
 	// or scheduling if it's the case.
 	if c.StaticGen != nil {
@@ -215,11 +635,253 @@ func resourcePostPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Re
 	}
 
 	// Writes the ETag Header.
-	etag := fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", fileETag(fi))
 	return http.StatusOK, nil
 }
 
+// uploadHookName is the name of the jobs of the upload hook.
+const uploadHookName = "upload"
+
+// runUploadHook runs the upload hook, if any, on the file at the path of
+// the user. It can't fail the upload so the errors are only logged.
+func (m *FileManager) runUploadHook(u *User, p string) {
+	if m.UploadHook == nil || !m.UploadHook.accepts(p) {
+		return
+	}
+
+	cmd, err := m.UploadHook.command(filepath.Join(string(u.FileSystem), p))
+	if err == nil {
+		_, err = m.runJob(u, uploadHookName, p, cmd)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Could not run the upload hook on %s: %v", p, err)
+	}
+}
+
+// checkUploadPath checks if the policies allow the user to create the file
+// or the directory, if the path ends with a slash, at the path and its
+// parents. The entries matched by the ignore patterns can't be created,
+// nor those with the name of the temporary files of the uploads.
+func (m *FileManager) checkUploadPath(u *User, p string) error {
+	dir := strings.HasSuffix(p, "/")
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	current := ""
+
+	for i, name := range parts {
+		if name == "" {
+			continue
+		}
+
+		current += "/" + name
+
+		if partialFile(name) {
+			return fmt.Errorf("%s: the name is reserved for the uploads", current)
+		}
+
+		if m.ignored(u, current) {
+			return fmt.Errorf("%s: the path matches an ignore pattern", current)
+		}
+
+		// Only the directories which don't exist yet are checked so the
+		// existing ones can still be used.
+		isDir := dir || i < len(parts)-1
+		if !m.NoDotDirs || !isDir || !strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if _, err := u.FileSystem.Stat(current); os.IsNotExist(err) {
+			return fmt.Errorf("%s: directories starting with a dot can't be created", current)
+		}
+	}
+
+	return nil
+}
+
+// newPath returns the path to check with checkUploadPath to create the
+// entry with the info at p.
+func newPath(p string, info os.FileInfo) string {
+	if info.IsDir() {
+		return strings.TrimSuffix(p, "/") + "/"
+	}
+
+	return p
+}
+
+// contentRange is the range of a file written by a request.
+type contentRange struct {
+	start, end int64
+	// total is the size of the file after the write or -1 if unknown.
+	total int64
+}
+
+// parseContentRange parses a Content-Range header in the format
+// 'bytes <start>-<end>/<total>', where total can be '*'.
+func parseContentRange(header string) (*contentRange, error) {
+	errInvalid := errors.New("invalid Content-Range: " + header)
+
+	if !strings.HasPrefix(header, "bytes ") {
+		return nil, errInvalid
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(bounds) != 2 {
+		return nil, errInvalid
+	}
+
+	rng := &contentRange{total: -1}
+	var err error
+
+	if rng.start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil || rng.start < 0 {
+		return nil, errInvalid
+	}
+
+	if rng.end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || rng.end < rng.start {
+		return nil, errInvalid
+	}
+
+	if parts[1] != "*" {
+		if rng.total, err = strconv.ParseInt(parts[1], 10, 64); err != nil || rng.total <= rng.end {
+			return nil, errInvalid
+		}
+	}
+
+	return rng, nil
+}
+
+var (
+	errRangeBody  = errors.New("the body doesn't have the length of the Content-Range")
+	errRangeTotal = errors.New("the Content-Range total is smaller than the file")
+	errRangeStart = errors.New("the Content-Range starts after the end of the file")
+)
+
+// resourcePutRange writes the body of the request to a range of the
+// existing file in the URL. The file is changed in place so a failed
+// write may leave a part of the range written, which the clients send
+// again. If the scanner doesn't accept the file once written, it is
+// removed.
+func resourcePutRange(c *RequestContext, w http.ResponseWriter, r *http.Request, rng *contentRange, modTime time.Time) (int, error) {
+	if code, err := resourceWriteRange(c, r, rng); err != nil {
+		return code, err
+	}
+
+	if c.Scanner != nil {
+		f, err := c.User.FileSystem.OpenFile(r.URL.Path, os.O_RDONLY, 0)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+
+		code, err := scanFile(c.Scanner, f)
+		f.Close()
+		if err != nil {
+			c.User.FileSystem.RemoveAll(r.URL.Path)
+			return code, err
+		}
+	}
+
+	if !modTime.IsZero() {
+		err := os.Chtimes(filepath.Join(string(c.User.FileSystem), r.URL.Path), modTime, modTime)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+	}
+
+	fi, err := c.User.FileSystem.Stat(r.URL.Path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if c.StaticGen != nil {
+		code, err := resourcePublishSchedule(c, w, r)
+		if code != 0 {
+			return code, err
+		}
+	}
+
+	w.Header().Set("ETag", fileETag(fi))
+	return http.StatusOK, nil
+}
+
+// resourceWriteRange writes the body of the request at the offset of the
+// range in the file in the URL. The body must have exactly the length of
+// the range, the declared total size can only make the file bigger and,
+// without one, the range can't start after the end of the file.
+func resourceWriteRange(c *RequestContext, r *http.Request, rng *contentRange) (int, error) {
+	length := rng.end - rng.start + 1
+	if r.ContentLength != -1 && r.ContentLength != length {
+		return http.StatusBadRequest, errRangeBody
+	}
+
+	f, err := c.User.FileSystem.OpenFile(r.URL.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	switch {
+	case rng.total != -1 && rng.total < info.Size():
+		return http.StatusBadRequest, errRangeTotal
+	case rng.total == -1 && rng.start > info.Size():
+		return http.StatusBadRequest, errRangeStart
+	}
+
+	// Only the first bytes of a file decide its type so the ranges which
+	// change them are checked before they are written.
+	var body io.Reader = io.LimitReader(r.Body, length)
+	if rng.start < sniffLength {
+		mime, reader, err := sniffRange(c.User, r.URL.Path, rng, body)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+
+		if !c.uploadAllowed(c.User, r.URL.Path, mime) {
+			return http.StatusUnsupportedMediaType, errUploadType
+		}
+
+		body = reader
+	}
+
+	// The file takes the declared total size.
+	if rng.total > info.Size() {
+		if err = f.Truncate(rng.total); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	offset := rng.start
+
+	for offset <= rng.end {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := f.WriteAt(buf[:n], offset); err != nil {
+				return http.StatusInternalServerError, err
+			}
+
+			offset += int64(n)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errorToHTTP(err, false), err
+		}
+	}
+
+	// Nothing of the body can be left over.
+	if extra, _ := r.Body.Read(make([]byte, 1)); offset != rng.end+1 || extra != 0 {
+		return http.StatusBadRequest, errRangeBody
+	}
+
+	return 0, nil
+}
+
 func resourcePublishSchedule(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	publish := r.Header.Get("Publish")
 	schedule := r.Header.Get("Schedule")
@@ -287,31 +949,128 @@ func resourcePatchHandler(c *RequestContext, w http.ResponseWriter, r *http.Requ
 
 	src := r.URL.Path
 
+	if action == "touch" {
+		return resourceTouch(c, w, r)
+	}
+
+	if action == "share" || action == "share-move" {
+		return resourceShareFolder(c, w, r, action == "share-move")
+	}
+
+	if action == "bulk" {
+		return resourceBulkMove(c, w, r)
+	}
+
+	if action == "xattrs" {
+		return resourceSetXattrs(c, w, r)
+	}
+
 	if dst == "/" || src == "/" {
 		return http.StatusForbidden, nil
 	}
 
+	// Renames may only change the case of the name of the file.
+	except := src
+	if action == "copy" {
+		except = ""
+	}
+
+	if err = c.checkCaseCollision(c.User, dst, except); err != nil {
+		return http.StatusConflict, err
+	}
+
+	// The files can't get a name, or a copy, the upload policies don't
+	// allow.
+	info, err := c.User.FileSystem.Stat(src)
+	if err != nil {
+		return errorToHTTP(err, true), err
+	}
+
+	if err = c.checkUploadPath(c.User, newPath(dst, info)); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if !info.IsDir() {
+		ok, err := c.fileAllowed(c.User, src, dst)
+		if err != nil {
+			return errorToHTTP(err, true), err
+		}
+
+		if !ok {
+			return http.StatusUnsupportedMediaType, errUploadType
+		}
+	}
+
 	if action == "copy" {
 		err = c.User.FileSystem.Copy(src, dst)
-	} else {
-		err = c.User.FileSystem.Rename(src, dst)
+		return errorToHTTP(err, true), err
+	}
+
+	if err = c.User.FileSystem.Rename(src, dst); err != nil {
+		return errorToHTTP(err, true), err
+	}
+
+	// The metadata of the files follows them.
+	err = c.moveMetadata(
+		filepath.Join(string(c.User.FileSystem), src),
+		filepath.Join(string(c.User.FileSystem), dst),
+	)
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// parseModTime parses a modification time sent by a client.
+func parseModTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil || t.Before(time.Unix(0, 0)) {
+		return time.Time{}, errInvalidModTime
+	}
+
+	return t, nil
+}
+
+// resourceTouch sets the modification time of the file to the one in the
+// 'Modified' header and replies with the updated time.
+func resourceTouch(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	modTime, err := parseModTime(r.Header.Get("Modified"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	path := filepath.Join(string(c.User.FileSystem), r.URL.Path)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	// The file system may store the time with less precision.
+	info, err := os.Stat(path)
+	if err != nil {
+		return errorToHTTP(err, false), err
 	}
 
-	return errorToHTTP(err, true), err
+	w.Header().Set("ETag", fileETag(info))
+	return renderJSON(w, struct {
+		Modified time.Time `json:"modified"`
+	}{info.ModTime()})
 }
 
-// displayMode obtains the display mode from the Cookie.
-func displayMode(w http.ResponseWriter, r *http.Request, scope string) string {
-	var displayMode string
+// displayMode obtains the display mode from the view mode of the user or,
+// if it isn't set, from the Cookie.
+func displayMode(w http.ResponseWriter, r *http.Request, scope string, u *User) string {
+	displayMode := u.ViewMode
 
 	// Checks the cookie.
-	if displayCookie, err := r.Cookie("display"); err == nil {
+	if displayCookie, err := r.Cookie("display"); err == nil && displayMode == "" {
 		displayMode = displayCookie.Value
 	}
 
 	// If it's invalid, set it to mosaic, which is the default.
-	if displayMode == "" || (displayMode != "mosaic" && displayMode != "list") {
-		displayMode = "mosaic"
+	if displayMode == "" || checkViewMode(displayMode) != nil {
+		displayMode = defaultViewMode
 	}
 
 	// Set the cookie.
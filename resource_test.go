@@ -0,0 +1,1041 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRangedPut(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	path := filepath.Join(fm.Temp, "scope", "file.txt")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	put := func(name, rng, body string) int {
+		r, err := http.NewRequest("PUT", "/api/resource/"+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Content-Range", rng)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		rng, body string
+		code      int
+		content   string
+	}{
+		{"bytes 2-3/10", "ab", http.StatusOK, "01ab456789"},
+		{"bytes 8-11/12", "wxyz", http.StatusOK, "01ab4567wxyz"},
+		{"bytes 0-1/*", "AB", http.StatusOK, "ABab4567wxyz"},
+		{"bytes 0-1/4", "CD", http.StatusBadRequest, "ABab4567wxyz"},
+		{"bytes 0-1/*", "CDE", http.StatusBadRequest, "ABab4567wxyz"},
+		{"bytes 0-3/*", "CD", http.StatusBadRequest, "ABab4567wxyz"},
+		{"bytes 0-5/4", "012345", http.StatusBadRequest, "ABab4567wxyz"},
+		{"bytes 3-1/*", "", http.StatusBadRequest, "ABab4567wxyz"},
+		{"items 0-1/*", "xy", http.StatusBadRequest, "ABab4567wxyz"},
+		{"bytes 13-14/*", "xy", http.StatusBadRequest, "ABab4567wxyz"},
+	}
+
+	for _, test := range tests {
+		if code := put("file.txt", test.rng, test.body); code != test.code {
+			t.Errorf("%s: got %v, want %v", test.rng, code, test.code)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != test.content {
+			t.Errorf("%s: got content %q, want %q", test.rng, data, test.content)
+		}
+	}
+
+	// The ranges are written in place.
+	if after, err := os.Stat(path); err != nil || !os.SameFile(before, after) {
+		t.Errorf("Ranged writes replaced the file: %v", err)
+	}
+
+	if code := put("missing.txt", "bytes 0-1/*", "ab"); code != http.StatusNotFound {
+		t.Errorf("Ranged write created a file: got %v", code)
+	}
+
+	// The bodies of unknown length are checked once the range is written.
+	r, err := http.NewRequest("PUT", "/api/resource/file.txt", ioutil.NopCloser(strings.NewReader("xyz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.ContentLength = -1
+	r.Header.Set("Content-Range", "bytes 0-1/*")
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if data, _ := ioutil.ReadFile(path); w.Code != http.StatusBadRequest || string(data) != "xyab4567wxyz" {
+		t.Errorf("Longer body of unknown length: got %v %q", w.Code, data)
+	}
+
+	names, err := filepath.Glob(filepath.Join(fm.Temp, "scope", partialPrefix+"*"))
+	if err != nil || len(names) != 0 {
+		t.Errorf("Ranged writes left temporary files: %v %v", names, err)
+	}
+
+	// Ranged writes also work with the scanner.
+	fm.Scanner = noopScanner{}
+	if code := put("file.txt", "bytes 1-2/*", "xy"); code != http.StatusOK {
+		t.Errorf("Ranged write with scanner failed: got %v", code)
+	}
+
+	if data, _ := ioutil.ReadFile(path); string(data) != "xxyb4567wxyz" {
+		t.Errorf("Ranged write with scanner: got %q", data)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope", "summary")
+	files := map[string]string{
+		"a.txt":             "12345",
+		"dir/b.txt":         "123",
+		"dir/sub/c.txt":     "1",
+		"node_modules/d.js": "1234567890",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(scope, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.Ignore = []string{"node_modules"}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		query string
+		want  summary
+	}{
+		{"", summary{Files: 1, Dirs: 1, Size: 5}},
+		{"&hidden=true", summary{Files: 1, Dirs: 2, Size: 5}},
+		{"&recursive=true", summary{Files: 3, Dirs: 2, Size: 9, Recursive: true}},
+		{"&recursive=true&hidden=true", summary{Files: 4, Dirs: 3, Size: 19, Recursive: true}},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/api/resource/summary/?action=summary"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var got summary
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v: %s", test.query, err, w.Body.String())
+		}
+
+		if got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestExclusiveCreate(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	create := func(name, body string) int {
+		r, err := http.NewRequest("POST", "/api/resource/"+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("If-None-Match", "*")
+		r.Header.Set("Action", "override")
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, scanner := range []Scanner{nil, noopScanner{}} {
+		fm.Scanner = scanner
+		name := fmt.Sprintf("file-%T.txt", scanner)
+
+		var (
+			wg    sync.WaitGroup
+			codes = make(chan int, 10)
+		)
+
+		for i := 0; i < cap(codes); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes <- create(name, fmt.Sprint(i))
+			}(i)
+		}
+
+		wg.Wait()
+		close(codes)
+
+		created := 0
+		for code := range codes {
+			switch code {
+			case http.StatusOK:
+				created++
+			case http.StatusPreconditionFailed:
+			default:
+				t.Errorf("%T: unexpected status %v", scanner, code)
+			}
+		}
+
+		if created != 1 {
+			t.Errorf("%T: %d creates succeeded, want 1", scanner, created)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(fm.Temp, "scope", name))
+		if err != nil || len(data) == 0 {
+			t.Errorf("%T: the file wasn't written: %v", scanner, err)
+		}
+	}
+
+	// A failed scan doesn't leave the reserved file behind.
+	fm.Scanner = eicarScanner{}
+	if code := create("infected.txt", "EICAR"); code != http.StatusUnprocessableEntity {
+		t.Errorf("Infected create: got %v", code)
+	}
+
+	if _, err := os.Stat(filepath.Join(fm.Temp, "scope", "infected.txt")); !os.IsNotExist(err) {
+		t.Errorf("The reserved file wasn't removed")
+	}
+}
+
+func TestModTime(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	path := filepath.Join(fm.Temp, "scope", "file.txt")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func(method, name, modified string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "/api/resource/"+name, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Modified", modified)
+		if method == "PATCH" {
+			r.Header.Set("Action", "touch")
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	modTime := func(name string) time.Time {
+		info, err := os.Stat(filepath.Join(fm.Temp, "scope", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return info.ModTime()
+	}
+
+	want := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	w := request("PATCH", "file.txt", want.Format(time.RFC3339))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Touch: got %v", w.Code)
+	}
+
+	var res struct {
+		Modified time.Time `json:"modified"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Modified.Equal(want) || !modTime("file.txt").Equal(want) {
+		t.Errorf("Touch: expected %v, got %v and %v", want, res.Modified, modTime("file.txt"))
+	}
+
+	for _, invalid := range []string{"", "yesterday", "1969-12-31T23:59:59Z"} {
+		if w = request("PATCH", "file.txt", invalid); w.Code != http.StatusBadRequest {
+			t.Errorf("Touch with %q: expected 400, got %v", invalid, w.Code)
+		}
+	}
+
+	if w = request("PATCH", "missing.txt", want.Format(time.RFC3339)); w.Code != http.StatusNotFound {
+		t.Errorf("Touch of missing file: expected 404, got %v", w.Code)
+	}
+
+	// Uploads can also set the modification time.
+	if w = request("POST", "upload.txt", want.Format(time.RFC3339)); w.Code != http.StatusOK {
+		t.Fatalf("Upload: got %v", w.Code)
+	}
+
+	if !modTime("upload.txt").Equal(want) {
+		t.Errorf("Upload: expected %v, got %v", want, modTime("upload.txt"))
+	}
+
+	if w = request("POST", "invalid.txt", "never"); w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with invalid time: expected 400, got %v", w.Code)
+	}
+
+	fm.Users["admin"].AllowEdit = false
+	if w = request("PATCH", "file.txt", want.Format(time.RFC3339)); w.Code != http.StatusForbidden {
+		t.Errorf("Touch without permission: expected 403, got %v", w.Code)
+	}
+}
+
+func TestUploadPathPolicy(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.NoDotDirs = true
+	fm.Ignore = []string{"node_modules"}
+
+	if err := os.Mkdir(filepath.Join(fm.Temp, "scope", ".existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{".hidden.txt", http.StatusOK},
+		{".existing/file.txt", http.StatusOK},
+		{"dir/", http.StatusOK},
+		{".new/", http.StatusBadRequest},
+		{"dir/.new/", http.StatusBadRequest},
+		{".new/file.txt", http.StatusBadRequest},
+		{"node_modules/", http.StatusBadRequest},
+		{"dir/node_modules/file.txt", http.StatusBadRequest},
+		{"dir/.upload-1234", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "/api/resource/"+test.path, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected %v, got %v: %s", test.path, test.code, w.Code, w.Body.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(fm.Temp, "scope", ".new")); !os.IsNotExist(err) {
+		t.Errorf("Forbidden directory was created: %v", err)
+	}
+
+	// The existing files can still be edited.
+	if err := os.MkdirAll(filepath.Join(fm.Temp, "scope", "node_modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "node_modules", "old.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string, headers map[string]string) int {
+		r, err := http.NewRequest(method, "/api/resource/"+path, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("PUT", "node_modules/old.txt", nil); code != http.StatusOK {
+		t.Errorf("Edit of an existing file: expected 200, got %v", code)
+	}
+
+	// The destinations of the renames and the copies are checked too.
+	moves := []struct {
+		action, path, dst string
+	}{
+		{"rename", ".hidden.txt", "/node_modules/moved.txt"},
+		{"copy", ".hidden.txt", "/.upload-1234"},
+		{"rename", "dir", "/.dir"},
+	}
+
+	for _, test := range moves {
+		code := do("PATCH", test.path, map[string]string{"Action": test.action, "Destination": test.dst})
+		if code != http.StatusBadRequest {
+			t.Errorf("%s to %s: expected 400, got %v", test.action, test.dst, code)
+		}
+	}
+
+	r, err := http.NewRequest("PATCH", "/api/resource/node_modules/", strings.NewReader(`{"items": ["/.hidden.txt"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Action", "bulk")
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if !strings.Contains(w.Body.String(), `"status":400`) {
+		t.Errorf("Bulk move into an ignored directory: got %s", w.Body.String())
+	}
+}
+
+func TestPreviewTooLarge(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := ioutil.WriteFile(filepath.Join(scope, "large.txt"), []byte(strings.Repeat("a", 2048)), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func() (int, *file) {
+		r, err := http.NewRequest("GET", "/api/resource/large.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		f := &file{}
+		if err := json.Unmarshal(w.Body.Bytes(), f); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Code, f
+	}
+
+	if code, f := get(); code != http.StatusOK || len(f.Content) != 2048 || f.TooLarge {
+		t.Errorf("Under the limit: got %v with %d bytes", code, len(f.Content))
+	}
+
+	fm.MaxPreviewSize = 1024
+	code, f := get()
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Over the limit: got %v", code)
+	}
+
+	if !f.TooLarge || f.Content != "" || f.Size != 2048 || f.Kind != "preview" {
+		t.Errorf("Over the limit: got %+v", f)
+	}
+}
+
+func TestConditionalDelete(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	path := filepath.Join(fm.Temp, "scope", "file.txt")
+	if err := os.MkdirAll(filepath.Join(fm.Temp, "scope", "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func(method, name, match, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "/api/resource/"+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		if match != "" {
+			r.Header.Set("If-Match", match)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("POST", "file.txt", "", "content")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload: got %v", w.Code)
+	}
+
+	etag := w.Header().Get("ETag")
+
+	// The file changes after the client got its ETag.
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if w = request("DELETE", "file.txt", etag, ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Delete with a stale ETag: expected 412, got %v", w.Code)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("The file was deleted with a stale ETag: %v", err)
+	}
+
+	// The clients get the ETag when they read the file.
+	if w = request("GET", "file.txt", "", ""); w.Header().Get("ETag") != fileETag(info) {
+		t.Errorf("Get: got the ETag %q, want %q", w.Header().Get("ETag"), fileETag(info))
+	}
+
+	r, err := http.NewRequest("GET", "/api/download/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("If-None-Match", fileETag(info))
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotModified || w.Header().Get("ETag") != fileETag(info) {
+		t.Errorf("Conditional download: got %v with the ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// The weak ETags never match.
+	if w = request("DELETE", "file.txt", "W/"+fileETag(info), ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Delete with a weak ETag: expected 412, got %v", w.Code)
+	}
+
+	if w = request("DELETE", "file.txt", fileETag(info), ""); w.Code != http.StatusOK {
+		t.Errorf("Delete with the current ETag: expected 200, got %v", w.Code)
+	}
+
+	if w = request("DELETE", "file.txt", "*", ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Delete of a missing file: expected 412, got %v", w.Code)
+	}
+
+	if w = request("DELETE", "dir", `"0"`, ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Delete of a directory with an ETag: expected 412, got %v", w.Code)
+	}
+
+	if w = request("DELETE", "dir", "*", ""); w.Code != http.StatusOK {
+		t.Errorf("Delete of a directory with '*': expected 200, got %v", w.Code)
+	}
+}
+
+func TestDeleteConfirm(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.DeleteConfirmThreshold = 3
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, name := range []string{"big/a.txt", "big/b.txt", "big/c.txt", "small/a.txt", "linked/target/a.txt", "linked/target/b.txt", "linked/target/c.txt"} {
+		if err := os.MkdirAll(filepath.Join(scope, filepath.Dir(name)), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(scope, name), []byte("content"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(scope, "links"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(scope, "linked", "target"), filepath.Join(scope, "links", "target")); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	remove := func(url, confirm string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("DELETE", "/api/resource/"+url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		if confirm != "" {
+			r.Header.Set("Confirm", confirm)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	dryRun := func(name string) *deleteDryRun {
+		w := remove(name+"?dryRun=true", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Dry-run of %s: got %v", name, w.Code)
+		}
+
+		res := &deleteDryRun{}
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(scope, name))
+		return err == nil
+	}
+
+	// The directory and its files are counted.
+	res := dryRun("big")
+	if res.Entries != 4 || res.Truncated || res.Token == "" || !exists("big") {
+		t.Errorf("Dry-run: got %+v", res)
+	}
+
+	if w := remove("big", ""); w.Code != http.StatusConflict || !exists("big") {
+		t.Errorf("Unconfirmed delete: got %v", w.Code)
+	}
+
+	if w := remove("big", dryRun("small").Token); w.Code != http.StatusConflict {
+		t.Errorf("Delete with the token of a small directory: got %v", w.Code)
+	}
+
+	if w := remove("big", "1."+res.Token[strings.Index(res.Token, ".")+1:]); w.Code != http.StatusConflict {
+		t.Errorf("Delete with an expired token: got %v", w.Code)
+	}
+
+	if w := remove("big", res.Token); w.Code != http.StatusOK || exists("big") {
+		t.Errorf("Confirmed delete: got %v", w.Code)
+	}
+
+	// The small directories don't need a token.
+	if res = dryRun("small"); res.Entries != 2 || res.Token != "" {
+		t.Errorf("Dry-run of a small directory: got %+v", res)
+	}
+
+	if w := remove("small", ""); w.Code != http.StatusOK || exists("small") {
+		t.Errorf("Delete of a small directory: got %v", w.Code)
+	}
+
+	// The links are removed, not the files they point to, so those
+	// aren't counted.
+	if res = dryRun("links"); res.Entries != 2 || res.Token != "" {
+		t.Errorf("Dry-run of a directory with a link: got %+v", res)
+	}
+
+	if w := remove("links", ""); w.Code != http.StatusOK || exists("links") || !exists("linked/target/a.txt") {
+		t.Errorf("Delete of a directory with a link: got %v", w.Code)
+	}
+}
+
+func TestDirsOnlyListing(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, dir := range []string{"docs", "secret", "node_modules"} {
+		if err := os.MkdirAll(filepath.Join(scope, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(scope, "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.Ignore = []string{"node_modules"}
+	fm.Users["admin"].Rules = []*Rule{{Path: "/secret", Allow: false}}
+
+	token := login(t, fm, defaultCredentials)
+
+	r, err := http.NewRequest("GET", "/api/resource/?dirsOnly=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Listing: got %v", w.Code)
+	}
+
+	res := &listing{}
+	if err = json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, item := range res.Items {
+		names = append(names, item.Name)
+	}
+
+	if len(names) != 1 || names[0] != "docs" {
+		t.Errorf("Expected only the allowed directories, got %v", names)
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := os.MkdirAll(filepath.Join(scope, "Docs"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(scope, "Docs", "README.md"), []byte("readme"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func(method, name, dst string) int {
+		r, err := http.NewRequest(method, "/api/resource"+name, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		if dst != "" {
+			r.Header.Set("Destination", dst)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// The names differing only in case are allowed by default.
+	if code := request("POST", "/Docs/readme.md", ""); code != http.StatusOK {
+		t.Errorf("Without the option: got %v", code)
+	}
+
+	if err := os.Remove(filepath.Join(scope, "Docs", "readme.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.NoCaseCollisions = true
+
+	tests := []struct {
+		method, name, dst string
+		code              int
+	}{
+		{"POST", "/Docs/readme.md", "", http.StatusConflict},
+		{"POST", "/docs/", "", http.StatusConflict},
+		{"POST", "/docs/new.txt", "", http.StatusConflict},
+		{"PUT", "/Docs/README.md", "", http.StatusOK},
+		{"POST", "/Docs/other.md", "", http.StatusOK},
+		{"PATCH", "/Docs/other.md", "/Docs/Readme.md", http.StatusConflict},
+		// Renames can change the case of the name.
+		{"PATCH", "/Docs/README.md", "/Docs/Readme.md", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		if code := request(test.method, test.name, test.dst); code != test.code {
+			t.Errorf("%s %s %s: got %v want %v", test.method, test.name, test.dst, code, test.code)
+		}
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	name := filepath.Join(fm.Temp, "scope", "tagged.txt")
+	if err := ioutil.WriteFile(name, []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func(method, body string) (int, map[string]string) {
+		r, err := http.NewRequest(method, "/api/resource/tagged.txt?action=xattrs", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Action", "xattrs")
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		attrs := map[string]string{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &attrs); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, attrs
+	}
+
+	if code, _ := request("GET", ""); code != http.StatusNotImplemented {
+		t.Errorf("Without the option: got %v", code)
+	}
+
+	fm.Xattrs = true
+
+	code, attrs := request("GET", "")
+	if code != http.StatusOK || len(attrs) != 0 {
+		t.Fatalf("Got %v with %v, want no attributes", code, attrs)
+	}
+
+	if code, _ := request("PATCH", `{"trusted.tag": "red"}`); code != http.StatusBadRequest {
+		t.Errorf("Setting a system attribute: got %v", code)
+	}
+
+	if !xattrWritable("user.tag") {
+		t.Skip("the extended attributes aren't supported")
+	}
+
+	code, attrs = request("PATCH", `{"user.tag": "red"}`)
+	if code == http.StatusInternalServerError {
+		t.Skip("the file system doesn't support the extended attributes")
+	}
+
+	if code != http.StatusOK || attrs["user.tag"] != "red" {
+		t.Errorf("Got %v with %v, want the tag", code, attrs)
+	}
+
+	if code, attrs = request("GET", ""); attrs["user.tag"] != "red" {
+		t.Errorf("Got %v with %v, want the tag", code, attrs)
+	}
+
+	code, attrs = request("PATCH", `{"user.tag": null}`)
+	if _, ok := attrs["user.tag"]; code != http.StatusOK || ok {
+		t.Errorf("Got %v with %v, want the tag removed", code, attrs)
+	}
+}
+
+func TestPreviewPortion(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	var content bytes.Buffer
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+
+	name := filepath.Join(fm.Temp, "scope", "app.log.txt")
+	if err := ioutil.WriteFile(name, content.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		query   string
+		max     int64
+		code    int
+		content string
+		partial bool
+	}{
+		{"?head=3", 0, http.StatusOK, "line 1\nline 2\nline 3\n", true},
+		{"?tail=2", 0, http.StatusOK, "line 4999\nline 5000\n", true},
+		{"?head=5&unit=bytes", 0, http.StatusOK, "line ", true},
+		{"?tail=2&unit=bytes", 0, http.StatusOK, "0\n", true},
+		{"?tail=10000", 0, http.StatusOK, content.String(), false},
+		{"?head=3", 10, http.StatusOK, "line 1\nlin", true},
+		{"?tail=3", 10, http.StatusOK, "line 5000\n", true},
+		{"?head=0", 0, http.StatusBadRequest, "", false},
+		{"?head=1&tail=1", 0, http.StatusBadRequest, "", false},
+		{"?head=1&unit=pages", 0, http.StatusBadRequest, "", false},
+	}
+
+	for _, test := range tests {
+		fm.MaxPreviewSize = test.max
+
+		r, err := http.NewRequest("GET", "/api/resource/app.log.txt"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: got status %v, want %v", test.query, w.Code, test.code)
+			continue
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		f := &file{}
+		if err := json.Unmarshal(w.Body.Bytes(), f); err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Content != test.content || f.Partial != test.partial || f.Kind != "preview" {
+			t.Errorf("%s: got kind %s, partial %v and %.40q, want partial %v and %.40q", test.query, f.Kind, f.Partial, f.Content, test.partial, test.content)
+		}
+	}
+
+	// The last lines may span several chunks.
+	fm.MaxPreviewSize = 0
+	tail, err := readTail(bytes.NewReader(content.Bytes()), int64(content.Len()), 4000, true, 0)
+	if err != nil || !strings.HasPrefix(string(tail), "line 1001\n") || !strings.HasSuffix(string(tail), "line 5000\n") {
+		t.Errorf("Got %.20q...: %v", tail, err)
+	}
+
+	// Files may not end with a line break.
+	tail, _ = readTail(strings.NewReader("a\nb\nc"), 5, 2, true, 0)
+	if string(tail) != "b\nc" {
+		t.Errorf("Without the final line break: got %q", tail)
+	}
+}
+
+func TestIdempotentUpload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	upload := func(method, url, key, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			r.Header.Set(idempotencyHeader, key)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	first := upload("POST", "/api/resource/a.txt", "key-1", "one")
+	if first.Code != http.StatusOK {
+		t.Fatalf("First upload: expected 200, got %v", first.Code)
+	}
+
+	// The retry of a POST would conflict with the file of the first one.
+	retry := upload("POST", "/api/resource/a.txt", "key-1", "two")
+	if retry.Code != http.StatusOK {
+		t.Fatalf("Retry: expected 200, got %v", retry.Code)
+	}
+
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Retry: the result wasn't replayed")
+	}
+
+	if etag := retry.Header().Get("ETag"); etag == "" || etag != first.Header().Get("ETag") {
+		t.Errorf("Retry: got the ETag %q, want %q", etag, first.Header().Get("ETag"))
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(fm.Temp, "scope", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "one" {
+		t.Errorf("The retry wrote the file again: got %q", content)
+	}
+
+	// The failed uploads aren't kept so they can be retried.
+	if code := upload("POST", "/api/resource/a.txt", "key-2", "two").Code; code != http.StatusConflict {
+		t.Fatalf("Upload over an existing file: expected 409, got %v", code)
+	}
+
+	if _, ok, _ := fm.state().Get(idempotencyKey("admin", "key-2")); ok {
+		t.Error("The result of a failed upload was kept")
+	}
+
+	if code := upload("PUT", "/api/resource/a.txt", "key-2", "two").Code; code != http.StatusOK {
+		t.Errorf("Retry of a failed upload: expected 200, got %v", code)
+	}
+
+	err = fm.state().Set(idempotencyKey("admin", "key-3"), []byte(`{"method":"PUT","path":"/b.txt","pending":true}`), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, url, key string
+		code             int
+	}{
+		{"PUT", "/api/resource/b.txt", "key-3", http.StatusConflict},
+		{"PUT", "/api/resource/c.txt", "key-1", http.StatusUnprocessableEntity},
+		{"PUT", "/api/resource/c.txt", "bad key", http.StatusBadRequest},
+		{"PUT", "/api/resource/c.txt", strings.Repeat("k", maxIdempotencyKey+1), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if code := upload(test.method, test.url, test.key, "three").Code; code != test.code {
+			t.Errorf("%s %s with the key %.20q: expected %v, got %v", test.method, test.url, test.key, test.code, code)
+		}
+	}
+
+	// The keys of the requests which crashed can be used again once their
+	// pending records expire.
+	err = fm.state().Set(idempotencyKey("admin", "key-4"), []byte(`{"method":"PUT","path":"/d.txt","pending":true}`), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if code := upload("PUT", "/api/resource/d.txt", "key-4", "four").Code; code != http.StatusOK {
+		t.Errorf("Upload with the key of a crashed request: expected 200, got %v", code)
+	}
+
+	fm.IdempotencyWindow = -1
+	if code := upload("POST", "/api/resource/a.txt", "key-1", "two").Code; code != http.StatusConflict {
+		t.Errorf("Retry without idempotency keys: expected 409, got %v", code)
+	}
+}
@@ -730,14 +730,19 @@ func init() {
 	}
 	file7m := &embedded.EmbeddedFile{
 		Filename:    "static/share/index.html",
-		FileModTime: time.Unix(1502791607, 0),
-		Content:     string("<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n  <meta charset=\"utf-8\">\r\n  <meta http-equiv=\"X-UA-Compatible\" content=\"IE=edge\">\r\n  <meta name=\"viewport\" content=\"width=device-width, initial-scale=1, user-scalable=no\">\r\n  <title>{{ .File.Name }}</title>\r\n  <link rel=\"icon\" type=\"image/png\" sizes=\"32x32\" href=\"{{ .BaseURL }}/static/img/icons/favicon-32x32.png\">\r\n  <link rel=\"icon\" type=\"image/png\" sizes=\"16x16\" href=\"{{ .BaseURL }}/static/img/icons/favicon-16x16.png\">\r\n  <!--[if IE]><link rel=\"shortcut icon\" href=\"{{ .BaseURL }}/static/img/icons/favicon.ico\"><![endif]-->\r\n  <link rel=\"manifest\" href=\"{{ .BaseURL }}/static/manifest.json\">\r\n  <meta name=\"theme-color\" content=\"#2979ff\">\r\n  <meta name=\"apple-mobile-web-app-capable\" content=\"yes\">\r\n  <meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black\">\r\n  <meta name=\"apple-mobile-web-app-title\" content=\"assets\">\r\n  <link rel=\"apple-touch-icon\" href=\"{{ .BaseURL }}/static/img/icons/apple-touch-icon-152x152.png\">\r\n  <meta name=\"msapplication-TileImage\" content=\"{{ .BaseURL }}/static/img/icons/msapplication-icon-144x144.png\">\r\n  <meta name=\"msapplication-TileColor\" content=\"#2979ff\">\r\n\r\n  <link rel=\"stylesheet\" href=\"https://cdnjs.cloudflare.com/ajax/libs/normalize/7.0.0/normalize.min.css\">\r\n  <style>\r\n    * {\r\n      box-sizing: border-box\r\n    }\r\n    body {\r\n      font-family: Arial, sans-serif;\r\n      color: #6f6f6f;\r\n      background: #f8f8f8;\r\n    }\r\n    a {\r\n      text-decoration: none;\r\n      color: inherit;\r\n    }\r\n    body > a  {\r\n      text-align: center;\r\n      position: absolute;\r\n      transform: translate(-50%, -50%);\r\n      top: 50%;\r\n      left: 50%;\r\n      box-shadow: rgba(0, 0, 0, 0.06) 0px 1px 3px, rgba(0, 0, 0, 0.12) 0px 1px 2px;\r\n      background: #fff;\r\n      display: block;\r\n      border-radius: 0.2em;\r\n      width: 90%;\r\n      max-width: 25em;\r\n    }\r\n    body > a > div:first-child {\r\n      width: 100%;\r\n      padding: 1em;\r\n      cursor: pointer;\r\n      background: #ffffff;\r\n      color: rgba(0, 0, 0, 0.5);\r\n      border-bottom: 1px solid rgba(0, 0, 0, 0.05);\r\n    }\r\n    body > a > div:last-child {\r\n      padding: 2em 3em;\r\n    }\r\n    body > a * {\r\n      margin: 0;\r\n    }\r\n    body > a h1 {\r\n      margin-top: .2em;\r\n    }\r\n  </style>\r\n</head>\r\n<body>\r\n  <a href=\"?dl=1\">\r\n    <div>Download {{ if .File.IsDir }}Folder{{ else }}File{{ end }}</div>\r\n    <div>\r\n      {{ if .File.IsDir -}}\r\n      <svg fill=\"#40c4ff\" height=\"150\" viewBox=\"0 0 24 24\" width=\"150\" xmlns=\"http://www.w3.org/2000/svg\">\r\n        <path d=\"M10 4H4c-1.1 0-1.99.9-1.99 2L2 18c0 1.1.9 2 2 2h16c1.1 0 2-.9 2-2V8c0-1.1-.9-2-2-2h-8l-2-2z\"/>\r\n        <path d=\"M0 0h24v24H0z\" fill=\"none\"/>\r\n      </svg>\r\n      {{ else -}}\r\n      <svg fill=\"#40c4ff\" height=\"150\" viewBox=\"0 0 24 24\" width=\"150\" xmlns=\"http://www.w3.org/2000/svg\">\r\n        <path d=\"M6 2c-1.1 0-1.99.9-1.99 2L4 20c0 1.1.89 2 1.99 2H18c1.1 0 2-.9 2-2V8l-6-6H6zm7 7V3.5L18.5 9H13z\"/>\r\n        <path d=\"M0 0h24v24H0z\" fill=\"none\"/>\r\n      </svg>\r\n      {{ end -}}\r\n      <h1>{{ .File.Name }}</h1>\r\n      </div>\r\n  </a>\r\n</body>\r\n</html>\r\n"),
+		FileModTime: time.Unix(1792042199, 0),
+		Content:     string("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n  <meta charset=\"utf-8\">\n  <meta http-equiv=\"X-UA-Compatible\" content=\"IE=edge\">\n  <meta name=\"viewport\" content=\"width=device-width, initial-scale=1, user-scalable=no\">\n  <title>{{ if .Title }}{{ .Title }}{{ else }}{{ .File.Name }}{{ end }}</title>\n  <link rel=\"icon\" type=\"image/png\" sizes=\"32x32\" href=\"{{ .BaseURL }}/static/img/icons/favicon-32x32.png\">\n  <link rel=\"icon\" type=\"image/png\" sizes=\"16x16\" href=\"{{ .BaseURL }}/static/img/icons/favicon-16x16.png\">\n  <!--[if IE]><link rel=\"shortcut icon\" href=\"{{ .BaseURL }}/static/img/icons/favicon.ico\"><![endif]-->\n  <link rel=\"manifest\" href=\"{{ .BaseURL }}/static/manifest.json\">\n  <meta name=\"theme-color\" content=\"#2979ff\">\n  <meta name=\"apple-mobile-web-app-capable\" content=\"yes\">\n  <meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black\">\n  <meta name=\"apple-mobile-web-app-title\" content=\"assets\">\n  <link rel=\"apple-touch-icon\" href=\"{{ .BaseURL }}/static/img/icons/apple-touch-icon-152x152.png\">\n  <meta name=\"msapplication-TileImage\" content=\"{{ .BaseURL }}/static/img/icons/msapplication-icon-144x144.png\">\n  <meta name=\"msapplication-TileColor\" content=\"#2979ff\">\n\n  <link rel=\"stylesheet\" href=\"https://cdnjs.cloudflare.com/ajax/libs/normalize/7.0.0/normalize.min.css\">\n  <style>\n    * {\n      box-sizing: border-box\n    }\n    body {\n      font-family: Arial, sans-serif;\n      color: #6f6f6f;\n      background: #f8f8f8;\n    }\n    a {\n      text-decoration: none;\n      color: inherit;\n    }\n    body > a  {\n      text-align: center;\n      position: absolute;\n      transform: translate(-50%, -50%);\n      top: 50%;\n      left: 50%;\n      box-shadow: rgba(0, 0, 0, 0.06) 0px 1px 3px, rgba(0, 0, 0, 0.12) 0px 1px 2px;\n      background: #fff;\n      display: block;\n      border-radius: 0.2em;\n      width: 90%;\n      max-width: 25em;\n    }\n    body > a > div:first-child {\n      width: 100%;\n      padding: 1em;\n      cursor: pointer;\n      background: #ffffff;\n      color: rgba(0, 0, 0, 0.5);\n      border-bottom: 1px solid rgba(0, 0, 0, 0.05);\n    }\n    body > a > div:last-child {\n      padding: 2em 3em;\n    }\n    body > a * {\n      margin: 0;\n    }\n    body > a h1 {\n      margin-top: .2em;\n    }\n    .alternative {\n      position: absolute;\n      bottom: 1em;\n      width: 100%;\n      text-align: center;\n      font-size: .9em;\n    }\n    .listing {\n      box-shadow: rgba(0, 0, 0, 0.06) 0px 1px 3px, rgba(0, 0, 0, 0.12) 0px 1px 2px;\n      background: #fff;\n      border-radius: 0.2em;\n      margin: 2em auto;\n      width: 90%;\n      max-width: 40em;\n    }\n    .listing > div {\n      display: flex;\n      align-items: center;\n      justify-content: space-between;\n      padding: 1em;\n      border-bottom: 1px solid rgba(0, 0, 0, 0.05);\n    }\n    .listing h1 {\n      font-size: 1.2em;\n      margin: 0;\n    }\n    .description {\n      color: #757575;\n      white-space: pre-line;\n    }\n    .listing ul {\n      list-style: none;\n      margin: 0;\n      padding: 0;\n    }\n    .listing li a {\n      display: block;\n      padding: .75em 1em;\n    }\n    .listing li a:hover {\n      background: #f8f8f8;\n    }\n  </style>\n</head>\n<body>\n  {{ if .Browse -}}\n  <div class=\"listing\">\n    <div>\n      <h1>{{ if and .Title (eq .Path \"/\") }}{{ .Title }}{{ else }}{{ .File.Name }}{{ end }}</h1>\n      {{ if and .Description (eq .Path \"/\") -}}\n      <p class=\"description\">{{ .Description }}</p>\n      {{ end -}}\n      <span><a href=\"?dl=1\">Download Folder</a>{{ range .Archives }} · <a href=\"?dl=1&amp;format={{ .Format }}\">{{ .Name }}</a>{{ end }}</span>\n    </div>\n    <ul>\n      {{ if .Parent -}}\n      <li><a href=\"{{ .Parent }}\">..</a></li>\n      {{ end -}}\n      {{ range .File.Items -}}\n      <li><a href=\"{{ .URL }}{{ if not .IsDir }}?dl=1{{ end }}\">{{ .Name }}{{ if .IsDir }}/{{ end }}</a></li>\n      {{ end -}}\n    </ul>\n  </div>\n  {{- else -}}\n  <a href=\"?dl=1\">\n    <div>Download {{ if .File.IsDir }}Folder{{ else }}File{{ end }}</div>\n    <div>\n      {{ if .File.IsDir -}}\n      <svg fill=\"#40c4ff\" height=\"150\" viewBox=\"0 0 24 24\" width=\"150\" xmlns=\"http://www.w3.org/2000/svg\">\n        <path d=\"M10 4H4c-1.1 0-1.99.9-1.99 2L2 18c0 1.1.9 2 2 2h16c1.1 0 2-.9 2-2V8c0-1.1-.9-2-2-2h-8l-2-2z\"/>\n        <path d=\"M0 0h24v24H0z\" fill=\"none\"/>\n      </svg>\n      {{ else -}}\n      <svg fill=\"#40c4ff\" height=\"150\" viewBox=\"0 0 24 24\" width=\"150\" xmlns=\"http://www.w3.org/2000/svg\">\n        <path d=\"M6 2c-1.1 0-1.99.9-1.99 2L4 20c0 1.1.89 2 1.99 2H18c1.1 0 2-.9 2-2V8l-6-6H6zm7 7V3.5L18.5 9H13z\"/>\n        <path d=\"M0 0h24v24H0z\" fill=\"none\"/>\n      </svg>\n      {{ end -}}\n      <h1>{{ if .Title }}{{ .Title }}{{ else }}{{ .File.Name }}{{ end }}</h1>\n      {{ if .Description -}}\n      <p class=\"description\">{{ .Description }}</p>\n      {{ end -}}\n      </div>\n  </a>\n  {{ if .File.IsDir -}}\n  {{ range .Archives -}}\n  <p class=\"alternative\"><a href=\"?dl=1&amp;format={{ .Format }}\">Download as {{ .Name }}</a></p>\n  {{ end -}}\n  {{ end -}}\n  {{- end }}\n</body>\n</html>\n"),
 	}
 	file7n := &embedded.EmbeddedFile{
 		Filename:    "sw.js",
 		FileModTime: time.Unix(1502791608, 0),
 		Content:     string("\"use strict\";function setOfCachedUrls(e){return e.keys().then(function(e){return e.map(function(e){return e.url})}).then(function(e){return new Set(e)})}var precacheConfig=[],cacheName=\"sw-precache-v3-File Manager-\"+(self.registration?self.registration.scope:\"\"),ignoreUrlParametersMatching=[/^utm_/],addDirectoryIndex=function(e,n){var t=new URL(e);return\"/\"===t.pathname.slice(-1)&&(t.pathname+=n),t.toString()},cleanResponse=function(e){return e.redirected?(\"body\"in e?Promise.resolve(e.body):e.blob()).then(function(n){return new Response(n,{headers:e.headers,status:e.status,statusText:e.statusText})}):Promise.resolve(e)},createCacheKey=function(e,n,t,r){var a=new URL(e);return r&&a.pathname.match(r)||(a.search+=(a.search?\"&\":\"\")+encodeURIComponent(n)+\"=\"+encodeURIComponent(t)),a.toString()},isPathWhitelisted=function(e,n){if(0===e.length)return!0;var t=new URL(n).pathname;return e.some(function(e){return t.match(e)})},stripIgnoredUrlParameters=function(e,n){var t=new URL(e);return t.hash=\"\",t.search=t.search.slice(1).split(\"&\").map(function(e){return e.split(\"=\")}).filter(function(e){return n.every(function(n){return!n.test(e[0])})}).map(function(e){return e.join(\"=\")}).join(\"&\"),t.toString()},hashParamName=\"_sw-precache\",urlsToCacheKeys=new Map(precacheConfig.map(function(e){var n=e[0],t=e[1],r=new URL(n,self.location),a=createCacheKey(r,hashParamName,t,!1);return[r.toString(),a]}));self.addEventListener(\"install\",function(e){e.waitUntil(caches.open(cacheName).then(function(e){return setOfCachedUrls(e).then(function(n){return Promise.all(Array.from(urlsToCacheKeys.values()).map(function(t){if(!n.has(t)){var r=new Request(t,{credentials:\"same-origin\"});return fetch(r).then(function(n){if(!n.ok)throw new Error(\"Request for \"+t+\" returned a response with status \"+n.status);return cleanResponse(n).then(function(n){return e.put(t,n)})})}}))})}).then(function(){return self.skipWaiting()}))}),self.addEventListener(\"activate\",function(e){var n=new Set(urlsToCacheKeys.values());e.waitUntil(caches.open(cacheName).then(function(e){return e.keys().then(function(t){return Promise.all(t.map(function(t){if(!n.has(t.url))return e.delete(t)}))})}).then(function(){return self.clients.claim()}))}),self.addEventListener(\"fetch\",function(e){if(\"GET\"===e.request.method){var n,t=stripIgnoredUrlParameters(e.request.url,ignoreUrlParametersMatching);(n=urlsToCacheKeys.has(t))||(t=addDirectoryIndex(t,\"index.html\"),n=urlsToCacheKeys.has(t));n&&e.respondWith(caches.open(cacheName).then(function(e){return e.match(urlsToCacheKeys.get(t)).then(function(e){if(e)return e;throw Error(\"The cached response that was expected is missing.\")})}).catch(function(n){return console.warn('Couldn\\'t serve response for \"%s\" from cache: %O',e.request.url,n),fetch(e.request)}))}});"),
 	}
+	file7o := &embedded.EmbeddedFile{
+		Filename:    "static/share/password.html",
+		FileModTime: time.Unix(1792041121, 0),
+		Content:     string("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n  <meta charset=\"utf-8\">\n  <meta http-equiv=\"X-UA-Compatible\" content=\"IE=edge\">\n  <meta name=\"viewport\" content=\"width=device-width, initial-scale=1, user-scalable=no\">\n  <title>File Manager</title>\n  <link rel=\"icon\" type=\"image/png\" sizes=\"32x32\" href=\"{{ .BaseURL }}/static/img/icons/favicon-32x32.png\">\n  <link rel=\"icon\" type=\"image/png\" sizes=\"16x16\" href=\"{{ .BaseURL }}/static/img/icons/favicon-16x16.png\">\n  <!--[if IE]><link rel=\"shortcut icon\" href=\"{{ .BaseURL }}/static/img/icons/favicon.ico\"><![endif]-->\n  <link rel=\"manifest\" href=\"{{ .BaseURL }}/static/manifest.json\">\n  <meta name=\"theme-color\" content=\"#2979ff\">\n  <meta name=\"apple-mobile-web-app-capable\" content=\"yes\">\n  <meta name=\"apple-mobile-web-app-status-bar-style\" content=\"black\">\n  <meta name=\"apple-mobile-web-app-title\" content=\"assets\">\n  <link rel=\"apple-touch-icon\" href=\"{{ .BaseURL }}/static/img/icons/apple-touch-icon-152x152.png\">\n  <meta name=\"msapplication-TileImage\" content=\"{{ .BaseURL }}/static/img/icons/msapplication-icon-144x144.png\">\n  <meta name=\"msapplication-TileColor\" content=\"#2979ff\">\n\n  <link rel=\"stylesheet\" href=\"https://cdnjs.cloudflare.com/ajax/libs/normalize/7.0.0/normalize.min.css\">\n  <style>\n    * {\n      box-sizing: border-box\n    }\n    body {\n      font-family: Arial, sans-serif;\n      color: #6f6f6f;\n      background: #f8f8f8;\n    }\n    body > div  {\n      text-align: center;\n      position: absolute;\n      transform: translate(-50%, -50%);\n      top: 50%;\n      left: 50%;\n      box-shadow: rgba(0, 0, 0, 0.06) 0px 1px 3px, rgba(0, 0, 0, 0.12) 0px 1px 2px;\n      background: #fff;\n      display: block;\n      border-radius: 0.2em;\n      padding: 2em 3em;\n    }\n    body > a * {\n      margin: 0;\n    }\n    input, button {\n      display: block;\n      width: 100%;\n      margin: .5em 0;\n      padding: .5em 1em;\n      font-size: 1em;\n    }\n    button {\n      border: 0;\n      border-radius: .1em;\n      color: #fff;\n      background: #2979ff;\n      cursor: pointer;\n    }\n    #error {\n      color: #f44336;\n    }\n  </style>\n</head>\n<body>\n  <div>\n    <h1>Protected share</h1>\n    <form id=\"form\">\n      <input id=\"password\" type=\"password\" placeholder=\"Password\" autofocus required>\n      <button type=\"submit\">Open</button>\n    </form>\n    <p id=\"error\"></p>\n  </div>\n  <script>\n    document.getElementById('form').addEventListener('submit', function (event) {\n      event.preventDefault()\n\n      var request = new XMLHttpRequest()\n      request.open('POST', window.location.pathname, true)\n      request.setRequestHeader('Content-Type', 'application/json')\n\n      request.onload = function () {\n        if (request.status === 200) {\n          // The token is kept in a cookie of the share.\n          window.location.reload()\n        } else if (request.status === 429) {\n          document.getElementById('error').textContent = 'Too many attempts. Try again later.'\n        } else {\n          document.getElementById('error').textContent = 'Wrong password.'\n        }\n      }\n\n      request.send(JSON.stringify({ password: document.getElementById('password').value }))\n    })\n  </script>\n</body>\n</html>\n"),
+	}
 
 	// define dirs
 	dir1 := &embedded.EmbeddedDir{
@@ -1780,6 +1785,7 @@ func init() {
 		ChildFiles: []*embedded.EmbeddedFile{
 			file7l, // "static/share/404.html"
 			file7m, // "static/share/index.html"
+			file7o, // "static/share/password.html"
 
 		},
 	}
@@ -2336,6 +2342,7 @@ func init() {
 			"static/manifest.json":                                           file7j,
 			"static/share/404.html":                                          file7l,
 			"static/share/index.html":                                        file7m,
+			"static/share/password.html":                                     file7o,
 			"sw.js":                                                          file7n,
 		},
 	})
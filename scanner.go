@@ -0,0 +1,146 @@
+package filemanager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultScanTimeout is the maximum time a scan can take.
+const defaultScanTimeout = 5 * time.Minute
+
+// Scanner checks the uploaded files before they are saved, e.g. for viruses.
+type Scanner interface {
+	// Scan reads the content of an uploaded file. It returns an
+	// *InfectedError if the file must be rejected.
+	Scan(r io.Reader) error
+}
+
+// InfectedError is returned by the scanners when a file is infected.
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return "the file is infected: " + e.Signature
+}
+
+// ClamdScanner scans the files using a clamd daemon.
+type ClamdScanner struct {
+	// Address is the path of the clamd Unix socket or its TCP address.
+	Address string
+
+	// Timeout is the maximum time a scan can take. If it is zero,
+	// five minutes are used.
+	Timeout time.Duration
+}
+
+// clamdChunkSize is the size of the chunks sent to clamd.
+const clamdChunkSize = 32 * 1024
+
+// Scan sends the content to clamd using the INSTREAM command.
+func (s *ClamdScanner) Scan(r io.Reader) error {
+	network := "tcp"
+	if strings.HasPrefix(s.Address, "/") {
+		network = "unix"
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+
+	conn, err := net.DialTimeout(network, s.Address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	// The content is sent in chunks prefixed by their length
+	// and terminated by an empty one.
+	buffer := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return err
+			}
+
+			if _, err := conn.Write(buffer[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err = conn.Write(size); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses the reply of clamd to a scan, which is
+// 'stream: OK' or 'stream: <signature> FOUND'.
+func parseClamdReply(reply string) error {
+	reply = strings.TrimRight(reply, "\x00\n")
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &InfectedError{Signature: strings.TrimSuffix(reply, " FOUND")}
+	case reply == "":
+		return errors.New("clamd closed the connection without replying")
+	}
+
+	return fmt.Errorf("clamd: %s", reply)
+}
+
+// scanFile scans an uploaded file, returning the HTTP status code to use
+// if it was rejected.
+func scanFile(s Scanner, r io.ReadSeeker) (int, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	err := s.Scan(r)
+	if _, ok := err.(*InfectedError); ok {
+		return http.StatusUnprocessableEntity, err
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
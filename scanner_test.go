@@ -0,0 +1,100 @@
+package filemanager
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noopScanner accepts every file.
+type noopScanner struct{}
+
+func (noopScanner) Scan(r io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// eicarScanner rejects the files containing the EICAR test string.
+type eicarScanner struct{}
+
+func (eicarScanner) Scan(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(data, []byte("EICAR")) {
+		return &InfectedError{Signature: "Eicar-Test-Signature"}
+	}
+
+	return nil
+}
+
+func TestScanUpload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	upload := func(name, content string) int {
+		r, err := http.NewRequest("POST", "/api/resource/"+name, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	fm.Scanner = noopScanner{}
+	if code := upload("clean.txt", "EICAR"); code != http.StatusOK {
+		t.Errorf("Upload with no-op scanner failed: got %v", code)
+	}
+
+	fm.Scanner = eicarScanner{}
+	if code := upload("infected.txt", "EICAR"); code != http.StatusUnprocessableEntity {
+		t.Errorf("Infected upload wasn't rejected: got %v", code)
+	}
+
+	if _, err := os.Stat(filepath.Join(fm.Temp, "scope", "infected.txt")); !os.IsNotExist(err) {
+		t.Errorf("Infected upload was saved")
+	}
+
+	if code := upload("other.txt", "content"); code != http.StatusOK {
+		t.Errorf("Clean upload was rejected: got %v", code)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(fm.Temp, "scope", "other.txt"))
+	if err != nil || string(data) != "content" {
+		t.Errorf("Clean upload wasn't saved: %v", err)
+	}
+
+	// No temporary files must be left behind.
+	files, err := filepath.Glob(filepath.Join(fm.Temp, "scope", ".upload-*"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("Temporary uploads were left: %v", files)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if err := parseClamdReply("stream: OK\x00"); err != nil {
+		t.Errorf("Clean reply returned %v", err)
+	}
+
+	err := parseClamdReply("stream: Eicar-Test-Signature FOUND\x00")
+	if e, ok := err.(*InfectedError); !ok || e.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Infected reply returned %v", err)
+	}
+
+	if err := parseClamdReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Errorf("Error reply returned no error")
+	}
+}
@@ -5,15 +5,17 @@ import (
 	"encoding/json"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 )
 
 type modifySettingsRequest struct {
-	*modifyRequest
+	modifyRequest
 	Data struct {
 		Commands  map[string][]string    `json:"commands"`
 		StaticGen map[string]interface{} `json:"staticGen"`
+		ReadOnly  readOnlyMode           `json:"readOnly"`
 	} `json:"data"`
 }
 
@@ -56,12 +58,19 @@ func settingsHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		return settingsPutHandler(c, w, r)
 	}
 
-	return http.StatusMethodNotAllowed, nil
+	return methodNotAllowed(w, apiMethods["settings"]...)
 }
 
 type settingsGetRequest struct {
-	Commands  map[string][]string `json:"commands"`
-	StaticGen []option            `json:"staticGen"`
+	Commands     map[string][]string `json:"commands"`
+	StaticGen    []option            `json:"staticGen"`
+	ReadOnly     readOnlyMode        `json:"readOnly"`
+	Checksums    []string            `json:"checksums"`
+	Archives     []string            `json:"archives"`
+	UploadPolicy UploadPolicy        `json:"uploadPolicy"`
+	// IdleTimeout is the number of seconds after which the unused
+	// sessions are logged out, or zero if they aren't.
+	IdleTimeout int64 `json:"idleTimeout"`
 }
 
 func settingsGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -70,8 +79,13 @@ func settingsGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 	}
 
 	result := &settingsGetRequest{
-		Commands:  c.Commands,
-		StaticGen: []option{},
+		Commands:     c.Commands,
+		StaticGen:    []option{},
+		ReadOnly:     c.readOnlyStatus(),
+		Checksums:    c.enabledChecksums(),
+		Archives:     c.enabledArchiveFormats(),
+		UploadPolicy: c.UploadPolicy,
+		IdleTimeout:  int64(c.IdleTimeout / time.Second),
 	}
 
 	if c.StaticGen != nil {
@@ -102,6 +116,21 @@ func settingsPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
+
+	// Toggles the read-only mode, which is the only
+	// setting that can be changed while it's enabled.
+	if mod.Which == "readOnly" {
+		err = c.SetReadOnlyMode(mod.Data.ReadOnly.Enabled, mod.Data.ReadOnly.Message)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		return http.StatusOK, nil
+	}
+
+	if enabled, _ := c.ReadOnlyMode(); enabled {
+		return rejectReadOnly(w)
+	}
 	// Update the commands.
 	if mod.Which == "commands" {
 		if err := c.db.Set("config", "commands", mod.Data.Commands); err != nil {
@@ -127,5 +156,5 @@ func settingsPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		return http.StatusOK, nil
 	}
 
-	return http.StatusMethodNotAllowed, nil
+	return http.StatusBadRequest, errInvalidUpdateField
 }
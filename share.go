@@ -1,22 +1,132 @@
 package filemanager
 
 import (
+	"crypto/hmac"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/asdine/storm"
 	"github.com/asdine/storm/q"
+	"github.com/hacdias/fileutils"
 )
 
+var (
+	errExpiry        = errors.New("the expiry must be a positive number of units")
+	errShareExpiry   = errors.New("the share must expire within the maximum share expiry")
+	errShareLimit    = errors.New("the maximum number of shares was reached")
+	errShareMetadata = errors.New("the title or the description of the share is too long")
+)
+
+// maxShareTitle and maxShareDescription are the maximum number of
+// characters of the title and the description of a share.
+const (
+	maxShareTitle       = 200
+	maxShareDescription = 2000
+)
+
+// defaultShareCleanupInterval is how often the expired shares are deleted
+// if FileManager.ShareCleanupInterval isn't set.
+const defaultShareCleanupInterval = time.Hour
+
+// shareCleanupState is when the shares were last cleaned up. It is locked
+// during the cleanup so two of them never run at once.
+type shareCleanupState struct {
+	sync.Mutex
+	last time.Time
+}
+
+// shareCleanupTick runs the share cleanup if ShareCleanupInterval has
+// passed since the last one. It is checked every minute so the interval
+// can be changed at any time.
+func (m *FileManager) shareCleanupTick() {
+	m.shareCleanup.Lock()
+	defer m.shareCleanup.Unlock()
+
+	interval := m.ShareCleanupInterval
+	if interval <= 0 {
+		interval = defaultShareCleanupInterval
+	}
+
+	if time.Since(m.shareCleanup.last) < interval {
+		return
+	}
+
+	m.shareCleanup.last = time.Now()
+	m.shareCleaner()
+}
+
 type shareLink struct {
-	Hash       string    `json:"hash" storm:"id,index"`
-	Path       string    `json:"path" storm:"index"`
+	Hash string `json:"hash" storm:"id,index"`
+	Path string `json:"path" storm:"index"`
+	// UserID is the ID of the user who created the share. It is zero for
+	// the shares created before it was recorded.
+	UserID     int       `json:"userId,omitempty" storm:"index"`
 	Expires    bool      `json:"expires"`
 	ExpireDate time.Time `json:"expireDate"`
+	// AllowedCIDRs restricts the access to the share to the clients
+	// whose IP is in one of these ranges. Empty means everyone.
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+	// RateLimit is the maximum download speed in bytes per second.
+	// Zero means there is no limit.
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Downloads is the number of times the share was downloaded. Resumed
+	// downloads are only counted once.
+	Downloads int `json:"downloads"`
+	// Password is the hash of the password needed to access the share,
+	// if any. It is removed before the shares are sent to the clients.
+	Password string `json:"password,omitempty"`
+	// Protected tells if the share needs a password.
+	Protected bool `json:"protected"`
+	// Browse lets the recipients of a directory share browse it and
+	// download its files one by one, instead of only as an archive.
+	Browse bool `json:"browse,omitempty"`
+	// ExpiryNotified tells if the owner was notified that the share is
+	// about to expire.
+	ExpiryNotified bool `json:"expiryNotified,omitempty"`
+	// Title and Description are shown on the page of the share instead
+	// of the name of the file, if they're set.
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// restricted tells if the access to the share is restricted in any way.
+func (s shareLink) restricted() bool {
+	return len(s.AllowedCIDRs) > 0 || s.RateLimit > 0 || s.Protected
+}
+
+// allowed checks if the IP address is allowed to access the share.
+func (s shareLink) allowed(ip net.IP) bool {
+	if len(s.AllowedCIDRs) == 0 {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range s.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func shareHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -31,7 +141,7 @@ func shareHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (in
 		return sharePostHandler(c, w, r)
 	}
 
-	return http.StatusNotImplemented, nil
+	return methodNotAllowed(w, apiMethods["share"]...)
 }
 
 func shareGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -56,7 +166,7 @@ func shareGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	return renderJSON(w, s)
+	return renderJSON(w, hidePasswords(s))
 }
 
 func sharePostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -66,54 +176,185 @@ func sharePostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	expire := r.URL.Query().Get("expires")
 	unit := r.URL.Query().Get("unit")
 
-	if expire == "" {
-		err := c.db.Select(q.Eq("Path", path), q.Eq("Expires", false)).First(&s)
-		if err == nil {
-			w.Write([]byte(c.RootURL() + "/share/" + s.Hash))
-			return 0, nil
+	cidrs, err := parseCIDRs(r.URL.Query().Get("cidrs"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var rate int64
+	if val := r.URL.Query().Get("rate"); val != "" {
+		rate, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || rate < 0 {
+			return http.StatusBadRequest, err
 		}
 	}
 
-	bytes, err := generateRandomBytes(32)
-	if err != nil {
-		return http.StatusInternalServerError, err
+	// The password is sent in the body so it doesn't end up in the logs.
+	var body struct {
+		Password    string `json:"password"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
 	}
 
-	str := hex.EncodeToString(bytes)
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			return http.StatusBadRequest, err
+		}
+	}
 
-	s = shareLink{
-		Path:    path,
-		Hash:    str,
-		Expires: expire != "",
+	body.Title = strings.TrimSpace(body.Title)
+	body.Description = strings.TrimSpace(body.Description)
+	if utf8.RuneCountInString(body.Title) > maxShareTitle || utf8.RuneCountInString(body.Description) > maxShareDescription {
+		return http.StatusBadRequest, errShareMetadata
 	}
 
+	// Shares without expiry get the default one, unless they
+	// are explicitly requested to be permanent.
+	var duration time.Duration
 	if expire != "" {
-		num, err := strconv.Atoi(expire)
+		var err error
+		if duration, err = parseExpiry(expire, unit); err != nil {
+			return http.StatusBadRequest, err
+		}
+	} else if r.URL.Query().Get("permanent") != "true" {
+		duration = c.DefaultShareExpiry
+	}
+
+	if c.MaxShareExpiry > 0 && (duration <= 0 || duration > c.MaxShareExpiry) {
+		return http.StatusBadRequest, errShareExpiry
+	}
+
+	browse := r.URL.Query().Get("browse") == "true"
+
+	// Reuses the permanent share without restrictions, if there is one.
+	if duration == 0 && len(cidrs) == 0 && rate == 0 && body.Password == "" && !browse && body.Title == "" && body.Description == "" {
+		var links []shareLink
+		err := c.db.Select(q.Eq("Path", path), q.Eq("Expires", false)).Find(&links)
+		if err == nil {
+			for _, link := range links {
+				if !link.restricted() && !link.Browse && link.Title == "" && link.Description == "" {
+					w.Write([]byte(c.RootURL() + "/share/" + link.Hash))
+					return 0, nil
+				}
+			}
+		}
+	}
+
+	s = shareLink{
+		Path:         path,
+		Expires:      duration != 0,
+		AllowedCIDRs: cidrs,
+		RateLimit:    rate,
+		Browse:       browse,
+		Title:        body.Title,
+		Description:  body.Description,
+	}
+
+	if s.Expires {
+		s.ExpireDate = time.Now().Add(duration)
+	}
+
+	if body.Password != "" {
+		s.Password, err = c.hashPassword(body.Password)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
 
-		var add time.Duration
-		switch unit {
-		case "seconds":
-			add = time.Second * time.Duration(num)
-		case "minutes":
-			add = time.Minute * time.Duration(num)
-		case "days":
-			add = time.Hour * 24 * time.Duration(num)
-		default:
-			add = time.Hour * time.Duration(num)
+		s.Protected = true
+	}
+
+	if err = c.createShare(c.User, &s); err == errShareLimit {
+		return http.StatusForbidden, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	s.Password = ""
+	return renderJSON(w, s)
+}
+
+// createShare saves a new share of the user with a random hash. The share
+// must have its path and options set. It fails with errShareLimit if the
+// user can't have more shares.
+func (m *FileManager) createShare(u *User, s *shareLink) error {
+	// Otherwise the concurrent requests could all see the count under
+	// the limit and go over it.
+	m.shareMu.Lock()
+	defer m.shareMu.Unlock()
+
+	if limit := m.shareLimit(u); limit > 0 {
+		count, err := m.activeShares(u)
+		if err != nil {
+			return err
 		}
 
-		s.ExpireDate = time.Now().Add(add)
+		if count >= limit {
+			return errShareLimit
+		}
 	}
 
-	err = c.db.Save(&s)
+	bytes, err := generateRandomBytes(32)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return err
 	}
 
-	return renderJSON(w, s)
+	s.Hash = hex.EncodeToString(bytes)
+	s.UserID = u.ID
+	if err = m.db.Save(s); err != nil {
+		return err
+	}
+
+	// The notification is sent in the background so it doesn't delay the
+	// response.
+	if m.Notifier != nil {
+		go m.sendNotification(m.shareNotification(ShareCreated, *s), s.Hash)
+	}
+
+	return nil
+}
+
+// shareUsage is the number of shares of a user and their limit.
+type shareUsage struct {
+	Count int `json:"count"`
+	// Limit is zero if there is no limit.
+	Limit int `json:"limit"`
+}
+
+// shareLimit returns the maximum number of shares of the user, which is
+// their own MaxShares or, if it is zero, the global one. Zero means
+// there is no limit.
+func (m *FileManager) shareLimit(u *User) int {
+	switch {
+	case u.MaxShares < 0:
+		return 0
+	case u.MaxShares > 0:
+		return u.MaxShares
+	}
+
+	return m.MaxShares
+}
+
+// activeShares counts the shares created by the user which haven't
+// expired. The expired ones are left to the cleanup.
+func (m *FileManager) activeShares(u *User) (int, error) {
+	var links []shareLink
+	err := m.db.Find("UserID", u.ID, &links)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, link := range links {
+		if !link.Expires || link.ExpireDate.After(time.Now()) {
+			count++
+		}
+	}
+
+	return count, nil
 }
 
 func shareDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -128,6 +369,11 @@ func shareDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		return http.StatusInternalServerError, err
 	}
 
+	// Only admins can delete the shares of files outside their scope.
+	if !c.User.Admin && !inScope(c.User, s.Path) {
+		return http.StatusForbidden, nil
+	}
+
 	err = c.db.DeleteStruct(&s)
 	if err != nil {
 		return http.StatusInternalServerError, err
@@ -135,3 +381,483 @@ func shareDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 
 	return http.StatusOK, nil
 }
+
+// inScope checks if the absolute path is inside the user's scope.
+func inScope(u *User, path string) bool {
+	scope := filepath.Clean(string(u.FileSystem))
+	rel, err := filepath.Rel(scope, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sharesHandler lets admins manage the shares of every user. A GET request
+// lists them, a DELETE request deletes them and a POST request to /expire
+// expires them immediately. The shares can be filtered by the prefix of their
+// absolute path using 'prefix', by their state (active, expired or permanent)
+// using 'status' and by a comma separated list of 'hashes'.
+func sharesHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+
+	links, err := filterShares(c, r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		return renderJSON(w, hidePasswords(links))
+	case r.Method == http.MethodDelete && r.URL.Path == "/":
+		for _, link := range links {
+			if err := c.db.DeleteStruct(link); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+
+		return renderJSON(w, hidePasswords(links))
+	case r.Method == http.MethodPost && r.URL.Path == "/expire":
+		now := time.Now()
+
+		for _, link := range links {
+			link.Expires = true
+			link.ExpireDate = now
+
+			if err := c.db.Save(link); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+
+		return renderJSON(w, hidePasswords(links))
+	case r.URL.Path == "/":
+		return methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	case r.URL.Path == "/expire":
+		return methodNotAllowed(w, http.MethodPost)
+	}
+
+	return http.StatusNotFound, nil
+}
+
+// hidePasswords removes the password hashes from the shares before they
+// are sent to the clients.
+func hidePasswords(links []*shareLink) []*shareLink {
+	for _, link := range links {
+		link.Password = ""
+	}
+
+	return links
+}
+
+// filterShares returns the shares which match the filters of the request.
+func filterShares(c *RequestContext, r *http.Request) ([]*shareLink, error) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	status := query.Get("status")
+
+	switch status {
+	case "", "active", "expired", "permanent":
+	default:
+		return nil, errors.New("invalid status: " + status)
+	}
+
+	hashes := map[string]bool{}
+	for _, hash := range strings.Split(query.Get("hashes"), ",") {
+		if hash != "" {
+			hashes[hash] = true
+		}
+	}
+
+	var all []*shareLink
+	if err := c.db.All(&all); err != nil {
+		return nil, err
+	}
+
+	links := []*shareLink{}
+	now := time.Now()
+
+	for _, link := range all {
+		if prefix != "" && !strings.HasPrefix(link.Path, prefix) {
+			continue
+		}
+
+		if len(hashes) > 0 && !hashes[link.Hash] {
+			continue
+		}
+
+		expired := link.Expires && link.ExpireDate.Before(now)
+
+		switch {
+		case status == "active" && expired,
+			status == "expired" && !expired,
+			status == "permanent" && link.Expires:
+			continue
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// parseCIDRs parses a comma separated list of IP ranges in CIDR
+// notation. Single IP addresses are converted to ranges.
+func parseCIDRs(list string) ([]string, error) {
+	cidrs := []string{}
+
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.New("invalid IP address: " + cidr)
+			}
+
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		cidrs = append(cidrs, network.String())
+	}
+
+	return cidrs, nil
+}
+
+// shareTokenDuration is how long the tokens given in exchange for the
+// password of a share are valid.
+const shareTokenDuration = time.Hour
+
+// shareToken gives access to a protected share without its password.
+type shareToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// The buckets of the password attempts of the protected shares.
+const (
+	rateShare       = "share"
+	rateShareClient = "share-client"
+)
+
+// The maximum number of password attempts per minute of each client, on
+// any share, and of each share, from any client.
+const (
+	shareAttemptsPerClient = 10
+	shareAttemptsPerShare  = 60
+)
+
+// shareCookieName returns the name of the cookie with the token of a share.
+func shareCookieName(hash string) string {
+	return "share_" + hash
+}
+
+// signShareToken returns the token which gives access to the share with
+// the hash until the given Unix time.
+func signShareToken(key []byte, hash string, expires int64) string {
+	e := strconv.FormatInt(expires, 10)
+	return e + "." + sign(key, "share", hash, e)
+}
+
+// validShareToken checks if the token gives access to the share.
+func (m *FileManager) validShareToken(hash, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	// Tokens signed with previous keys are valid during their grace period.
+	for _, key := range m.verificationKeys() {
+		if hmac.Equal([]byte(signShareToken(key, hash, expires)), []byte(token)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shareAuthorized tells if the request has a valid token for the share,
+// either in its cookie or in the 'token' query parameter.
+func shareAuthorized(c *RequestContext, r *http.Request, s *shareLink) bool {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return c.validShareToken(s.Hash, token)
+	}
+
+	cookie, err := r.Cookie(shareCookieName(s.Hash))
+	return err == nil && c.validShareToken(s.Hash, cookie.Value)
+}
+
+// shareAuthHandler checks the password of a protected share and replies
+// with a token which gives access to it for a while. The token is also
+// set in a cookie so the following requests, such as the range requests
+// of a download, don't need the password.
+func shareAuthHandler(c *RequestContext, w http.ResponseWriter, r *http.Request, s *shareLink) (int, error) {
+	var body struct {
+		Password string `json:"password"`
+	}
+
+	// The passwords can't be guessed faster than the attempts allowed to
+	// each client and to each share.
+	ok, retry := c.takeRequest(rateShareClient, c.clientIP(r).String(), shareAttemptsPerClient, shareAttemptsPerClient)
+	if ok {
+		ok, retry = c.takeRequest(rateShare, s.Hash, shareAttemptsPerShare, shareAttemptsPerShare)
+	}
+
+	if !ok {
+		return rejectRateLimited(w, retry)
+	}
+
+	if r.Body == nil {
+		return http.StatusBadRequest, errEmptyRequest
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if !checkPasswordHash(body.Password, s.Password) {
+		return http.StatusForbidden, nil
+	}
+
+	expires := time.Now().Add(shareTokenDuration)
+	if s.Expires && s.ExpireDate.Before(expires) {
+		expires = s.ExpireDate
+	}
+
+	token := signShareToken(c.signingKey(), s.Hash, expires.Unix())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookieName(s.Hash),
+		Value:    token,
+		Path:     c.RootURL() + "/share/" + s.Hash,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return renderJSON(w, shareToken{
+		Token:   token,
+		Expires: expires,
+	})
+}
+
+// shareSubpath returns the absolute path of the entry at the virtual path
+// inside a browsable share. The path, after resolving the symbolic links,
+// must be inside the shared directory.
+func shareSubpath(s *shareLink, sub string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.Path)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.Path, filepath.FromSlash(fileutils.SlashClean(sub)))
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", os.ErrPermission
+	}
+
+	return path, nil
+}
+
+// shareBrowse renders the listing of a directory inside a browsable share.
+// It is listed as if the share was the scope of a user without rules so
+// only the global ignore patterns apply and they can't be overridden.
+func shareBrowse(c *RequestContext, w http.ResponseWriter, r *http.Request, s *shareLink, sub string) (int, error) {
+	sub = strings.TrimSuffix(fileutils.SlashClean(sub), "/") + "/"
+	base := url.URL{Path: c.RootURL() + "/share/" + s.Hash + sub}
+
+	c.User = &User{FileSystem: fileutils.Dir(s.Path)}
+	c.File.VirtualPath = sub
+	c.File.URL = base.String()
+
+	query := r.URL.Query()
+	query.Del("hidden")
+	r.URL.RawQuery = query.Encode()
+
+	if err := c.File.getListing(c, r); err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	c.File.listing.Sort, c.File.listing.Order = "name", "asc"
+	c.File.listing.ApplySort()
+
+	parent := ""
+	if sub != "/" {
+		dir := strings.TrimSuffix(path.Dir(strings.TrimSuffix(sub, "/")), "/") + "/"
+		parent = (&url.URL{Path: c.RootURL() + "/share/" + s.Hash + dir}).String()
+	}
+
+	tpl := template.Must(template.New("file").Parse(c.assets.MustString("static/share/index.html")))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := tpl.Execute(w, map[string]interface{}{
+		"BaseURL":     c.RootURL(),
+		"File":        c.File,
+		"Browse":      true,
+		"Path":        sub,
+		"Parent":      parent,
+		"Title":       s.Title,
+		"Description": s.Description,
+		"Archives":    c.alternativeArchives(),
+	})
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// countDownload increments the download counter of the share.
+func (m *FileManager) countDownload(hash string) error {
+	tx, err := m.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var s shareLink
+	if err = tx.One("Hash", hash, &s); err != nil {
+		return err
+	}
+
+	if err = tx.UpdateField(&shareLink{Hash: hash}, "Downloads", s.Downloads+1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// downloadRecorder is a response writer which records the status and
+// the number of bytes sent so it can be known if a download finished.
+type downloadRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (d *downloadRecorder) WriteHeader(code int) {
+	if d.status == 0 {
+		d.status = code
+	}
+
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *downloadRecorder) Write(p []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+
+	n, err := d.ResponseWriter.Write(p)
+	d.written += int64(n)
+	return n, err
+}
+
+// completed tells if the response sent the whole file, of the given
+// size, or its last range. This way a download resumed with range
+// requests is complete only once. If the size is negative, such as
+// for the archives, every successful response is complete.
+func (d *downloadRecorder) completed(size int64) bool {
+	switch d.status {
+	case http.StatusOK:
+		return size < 0 || d.written == size
+	case http.StatusPartialContent:
+		// Multipart responses have no Content-Range header so
+		// they're never complete.
+		rng, err := parseContentRange(d.Header().Get("Content-Range"))
+		if err != nil {
+			return false
+		}
+
+		return rng.end == rng.total-1 && d.written == rng.end-rng.start+1
+	default:
+		return false
+	}
+}
+
+// throttledWriter is a response writer which limits the rate,
+// in bytes per second, of the data sent to the client.
+type throttledWriter struct {
+	http.ResponseWriter
+	rate int64
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		// Writes at most one second worth of data at once.
+		n := len(p)
+		if int64(n) > t.rate {
+			n = int(t.rate)
+		}
+
+		start := time.Now()
+		m, err := t.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+
+		// Waits the time the chunk should have taken to be sent.
+		wait := time.Duration(int64(n)*int64(time.Second)/t.rate) - time.Since(start)
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+
+	return written, nil
+}
+
+// expireDuration converts a number and a unit (seconds, minutes, hours
+// or days) into a duration. Hours are used if the unit is unknown.
+func expireDuration(num int, unit string) time.Duration {
+	switch unit {
+	case "seconds":
+		return time.Second * time.Duration(num)
+	case "minutes":
+		return time.Minute * time.Duration(num)
+	case "days":
+		return time.Hour * 24 * time.Duration(num)
+	default:
+		return time.Hour * time.Duration(num)
+	}
+}
+
+// parseExpiry parses an expiry in a number of units, see expireDuration.
+// The number must be positive and small enough for the duration to fit.
+func parseExpiry(expire, unit string) (time.Duration, error) {
+	num, err := strconv.Atoi(expire)
+	if err != nil {
+		return 0, err
+	}
+
+	if num <= 0 || time.Duration(num) > math.MaxInt64/expireDuration(1, unit) {
+		return 0, errExpiry
+	}
+
+	return expireDuration(num, unit), nil
+}
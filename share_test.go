@@ -0,0 +1,459 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShareLimit(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	if err := ioutil.WriteFile(filepath.Join(scope, "file.txt"), []byte("file"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired shares and the ones of other users don't count.
+	links := []*shareLink{
+		{Hash: "expired", UserID: 1, Path: filepath.Join(scope, "file.txt"), Expires: true, ExpireDate: time.Now().Add(-time.Hour)},
+		{Hash: "other", UserID: 2, Path: filepath.Join(scope, "file.txt"), Expires: true, ExpireDate: time.Now().Add(time.Hour)},
+	}
+
+	for _, link := range links {
+		if err := fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func() int {
+		r, err := http.NewRequest("POST", "/api/share/file.txt?expires=1&unit=hours", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	fm.MaxShares = 2
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusForbidden} {
+		if code := share(); code != want {
+			t.Errorf("share %d: got status %d, want %d", i, code, want)
+		}
+	}
+
+	r, err := http.NewRequest("GET", "/api/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var p profile
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Shares.Count != 2 || p.Shares.Limit != 2 {
+		t.Errorf("got %+v, want 2 shares out of 2", p.Shares)
+	}
+
+	// The limit of the user takes precedence over the global one.
+	fm.Users["admin"].MaxShares = 3
+	if code := share(); code != http.StatusOK {
+		t.Errorf("got status %d with the limit of the user, want %d", code, http.StatusOK)
+	}
+
+	fm.Users["admin"].MaxShares = -1
+	if code := share(); code != http.StatusOK {
+		t.Errorf("got status %d without limit, want %d", code, http.StatusOK)
+	}
+
+	// The concurrent shares don't go over the limit.
+	admin := fm.Users["admin"]
+	admin.MaxShares = 10
+	errs := make(chan error, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- fm.createShare(admin, &shareLink{Path: filepath.Join(scope, "file.txt")})
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if err != errShareLimit {
+			t.Error(err)
+		}
+	}
+
+	if count, err := fm.activeShares(admin); err != nil || count != 10 || created != 6 {
+		t.Errorf("got %d shares after creating %d concurrently, want 10 after 6 (%v)", count, created, err)
+	}
+}
+
+func TestShareMetadata(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/api/share/file.txt", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := share(`{"title":" <b>Report</b> ","description":"The report of the year"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	var link shareLink
+	if err := json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if link.Title != "<b>Report</b>" || link.Description != "The report of the year" {
+		t.Errorf("Got title %q and description %q", link.Title, link.Description)
+	}
+
+	var saved shareLink
+	if err := fm.db.One("Hash", link.Hash, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.Title != link.Title || saved.Description != link.Description {
+		t.Errorf("The title and the description weren't saved: %+v", saved)
+	}
+
+	// The permanent share with a title isn't reused for a plain one.
+	w = share("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	var plain shareLink
+	if err := json.NewDecoder(w.Body).Decode(&plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain.Hash == link.Hash || plain.Title != "" {
+		t.Errorf("The share with a title was reused: %+v", plain)
+	}
+
+	long := fmt.Sprintf(`{"title":%q}`, strings.Repeat("a", maxShareTitle+1))
+	if w := share(long); w.Code != http.StatusBadRequest {
+		t.Errorf("Too long title: got status %v", w.Code)
+	}
+}
+
+func TestShareRestrictions(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func(query string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/api/share/file.txt"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, query := range []string{"?cidrs=10.0.0.0/33", "?cidrs=invalid", "?rate=-1", "?rate=fast"} {
+		if w := share(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %v", query, w.Code)
+		}
+	}
+
+	w := share("?cidrs=10.0.0.0/8,+192.168.1.5&rate=100000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %v", w.Code)
+	}
+
+	var link shareLink
+	if err := json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(link.AllowedCIDRs, []string{"10.0.0.0/8", "192.168.1.5/32"}) || link.RateLimit != 100000 {
+		t.Errorf("Got the restrictions %v and %d", link.AllowedCIDRs, link.RateLimit)
+	}
+
+	// The restricted share isn't reused for a plain one.
+	if w = share(""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), link.Hash) {
+		t.Errorf("The restricted share was reused: got %v %s", w.Code, w.Body.String())
+	}
+
+	download := func(remote, forwarded string) bool {
+		r, err := http.NewRequest("GET", "/share/"+link.Hash+"?dl=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Body.String() == "content"
+	}
+
+	if !download("10.1.2.3:1234", "") || !download("192.168.1.5:1234", "") {
+		t.Error("The allowed networks can't download the share")
+	}
+
+	if download("192.168.1.6:1234", "") || download("127.0.0.1:1234", "10.1.2.3") {
+		t.Error("Other networks can download the share")
+	}
+
+	if err := fm.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !download("127.0.0.1:1234", "10.1.2.3") {
+		t.Error("The clients of a trusted proxy can't download the share")
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	tw := &throttledWriter{ResponseWriter: w, rate: 10000}
+
+	start := time.Now()
+	if n, err := tw.Write(make([]byte, 15000)); n != 15000 || err != nil {
+		t.Fatalf("Got %d bytes written and %v", n, err)
+	}
+
+	// The second chunk is sent a second after the first one.
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("15000 bytes at 10000 bytes per second took %v", elapsed)
+	}
+
+	if w.Body.Len() != 15000 {
+		t.Errorf("Got %d bytes", w.Body.Len())
+	}
+}
+
+func TestSharesAdmin(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	now := time.Now()
+	links := []*shareLink{
+		{Hash: "permanent", UserID: 1, Path: "/srv/a/file.txt", Password: "hash", Protected: true},
+		{Hash: "active", UserID: 2, Path: "/srv/a/dir", Expires: true, ExpireDate: now.Add(time.Hour)},
+		{Hash: "expired", UserID: 2, Path: "/srv/b/file.txt", Expires: true, ExpireDate: now.Add(-time.Hour)},
+		{Hash: "other", UserID: 3, Path: "/srv/b/dir"},
+	}
+
+	for _, link := range links {
+		if err := fm.db.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, url string) (int, []string) {
+		r, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var res []shareLink
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hashes := []string{}
+		for _, link := range res {
+			if link.Password != "" {
+				t.Errorf("%s %s: the password hash of %s was sent", method, url, link.Hash)
+			}
+
+			hashes = append(hashes, link.Hash)
+		}
+
+		sort.Strings(hashes)
+		return w.Code, hashes
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+		hashes []string
+	}{
+		{"GET", "/api/shares/", http.StatusOK, []string{"active", "expired", "other", "permanent"}},
+		{"GET", "/api/shares/?status=active", http.StatusOK, []string{"active", "other", "permanent"}},
+		{"GET", "/api/shares/?status=expired", http.StatusOK, []string{"expired"}},
+		{"GET", "/api/shares/?status=permanent", http.StatusOK, []string{"other", "permanent"}},
+		{"GET", "/api/shares/?prefix=/srv/a/", http.StatusOK, []string{"active", "permanent"}},
+		{"GET", "/api/shares/?hashes=other,active", http.StatusOK, []string{"active", "other"}},
+		{"GET", "/api/shares/?status=old", http.StatusBadRequest, []string{}},
+		{"GET", "/api/shares/expire", http.StatusMethodNotAllowed, []string{}},
+		{"GET", "/api/shares/other", http.StatusNotFound, []string{}},
+		// The shares are expired and deleted across users.
+		{"POST", "/api/shares/expire?prefix=/srv/a/", http.StatusOK, []string{"active", "permanent"}},
+		{"GET", "/api/shares/?status=expired", http.StatusOK, []string{"active", "expired", "permanent"}},
+		{"DELETE", "/api/shares/?status=expired&prefix=/srv/a/", http.StatusOK, []string{"active", "permanent"}},
+		{"GET", "/api/shares/", http.StatusOK, []string{"expired", "other"}},
+	}
+
+	for _, test := range tests {
+		if code, hashes := do(test.method, test.url); code != test.code || !reflect.DeepEqual(hashes, test.hashes) {
+			t.Errorf("%s %s: got %v %v, want %v %v", test.method, test.url, code, hashes, test.code, test.hashes)
+		}
+	}
+
+	// Only the admins can manage them.
+	fm.Users["admin"].Admin = false
+	if code, _ := do("GET", "/api/shares/"); code != http.StatusForbidden {
+		t.Errorf("Non admin: got %v", code)
+	}
+
+	if code, _ := do("DELETE", "/api/shares/"); code != http.StatusForbidden {
+		t.Errorf("Non admin delete: got %v", code)
+	}
+
+	var all []shareLink
+	if err := fm.db.All(&all); err != nil || len(all) != 2 {
+		t.Errorf("Got %d shares after the forbidden delete: %v", len(all), err)
+	}
+}
+
+func TestShareExpiry(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	if err := ioutil.WriteFile(filepath.Join(fm.Temp, "scope", "file.txt"), []byte("content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	share := func(query string) (int, shareLink) {
+		r, err := http.NewRequest("POST", "/api/share/file.txt"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		// The reused permanent shares are sent as their URL.
+		var link shareLink
+		if w.Code == http.StatusOK && !strings.HasPrefix(w.Body.String(), "{") {
+			link.Hash = w.Body.String()[strings.LastIndex(w.Body.String(), "/")+1:]
+		} else if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, link
+	}
+
+	// Without a default, the shares are permanent.
+	if code, link := share(""); code != http.StatusOK || link.Expires {
+		t.Errorf("No default: got %v %+v", code, link)
+	}
+
+	// The expiries which aren't positive don't make the shares permanent.
+	for _, query := range []string{"?expires=0", "?expires=-5&unit=days", "?expires=9223372036854775807&unit=days"} {
+		if code, _ := share(query); code != http.StatusBadRequest {
+			t.Errorf("%q: got %v", query, code)
+		}
+	}
+
+	fm.DefaultShareExpiry = 2 * time.Hour
+	code, link := share("")
+	if code != http.StatusOK || !link.Expires || time.Until(link.ExpireDate) > 2*time.Hour || time.Until(link.ExpireDate) < time.Hour {
+		t.Errorf("Default expiry: got %v %+v", code, link)
+	}
+
+	if code, link = share("?permanent=true"); code != http.StatusOK || link.Expires {
+		t.Errorf("Permanent: got %v %+v", code, link)
+	}
+
+	if code, link = share("?expires=30&unit=minutes"); code != http.StatusOK || time.Until(link.ExpireDate) > 30*time.Minute {
+		t.Errorf("Explicit expiry: got %v %+v", code, link)
+	}
+
+	// The maximum rejects the longer and the permanent shares.
+	fm.MaxShareExpiry = 24 * time.Hour
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"?expires=1&unit=days", http.StatusOK},
+		{"?expires=25&unit=hours", http.StatusBadRequest},
+		{"?permanent=true", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if code, _ := share(test.query); code != test.code {
+			t.Errorf("%q with a maximum: got %v, want %v", test.query, code, test.code)
+		}
+	}
+
+	fm.DefaultShareExpiry = 0
+	if code, _ := share(""); code != http.StatusBadRequest {
+		t.Errorf("Permanent default with a maximum: got %v", code)
+	}
+}
@@ -0,0 +1,109 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var errNoShareFolder = errors.New("the share folder isn't configured")
+
+// resourceShareFolder copies, or moves, the file to the share folder of the
+// user and shares it, replying with the new share. The file keeps its name
+// and isn't overwritten if there is already one with it in the folder.
+func resourceShareFolder(c *RequestContext, w http.ResponseWriter, r *http.Request, move bool) (int, error) {
+	if c.ShareFolder == "" {
+		return http.StatusNotImplemented, errNoShareFolder
+	}
+
+	if !c.User.AllowNew {
+		return http.StatusForbidden, nil
+	}
+
+	src := r.URL.Path
+	folder := path.Clean("/" + c.ShareFolder)
+	dst := path.Join(folder, path.Base(src))
+
+	if src == "/" || src == folder || !c.User.Allowed(dst) {
+		return http.StatusForbidden, nil
+	}
+
+	// The shares get the default expiry, like the ones created without one.
+	duration := c.DefaultShareExpiry
+	if c.MaxShareExpiry > 0 && (duration <= 0 || duration > c.MaxShareExpiry) {
+		return http.StatusBadRequest, errShareExpiry
+	}
+
+	info, err := c.User.FileSystem.Stat(src)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if err = c.checkUploadPath(c.User, newPath(dst, info)); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err = c.checkCaseCollision(c.User, dst, ""); err != nil {
+		return http.StatusConflict, err
+	}
+
+	if _, err = c.User.FileSystem.Stat(dst); err == nil {
+		return http.StatusConflict, os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return errorToHTTP(err, false), err
+	}
+
+	// Checks the limit before touching the files so they aren't left in
+	// the folder without a share.
+	if limit := c.shareLimit(c.User); limit > 0 {
+		count, err := c.activeShares(c.User)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		if count >= limit {
+			return http.StatusForbidden, errShareLimit
+		}
+	}
+
+	if err = c.User.FileSystem.Mkdir(folder, 0775); err != nil && !os.IsExist(err) {
+		return errorToHTTP(err, false), err
+	}
+
+	if move {
+		err = c.User.FileSystem.Rename(src, dst)
+	} else {
+		err = c.User.FileSystem.Copy(src, dst)
+	}
+
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	scope := string(c.User.FileSystem)
+	if move {
+		if err = c.moveMetadata(filepath.Join(scope, src), filepath.Join(scope, dst)); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	s := shareLink{
+		Path:    filepath.Join(scope, dst),
+		Expires: duration != 0,
+	}
+
+	if s.Expires {
+		s.ExpireDate = time.Now().Add(duration)
+	}
+
+	if err = c.createShare(c.User, &s); err == errShareLimit {
+		return http.StatusForbidden, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, s)
+}
@@ -0,0 +1,94 @@
+package filemanager
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultShutdownTimeout is the default maximum time to wait for the
+// downloads and jobs in progress when shutting down.
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownState tracks the operations which must finish before
+// the File Manager is shut down.
+type shutdownState struct {
+	sync.Mutex
+	closing bool
+	ops     sync.WaitGroup
+}
+
+// begin registers the start of an operation. It returns false if the File
+// Manager is shutting down, in which case the operation mustn't start.
+func (s *shutdownState) begin() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.ops.Add(1)
+	return true
+}
+
+// end registers the end of an operation.
+func (s *shutdownState) end() {
+	s.ops.Done()
+}
+
+// isClosing tells if the File Manager is shutting down.
+func (s *shutdownState) isClosing() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.closing
+}
+
+// Shutdown gracefully shuts down the File Manager. It stops accepting
+// requests, which fail with 503 Service Unavailable, waits for the
+// downloads and jobs in progress to finish and closes the database. If
+// the context expires first, the remaining jobs are killed and the
+// context's error is returned after closing the database.
+func (m *FileManager) Shutdown(ctx context.Context) error {
+	m.shutdown.Lock()
+	m.shutdown.closing = true
+	m.shutdown.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.shutdown.ops.Wait()
+		close(done)
+	}()
+
+	var err error
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		m.jobs.kill()
+	}
+
+	m.cron.Stop()
+
+	if m.changes != nil {
+		m.changes.watcher.Close()
+	}
+
+	if cerr := m.db.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// rejectShutdown returns 503 Service Unavailable for the requests
+// received while shutting down.
+func rejectShutdown(w http.ResponseWriter) (int, error) {
+	w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+	w.Header().Set("Connection", "close")
+	return http.StatusServiceUnavailable, nil
+}
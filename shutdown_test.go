@@ -0,0 +1,67 @@
+package filemanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	// Simulates a download in progress.
+	if !fm.shutdown.begin() {
+		t.Fatal("Couldn't begin an operation")
+	}
+
+	finished := make(chan error)
+	go func() {
+		finished <- fm.Shutdown(context.Background())
+	}()
+
+	// Waits for the shutdown to start.
+	for !fm.shutdown.isClosing() {
+		time.Sleep(time.Millisecond)
+	}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Request accepted while shutting down: got %v", w.Code)
+	}
+
+	select {
+	case <-finished:
+		t.Fatal("Shut down before the download finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fm.shutdown.end()
+
+	if err = <-finished; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.shutdown.begin()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := fm.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wrong error: got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package filemanager
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateStore keeps the short-lived state, such as the revoked tokens and
+// the jobs, which must be shared by the instances of File Manager when
+// several of them run behind a load balancer. The keys are slash
+// separated paths.
+type StateStore interface {
+	// Get returns the value of the key and whether it exists.
+	Get(key string) ([]byte, bool, error)
+	// Set sets the value of the key, which expires after ttl. Zero means
+	// it never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+	// SetNX sets the key like Set, but only if it doesn't exist, and
+	// tells if it was set. It is atomic, even between the instances
+	// sharing the store.
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	// Incr increments the integer value of the key, which is zero if it
+	// doesn't exist, and returns it. The key expires after ttl since it
+	// was created, or never if ttl is zero. It is atomic, even between
+	// the instances sharing the store.
+	Incr(key string, ttl time.Duration) (int64, error)
+	// CompareAndSwap sets the key like Set, but only if its value is old,
+	// or if it doesn't exist when old is nil, and tells if it was set. It
+	// is atomic, even between the instances sharing the store.
+	CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes the key. It isn't an error if it doesn't exist.
+	Delete(key string) error
+	// Keys returns the keys which start with the prefix, sorted.
+	Keys(prefix string) ([]string, error)
+}
+
+// memoryStoreSweep is how often the expired keys of a memory store are
+// removed.
+const memoryStoreSweep = time.Minute
+
+// memoryStore is the state store used by default. It is only shared by
+// the requests to the same instance.
+type memoryStore struct {
+	sync.Mutex
+	items map[string]memoryItem
+	swept time.Time
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// NewMemoryStore returns a state store which keeps the state in memory.
+func NewMemoryStore() StateStore {
+	return &memoryStore{
+		items: map[string]memoryItem{},
+		swept: time.Now(),
+	}
+}
+
+func (s *memoryStore) Get(key string) ([]byte, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.expired(time.Now()) {
+		return nil, false, nil
+	}
+
+	return item.value, true, nil
+}
+
+func (s *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.set(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if item, ok := s.items[key]; ok && !item.expired(time.Now()) {
+		return false, nil
+	}
+
+	s.set(key, value, ttl)
+	return true, nil
+}
+
+func (s *memoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	item, ok := s.items[key]
+	if !ok || item.expired(now) {
+		s.set(key, []byte("1"), ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(string(item.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	n++
+	item.value = []byte(strconv.FormatInt(n, 10))
+	s.items[key] = item
+	return n, nil
+}
+
+func (s *memoryStore) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	item, ok := s.items[key]
+	if ok && item.expired(time.Now()) {
+		ok = false
+	}
+
+	if ok != (old != nil) || !bytes.Equal(item.value, old) {
+		return false, nil
+	}
+
+	s.set(key, value, ttl)
+	return true, nil
+}
+
+// set sets the key, removing the expired ones from time to time. The
+// store must be locked.
+func (s *memoryStore) set(key string, value []byte, ttl time.Duration) {
+	now := time.Now()
+	if now.Sub(s.swept) > memoryStoreSweep {
+		for k, item := range s.items {
+			if item.expired(now) {
+				delete(s.items, k)
+			}
+		}
+
+		s.swept = now
+	}
+
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expires = now.Add(ttl)
+	}
+
+	s.items[key] = item
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+func (s *memoryStore) Keys(prefix string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	keys := []string{}
+	for k, item := range s.items {
+		if strings.HasPrefix(k, prefix) && !item.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// state returns the state store of the instance, which is kept in memory
+// if FileManager.State isn't set.
+func (m *FileManager) state() StateStore {
+	if m.State != nil {
+		return m.State
+	}
+
+	return m.memoryState
+}
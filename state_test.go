@@ -0,0 +1,337 @@
+package filemanager
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore checks the behavior shared by every state store.
+func testStore(t *testing.T, s StateStore) {
+	if err := s.Set("jobs/a/1", []byte("one"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("jobs/a/2", []byte("two"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("jobs/b/1", []byte("other"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok, err := s.Get("jobs/a/1"); err != nil || !ok || string(value) != "one" {
+		t.Errorf("Get: got %q %v %v", value, ok, err)
+	}
+
+	if _, ok, err := s.Get("missing"); err != nil || ok {
+		t.Errorf("Get of a missing key: got %v %v", ok, err)
+	}
+
+	keys, err := s.Keys("jobs/a/")
+	if err != nil || strings.Join(keys, ",") != "jobs/a/1,jobs/a/2" {
+		t.Errorf("Keys: got %v %v", keys, err)
+	}
+
+	if err = s.Delete("jobs/a/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := s.Get("jobs/a/1"); ok {
+		t.Error("The key wasn't deleted")
+	}
+
+	// Only the first SetNX of a key sets it.
+	if ok, err := s.SetNX("once", []byte("first"), time.Hour); err != nil || !ok {
+		t.Errorf("SetNX of a new key: got %v %v", ok, err)
+	}
+
+	if ok, err := s.SetNX("once", []byte("second"), time.Hour); err != nil || ok {
+		t.Errorf("SetNX of an existing key: got %v %v", ok, err)
+	}
+
+	if value, _, _ := s.Get("once"); string(value) != "first" {
+		t.Errorf("SetNX replaced the value: got %q", value)
+	}
+
+	// The counters start at one.
+	for i := int64(1); i <= 3; i++ {
+		if n, err := s.Incr("counter", time.Hour); err != nil || n != i {
+			t.Errorf("Incr %d: got %v %v", i, n, err)
+		}
+	}
+
+	// Only the swaps of the current value, or of a missing key when the
+	// old value is nil, set the key.
+	swaps := []struct {
+		old, value string
+		missing    bool
+		ok         bool
+	}{
+		{"", "1", true, true},
+		{"", "2", true, false},
+		{"2", "3", false, false},
+		{"1", "2", false, true},
+		{"2", "", false, true},
+		{"", "4", false, true},
+	}
+
+	for _, swap := range swaps {
+		var old []byte
+		if !swap.missing {
+			old = []byte(swap.old)
+		}
+
+		if ok, err := s.CompareAndSwap("swapped", old, []byte(swap.value), time.Hour); err != nil || ok != swap.ok {
+			t.Errorf("CompareAndSwap %+v: got %v %v", swap, ok, err)
+		}
+	}
+
+	if value, _, _ := s.Get("swapped"); string(value) != "4" {
+		t.Errorf("CompareAndSwap: got %q", value)
+	}
+
+	if err = s.Set("short", []byte("lived"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Incr("short-counter", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, ok, _ := s.Get("short"); ok {
+		t.Error("The key didn't expire")
+	}
+
+	if n, err := s.Incr("short-counter", time.Millisecond); err != nil || n != 1 {
+		t.Errorf("Incr of an expired counter: got %v %v", n, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+// fakeRedis is a Redis server which supports the commands used by
+// RedisStore, with a single page of SCAN results.
+type fakeRedis struct {
+	sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+	// delay is how long each command takes.
+	delay time.Duration
+	conns int
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+
+		args := []string{}
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		f.Lock()
+		delay := f.delay
+		f.Unlock()
+
+		time.Sleep(delay)
+		conn.Write([]byte(f.run(args)))
+	}
+}
+
+func (f *fakeRedis) run(args []string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	for key, expires := range f.expires {
+		if time.Now().After(expires) {
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+	}
+
+	bulk := func(s string) string {
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if value, ok := f.values[args[1]]; ok {
+			return bulk(value)
+		}
+
+		return "$-1\r\n"
+	case "SET":
+		options := args[3:]
+		if len(options) > 0 && options[0] == "NX" {
+			if _, ok := f.values[args[1]]; ok {
+				return "$-1\r\n"
+			}
+
+			options = options[1:]
+		}
+
+		f.values[args[1]] = args[2]
+		delete(f.expires, args[1])
+		if len(options) == 2 && options[0] == "PX" {
+			ms, _ := strconv.Atoi(options[1])
+			f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+
+		return "+OK\r\n"
+	case "EVAL":
+		key := args[3]
+		if args[1] == redisSwap {
+			value, ok := f.values[key]
+			if ok != (args[4] == "1") || (ok && value != args[5]) {
+				return ":0\r\n"
+			}
+
+			f.values[key] = args[6]
+			delete(f.expires, key)
+			if ms, _ := strconv.Atoi(args[7]); ms > 0 {
+				f.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+
+			return ":1\r\n"
+		}
+
+		// Otherwise, it's the script of Incr.
+		n, _ := strconv.Atoi(f.values[key])
+		n++
+		f.values[key] = strconv.Itoa(n)
+		if ms, _ := strconv.Atoi(args[4]); n == 1 && ms > 0 {
+			f.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "DEL":
+		delete(f.values, args[1])
+		return ":1\r\n"
+	case "SCAN":
+		prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), `\`, "", -1)
+		keys := []string{}
+		for key := range f.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+
+		return "*2\r\n" + bulk("0") + "*" + strconv.Itoa(len(keys)) + "\r\n" + strings.Join(keys, "")
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisStore(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	server := &fakeRedis{values: map[string]string{}, expires: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			server.Lock()
+			server.conns++
+			server.Unlock()
+
+			go server.serve(conn)
+		}
+	}()
+
+	s, err := NewRedisStore("redis://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+
+	// The keys are prefixed in the server.
+	server.Lock()
+	if _, ok := server.values["filemanager:jobs/b/1"]; !ok {
+		t.Errorf("The keys weren't prefixed: %v", server.values)
+	}
+	server.Unlock()
+
+	// A broken connection is reopened.
+	for len(s.pool) > 0 {
+		(<-s.pool).Close()
+	}
+
+	if err = s.Set("broken", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := <-s.pool
+	conn.Close()
+	s.pool <- conn
+
+	if _, ok, err := s.Get("jobs/b/1"); err != nil || !ok {
+		t.Errorf("Get after the connection broke: got %v %v", ok, err)
+	}
+
+	// The commands run at once on several connections and only some of
+	// them are kept.
+	server.Lock()
+	server.delay = 20 * time.Millisecond
+	server.conns = 0
+	server.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2*redisPoolSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, err := s.Get("jobs/b/1"); err != nil || !ok {
+				t.Errorf("Concurrent Get: got %v %v", ok, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	server.Lock()
+	conns := server.conns
+	server.Unlock()
+
+	if conns < 2 || len(s.pool) > redisPoolSize {
+		t.Errorf("Got %d new connections and %d idle ones", conns, len(s.pool))
+	}
+}
+
+func TestNewRedisStore(t *testing.T) {
+	s, err := NewRedisStore("redis://:secret@example.com/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Addr != "example.com:6379" || s.Password != "secret" || s.DB != 2 {
+		t.Errorf("Got %s %q %d", s.Addr, s.Password, s.DB)
+	}
+
+	for _, url := range []string{"http://example.com", "redis://", "redis://example.com/db"} {
+		if _, err := NewRedisStore(url); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
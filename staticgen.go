@@ -2,7 +2,6 @@ package filemanager
 
 import (
 	"errors"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -130,7 +129,7 @@ func (h Hugo) Publish(c *RequestContext, w http.ResponseWriter, r *http.Request)
 func (h *Hugo) Preview(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Get a new temporary path if there is none.
 	if h.previewPath == "" {
-		path, err := ioutil.TempDir("", "")
+		path, err := c.tempDir()
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -155,6 +154,20 @@ func (h *Hugo) Preview(c *RequestContext, w http.ResponseWriter, r *http.Request
 	return 0, nil
 }
 
+// DryRun builds the website as Publish would, but into a temporary
+// directory which is removed afterwards.
+func (h Hugo) DryRun(c *RequestContext) (*BuildReport, error) {
+	dest, err := c.tempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dest)
+
+	args := withoutWatch(h.Args)
+	args = append(args, "--destination", dest)
+	return buildReport(h.Exe, args, h.Root, dest)
+}
+
 func (h Hugo) run(force bool) {
 	// If the CleanPublic option is enabled, clean it.
 	if h.CleanPublic {
@@ -240,7 +253,7 @@ func (j Jekyll) Publish(c *RequestContext, w http.ResponseWriter, r *http.Reques
 func (j *Jekyll) Preview(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Get a new temporary path if there is none.
 	if j.previewPath == "" {
-		path, err := ioutil.TempDir("", "")
+		path, err := c.tempDir()
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -265,6 +278,20 @@ func (j *Jekyll) Preview(c *RequestContext, w http.ResponseWriter, r *http.Reque
 	return 0, nil
 }
 
+// DryRun builds the website as Publish would, but into a temporary
+// directory which is removed afterwards.
+func (j Jekyll) DryRun(c *RequestContext) (*BuildReport, error) {
+	dest, err := c.tempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dest)
+
+	args := withoutWatch(j.Args)
+	args = append(args, "--destination", dest)
+	return buildReport(j.Exe, args, j.Root, dest)
+}
+
 func (j Jekyll) run() {
 	// If the CleanPublic option is enabled, clean it.
 	if j.CleanPublic {
@@ -0,0 +1,102 @@
+package filemanager
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// summary is the number of entries of a directory and their total size.
+type summary struct {
+	Files     int   `json:"files"`
+	Dirs      int   `json:"dirs"`
+	Size      int64 `json:"size"`
+	Recursive bool  `json:"recursive"`
+	// Truncated indicates that the directories deeper than the maximum
+	// depth weren't counted.
+	Truncated bool `json:"truncated"`
+	// Unreadable is the number of directories which couldn't be read.
+	Unreadable int `json:"unreadable"`
+}
+
+// add counts an entry.
+func (s *summary) add(info os.FileInfo) {
+	if info.IsDir() {
+		s.Dirs++
+		return
+	}
+
+	s.Files++
+	s.Size += info.Size()
+}
+
+// summaryHandler counts the entries of the directory in c.File without
+// listing them. If the 'recursive' query parameter is true, the entries
+// of the subdirectories are also counted.
+func summaryHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.File.IsDir {
+		return http.StatusBadRequest, errInvalidOption
+	}
+
+	showHidden := r.URL.Query().Get("hidden") == "true"
+	s := &summary{
+		Recursive: r.URL.Query().Get("recursive") == "true",
+	}
+
+	// skip checks if an entry, given its virtual path, isn't counted.
+	skip := func(path string) bool {
+		return !c.User.Allowed(path) || (!showHidden && c.ignored(c.User, path))
+	}
+
+	if !s.Recursive {
+		f, err := c.User.FileSystem.OpenFile(c.File.VirtualPath, os.O_RDONLY, 0)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+		defer f.Close()
+
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+
+		for _, info := range infos {
+			if !skip(filepath.Join(c.File.VirtualPath, info.Name())) {
+				s.add(info)
+			}
+		}
+
+		return renderJSON(w, s)
+	}
+
+	root := filepath.Clean(string(c.User.FileSystem))
+
+	truncated, err := walk(root, c.File.Path, c.MaxDepth, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			s.Unreadable++
+			return nil
+		}
+
+		if path == c.File.Path {
+			return nil
+		}
+
+		if skip(virtualPath(root, path)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		s.add(info)
+		return nil
+	})
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	s.Truncated = truncated
+	return renderJSON(w, s)
+}
@@ -0,0 +1,39 @@
+package filemanager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symlinkTarget resolves the symbolic link at a virtual path of the user.
+// It returns the information of the target if it is inside the scope of
+// the user and allowed by the rules, and nil otherwise. broken tells if
+// the link can't be resolved, such as when the target doesn't exist.
+func symlinkTarget(u *User, virtual string) (info os.FileInfo, broken bool) {
+	root, err := filepath.EvalSymlinks(string(u.FileSystem))
+	if err != nil {
+		return nil, false
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(string(u.FileSystem), virtual))
+	if err != nil {
+		return nil, true
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, false
+	}
+
+	if !u.Allowed("/" + filepath.ToSlash(rel)) {
+		return nil, false
+	}
+
+	info, err = os.Stat(resolved)
+	if err != nil {
+		return nil, true
+	}
+
+	return info, false
+}
@@ -0,0 +1,123 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListingSymlinks(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	dir := filepath.Join(fm.Temp, "scope", "links")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		filepath.Join(dir, "big.txt"):         strings.Repeat("a", 4096),
+		filepath.Join(dir, "secret.txt"):      strings.Repeat("b", 2048),
+		filepath.Join(fm.Temp, "outside.txt"): strings.Repeat("c", 1024),
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"big":     filepath.Join(dir, "big.txt"),
+		"secret":  filepath.Join(dir, "secret.txt"),
+		"outside": filepath.Join(fm.Temp, "outside.txt"),
+		"broken":  filepath.Join(dir, "missing.txt"),
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("Symbolic links aren't supported: %v", err)
+		}
+	}
+
+	fm.Users["admin"].Rules = []*Rule{{Path: "/links/secret.txt", Allow: false}}
+
+	token := login(t, fm, defaultCredentials)
+
+	list := func() map[string]*file {
+		r, err := http.NewRequest("GET", "/api/resource/links/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Listing: got %v", w.Code)
+		}
+
+		res := &listing{}
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+
+		items := map[string]*file{}
+		for _, item := range res.Items {
+			items[item.Name] = item
+		}
+
+		return items
+	}
+
+	tests := []struct {
+		name     string
+		size     int64
+		resolved bool
+		broken   bool
+	}{
+		{"big", 4096, true, false},
+		{"secret", 2048, false, false},
+		{"outside", 1024, false, false},
+		{"broken", 0, false, true},
+	}
+
+	for _, resolve := range []bool{false, true} {
+		fm.ResolveSymlinks = resolve
+		items := list()
+
+		for _, test := range tests {
+			item := items[test.name]
+			if item == nil {
+				t.Errorf("%s: missing from the listing", test.name)
+				continue
+			}
+
+			if !item.Symlink || item.Broken != test.broken {
+				t.Errorf("%s: got symlink %v and broken %v", test.name, item.Symlink, item.Broken)
+			}
+
+			// Only the links resolved to their targets have their size.
+			if resolved := item.Size == test.size; resolved != (resolve && test.resolved) {
+				t.Errorf("%s: got size %d with the option set to %v", test.name, item.Size, resolve)
+			}
+		}
+	}
+
+	// The broken links are described instead of failing.
+	info, err := getInfo(&url.URL{Path: "/links/broken"}, fm.FileManager, fm.Users["admin"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Symlink || !info.Broken {
+		t.Errorf("Broken link: got symlink %v and broken %v", info.Symlink, info.Broken)
+	}
+}
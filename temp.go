@@ -0,0 +1,61 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix is the prefix of the names of the temporary files and
+// directories so the ones orphaned by a crash can be found.
+const tempPrefix = "filemanager-"
+
+// SetTempDir sets the directory where the temporary files, such as the
+// archives being downloaded, are created. It checks the directory is
+// writable and removes the temporary files left there by previous runs,
+// so it mustn't be shared with other instances. If dir is empty, the
+// system's temporary directory is used.
+func (m *FileManager) SetTempDir(dir string) error {
+	if dir == "" {
+		m.TempDir = ""
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Checks if the directory is writable.
+	probe, err := ioutil.TempFile(dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+
+	probe.Close()
+	os.Remove(probe.Name())
+
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, tempPrefix) {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			log.Print(err)
+		}
+	}
+
+	m.TempDir = dir
+	return nil
+}
+
+// tempDir creates a new temporary directory.
+func (m *FileManager) tempDir() (string, error) {
+	return ioutil.TempDir(m.TempDir, tempPrefix)
+}
@@ -0,0 +1,55 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetTempDir(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	dir := filepath.Join(fm.Temp, "tmp")
+	orphan := filepath.Join(dir, tempPrefix+"123")
+	other := filepath.Join(dir, "other")
+
+	for _, d := range []string{orphan, other} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fm.SetTempDir(dir); err != nil {
+		t.Fatalf("Couldn't set the temporary directory: %v", err)
+	}
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("The orphaned temporary directory wasn't removed")
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Another directory was removed: %v", err)
+	}
+
+	temp, err := fm.tempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(temp) != dir || !strings.HasPrefix(filepath.Base(temp), tempPrefix) {
+		t.Errorf("Temporary directory created in the wrong place: %s", temp)
+	}
+
+	// Unwritable directories are rejected.
+	file := filepath.Join(fm.Temp, "file")
+	if err = ioutil.WriteFile(file, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fm.SetTempDir(file); err == nil {
+		t.Errorf("A file was accepted as the temporary directory")
+	}
+}
@@ -0,0 +1,432 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// Registers the decoders of the supported image formats.
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// thumbnailSize is the maximum width and height of a thumbnail.
+	thumbnailSize = 64
+	// maxInlineThumbnails is the maximum number of thumbnails embedded
+	// in a single listing. The other images only have their URL.
+	maxInlineThumbnails = 100
+	// maxThumbnailSource is the maximum size of the images which
+	// thumbnails are embedded in listings.
+	maxThumbnailSource = 10 << 20
+	// thumbnailCacheSize is the maximum number of thumbnails in cache.
+	thumbnailCacheSize = 1024
+	// maxThumbnailPixels is the maximum number of pixels of the images
+	// which thumbnails are made of, since a small file can declare huge
+	// dimensions which take a lot of memory to decode.
+	maxThumbnailPixels = 50 << 20
+)
+
+var (
+	errNoThumbnail   = errors.New("thumbnails are only made of images up to 10 MB")
+	errThumbnailDir  = errors.New("the thumbnails can only be generated for directories")
+	errImageTooLarge = errors.New("the image has too many pixels to make a thumbnail of it")
+)
+
+// thumbnailKey identifies a version of an image.
+type thumbnailKey struct {
+	path    string
+	modTime time.Time
+}
+
+// thumbnailCache keeps the data URIs of the generated thumbnails,
+// indexed by the path and modification time of the image.
+type thumbnailCache struct {
+	sync.Mutex
+	items map[thumbnailKey]string
+}
+
+// get returns the thumbnail of the image as a data URI, generating it
+// if it isn't in cache yet.
+func (t *thumbnailCache) get(f *file) (string, error) {
+	key := thumbnailKey{path: f.Path, modTime: f.ModTime}
+
+	t.Lock()
+	uri, ok := t.items[key]
+	t.Unlock()
+
+	if ok {
+		return uri, nil
+	}
+
+	uri, err := makeThumbnail(f.Path)
+	if err != nil {
+		return "", err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	// Starts over when the cache is full.
+	if len(t.items) >= thumbnailCacheSize {
+		t.items = map[thumbnailKey]string{}
+	}
+
+	t.items[key] = uri
+	return uri, nil
+}
+
+// has tells if the thumbnail of the image is in cache.
+func (t *thumbnailCache) has(f *file) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	_, ok := t.items[thumbnailKey{path: f.Path, modTime: f.ModTime}]
+	return ok
+}
+
+// move makes the thumbnails of the images at src, and of its
+// descendants, follow them to dst.
+func (t *thumbnailCache) move(src, dst string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for key, uri := range t.items {
+		if path, ok := movedPath(key.path, src, dst); ok {
+			delete(t.items, key)
+			key.path = path
+			t.items[key] = uri
+		}
+	}
+}
+
+// makeThumbnail scales down the image at path and returns it as
+// a JPEG data URI.
+func makeThumbnail(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// The dimensions are checked before decoding the image.
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxThumbnailPixels {
+		return "", errImageTooLarge
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Keeps the aspect ratio.
+	if width > thumbnailSize || height > thumbnailSize {
+		if width > height {
+			width, height = thumbnailSize, height*thumbnailSize/width
+		} else {
+			width, height = width*thumbnailSize/height, thumbnailSize
+		}
+	}
+
+	if width == 0 {
+		width = 1
+	}
+
+	if height == 0 {
+		height = 1
+	}
+
+	// Nearest neighbour scaling is good enough for such small images.
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, src.At(
+				bounds.Min.X+x*bounds.Dx()/width,
+				bounds.Min.Y+y*bounds.Dy()/height,
+			))
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	if err = jpeg.Encode(buffer, dst, &jpeg.Options{Quality: 70}); err != nil {
+		return "", err
+	}
+
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// embedThumbnails adds the thumbnails of the images to the listing, up to
+// maxInlineThumbnails images.
+func (l listing) embedThumbnails(cache *thumbnailCache) {
+	count := 0
+
+	for _, item := range l.Items {
+		if count >= maxInlineThumbnails {
+			return
+		}
+
+		if item.Type != "image" || item.Size > maxThumbnailSource {
+			continue
+		}
+
+		uri, err := cache.get(item)
+		if err != nil {
+			continue
+		}
+
+		item.Thumbnail = uri
+		count++
+	}
+}
+
+// thumbnailHandler serves the thumbnail of the image in c.File. Its ETag
+// changes with the modification time of the image so, once it changes,
+// the cached thumbnails are replaced when they're revalidated. The URLs
+// whose 'v' parameter is the ETag, without the quotes, are cached for
+// ThumbnailMaxAge.
+func thumbnailHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method == http.MethodPost {
+		return thumbnailWarmHandler(c, w, r)
+	}
+
+	if c.File.IsDir {
+		return http.StatusBadRequest, errNoThumbnail
+	}
+
+	if err := c.File.GetFileType(false); err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if c.File.Type != "image" || c.File.Size > maxThumbnailSource {
+		return http.StatusBadRequest, errNoThumbnail
+	}
+
+	version := fmt.Sprintf("%x%x", c.File.ModTime.UnixNano(), c.File.Size)
+	etag := `"` + version + `"`
+	w.Header().Set("ETag", etag)
+
+	// The thumbnails aren't public, so only the browsers cache them. Only
+	// the URLs with the version of the image, which is its ETag, never
+	// change, so the others are always revalidated.
+	if c.ThumbnailMaxAge > 0 && r.URL.Query().Get("v") == version {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", int(c.ThumbnailMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return 0, nil
+	}
+
+	release, ok := c.acquire(r, limitThumbnail)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
+	uri, err := c.thumbnails.get(c.File)
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(uri[strings.Index(uri, ",")+1:])
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Write(data)
+	return 0, nil
+}
+
+// thumbnailWarmHandler generates the thumbnails of the images in the
+// directory in c.File, and in its subdirectories if 'recursive' is true,
+// so they're in cache when the directory is browsed. It runs in the
+// background and the response is the job which can be followed on
+// /api/jobs/<id>.
+func thumbnailWarmHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.File.IsDir {
+		return http.StatusBadRequest, errThumbnailDir
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	images, truncated, err := c.thumbnailSources(c.User, c.File, recursive)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	bytes, err := generateRandomBytes(16)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	j := &job{
+		ID:       hex.EncodeToString(bytes),
+		Action:   "thumbnails",
+		Path:     c.File.VirtualPath,
+		Username: c.User.Username,
+		Status:   "running",
+		Started:  time.Now(),
+		Total:    len(images),
+	}
+
+	if truncated {
+		fmt.Fprintf(j, "Only the thumbnails of the first %d images are generated.\n", thumbnailCacheSize)
+	}
+
+	// Shutting down waits for the jobs in progress.
+	if !c.shutdown.begin() {
+		return rejectShutdown(w)
+	}
+
+	c.jobs.add(j)
+	c.saveJob(j)
+
+	go func() {
+		defer c.shutdown.end()
+		c.warmThumbnails(j, images)
+		c.saveJob(j)
+	}()
+
+	w.Header().Set("Location", c.RootURL()+"/api/jobs/"+j.ID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	return renderJSON(w, j.snapshot())
+}
+
+// thumbnailSources returns the images in the directory of the user which
+// thumbnails can be made of, up to thumbnailCacheSize of them, and tells
+// if there were more. The symbolic links aren't followed so the images
+// out of the scope aren't read.
+func (m *FileManager) thumbnailSources(u *User, dir *file, recursive bool) ([]*file, bool, error) {
+	depth := 1
+	if recursive {
+		depth = m.MaxDepth
+	}
+
+	images := []*file{}
+	truncated := false
+
+	_, err := walk(string(u.FileSystem), dir.Path, depth, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir.Path {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir.Path, p)
+		if err != nil {
+			return err
+		}
+
+		virtual := path.Join(dir.VirtualPath, filepath.ToSlash(rel))
+		link, err := os.Lstat(p)
+		skip := err != nil || link.Mode()&os.ModeSymlink != 0 ||
+			!u.Allowed(virtual) || m.ignored(u, virtual)
+
+		if info.IsDir() {
+			if skip {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if skip || info.Size() > maxThumbnailSource {
+			return nil
+		}
+
+		f := &file{
+			Name:        info.Name(),
+			Size:        info.Size(),
+			Extension:   filepath.Ext(info.Name()),
+			ModTime:     info.ModTime(),
+			Path:        p,
+			VirtualPath: virtual,
+		}
+
+		if f.GetFileType(false) != nil || f.Type != "image" {
+			return nil
+		}
+
+		if len(images) >= thumbnailCacheSize {
+			truncated = true
+			return nil
+		}
+
+		images = append(images, f)
+		return nil
+	})
+
+	return images, truncated, err
+}
+
+// warmThumbnails generates the thumbnails of the images which aren't in
+// cache yet, within the limit of thumbnails generated at once. The images
+// which can't be decoded are listed in the output of the job. It stops if
+// the File Manager is shutting down.
+func (m *FileManager) warmThumbnails(j *job, images []*file) {
+	failed := 0
+
+	for _, f := range images {
+		if m.shutdown.isClosing() {
+			break
+		}
+
+		if !m.thumbnails.has(f) {
+			release := m.acquireJob(limitThumbnail)
+			_, err := m.thumbnails.get(f)
+			release()
+
+			if err != nil {
+				failed++
+				fmt.Fprintf(j, "%s: %v\n", f.VirtualPath, err)
+			}
+		}
+
+		j.Lock()
+		j.Done++
+		j.Unlock()
+	}
+
+	now := time.Now()
+
+	j.Lock()
+	defer j.Unlock()
+
+	j.Finished = &now
+	j.Status = "done"
+
+	switch {
+	case j.Done < j.Total:
+		j.Status = "failed"
+		j.Error = "the File Manager is shutting down"
+	case failed > 0:
+		j.Status = "failed"
+		j.Error = fmt.Sprintf("%d of the %d images couldn't be decoded", failed, j.Total)
+	}
+}
@@ -0,0 +1,231 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThumbnailCaching(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.ThumbnailMaxAge = time.Hour
+
+	path := filepath.Join(fm.Temp, "scope", "image.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = png.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/api/thumbnail/image.png"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Thumbnail: got %v %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// The URL without the version always gets revalidated.
+	if cc := w.Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("Unexpected Cache-Control without version: %s", cc)
+	}
+
+	etag := w.Header().Get("ETag")
+	version := "?v=" + strings.Trim(etag, `"`)
+
+	if cc := get(version, "").Header().Get("Cache-Control"); cc != "private, max-age=3600, immutable" {
+		t.Errorf("Unexpected Cache-Control: %s", cc)
+	}
+
+	if w = get("", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Revalidation: expected 304, got %v", w.Code)
+	}
+
+	// Changing the image changes the ETag.
+	modTime := time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if w = get("", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("Changed image: expected 200 with new ETag, got %v %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	// The old version isn't cached anymore.
+	if cc := get(version, "").Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("Unexpected Cache-Control with an old version: %s", cc)
+	}
+
+	fm.ThumbnailMaxAge = 0
+	if w = get("?v="+strings.Trim(w.Header().Get("ETag"), `"`), ""); w.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("Unexpected Cache-Control without max age: %s", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestThumbnailWarmup(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	photos := filepath.Join(fm.Temp, "scope", "photos")
+	if err := os.MkdirAll(filepath.Join(photos, "2018"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.png", "2018/b.png"} {
+		f, err := os.Create(filepath.Join(photos, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = png.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(photos, "broken.png"), []byte("not an image"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(photos, "notes.txt"), []byte("notes"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	request := func(method, url string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	warm := func(query string) *job {
+		w := request("POST", "/api/thumbnail/photos/"+query)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%q: got %v", query, w.Code)
+		}
+
+		j := &job{}
+		if err := json.Unmarshal(w.Body.Bytes(), j); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100 && j.Finished == nil; i++ {
+			time.Sleep(20 * time.Millisecond)
+
+			w := request("GET", "/api/jobs/"+j.ID)
+			if err := json.Unmarshal(w.Body.Bytes(), j); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if j.Finished == nil {
+			t.Fatalf("%q: the job didn't finish", query)
+		}
+
+		return j
+	}
+
+	j := warm("")
+	if j.Total != 2 || j.Done != 2 || j.Status != "failed" || !strings.Contains(j.Output, "broken.png") {
+		t.Errorf("Got %+v, want the broken image reported", j)
+	}
+
+	a := &file{Path: filepath.Join(photos, "a.png")}
+	b := &file{Path: filepath.Join(photos, "2018", "b.png")}
+	for _, f := range []*file{a, b} {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.ModTime = info.ModTime()
+	}
+
+	if !fm.thumbnails.has(a) || fm.thumbnails.has(b) {
+		t.Errorf("Without recursion: got a cached %v and b cached %v", fm.thumbnails.has(a), fm.thumbnails.has(b))
+	}
+
+	if j = warm("?recursive=true"); j.Total != 3 || j.Done != 3 || !fm.thumbnails.has(b) {
+		t.Errorf("Recursive: got %+v and b cached %v", j, fm.thumbnails.has(b))
+	}
+
+	if w := request("POST", "/api/thumbnail/photos/a.png"); w.Code != http.StatusBadRequest {
+		t.Errorf("On a file: got %v", w.Code)
+	}
+}
+
+func TestThumbnailTooManyPixels(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	small := filepath.Join(temp, "small.png")
+	if err = ioutil.WriteFile(small, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = makeThumbnail(small); err != nil {
+		t.Errorf("Small image: %v", err)
+	}
+
+	// The header of the PNG declares 100000x100000 pixels, which the
+	// file is far too small to have.
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	huge := filepath.Join(temp, "huge.png")
+	if err = ioutil.WriteFile(huge, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = makeThumbnail(huge); err != errImageTooLarge {
+		t.Errorf("Huge image: got %v, want %v", err, errImageTooLarge)
+	}
+}
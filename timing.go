@@ -0,0 +1,101 @@
+package filemanager
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// serverTiming measures the stages of a request and reports them in the
+// Server-Timing header. Its methods do nothing if it's nil, so it only
+// has to be created when it's enabled.
+type serverTiming struct {
+	header http.Header
+	last   time.Time
+	done   bool
+}
+
+// newServerTiming starts measuring a request if the debug mode is on.
+func newServerTiming(c *RequestContext, w http.ResponseWriter) *serverTiming {
+	if !c.Debug {
+		return nil
+	}
+
+	return &serverTiming{
+		header: w.Header(),
+		last:   time.Now(),
+	}
+}
+
+// mark adds the time elapsed since the previous mark as the metric name.
+func (t *serverTiming) mark(name string) {
+	if t == nil || t.done {
+		return
+	}
+
+	now := time.Now()
+	dur := float64(now.Sub(t.last)) / float64(time.Millisecond)
+	t.header.Add("Server-Timing", name+";dur="+strconv.FormatFloat(dur, 'f', 3, 64))
+	t.last = now
+}
+
+// finish adds the last metric. Once the response headers are sent,
+// they can't be changed anymore, so no other metric is added.
+func (t *serverTiming) finish(name string) {
+	if t == nil {
+		return
+	}
+
+	t.mark(name)
+	t.done = true
+}
+
+// wrap returns a ResponseWriter which adds the last metric just before
+// the headers are sent.
+func (t *serverTiming) wrap(w http.ResponseWriter, name string) http.ResponseWriter {
+	if t == nil {
+		return w
+	}
+
+	return &timingWriter{ResponseWriter: w, timing: t, name: name}
+}
+
+// timingWriter finishes the timing of a request when the headers of the
+// response are sent.
+type timingWriter struct {
+	http.ResponseWriter
+	timing *serverTiming
+	name   string
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.timing.finish(w.name)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(p []byte) (int, error) {
+	w.timing.finish(w.name)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush is needed to stream the responses.
+func (w *timingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.timing.finish(w.name)
+		f.Flush()
+	}
+}
+
+// Hijack is needed to upgrade the connections to websockets.
+func (w *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer doesn't support hijacking")
+	}
+
+	w.timing.finish(w.name)
+	return h.Hijack()
+}
@@ -0,0 +1,408 @@
+package filemanager
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm"
+)
+
+// tusVersion is the version of the TUS resumable upload protocol
+// supported by this handler.
+const tusVersion = "1.0.0"
+
+// partialPrefix is the prefix of the names of the partial files of the
+// uploads, which the clients can't use.
+const partialPrefix = ".upload-"
+
+// partialFile tells if the file at the path is the partial file of an
+// upload, which is never shown to the clients.
+func partialFile(p string) bool {
+	return strings.HasPrefix(path.Base(filepath.ToSlash(p)), partialPrefix)
+}
+
+var (
+	errUploadTooLarge    = errors.New("upload exceeds the maximum size")
+	errUploadPending     = errors.New("the unfinished uploads exceed the maximum size")
+	errUploadExists      = errors.New("a file was created on the path of the upload")
+	errUploadOffset      = errors.New("upload offset doesn't match")
+	errUploadContentType = errors.New("content type must be application/offset+octet-stream")
+)
+
+// upload is an unfinished TUS upload. Its data is written to a hidden
+// partial file next to the target which is renamed once it is complete.
+// Override tells if it replaces a file which existed on creation.
+type upload struct {
+	ID       string `json:"id" storm:"id"`
+	Username string `json:"username" storm:"index"`
+	Path     string `json:"path"`
+	Length   int64  `json:"length"`
+	Override bool   `json:"override"`
+}
+
+// pendingUploads returns the sum of the lengths of the unfinished
+// uploads of the user.
+func (m *FileManager) pendingUploads(username string) (int64, error) {
+	var uploads []upload
+	err := m.db.Find("Username", username, &uploads)
+	if err != nil && err != storm.ErrNotFound {
+		return 0, err
+	}
+
+	var total int64
+	for _, u := range uploads {
+		total += u.Length
+	}
+
+	return total, nil
+}
+
+// partialPath returns the virtual path of the partial file.
+func (u upload) partialPath() string {
+	return path.Join(path.Dir(u.Path), partialPrefix+u.ID)
+}
+
+// tusHandler implements the core, creation and termination parts of the
+// TUS resumable upload protocol (https://tus.io/protocols/resumable-upload.html).
+func tusHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		if c.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(c.MaxUploadSize, 10))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return 0, nil
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		return http.StatusPreconditionFailed, nil
+	}
+
+	r.URL.Path = sanitizeURL(r.URL.Path)
+
+	if r.Method == http.MethodPost {
+		return tusPostHandler(c, w, r)
+	}
+
+	// Every other method works on an already created upload.
+	var u upload
+	err := c.db.One("ID", strings.TrimPrefix(r.URL.Path, "/"), &u)
+	if err == storm.ErrNotFound {
+		return http.StatusNotFound, nil
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if u.Username != c.User.Username {
+		return http.StatusForbidden, nil
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		return tusHeadHandler(c, w, r, &u)
+	case http.MethodPatch:
+		return tusPatchHandler(c, w, r, &u)
+	case http.MethodDelete:
+		return tusDeleteHandler(c, w, r, &u)
+	}
+
+	return methodNotAllowed(w, http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodDelete)
+}
+
+// tusPostHandler creates a new upload for the path in the URL.
+func tusPostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.User.AllowNew {
+		return http.StatusForbidden, nil
+	}
+
+	if r.URL.Path == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		return http.StatusBadRequest, nil
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return http.StatusBadRequest, err
+	}
+
+	if err = c.checkUploadPath(c.User, r.URL.Path); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err = c.checkCaseCollision(c.User, r.URL.Path, ""); err != nil {
+		return http.StatusConflict, err
+	}
+
+	if c.MaxUploadSize > 0 && length > c.MaxUploadSize {
+		return http.StatusRequestEntityTooLarge, errUploadTooLarge
+	}
+
+	// Just like with POST requests on resources, we don't override
+	// existing files unless we are asked to.
+	override := false
+	if _, err = c.User.FileSystem.Stat(r.URL.Path); err == nil {
+		if r.Header.Get("Action") != "override" {
+			return http.StatusConflict, errors.New("There is already a file on that path")
+		}
+
+		if !c.User.AllowEdit {
+			return http.StatusForbidden, nil
+		}
+
+		override = true
+	}
+
+	c.uploadsMu.Lock()
+	defer c.uploadsMu.Unlock()
+
+	if c.MaxPendingUploadSize > 0 {
+		pending, err := c.pendingUploads(c.User.Username)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		if pending+length > c.MaxPendingUploadSize {
+			return http.StatusRequestEntityTooLarge, errUploadPending
+		}
+	}
+
+	bytes, err := generateRandomBytes(16)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	u := &upload{
+		ID:       hex.EncodeToString(bytes),
+		Username: c.User.Username,
+		Path:     r.URL.Path,
+		Length:   length,
+		Override: override,
+	}
+
+	// Creates the empty partial file.
+	f, err := c.User.FileSystem.OpenFile(u.partialPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0776)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+	f.Close()
+
+	if err = c.db.Save(u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	// Empty uploads are finished as soon as they are created.
+	if length == 0 {
+		if code, err := tusFinish(c, u); err != nil {
+			return code, err
+		}
+	}
+
+	w.Header().Set("Location", c.RootURL()+"/api/tus/"+u.ID)
+	w.WriteHeader(http.StatusCreated)
+	return 0, nil
+}
+
+// tusHeadHandler tells the client how much of the upload was already received.
+func tusHeadHandler(c *RequestContext, w http.ResponseWriter, r *http.Request, u *upload) (int, error) {
+	info, err := c.User.FileSystem.Stat(u.partialPath())
+	if err != nil {
+		return errorToHTTP(err, true), err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.WriteHeader(http.StatusOK)
+	return 0, nil
+}
+
+// tusPatchHandler appends a chunk to the upload. When the last byte is
+// received, the partial file is moved to its final path.
+func tusPatchHandler(c *RequestContext, w http.ResponseWriter, r *http.Request, u *upload) (int, error) {
+	// Discard any invalid chunk before returning to avoid connection
+	// reset error.
+	defer func() {
+		io.Copy(ioutil.Discard, r.Body)
+	}()
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return http.StatusUnsupportedMediaType, errUploadContentType
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	f, err := c.User.FileSystem.OpenFile(u.partialPath(), os.O_WRONLY|os.O_APPEND, 0776)
+	if err != nil {
+		return errorToHTTP(err, true), err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if info.Size() != offset {
+		return http.StatusConflict, errUploadOffset
+	}
+
+	// Never write more than what was announced on creation.
+	written, err := io.Copy(f, io.LimitReader(r.Body, u.Length-offset))
+	offset += written
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if offset == u.Length {
+		f.Close()
+
+		if code, err := tusFinish(c, u); err != nil {
+			return code, err
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
+
+// tusDeleteHandler cancels an upload and removes its partial file.
+func tusDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Request, u *upload) (int, error) {
+	if err := c.User.FileSystem.RemoveAll(u.partialPath()); err != nil {
+		return errorToHTTP(err, true), err
+	}
+
+	if err := c.db.DeleteStruct(u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
+
+// tusCheckType checks if the policies allow the type of the complete
+// upload and removes it otherwise.
+func tusCheckType(c *RequestContext, u *upload) (int, error) {
+	ok, err := c.fileAllowed(c.User, u.partialPath(), u.Path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if ok {
+		return 0, nil
+	}
+
+	c.User.FileSystem.RemoveAll(u.partialPath())
+	c.db.DeleteStruct(u)
+	return http.StatusUnsupportedMediaType, errUploadType
+}
+
+// tusFinish moves a completed upload to its final path, running the
+// save commands around it.
+func tusFinish(c *RequestContext, u *upload) (int, error) {
+	path := filepath.Join(string(c.User.FileSystem), u.Path)
+
+	if code, err := tusCheckType(c, u); err != nil {
+		return code, err
+	}
+
+	if c.Scanner != nil {
+		if code, err := tusScan(c, u); err != nil {
+			return code, err
+		}
+	}
+
+	if err := c.Runner("before_save", path); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	// The file created on the path while uploading is only replaced if
+	// the one which existed on creation was meant to be.
+	partial := filepath.Join(string(c.User.FileSystem), u.partialPath())
+	if u.Override {
+		if err := os.Rename(partial, path); err != nil {
+			return errorToHTTP(err, false), err
+		}
+	} else if err := renameNew(partial, path); os.IsExist(err) {
+		c.User.FileSystem.RemoveAll(u.partialPath())
+		c.db.DeleteStruct(u)
+		return http.StatusConflict, errUploadExists
+	} else if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if err := c.db.DeleteStruct(u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err := c.Runner("after_save", path); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// tusScan scans a completed upload. The upload is discarded if
+// it's rejected by the scanner.
+func tusScan(c *RequestContext, u *upload) (int, error) {
+	f, err := c.User.FileSystem.OpenFile(u.partialPath(), os.O_RDONLY, 0)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	code, err := scanFile(c.Scanner, f)
+	f.Close()
+
+	if code == http.StatusUnprocessableEntity {
+		c.User.FileSystem.RemoveAll(u.partialPath())
+		c.db.DeleteStruct(u)
+	}
+
+	return code, err
+}
+
+// renameNew renames the file at oldpath to newpath, which are absolute,
+// unless there is already a file at newpath.
+func renameNew(oldpath, newpath string) error {
+	// Unlike a rename, the hard link fails if newpath exists.
+	err := os.Link(oldpath, newpath)
+	if err == nil {
+		if err = os.Remove(oldpath); err != nil {
+			log.Print(err)
+		}
+
+		return nil
+	}
+
+	if os.IsExist(err) {
+		return err
+	}
+
+	// Some file systems don't have hard links.
+	if _, err = os.Lstat(newpath); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(oldpath, newpath)
+}
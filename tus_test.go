@@ -0,0 +1,192 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTusUpload(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+
+	token := login(t, fm, defaultCredentials)
+
+	tus := func(method, url string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Tus-Resumable", tusVersion)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	create := func(name string, length int) string {
+		w := tus("POST", "/api/tus/"+name, map[string]string{"Upload-Length": strconv.Itoa(length)}, "")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Create %s: got %v", name, w.Code)
+		}
+
+		return strings.TrimPrefix(w.Header().Get("Location"), "/api/tus")
+	}
+
+	patch := func(location string, offset int, body string) *httptest.ResponseRecorder {
+		return tus("PATCH", "/api/tus"+location, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, body)
+	}
+
+	// The server tells what it supports.
+	w := tus("OPTIONS", "/api/tus/", nil, "")
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("Options: got %v %v", w.Code, w.Header())
+	}
+
+	if w = tus("POST", "/api/tus/file.txt", map[string]string{"Tus-Resumable": "0.2.0", "Upload-Length": "1"}, ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Other version: got %v", w.Code)
+	}
+
+	// The upload is received in chunks.
+	location := create("file.txt", 10)
+
+	if w = tus("HEAD", "/api/tus"+location, nil, ""); w.Header().Get("Upload-Offset") != "0" || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("Head: got %v", w.Header())
+	}
+
+	if w = patch(location, 0, "01234"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("First chunk: got %v %v", w.Code, w.Header())
+	}
+
+	if w = patch(location, 2, "xx"); w.Code != http.StatusConflict {
+		t.Errorf("Wrong offset: got %v", w.Code)
+	}
+
+	w = tus("PATCH", "/api/tus"+location, map[string]string{"Upload-Offset": "5"}, "56789")
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Wrong content type: got %v", w.Code)
+	}
+
+	// The partial file isn't listed.
+	r, err := http.NewRequest("GET", "/api/resource/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	var listing struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+
+	if err = json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range listing.Items {
+		if partialFile(item.Name) {
+			t.Errorf("The partial file %s is listed", item.Name)
+		}
+	}
+
+	if w = patch(location, 5, "56789"); w.Code != http.StatusNoContent {
+		t.Errorf("Last chunk: got %v", w.Code)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(scope, "file.txt")); string(data) != "0123456789" {
+		t.Errorf("Finished upload: got %q", data)
+	}
+
+	if w = tus("HEAD", "/api/tus"+location, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Head of a finished upload: got %v", w.Code)
+	}
+
+	// The existing files are only replaced if asked to.
+	if w = tus("POST", "/api/tus/file.txt", map[string]string{"Upload-Length": "1"}, ""); w.Code != http.StatusConflict {
+		t.Errorf("Create over a file: got %v", w.Code)
+	}
+
+	// The files created while uploading are never replaced.
+	location = create("other.txt", 3)
+	if err = ioutil.WriteFile(filepath.Join(scope, "other.txt"), []byte("mine"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if w = patch(location, 0, "abc"); w.Code != http.StatusConflict {
+		t.Errorf("Finish over a new file: got %v", w.Code)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(scope, "other.txt")); string(data) != "mine" {
+		t.Errorf("The new file was replaced: got %q", data)
+	}
+
+	// The uploads can be cancelled.
+	location = create("cancel.txt", 3)
+	if w = tus("DELETE", "/api/tus"+location, nil, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Delete: got %v", w.Code)
+	}
+
+	names, err := filepath.Glob(filepath.Join(scope, partialPrefix+"*"))
+	if err != nil || len(names) != 0 {
+		t.Errorf("The partial files were left: %v %v", names, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(scope, "cancel.txt")); !os.IsNotExist(err) {
+		t.Errorf("The cancelled upload was saved: %v", err)
+	}
+}
+
+func TestTusPending(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.MaxPendingUploadSize = 10
+
+	token := login(t, fm, defaultCredentials)
+
+	create := func(name string, length int) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/api/tus/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Tus-Resumable", tusVersion)
+		r.Header.Set("Upload-Length", strconv.Itoa(length))
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := create("a.txt", 6); w.Code != http.StatusCreated {
+		t.Fatalf("First upload: got %v", w.Code)
+	}
+
+	if w := create("b.txt", 5); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload over the pending size: got %v", w.Code)
+	}
+
+	if w := create("c.txt", 4); w.Code != http.StatusCreated {
+		t.Errorf("Upload within the pending size: got %v", w.Code)
+	}
+}
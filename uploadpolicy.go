@@ -0,0 +1,154 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// sniffLength is the number of bytes used to detect the MIME type of
+// the uploaded files.
+const sniffLength = 512
+
+var errUploadType = errors.New("the type of the file isn't allowed")
+
+// UploadPolicy restricts the types of the files which can be uploaded.
+// The types are extensions, such as '.exe', or MIME types, such as
+// 'video/mp4' or 'video/*'. The MIME type is detected from the first
+// bytes of the file so renaming it doesn't change it.
+type UploadPolicy struct {
+	// Allow are the only types which can be uploaded. Empty means
+	// every type can be uploaded.
+	Allow []string `json:"allow"`
+	// Deny are the types which can't be uploaded, even if allowed.
+	Deny []string `json:"deny"`
+}
+
+// allows checks if the file with the name and the MIME type can be
+// uploaded.
+func (p UploadPolicy) allows(name, mime string) bool {
+	for _, pattern := range p.Deny {
+		if matchType(pattern, name, mime) {
+			return false
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Allow {
+		if matchType(pattern, name, mime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchType checks if the file with the name and the MIME type is of
+// the type, ignoring the case.
+func matchType(pattern, name, mime string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return false
+	}
+
+	// Extensions are compared as suffixes so '.tar.gz' works.
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(strings.ToLower(name), pattern)
+	}
+
+	mime = strings.ToLower(strings.TrimSpace(strings.SplitN(mime, ";", 2)[0]))
+
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mime, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return mime == pattern
+}
+
+// uploadPolicies returns the policies the uploads of the user must pass.
+func (m *FileManager) uploadPolicies(u *User) []UploadPolicy {
+	return []UploadPolicy{m.UploadPolicy, u.UploadPolicy}
+}
+
+// uploadAllowed checks if the global and the user's policies allow
+// uploading the file with the name and the MIME type.
+func (m *FileManager) uploadAllowed(u *User, name, mime string) bool {
+	for _, p := range m.uploadPolicies(u) {
+		if !p.allows(name, mime) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fileAllowed checks if the global and the user's policies allow the
+// content of the file at the virtual path src to be saved at dst.
+func (m *FileManager) fileAllowed(u *User, src, dst string) (bool, error) {
+	f, err := u.FileSystem.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	mime, _, err := sniffType(f)
+	if err != nil {
+		return false, err
+	}
+
+	return m.uploadAllowed(u, dst, mime), nil
+}
+
+// sniffType detects the MIME type of the content from its first bytes
+// and returns a reader with the whole content.
+func sniffType(r io.Reader) (string, io.Reader, error) {
+	buffer := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	buffer = buffer[:n]
+	return http.DetectContentType(buffer), io.MultiReader(bytes.NewReader(buffer), r), nil
+}
+
+// sniffRange detects the MIME type the file at the virtual path p takes
+// once the content is written at the range, which starts in the bytes
+// used to detect it, and returns a reader with the whole content.
+func sniffRange(u *User, p string, rng *contentRange, r io.Reader) (string, io.Reader, error) {
+	buffer := make([]byte, sniffLength)
+
+	f, err := u.FileSystem.OpenFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	size, err := io.ReadFull(f, buffer)
+	f.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	n, err := io.ReadFull(r, buffer[rng.start:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	// The file grows up to the end of the range or the declared total.
+	if end := int(rng.start) + n; end > size {
+		size = end
+	}
+
+	if rng.total != -1 && rng.total < int64(sniffLength) && int(rng.total) > size {
+		size = int(rng.total)
+	}
+
+	content := buffer[rng.start : int(rng.start)+n]
+	return http.DetectContentType(buffer[:size]), io.MultiReader(bytes.NewReader(content), r), nil
+}
@@ -0,0 +1,122 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadPolicy(t *testing.T) {
+	policy := UploadPolicy{
+		Allow: []string{"image/*", ".TXT", "application/pdf"},
+		Deny:  []string{".exe", "image/gif"},
+	}
+
+	tests := []struct {
+		name, mime string
+		want       bool
+	}{
+		{"photo.png", "image/png", true},
+		{"photo.jpg", "image/jpeg", true},
+		{"notes.txt", "text/plain; charset=utf-8", true},
+		{"NOTES.TXT", "text/plain; charset=utf-8", true},
+		{"doc.pdf", "application/pdf", true},
+		{"anim.gif", "image/gif", false},
+		{"renamed.png", "image/gif", false},
+		{"setup.exe", "image/png", false},
+		{"page.html", "text/html; charset=utf-8", false},
+	}
+
+	for _, test := range tests {
+		if got := policy.allows(test.name, test.mime); got != test.want {
+			t.Errorf("allows(%q, %q) = %v, want %v", test.name, test.mime, got, test.want)
+		}
+	}
+
+	if !(UploadPolicy{}).allows("anything.bin", "application/octet-stream") {
+		t.Error("Empty policy doesn't allow everything")
+	}
+}
+
+func TestUploadPolicyEnforced(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	fm.UploadPolicy.Deny = []string{".exe"}
+	fm.Users["admin"].UploadPolicy.Deny = []string{"image/*"}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		name, content string
+		code          int
+	}{
+		{"notes.txt", "some notes", http.StatusOK},
+		{"setup.exe", "MZ", http.StatusUnsupportedMediaType},
+		// The content is sniffed, so renaming an image doesn't help.
+		{"image.txt", "\x89PNG\r\n\x1a\n", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "/api/resource/"+test.name, strings.NewReader(test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected %v, got %v", test.name, test.code, w.Code)
+		}
+	}
+	// The policies can't be bypassed by renaming, copying or changing
+	// a part of a file.
+	patch := func(action, dst string) int {
+		r, err := http.NewRequest("PATCH", "/api/resource/notes.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Action", action)
+		r.Header.Set("Destination", dst)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := patch("rename", "/notes.exe"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Rename to a denied type: got %v", code)
+	}
+
+	if code := patch("copy", "/setup.exe"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Copy to a denied type: got %v", code)
+	}
+
+	if code := patch("rename", "/renamed.txt"); code != http.StatusOK {
+		t.Errorf("Rename to an allowed type: got %v", code)
+	}
+
+	r, err := http.NewRequest("PUT", "/api/resource/renamed.txt", strings.NewReader("\x89PNG\r\n\x1a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Range", "bytes 0-7/*")
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Ranged write of a denied type: got %v", w.Code)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(fm.Temp, "scope", "renamed.txt")); string(data) != "some notes" {
+		t.Errorf("The denied ranged write changed the file: got %q", data)
+	}
+}
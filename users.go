@@ -1,10 +1,15 @@
 package filemanager
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,7 +23,7 @@ type modifyRequest struct {
 }
 
 type modifyUserRequest struct {
-	*modifyRequest
+	modifyRequest
 	Data *User `json:"data"`
 }
 
@@ -42,7 +47,7 @@ func usersHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (in
 		return usersPutHandler(c, w, r)
 	}
 
-	return http.StatusNotImplemented, nil
+	return methodNotAllowed(w, apiMethods["users"]...)
 }
 
 // getUserID returns the id from the user which is present
@@ -94,8 +99,13 @@ func usersGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	// Request for the listing of users.
 	if r.URL.Path == "/" {
 		users := []User{}
+		query := strings.ToLower(r.URL.Query().Get("q"))
 
 		for _, user := range c.Users {
+			if !strings.Contains(strings.ToLower(user.Username), query) {
+				continue
+			}
+
 			// Copies the user info and removes its
 			// password so it won't be sent to the
 			// front-end.
@@ -108,7 +118,14 @@ func usersGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 			return users[i].ID < users[j].ID
 		})
 
-		return renderJSON(w, users)
+		start, end, err := pageBounds(r, len(users))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		// The total lets the clients know how many pages there are.
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(users)))
+		return renderJSON(w, users[start:end])
 	}
 
 	id, err := getUserID(r)
@@ -131,9 +148,58 @@ func usersGetHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	return http.StatusNotFound, errUserNotExist
 }
 
+// usersPerPage is the default number of users in each page of the listing.
+const usersPerPage = 50
+
+// pageBounds returns the bounds of the page of n items requested with the
+// 'page' and 'perPage' query parameters. Without them, every item is in
+// the page.
+func pageBounds(r *http.Request, n int) (int, int, error) {
+	var (
+		page    = 1
+		perPage = 0
+		err     error
+	)
+
+	if val := r.URL.Query().Get("page"); val != "" {
+		if page, err = strconv.Atoi(val); err != nil || page < 1 {
+			return 0, 0, errInvalidOption
+		}
+
+		perPage = usersPerPage
+	}
+
+	if val := r.URL.Query().Get("perPage"); val != "" {
+		if perPage, err = strconv.Atoi(val); err != nil || perPage < 1 {
+			return 0, 0, errInvalidOption
+		}
+	}
+
+	if perPage == 0 {
+		return 0, n, nil
+	}
+
+	// Compared by division so huge pages don't overflow.
+	start := n
+	if page-1 <= n/perPage {
+		start = (page - 1) * perPage
+	}
+
+	if start > n {
+		start = n
+	}
+
+	end := start + perPage
+	if end > n {
+		end = n
+	}
+
+	return start, end, nil
+}
+
 func usersPostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	if r.URL.Path != "/" {
-		return http.StatusMethodNotAllowed, nil
+		return methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
 	}
 
 	u, _, err := getUser(r)
@@ -171,18 +237,43 @@ func usersPostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		u.Commands = []string{}
 	}
 
+	// Checks if the environment variables are valid.
+	if err := checkEnvironment(u.Environment); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the ignore patterns are valid.
+	if err := checkIgnore(u.Ignore); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the avatar is valid.
+	if u.Avatar, err = avatarURL(u.Avatar); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the view mode is valid.
+	if err := checkViewMode(u.ViewMode); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the landing path is valid.
+	if err := checkLandingPath(u, u.LandingPath); err != nil {
+		return http.StatusBadRequest, err
+	}
+
 	// It's a new user so the ID will be auto created.
 	if u.ID != 0 {
 		u.ID = 0
 	}
 
-	// Checks if the scope exists.
-	if code, err := checkFS(string(u.FileSystem)); err != nil {
+	// Creates the scope if it doesn't exist.
+	if code, err := c.provisionHome(u); err != nil {
 		return code, err
 	}
 
 	// Hashes the password.
-	pw, err := hashPassword(u.Password)
+	pw, err := c.hashPassword(u.Password)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -202,12 +293,104 @@ func usersPostHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	// Saves the user to the memory.
 	c.Users[u.Username] = u
 
+	// Starts watching the scope of the new user.
+	if c.changes != nil {
+		if err := c.changes.watch(string(u.FileSystem)); err != nil {
+			log.Print(err)
+		}
+	}
+
 	// Set the Location header and return.
 	w.Header().Set("Location", "/users/"+strconv.Itoa(u.ID))
 	w.WriteHeader(http.StatusCreated)
 	return 0, nil
 }
 
+// getUserByID returns the in-memory user with the given ID or nil
+// if there isn't any.
+func getUserByID(c *RequestContext, id int) *User {
+	for _, user := range c.Users {
+		if user.ID == id {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// envNameRegexp matches valid environment variable names.
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkEnvironment checks if every environment variable has a valid name.
+func checkEnvironment(env map[string]string) error {
+	for key := range env {
+		if !envNameRegexp.MatchString(key) {
+			return errInvalidEnvironment
+		}
+	}
+
+	return nil
+}
+
+// gravatarURL is the URL of the Gravatar pictures, without the hash.
+const gravatarURL = "https://www.gravatar.com/avatar/"
+
+// avatarURL validates the avatar of a user, which can be an http(s) URL
+// or an email. The emails are converted into their Gravatar URL so they
+// aren't stored nor sent to the clients.
+func avatarURL(avatar string) (string, error) {
+	avatar = strings.TrimSpace(avatar)
+	if avatar == "" {
+		return "", nil
+	}
+
+	if !strings.Contains(avatar, "://") {
+		if !strings.Contains(avatar, "@") {
+			return "", errInvalidAvatar
+		}
+
+		hash := md5.Sum([]byte(strings.ToLower(avatar)))
+		return gravatarURL + hex.EncodeToString(hash[:]) + "?d=identicon", nil
+	}
+
+	u, err := url.Parse(avatar)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errInvalidAvatar
+	}
+
+	return u.String(), nil
+}
+
+// The layouts of the listings. defaultViewMode is used when the user has
+// none, like the users saved before the view modes existed.
+const defaultViewMode = "mosaic"
+
+var viewModes = []string{"list", "grid", defaultViewMode}
+
+// checkViewMode checks if the view mode is known. It can be empty.
+func checkViewMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+
+	for _, known := range viewModes {
+		if mode == known {
+			return nil
+		}
+	}
+
+	return errInvalidViewMode
+}
+
+// viewMode returns the layout of the listings of the user.
+func (u *User) viewMode() string {
+	if u.ViewMode == "" || checkViewMode(u.ViewMode) != nil {
+		return defaultViewMode
+	}
+
+	return u.ViewMode
+}
+
 func checkFS(path string) (int, error) {
 	info, err := os.Stat(path)
 
@@ -216,7 +399,7 @@ func checkFS(path string) (int, error) {
 			return http.StatusInternalServerError, err
 		}
 
-		err = os.MkdirAll(path, 0666)
+		err = os.MkdirAll(path, 0755)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -225,7 +408,7 @@ func checkFS(path string) (int, error) {
 	}
 
 	if !info.IsDir() {
-		return http.StatusBadRequest, errors.New("Scope is not a dir")
+		return http.StatusBadRequest, errScopeNotDir
 	}
 
 	return 0, nil
@@ -233,7 +416,7 @@ func checkFS(path string) (int, error) {
 
 func usersDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	if r.URL.Path == "/" {
-		return http.StatusMethodNotAllowed, nil
+		return methodNotAllowed(w, http.MethodGet, http.MethodPost)
 	}
 
 	id, err := getUserID(r)
@@ -241,6 +424,8 @@ func usersDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		return http.StatusInternalServerError, err
 	}
 
+	u := getUserByID(c, id)
+
 	// Deletes the user from the database.
 	err = c.db.DeleteStruct(&User{ID: id})
 	if err == storm.ErrNotFound {
@@ -259,13 +444,25 @@ func usersDeleteHandler(c *RequestContext, w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	if err := c.deleteCommandHistory(id); err != nil {
+		log.Print(err)
+	}
+
+	// The user is already deleted so the scope is kept if it
+	// can't be removed.
+	if u != nil {
+		if err := c.releaseHome(u); err != nil {
+			log.Print(err)
+		}
+	}
+
 	return http.StatusOK, nil
 }
 
 func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	// New users should be created on /api/users.
 	if r.URL.Path == "/" {
-		return http.StatusMethodNotAllowed, nil
+		return methodNotAllowed(w, http.MethodGet, http.MethodPost)
 	}
 
 	// Gets the user ID from the URL and checks if it's valid.
@@ -285,10 +482,15 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		return http.StatusBadRequest, err
 	}
 
-	// Updates the CSS and locale.
+	// Updates the CSS, locale and view mode.
 	if which == "partial" {
+		if err := checkViewMode(u.ViewMode); err != nil {
+			return http.StatusBadRequest, err
+		}
+
 		c.User.CSS = u.CSS
 		c.User.Locale = u.Locale
+		c.User.ViewMode = u.ViewMode
 		err = c.db.UpdateField(&User{ID: c.User.ID}, "CSS", u.CSS)
 		if err != nil {
 			return http.StatusInternalServerError, err
@@ -299,16 +501,116 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 			return http.StatusInternalServerError, err
 		}
 
+		err = c.db.UpdateField(&User{ID: c.User.ID}, "ViewMode", u.ViewMode)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		return http.StatusOK, nil
+	}
+
+	// Updates the view mode, which the users change while browsing.
+	if which == "viewMode" {
+		if err := checkViewMode(u.ViewMode); err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		suser := getUserByID(c, id)
+		if suser == nil {
+			return http.StatusNotFound, errUserNotExist
+		}
+
+		err = c.db.UpdateField(&User{ID: id}, "ViewMode", u.ViewMode)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		suser.ViewMode = u.ViewMode
+		return http.StatusOK, nil
+	}
+
+	// Updates the ignore patterns of the user.
+	if which == "ignore" {
+		if err := checkIgnore(u.Ignore); err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		suser := getUserByID(c, id)
+		if suser == nil {
+			return http.StatusNotFound, errUserNotExist
+		}
+
+		err = c.db.UpdateField(&User{ID: id}, "Ignore", u.Ignore)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		suser.Ignore = u.Ignore
 		return http.StatusOK, nil
 	}
 
+	// Updates the avatar of the user.
+	if which == "avatar" {
+		avatar, err := avatarURL(u.Avatar)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		suser := getUserByID(c, id)
+		if suser == nil {
+			return http.StatusNotFound, errUserNotExist
+		}
+
+		err = c.db.UpdateField(&User{ID: id}, "Avatar", avatar)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		suser.Avatar = avatar
+		return http.StatusOK, nil
+	}
+
+	// Updates the environment variables of the commands. Only
+	// admins can change them.
+	if which == "environment" {
+		if !c.User.Admin {
+			return http.StatusForbidden, nil
+		}
+
+		if err := checkEnvironment(u.Environment); err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		suser := getUserByID(c, id)
+		if suser == nil {
+			return http.StatusNotFound, errUserNotExist
+		}
+
+		err = c.db.UpdateField(&User{ID: id}, "Environment", u.Environment)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		suser.Environment = u.Environment
+		return http.StatusOK, nil
+	}
+
+	// Renames the user. Only admins can change the usernames.
+	if which == "username" {
+		if !c.User.Admin {
+			return http.StatusForbidden, nil
+		}
+
+		return renameUser(c, id, u.Username)
+	}
+
 	// Updates the Password.
 	if which == "password" {
 		if u.Password == "" {
 			return http.StatusBadRequest, errEmptyPassword
 		}
 
-		pw, err := hashPassword(u.Password)
+		pw, err := c.hashPassword(u.Password)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -342,6 +644,31 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 		return code, err
 	}
 
+	// Checks if the environment variables are valid.
+	if err := checkEnvironment(u.Environment); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the ignore patterns are valid.
+	if err := checkIgnore(u.Ignore); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the avatar is valid.
+	if u.Avatar, err = avatarURL(u.Avatar); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the view mode is valid.
+	if err := checkViewMode(u.ViewMode); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Checks if the landing path is valid.
+	if err := checkLandingPath(u, u.LandingPath); err != nil {
+		return http.StatusBadRequest, err
+	}
+
 	// Initialize rules if they're not initialized.
 	if u.Rules == nil {
 		u.Rules = []*Rule{}
@@ -353,22 +680,28 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	}
 
 	// Gets the current saved user from the in-memory map.
-	var suser *User
-	for _, user := range c.Users {
-		if user.ID == id {
-			suser = user
-			break
-		}
-	}
+	suser := getUserByID(c, id)
 	if suser == nil {
 		return http.StatusNotFound, nil
 	}
 
 	u.ID = id
 
+	// Only admins can link the users to the accounts of the OpenID
+	// provider, since the account can log in as the user.
+	if !c.User.Admin {
+		u.OIDCIssuer = suser.OIDCIssuer
+		u.OIDCSubject = suser.OIDCSubject
+	}
+
+	// The username can't be taken by another user.
+	if other, ok := c.Users[u.Username]; ok && other.ID != id {
+		return http.StatusConflict, errUserExist
+	}
+
 	// Changes the password if the request wants it.
 	if u.Password != "" {
-		pw, err := hashPassword(u.Password)
+		pw, err := c.hashPassword(u.Password)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -381,6 +714,10 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	// Updates the whole User struct because we always are supposed
 	// to send a new entire object.
 	err = c.db.Save(u)
+	if err == storm.ErrAlreadyExists {
+		return http.StatusConflict, errUserExist
+	}
+
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -392,5 +729,187 @@ func usersPutHandler(c *RequestContext, w http.ResponseWriter, r *http.Request)
 	}
 
 	c.Users[u.Username] = u
+
+	// Starts watching the scope if it has changed.
+	if c.changes != nil && suser.FileSystem != u.FileSystem {
+		if err := c.changes.watch(string(u.FileSystem)); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// renameUser changes the username of a user. The tokens issued with the
+// old username stop working, so the user has to log in again.
+func renameUser(c *RequestContext, id int, username string) (int, error) {
+	if username == "" {
+		return http.StatusBadRequest, errEmptyUsername
+	}
+
+	suser := getUserByID(c, id)
+	if suser == nil {
+		return http.StatusNotFound, errUserNotExist
+	}
+
+	if suser.Username == username {
+		return http.StatusOK, nil
+	}
+
+	if _, ok := c.Users[username]; ok {
+		return http.StatusConflict, errUserExist
+	}
+
+	// The record and the unique index of the usernames are updated in
+	// a single transaction.
+	err := c.db.UpdateField(&User{ID: id}, "Username", username)
+	if err == storm.ErrAlreadyExists {
+		return http.StatusConflict, errUserExist
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	delete(c.Users, suser.Username)
+	suser.Username = username
+	c.Users[username] = suser
+
 	return http.StatusOK, nil
 }
+
+// capabilities are the actions a user can perform, derived from
+// its permissions and from the server configuration.
+type capabilities struct {
+	CanUpload  bool `json:"canUpload"`
+	CanEdit    bool `json:"canEdit"`
+	CanDelete  bool `json:"canDelete"`
+	CanShare   bool `json:"canShare"`
+	CanExecute bool `json:"canExecute"`
+	CanPublish bool `json:"canPublish"`
+}
+
+// profile is the information a user can see about itself.
+type profile struct {
+	ID            int          `json:"id"`
+	Username      string       `json:"username"`
+	Admin         bool         `json:"admin"`
+	Scope         string       `json:"scope"`
+	Locale        string       `json:"locale"`
+	ViewMode      string       `json:"viewMode"`
+	LandingPath   string       `json:"landingPath"`
+	Avatar        string       `json:"avatar"`
+	AllowNew      bool         `json:"allowNew"`
+	AllowEdit     bool         `json:"allowEdit"`
+	AllowCommands bool         `json:"allowCommands"`
+	AllowPublish  bool         `json:"allowPublish"`
+	Commands      []string     `json:"commands"`
+	Capabilities  capabilities `json:"capabilities"`
+	ReadOnly      readOnlyMode `json:"readOnly"`
+	Checksums     []string     `json:"checksums"`
+	// ArchiveFormats are the formats the directories can be downloaded as.
+	ArchiveFormats []string `json:"archiveFormats"`
+	// UploadPolicies are the policies the uploads must pass, so the
+	// clients can filter the files before uploading them.
+	UploadPolicies []UploadPolicy `json:"uploadPolicies"`
+	// Disk is the space of the file system of the scope, so the clients
+	// can tell if the uploads fit. It is omitted if it can't be known.
+	Disk *diskUsage `json:"disk,omitempty"`
+	// Shares is the number of shares of the user and their limit, so the
+	// clients can warn before it is reached.
+	Shares shareUsage `json:"shares"`
+}
+
+// meHandler returns the profile and the permissions of the current user.
+func meHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(w, http.MethodGet)
+	}
+
+	u := c.User
+	readOnly := c.readOnlyStatus()
+	writable := !readOnly.Enabled
+
+	shares, err := c.activeShares(u)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, &profile{
+		ID:            u.ID,
+		Username:      u.Username,
+		Admin:         u.Admin,
+		Scope:         string(u.FileSystem),
+		Locale:        u.Locale,
+		ViewMode:      u.viewMode(),
+		LandingPath:   c.landingPath(u),
+		Avatar:        u.Avatar,
+		AllowNew:      u.AllowNew,
+		AllowEdit:     u.AllowEdit,
+		AllowCommands: u.AllowCommands,
+		AllowPublish:  u.AllowPublish,
+		Commands:      u.Commands,
+		Capabilities: capabilities{
+			CanUpload:  writable && u.AllowNew,
+			CanEdit:    writable && u.AllowEdit,
+			CanDelete:  writable && u.AllowEdit,
+			CanShare:   writable,
+			CanExecute: writable && u.AllowCommands && len(u.Commands) > 0,
+			CanPublish: writable && u.AllowPublish && c.StaticGen != nil,
+		},
+		ReadOnly:       readOnly,
+		Checksums:      c.enabledChecksums(),
+		ArchiveFormats: c.enabledArchiveFormats(),
+		UploadPolicies: c.uploadPolicies(u),
+		Disk:           userDisk(u),
+		Shares:         shareUsage{Count: shares, Limit: c.shareLimit(u)},
+	})
+}
+
+// permission explains if a user can access a path.
+type permission struct {
+	Username string `json:"username"`
+	Path     string `json:"path"`
+	Allowed  bool   `json:"allowed"`
+	// Rule is the index of the rule which decides the access or -1 if
+	// no rule matches the path, in which case it's allowed.
+	Rule int `json:"rule"`
+	// Rules are the rules of the user, in the order they're defined.
+	// The last one that matches a path decides the access.
+	Rules []*Rule `json:"rules"`
+}
+
+// allowedHandler tells if the user can access the path in the 'path'
+// query parameter and which rule decides it. Admins can check other
+// users by setting their ID in the 'user' query parameter.
+func allowedHandler(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	u := c.User
+
+	if id := r.URL.Query().Get("user"); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if n != u.ID {
+			if !u.Admin {
+				return http.StatusForbidden, nil
+			}
+
+			if u = getUserByID(c, n); u == nil {
+				return http.StatusNotFound, errUserNotExist
+			}
+		}
+	}
+
+	path := "/" + strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+	rule := u.matchRule(path)
+
+	return renderJSON(w, &permission{
+		Username: u.Username,
+		Path:     path,
+		Allowed:  rule < 0 || u.Rules[rule].Allow,
+		Rule:     rule,
+		Rules:    u.Rules,
+	})
+}
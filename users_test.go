@@ -0,0 +1,405 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAvatarURL(t *testing.T) {
+	tests := []struct {
+		avatar, want string
+		ok           bool
+	}{
+		{"", "", true},
+		{"https://example.com/me.png", "https://example.com/me.png", true},
+		{" Me@Example.com ", gravatarURL + "2e0d5407ce8609047b8255c50405d7b1?d=identicon", true},
+		{"javascript:alert(1)", "", false},
+		{"ftp://example.com/me.png", "", false},
+		{"https://", "", false},
+		{"not an avatar", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := avatarURL(test.avatar)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.avatar, err)
+			continue
+		}
+
+		if test.ok && got != test.want {
+			t.Errorf("%q: got %q, want %q", test.avatar, got, test.want)
+		}
+	}
+}
+
+func TestUsersPagination(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	// The admin has the ID 1.
+	for i := 2; i <= 6; i++ {
+		name := fmt.Sprintf("user%d", i)
+		fm.Users[name] = &User{ID: i, Username: name, Password: "hash"}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	tests := []struct {
+		query string
+		code  int
+		total string
+		ids   []int
+	}{
+		{"", http.StatusOK, "6", []int{1, 2, 3, 4, 5, 6}},
+		{"?perPage=4", http.StatusOK, "6", []int{1, 2, 3, 4}},
+		{"?page=2&perPage=4", http.StatusOK, "6", []int{5, 6}},
+		{"?page=3&perPage=4", http.StatusOK, "6", []int{}},
+		{"?q=USER&page=1&perPage=2", http.StatusOK, "5", []int{2, 3}},
+		{"?q=min", http.StatusOK, "1", []int{1}},
+		{"?page=0", http.StatusBadRequest, "", nil},
+		{"?perPage=x", http.StatusBadRequest, "", nil},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/api/users/"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected %v, got %v", test.query, test.code, w.Code)
+			continue
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		if total := w.Header().Get("X-Total-Count"); total != test.total {
+			t.Errorf("%s: expected total %s, got %s", test.query, test.total, total)
+		}
+
+		var users []User
+		if err := json.NewDecoder(w.Body).Decode(&users); err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+		for _, u := range users {
+			if u.Password != "" {
+				t.Errorf("%s: the password of %s was sent", test.query, u.Username)
+			}
+
+			ids = append(ids, u.ID)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.ids, ids)
+		}
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	password, err := hashPassword("secret", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		u := &User{Username: name, Password: password, FileSystem: fm.Users["admin"].FileSystem}
+		if err = fm.db.Save(u); err != nil {
+			t.Fatal(err)
+		}
+		fm.Users[name] = u
+	}
+
+	login := func(username string) (int, string) {
+		body := fmt.Sprintf(`{"username":%q,"password":"secret"}`, username)
+		if username == "admin" {
+			body = defaultCredentials
+		}
+
+		r, err := http.NewRequest("POST", "/api/auth/get", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	do := func(token, method, url, body string) int {
+		r, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	_, admin := login("admin")
+	_, old := login("alice")
+	id := fm.Users["alice"].ID
+	url := fmt.Sprintf("/api/users/%d", id)
+	rename := func(token, username string) int {
+		return do(token, "PUT", url, fmt.Sprintf(`{"what":"user","which":"username","data":{"username":%q}}`, username))
+	}
+
+	if code := rename(old, "carol"); code != http.StatusForbidden {
+		t.Errorf("Rename by the user: expected 403, got %v", code)
+	}
+
+	if code := rename(admin, "bob"); code != http.StatusConflict {
+		t.Errorf("Rename to a taken username: expected 409, got %v", code)
+	}
+
+	if code := rename(admin, "carol"); code != http.StatusOK {
+		t.Fatalf("Rename: expected 200, got %v", code)
+	}
+
+	if _, ok := fm.Users["alice"]; ok || fm.Users["carol"] == nil || fm.Users["carol"].ID != id {
+		t.Error("The user wasn't renamed in memory")
+	}
+
+	var stored User
+	if err = fm.db.One("Username", "carol", &stored); err != nil || stored.ID != id {
+		t.Errorf("The user wasn't renamed in the database: %v", err)
+	}
+
+	if code := do(old, "GET", "/api/me", ""); code != http.StatusUnauthorized {
+		t.Errorf("Token of the old username: expected 401, got %v", code)
+	}
+
+	// A new user with the old username can't be reached with the old tokens.
+	u := &User{Username: "alice", Password: password, FileSystem: fm.Users["admin"].FileSystem}
+	if err = fm.db.Save(u); err != nil {
+		t.Fatal(err)
+	}
+	fm.Users["alice"] = u
+
+	if code := do(old, "GET", "/api/me", ""); code != http.StatusUnauthorized {
+		t.Errorf("Token of a reused username: expected 401, got %v", code)
+	}
+
+	if code, _ := login("carol"); code != http.StatusOK {
+		t.Errorf("Login with the new username: expected 200, got %v", code)
+	}
+}
+
+func TestMe(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	get := func(method string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "/api/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	me := func() profile {
+		w := get("GET")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Got status %v", w.Code)
+		}
+
+		if strings.Contains(w.Body.String(), fm.Users["admin"].Password) {
+			t.Errorf("The password hash was sent: %s", w.Body.String())
+		}
+
+		var p profile
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	p := me()
+	if p.Username != "admin" || !p.Admin || p.Scope != filepath.Join(fm.Temp, "scope") || p.Locale != "en" {
+		t.Errorf("Got the profile %+v", p)
+	}
+
+	want := capabilities{CanUpload: true, CanEdit: true, CanDelete: true, CanShare: true}
+	if p.Capabilities != want {
+		t.Errorf("Got the capabilities %+v, want %+v", p.Capabilities, want)
+	}
+
+	// The capabilities follow the permissions of the user.
+	fm.Users["admin"].AllowEdit = false
+	fm.Users["admin"].Commands = []string{"git"}
+	want = capabilities{CanUpload: true, CanShare: true, CanExecute: true}
+	if p = me(); p.Capabilities != want || p.AllowEdit {
+		t.Errorf("Without edit permission: got %+v, want %+v", p.Capabilities, want)
+	}
+
+	// Nothing can be changed in read-only mode.
+	if err := fm.SetReadOnlyMode(true, "Maintenance"); err != nil {
+		t.Fatal(err)
+	}
+
+	if p = me(); p.Capabilities != (capabilities{}) || !p.ReadOnly.Enabled {
+		t.Errorf("Read-only mode: got %+v and %+v", p.Capabilities, p.ReadOnly)
+	}
+
+	if w := get("POST"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %v", w.Code)
+	}
+}
+
+func TestViewMode(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	token := login(t, fm, defaultCredentials)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.AddCookie(&http.Cookie{Name: "display", Value: "list"})
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	viewMode := func() (string, string) {
+		var me profile
+		if err := json.Unmarshal(do("GET", "/api/me", "").Body.Bytes(), &me); err != nil {
+			t.Fatal(err)
+		}
+
+		var listing struct {
+			Display string `json:"display"`
+		}
+
+		if err := json.Unmarshal(do("GET", "/api/resource/", "").Body.Bytes(), &listing); err != nil {
+			t.Fatal(err)
+		}
+
+		return me.ViewMode, listing.Display
+	}
+
+	// The users saved without a view mode get the default one, and their
+	// listings follow the cookie.
+	if me, listing := viewMode(); me != "mosaic" || listing != "list" {
+		t.Errorf("Without a view mode: got %q and the listing in %q", me, listing)
+	}
+
+	update := func(mode string) int {
+		body := fmt.Sprintf(`{"what":"user","which":"viewMode","data":{"viewMode":%q}}`, mode)
+		return do("PUT", "/api/users/1", body).Code
+	}
+
+	if code := update("tiles"); code != http.StatusBadRequest {
+		t.Errorf("Unknown view mode: expected 400, got %v", code)
+	}
+
+	if code := update("grid"); code != http.StatusOK {
+		t.Fatalf("Update: expected 200, got %v", code)
+	}
+
+	if me, listing := viewMode(); me != "grid" || listing != "grid" {
+		t.Errorf("With a view mode: got %q and the listing in %q", me, listing)
+	}
+
+	var stored User
+	if err := fm.db.One("ID", 1, &stored); err != nil || stored.ViewMode != "grid" {
+		t.Errorf("The view mode wasn't saved: %q, %v", stored.ViewMode, err)
+	}
+}
+
+func TestLandingPath(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	for _, dir := range []string{"shared", "home", "private"} {
+		if err := os.MkdirAll(filepath.Join(fm.Temp, "scope", dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := login(t, fm, defaultCredentials)
+
+	landing := func() string {
+		r, err := http.NewRequest("GET", "/api/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		var me profile
+		if err := json.Unmarshal(w.Body.Bytes(), &me); err != nil {
+			t.Fatal(err)
+		}
+
+		return me.LandingPath
+	}
+
+	u := fm.Users["admin"]
+	u.Rules = []*Rule{{Path: "/private", Allow: false}}
+
+	tests := []struct {
+		global, user, want string
+	}{
+		{"", "", "/"},
+		{"/shared", "", "/shared/"},
+		{"shared/", "", "/shared/"},
+		{"/shared", "/home", "/home/"},
+		{"/shared", "/private", "/shared/"},
+		{"/shared", "/missing", "/shared/"},
+		{"/../../etc", "", "/"},
+		{"/private", "", "/"},
+	}
+
+	for _, test := range tests {
+		fm.LandingPath = test.global
+		u.LandingPath = test.user
+
+		if got := landing(); got != test.want {
+			t.Errorf("Landing path with %q and %q: got %q, want %q", test.global, test.user, got, test.want)
+		}
+	}
+
+	if err := checkLandingPath(u, "/private/docs"); err != errInvalidLandingPath {
+		t.Errorf("Disallowed landing path: got %v", err)
+	}
+
+	if err := checkLandingPath(u, "home"); err != errInvalidLandingPath {
+		t.Errorf("Relative landing path: got %v", err)
+	}
+
+	if err := checkLandingPath(u, "/home"); err != nil {
+		t.Errorf("Landing path: got %v", err)
+	}
+}
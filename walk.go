@@ -0,0 +1,181 @@
+package filemanager
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultMaxDepth is the default maximum depth of recursive operations.
+const defaultMaxDepth = 64
+
+// fileID identifies a directory by its device and inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// walker holds the state of a walk through a file tree.
+type walker struct {
+	scope     string
+	maxDepth  int
+	fn        filepath.WalkFunc
+	truncated bool
+
+	// The directories already walked, by their ID or, on the platforms
+	// without them, by their information.
+	visited map[fileID]bool
+	others  []os.FileInfo
+}
+
+// walk walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, just like filepath.Walk. Unlike it, symbolic links
+// are followed if they point inside scope, which is the directory of the
+// user the tree is in. The other links are reported as they are, without
+// following them. Each directory is only walked once, so the links to
+// the directories which were already walked, like the ones which make a
+// loop, are reported but not walked into again. If maxDepth is greater
+// than zero, the directories deeper than that aren't walked and walk
+// reports the tree was truncated.
+func walk(scope, root string, maxDepth int, fn filepath.WalkFunc) (bool, error) {
+	w := &walker{
+		scope:    scope,
+		maxDepth: maxDepth,
+		fn:       fn,
+		visited:  map[fileID]bool{},
+	}
+
+	if resolved, err := filepath.EvalSymlinks(scope); err == nil {
+		w.scope = resolved
+	}
+
+	info, err := w.stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, info, 0)
+	}
+
+	if err == filepath.SkipDir {
+		err = nil
+	}
+
+	return w.truncated, err
+}
+
+// stat returns the information of the file, or of the target of the
+// symbolic link if it is inside the scope. The broken links and the ones
+// pointing outside of the scope are treated as regular files.
+func (w *walker) stat(path string) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return info, err
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil || !insideDir(w.scope, target) {
+		return info, nil
+	}
+
+	if target, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+
+	return info, nil
+}
+
+// insideDir checks if the path is the directory or is inside it.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// visit marks the directory as walked and tells if it already was.
+func (w *walker) visit(info os.FileInfo) bool {
+	if id, ok := dirID(info); ok {
+		if w.visited[id] {
+			return true
+		}
+
+		w.visited[id] = true
+		return false
+	}
+
+	for _, other := range w.others {
+		if os.SameFile(other, info) {
+			return true
+		}
+	}
+
+	w.others = append(w.others, info)
+	return false
+}
+
+func (w *walker) walk(path string, info os.FileInfo, depth int) error {
+	if err := w.fn(path, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+
+	if w.maxDepth > 0 && depth >= w.maxDepth {
+		w.truncated = true
+		return nil
+	}
+
+	// The directories reached again through symbolic links, including
+	// the ones which make a loop, are only walked once.
+	if w.visit(info) {
+		return nil
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return w.fn(path, info, err)
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+
+		fileInfo, err := w.stat(filename)
+		if err != nil {
+			if err := w.fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+
+			continue
+		}
+
+		err = w.walk(filename, fileInfo, depth+1)
+		if err != nil {
+			if !fileInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readDirNames reads the directory named by dirname and returns
+// a sorted list of directory entries.
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// virtualPath returns the path of the file at p relative to root, which is
+// the scope of the user, as the rules expect it: with forward slashes and
+// a leading one, even when the scope is the root of the file system.
+func virtualPath(root, p string) string {
+	return filepath.ToSlash(filepath.Clean(string(filepath.Separator) + strings.TrimPrefix(p, root)))
+}
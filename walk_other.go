@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package filemanager
+
+import "os"
+
+// The directories have no inodes on this platform, so the walks compare
+// them with os.SameFile instead.
+
+func dirID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
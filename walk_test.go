@@ -0,0 +1,190 @@
+package filemanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkSymlinkCycle(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	if err = os.MkdirAll(filepath.Join(temp, "a", "b"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// Creates a symbolic link from a/b/loop to a.
+	if err = os.Symlink(filepath.Join(temp, "a"), filepath.Join(temp, "a", "b", "loop")); err != nil {
+		t.Skip("symbolic links aren't supported:", err)
+	}
+
+	count := 0
+	truncated, err := walk(temp, temp, 0, func(path string, info os.FileInfo, err error) error {
+		count++
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if truncated {
+		t.Error("Walk without depth limit was truncated")
+	}
+
+	// The temporary directory, a, a/b and a/b/loop.
+	if count != 4 {
+		t.Errorf("Wrong number of walked files: got %v want %v", count, 4)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	if err = os.MkdirAll(filepath.Join(temp, "a", "b", "c"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated, err := walk(temp, temp, 2, func(path string, info os.FileInfo, err error) error {
+		if path == filepath.Join(temp, "a", "b", "c") {
+			t.Errorf("Walked deeper than the maximum depth: %v", path)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !truncated {
+		t.Error("Walk wasn't reported as truncated")
+	}
+}
+
+func TestWalkSiblingLinks(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	if err = os.MkdirAll(filepath.Join(temp, "a"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two links from a to its parent would double the tree at each level
+	// if the directories were walked once for each path to them.
+	for _, name := range []string{"x", "y"} {
+		if err = os.Symlink(temp, filepath.Join(temp, "a", name)); err != nil {
+			t.Skip("symbolic links aren't supported:", err)
+		}
+	}
+
+	walked := []string{}
+	_, err = walk(temp, temp, 0, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(temp, path)
+		walked = append(walked, filepath.ToSlash(rel))
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[. a a/x a/y]"
+	if got := fmt.Sprint(walked); got != want {
+		t.Errorf("Walked %v, want %v", got, want)
+	}
+}
+
+func TestWalkOutsideScope(t *testing.T) {
+	temp, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(temp)
+
+	scope := filepath.Join(temp, "scope")
+	for _, dir := range []string{filepath.Join(scope, "in"), filepath.Join(temp, "out")} {
+		if err = os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("file"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"inside":  filepath.Join(scope, "in"),
+		"outside": filepath.Join(temp, "out"),
+		"file":    filepath.Join(temp, "out", "file.txt"),
+	}
+
+	for name, target := range links {
+		if err = os.Symlink(target, filepath.Join(scope, name)); err != nil {
+			t.Skip("symbolic links aren't supported:", err)
+		}
+	}
+
+	walked := map[string]os.FileInfo{}
+	_, err = walk(scope, scope, 0, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(scope, path)
+		walked[filepath.ToSlash(rel)] = info
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := walked["outside/file.txt"]; ok {
+		t.Error("A link outside of the scope was followed")
+	}
+
+	for _, name := range []string{"outside", "file"} {
+		if info := walked[name]; info == nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("The link %s outside of the scope wasn't reported as a link", name)
+		}
+	}
+
+	// The link inside the scope is reported as a directory but the one
+	// it points to is only walked once.
+	if info := walked["inside"]; info == nil || !info.IsDir() {
+		t.Error("The link inside the scope wasn't followed")
+	}
+
+	if _, ok := walked["in/file.txt"]; !ok {
+		t.Error("A directory of the scope wasn't walked")
+	}
+}
+
+func TestVirtualPath(t *testing.T) {
+	sep := string(filepath.Separator)
+
+	tests := []struct {
+		root, path, want string
+	}{
+		{sep + "scope", sep + "scope", "/"},
+		{sep + "scope", filepath.Join(sep+"scope", "a", "b.txt"), "/a/b.txt"},
+		{sep, sep, "/"},
+		{sep, filepath.Join(sep, "a", "b.txt"), "/a/b.txt"},
+	}
+
+	for _, test := range tests {
+		if got := virtualPath(test.root, test.path); got != test.want {
+			t.Errorf("%s in %s: got %s, want %s", test.path, test.root, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package filemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+// dirID returns the device and the inode of the directory, which identify
+// it whatever the path it was reached through.
+func dirID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -3,6 +3,7 @@ package filemanager
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"mime"
 	"net/http"
 	"os"
@@ -25,6 +26,35 @@ var (
 	cmdNotAllowed     = []byte("Command not allowed.")
 )
 
+// safeEnvironment is the list of the server's environment variables
+// which are passed to the commands executed by the users. Any other
+// variable could contain secrets so they're never passed.
+var safeEnvironment = []string{
+	"PATH", "HOME", "USER", "LANG", "LC_ALL", "TMPDIR", "TZ",
+}
+
+// commandEnvironment builds the environment of the commands executed
+// by the user: the safe variables from the server and the user ones.
+func commandEnvironment(u *User) []string {
+	env := []string{}
+
+	for _, key := range safeEnvironment {
+		if _, ok := u.Environment[key]; ok {
+			continue
+		}
+
+		if val, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+val)
+		}
+	}
+
+	for key, val := range u.Environment {
+		env = append(env, key+"="+val)
+	}
+
+	return env
+}
+
 // command handles the requests for VCS related commands: git, svn and mercurial
 func command(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
 	// Upgrades the connection to a websocket and checks for errors.
@@ -88,10 +118,12 @@ func command(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, er
 	// Sets up the command executation.
 	cmd := exec.Command(command[0], command[1:]...)
 	cmd.Dir = path
+	cmd.Env = commandEnvironment(c.User)
 	cmd.Stderr = buff
 	cmd.Stdout = buff
 
 	// Starts the command and checks for errors.
+	record := newCommandRecord(c, command, r.URL.Path)
 	err = cmd.Start()
 	if err != nil {
 		return http.StatusInternalServerError, err
@@ -99,10 +131,14 @@ func command(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, er
 
 	// Set a 'done' variable to check whetever the command has already finished
 	// running or not. This verification is done using a goroutine that uses the
-	// method .Wait() from the command.
+	// method .Wait() from the command. The command is recorded in the history
+	// there so it is even if the connection is closed before it finishes.
 	done := false
 	go func() {
 		err = cmd.Wait()
+		record.Duration = int64(time.Since(record.Started) / time.Millisecond)
+		record.ExitCode = exitCode(cmd, err)
+		c.recordCommand(record)
 		done = true
 	}()
 
@@ -238,8 +274,47 @@ func parseSearch(value string) *searchOptions {
 	return opts
 }
 
-// search searches for a file or directory.
+// errorToWarning converts the errors found while searching into
+// a short description which doesn't expose absolute paths.
+func errorToWarning(err error) string {
+	switch {
+	case os.IsPermission(err):
+		return "permission denied"
+	case os.IsNotExist(err):
+		return "not found"
+	default:
+		return "could not be read"
+	}
+}
+
+// search searches for a file or directory inside of the directory in
+// the URL. The results are relative to that directory.
 func search(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	// The path is cleaned so it can't escape the scope of the
+	// user nor the rules.
+	r.URL.Path = sanitizeURL(r.URL.Path)
+	if !c.User.Allowed(r.URL.Path) {
+		return http.StatusForbidden, nil
+	}
+
+	root := filepath.Clean(string(c.User.FileSystem))
+	scope := filepath.Join(root, r.URL.Path)
+
+	info, err := os.Stat(scope)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	if !info.IsDir() {
+		return http.StatusBadRequest, errors.New("the search scope must be a directory")
+	}
+
+	release, ok := c.acquire(r, limitSearch)
+	if !ok {
+		return rejectBusy(w)
+	}
+	defer release()
+
 	// Upgrades the connection to a websocket and checks for errors.
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -267,34 +342,63 @@ func search(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, err
 	}
 
 	search = parseSearch(value)
-	scope := strings.TrimPrefix(r.URL.Path, "/")
-	scope = "/" + scope
-	scope = string(c.User.FileSystem) + scope
-	scope = strings.Replace(scope, "\\", "/", -1)
-	scope = filepath.Clean(scope)
 
-	err = filepath.Walk(scope, func(path string, f os.FileInfo, err error) error {
-		if search.CaseInsensitive {
-			path = strings.ToLower(path)
-		}
+	// Paths which couldn't be searched, such as unreadable directories.
+	warnings := []string{}
+	showHidden := r.URL.Query().Get("hidden") == "true"
+
+	truncated, err := walk(root, scope, c.MaxDepth, func(path string, f os.FileInfo, err error) error {
+		// The rules and the ignore patterns apply to the path relative
+		// to the scope of the user.
+		virtual := virtualPath(root, path)
 
 		path = strings.TrimPrefix(path, scope)
-		path = strings.TrimPrefix(path, "/")
-		path = strings.Replace(path, "\\", "/", -1)
+		path = strings.TrimPrefix(path, string(filepath.Separator))
+		path = filepath.ToSlash(path)
+
+		// Skips the entries which can't be read and keeps searching.
+		if err != nil {
+			if c.User.Allowed(virtual) {
+				warnings = append(warnings, path+": "+errorToWarning(err))
+			}
+
+			return nil
+		}
+
+		// Skips the partial files of the uploads and the ignored
+		// entries, including the contents of the ignored directories.
+		if path != "" && (partialFile(path) || (!showHidden && c.ignored(c.User, virtual))) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// The directories aren't skipped because there might be
+		// rules allowing some of their contents.
+		if !c.User.Allowed(virtual) {
+			return nil
+		}
+
+		match := path
+		if search.CaseInsensitive {
+			match = strings.ToLower(match)
+		}
 
 		// Only execute if there are conditions to meet.
 		if len(search.Conditions) > 0 {
-			match := false
+			ok := false
 
 			for _, t := range search.Conditions {
-				if t(path) {
-					match = true
+				if t(match) {
+					ok = true
 					break
 				}
 			}
 
 			// If doesn't meet the condition, go to the next.
-			if !match {
+			if !ok {
 				return nil
 			}
 		}
@@ -302,18 +406,11 @@ func search(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, err
 		if len(search.Terms) > 0 {
 			is := false
 
-			// Checks if matches the terms and if it is allowed.
+			// Checks if matches the terms.
 			for _, term := range search.Terms {
-				if is {
-					break
-				}
-
-				if strings.Contains(path, term) {
-					if !c.User.Allowed(path) {
-						return nil
-					}
-
+				if strings.Contains(match, term) {
 					is = true
+					break
 				}
 			}
 
@@ -334,5 +431,25 @@ func search(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, err
 		return http.StatusInternalServerError, err
 	}
 
+	// The last message lists the paths which couldn't be searched.
+	if len(warnings) > 0 {
+		response, _ := json.Marshal(map[string]interface{}{
+			"warnings": warnings,
+		})
+
+		if err = conn.WriteMessage(websocket.TextMessage, response); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	// If the search didn't go through every directory, tell it to
+	// the client on the close message.
+	if truncated {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "truncated")
+		if err = conn.WriteMessage(websocket.CloseMessage, msg); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
 	return 0, nil
 }
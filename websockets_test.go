@@ -0,0 +1,105 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSearchScope(t *testing.T) {
+	fm := newTest(t)
+	defer fm.Clean()
+
+	scope := filepath.Join(fm.Temp, "scope")
+	for _, name := range []string{"project/Readme.md", "project/src/main.go", "project/secret/main.go", "other/main.go"} {
+		path := filepath.Join(scope, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm.Users["admin"].Rules = []*Rule{
+		{Path: "/project/secret", Allow: false},
+		{Path: "/other", Allow: false},
+	}
+
+	server := httptest.NewServer(fm)
+	defer server.Close()
+
+	token := login(t, fm, defaultCredentials)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+
+	search := func(path, query string) ([]string, int) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/search" + path
+		conn, res, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			return nil, res.StatusCode
+		}
+		defer conn.Close()
+
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(query)); err != nil {
+			t.Fatal(err)
+		}
+
+		results := []string{}
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+
+			var result struct {
+				Path string `json:"path"`
+			}
+
+			if err = json.Unmarshal(message, &result); err != nil {
+				t.Fatal(err)
+			}
+
+			results = append(results, result.Path)
+		}
+
+		sort.Strings(results)
+		return results, http.StatusOK
+	}
+
+	tests := []struct {
+		path, query string
+		code        int
+		results     []string
+	}{
+		{"/project", "main", http.StatusOK, []string{"src/main.go"}},
+		{"/project/", "readme case:insensitive", http.StatusOK, []string{"Readme.md"}},
+		{"/project/src", "main", http.StatusOK, []string{"main.go"}},
+		{"/project/../other", "main", http.StatusForbidden, nil},
+		{"/project/secret", "main", http.StatusForbidden, nil},
+		{"/project/Readme.md", "main", http.StatusBadRequest, nil},
+		{"/missing", "main", http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+		results, code := search(test.path, test.query)
+		if code != test.code {
+			t.Errorf("%s: got status %v, want %v", test.path, code, test.code)
+			continue
+		}
+
+		if strings.Join(results, ",") != strings.Join(test.results, ",") {
+			t.Errorf("%s %q: got %v, want %v", test.path, test.query, results, test.results)
+		}
+	}
+}
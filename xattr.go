@@ -0,0 +1,97 @@
+package filemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// tagsXattr is the extended attribute which holds the tags of a file as a
+// comma separated list, like the desktops of freedesktop.org keep them.
+const tagsXattr = "user.xdg.tags"
+
+var (
+	errXattrsDisabled = errors.New("the extended attributes are disabled")
+	errXattrName      = errors.New("the extended attribute can't be set")
+)
+
+// resourceGetXattrs replies with the extended attributes of the file, as a
+// map of their names to their values. The map is empty on the platforms
+// and the file systems which don't support them.
+func resourceGetXattrs(c *RequestContext, w http.ResponseWriter, f *file) (int, error) {
+	if !c.Xattrs {
+		return http.StatusNotImplemented, errXattrsDisabled
+	}
+
+	attrs, err := listXattrs(f.Path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	return renderJSON(w, attrs)
+}
+
+// resourceSetXattrs sets the extended attributes of the file in the body,
+// which maps their names to their values. The attributes whose value is
+// null are removed. It replies with the attributes of the file.
+func resourceSetXattrs(c *RequestContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	if !c.Xattrs {
+		return http.StatusNotImplemented, errXattrsDisabled
+	}
+
+	if r.URL.Path == "/" {
+		return http.StatusForbidden, nil
+	}
+
+	var attrs map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	for name := range attrs {
+		if !xattrWritable(name) {
+			return http.StatusBadRequest, errXattrName
+		}
+	}
+
+	path := filepath.Join(string(c.User.FileSystem), r.URL.Path)
+	for name, value := range attrs {
+		var err error
+		if value == nil {
+			err = removeXattr(path, name)
+		} else {
+			err = setXattr(path, name, *value)
+		}
+
+		if err != nil {
+			return errorToHTTP(err, false), err
+		}
+	}
+
+	current, err := listXattrs(path)
+	if err != nil {
+		return errorToHTTP(err, false), err
+	}
+
+	return renderJSON(w, current)
+}
+
+// fileTags returns the tags of the file at the path, which are empty if
+// it has none or the extended attributes aren't supported.
+func fileTags(path string) ([]string, error) {
+	attrs, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tag := range strings.Split(attrs[tagsXattr], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,6 @@
+package filemanager
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is returned when the file doesn't have the attribute.
+const errNoXattr = unix.ENODATA
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package filemanager
+
+// The extended attributes aren't supported on this platform, so the files
+// have none.
+
+func listXattrs(path string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func setXattr(path, name, value string) error {
+	return errXattrName
+}
+
+func removeXattr(path, name string) error {
+	return nil
+}
+
+func xattrWritable(name string) bool {
+	return false
+}
@@ -0,0 +1,106 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package filemanager
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrUnsupported tells if the error means the file system doesn't
+// support the extended attributes.
+func xattrUnsupported(err error) bool {
+	return err == unix.ENOTSUP || err == unix.EOPNOTSUPP
+}
+
+// xattrWritable tells if the attribute may be set. Only the attributes in
+// the user namespace can be, since the others are used by the system. The
+// attributes of macOS have no namespaces, so the same prefix is required
+// there to keep the system ones, like the quarantine flag, untouched.
+func xattrWritable(name string) bool {
+	return strings.HasPrefix(name, "user.") && len(name) > len("user.")
+}
+
+// readXattrBuffer calls a syscall which fills a buffer, with a buffer of
+// the size it asks for. It is retried if the data grew in between.
+func readXattrBuffer(call func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return []byte{}, nil
+		}
+
+		buf := make([]byte, size)
+		size, err = call(buf)
+		if err == unix.ERANGE {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:size], nil
+	}
+}
+
+// listXattrs returns the extended attributes of the file at the path. The
+// symbolic links aren't followed, so they can't reach files out of the
+// scope.
+func listXattrs(path string) (map[string]string, error) {
+	attrs := map[string]string{}
+
+	names, err := readXattrBuffer(func(buf []byte) (int, error) {
+		return unix.Llistxattr(path, buf)
+	})
+
+	if xattrUnsupported(err) {
+		return attrs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(string(names), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		value, err := readXattrBuffer(func(buf []byte) (int, error) {
+			return unix.Lgetxattr(path, name, buf)
+		})
+
+		// The attribute may have been removed in the meantime.
+		if err == errNoXattr {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		attrs[name] = string(value)
+	}
+
+	return attrs, nil
+}
+
+func setXattr(path, name, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
+
+func removeXattr(path, name string) error {
+	err := unix.Lremovexattr(path, name)
+	if err == errNoXattr {
+		return nil
+	}
+
+	return err
+}